@@ -3,8 +3,11 @@ package main
 import (
 	"kalebecommerce/cache" // Import the cache package
 	"kalebecommerce/config"
+	"kalebecommerce/controllers"
+	"kalebecommerce/payments"
 	"kalebecommerce/routes"
 	"log"
+	"time"
 
 	"github.com/joho/godotenv"
 	"gorm.io/gorm"
@@ -25,8 +28,21 @@ func main() {
 	// Initialize the in-memory cache
 	cache.InitCache()
 
+	provider, err := payments.New(cfg.PaymentProvider)
+	if err != nil {
+		log.Fatalf("failed to init payments: %v", err)
+	}
+	if cfg.PaymentWebhookSecret == "" || cfg.PaymentWebhookSecret == cfg.JWTSecret {
+		log.Fatal("PAYMENT_WEBHOOK_SECRET must be set and differ from JWT_SECRET")
+	}
+
+	// Apply scheduled product prices, expire unpaid orders and old loyalty points in the background
+	controllers.StartPriceScheduler(db, time.Minute)
+	controllers.StartPaymentExpiry(db, cfg, time.Minute)
+	controllers.StartPointsExpiry(db, cfg, time.Hour)
+
 	// Pass the cache instance to the router setup function
-	r := routes.SetupRouter(db, cfg, cache.Cache)
+	r := routes.SetupRouter(db, cfg, cache.Cache, provider)
 	port := cfg.Port
 	if port == "" {
 		port = "8080"
@@ -1,7 +1,15 @@
 package config
 
 import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"kalebecommerce/money"
+	"math"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -13,6 +21,31 @@ type Config struct {
 	DatabaseURL string
 	JWTSecret   string
 	Port        string
+	StoreName   string
+	StoreURL    string
+	Currency    string
+	// CartMergeStrategy decides quantities when a guest cart and an account cart
+	// hold the same product: "sum" (default), "max", "guest" or "account".
+	CartMergeStrategy string
+	// PaymentProvider names the payment gateway ("mock" is the only built-in one).
+	PaymentProvider string
+	// PaymentWebhookSecret signs provider webhooks; it must differ from JWTSecret.
+	PaymentWebhookSecret string
+	// PaymentMockEndpoints exposes the mock provider's pay/decline endpoint, for local development only.
+	PaymentMockEndpoints bool
+	// PaymentExpiry is how long a payment may stay pending before the order fails.
+	PaymentExpiry time.Duration
+	// ReturnWindowDays is how long after ordering customers may request a return.
+	ReturnWindowDays int
+	// PricesIncludeTax marks catalog prices as tax-inclusive (gross); otherwise
+	// tax is added on top at checkout.
+	PricesIncludeTax bool
+	// TaxOriginCountry taxes orders without a shipping address (ISO alpha-2).
+	TaxOriginCountry string
+	// LoyaltyPointValue is what one loyalty point takes off an order, in the base currency.
+	LoyaltyPointValue money.Amount
+	// LoyaltyPointsExpiryDays is how long earned points last; 0 keeps them forever.
+	LoyaltyPointsExpiryDays int
 }
 
 func GetConfig() *Config {
@@ -20,7 +53,49 @@ func GetConfig() *Config {
 		DatabaseURL: os.Getenv("DATABASE_URL"),
 		JWTSecret:   os.Getenv("JWT_SECRET"),
 		Port:        os.Getenv("PORT"),
+		StoreName:   getEnv("STORE_NAME", "Kaleb E-Commerce"),
+		StoreURL:    getEnv("STORE_URL", "http://localhost:8080"),
+		Currency:    getEnv("STORE_CURRENCY", "USD"),
+
+		CartMergeStrategy: getEnv("CART_MERGE_STRATEGY", "sum"),
+
+		PaymentProvider:      os.Getenv("PAYMENT_PROVIDER"),
+		PaymentWebhookSecret: os.Getenv("PAYMENT_WEBHOOK_SECRET"),
+		PaymentMockEndpoints: getEnv("PAYMENT_MOCK_ENDPOINTS", "false") == "true",
+		PaymentExpiry:        time.Duration(getEnvInt("PAYMENT_EXPIRY_MINUTES", 30)) * time.Minute,
+
+		ReturnWindowDays: getEnvInt("RETURN_WINDOW_DAYS", 30),
+
+		PricesIncludeTax: getEnv("PRICES_INCLUDE_TAX", "false") == "true",
+		TaxOriginCountry: strings.ToUpper(getEnv("TAX_ORIGIN_COUNTRY", "")),
+
+		LoyaltyPointValue:       getEnvAmount("LOYALTY_POINT_VALUE", money.MustParse("0.01")),
+		LoyaltyPointsExpiryDays: getEnvInt("LOYALTY_POINTS_EXPIRY_DAYS", 365),
+	}
+}
+
+// getEnv reads an environment variable, falling back to a default when unset.
+func getEnv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// getEnvInt reads an integer environment variable, falling back to a default when unset or invalid.
+func getEnvInt(key string, fallback int) int {
+	if value, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		return value
 	}
+	return fallback
+}
+
+// getEnvAmount reads a money amount environment variable, falling back to a default when unset or invalid.
+func getEnvAmount(key string, fallback money.Amount) money.Amount {
+	if value, err := money.Parse(os.Getenv(key)); err == nil {
+		return value
+	}
+	return fallback
 }
 
 func InitDB(dsn string) (*gorm.DB, error) {
@@ -29,10 +104,44 @@ func InitDB(dsn string) (*gorm.DB, error) {
 		return nil, err
 	}
 	db.Exec("CREATE EXTENSION IF NOT EXISTS \"uuid-ossp\";")
-	err = db.AutoMigrate(&User{}, &Product{}, &Order{}, &OrderItem{})
+	if err := checkMoneyColumns(db); err != nil {
+		return nil, err
+	}
+	err = db.AutoMigrate(&User{}, &Product{}, &Order{}, &OrderItem{}, &ImportJob{},
+		&ProductPriceChange{}, &ScheduledPrice{}, &Cart{}, &CartItem{}, &IdempotencyKey{},
+		&OrderEvent{}, &Payment{}, &Refund{}, &RefundItem{},
+		&ReturnRequest{}, &ReturnItem{}, &ReturnPhoto{}, &ProductPrice{}, &ExchangeRate{},
+		&TaxZone{}, &TaxRate{}, &OrderTaxLine{},
+		&UserAddress{}, &ShippingZone{}, &ShippingMethod{},
+		&Shipment{}, &ShipmentItem{}, &Promotion{}, &PromotionRedemption{}, &OrderDiscount{},
+		&GiftCard{}, &GiftCardTransaction{}, &StoreCreditAccount{}, &StoreCreditTransaction{}, &OrderTender{},
+		&LoyaltyRule{}, &LoyaltyAccount{}, &LoyaltyTransaction{})
 	return db, err
 }
 
+// checkMoneyColumns refuses to start against a schema whose prices are still
+// stored as floating point. AutoMigrate would cast those columns to bigint
+// without scaling them to cents, so migration 000014 has to run first.
+func checkMoneyColumns(db *gorm.DB) error {
+	if !db.Migrator().HasTable(&Product{}) {
+		return nil
+	}
+	columns, err := db.Migrator().ColumnTypes(&Product{})
+	if err != nil {
+		return err
+	}
+	for _, col := range columns {
+		if col.Name() != "price" {
+			continue
+		}
+		switch strings.ToLower(col.DatabaseTypeName()) {
+		case "float4", "float8", "numeric", "real", "double precision":
+			return errors.New("products.price is not stored in minor units; run migration 000014_money_minor_units before starting")
+		}
+	}
+	return nil
+}
+
 // Models
 type User struct {
 	ID        string `gorm:"primaryKey" json:"id" json:"id"`
@@ -45,31 +154,743 @@ type User struct {
 }
 
 type Product struct {
-	ID          string     `gorm:"primaryKey" json:"id" json:"id"`
-	Name        string     `json:"name"`
-	Description string     `json:"description"`
-	ImageURL    string     `json:"image_url"`
-	Price       float64    `json:"price"`
-	Stock       int        `json:"stock"`
-	Category    string     `json:"category"`
-	UserID      *uuid.UUID `json:"user_id"`
-	CreatedAt   time.Time
-	UpdatedAt   time.Time
+	ID             string        `gorm:"primaryKey" json:"id" json:"id"`
+	SKU            *string       `gorm:"uniqueIndex" json:"sku"`
+	Name           string        `json:"name"`
+	Description    string        `json:"description"`
+	ImageURL       string        `json:"image_url"`
+	Price          money.Amount  `json:"price"`
+	CompareAtPrice *money.Amount `json:"compare_at_price"`
+	Currency       string        `gorm:"-" json:"currency,omitempty"` // set when prices are shown in a chosen currency
+	Stock          int           `json:"stock"`
+	Category       string        `json:"category"`
+	TaxClass       string        `gorm:"size:32;not null;default:'standard'" json:"tax_class"`
+	WeightGrams    int           `gorm:"not null;default:0" json:"weight_grams"`
+	LengthMM       int           `gorm:"not null;default:0" json:"length_mm"`
+	WidthMM        int           `gorm:"not null;default:0" json:"width_mm"`
+	HeightMM       int           `gorm:"not null;default:0" json:"height_mm"`
+	GiftCard       bool          `gorm:"not null;default:false" json:"gift_card"` // each unit bought issues a gift card worth its price
+	UserID         *uuid.UUID    `json:"user_id"`
+	Version        int           `gorm:"not null;default:1" json:"version"`
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+// OnSale reports whether the product is priced below its compare-at price.
+func (p Product) OnSale() bool {
+	return p.CompareAtPrice != nil && *p.CompareAtPrice > p.Price
+}
+
+// DiscountPercent is the whole-number discount off the compare-at price, or 0.
+func (p Product) DiscountPercent() int {
+	if !p.OnSale() {
+		return 0
+	}
+	return int(math.Round(float64(*p.CompareAtPrice-p.Price) / float64(*p.CompareAtPrice) * 100))
+}
+
+// MarshalJSON adds the derived sale fields to the product representation.
+func (p Product) MarshalJSON() ([]byte, error) {
+	type product Product
+	return json.Marshal(struct {
+		product
+		OnSale          bool `json:"on_sale"`
+		DiscountPercent int  `json:"discount_percent"`
+	}{product(p), p.OnSale(), p.DiscountPercent()})
+}
+
+// ProductPrice overrides a product's price in one currency; without an
+// override the base price is converted with the exchange rate.
+type ProductPrice struct {
+	ID             string        `gorm:"primaryKey" json:"id"`
+	ProductID      string        `gorm:"not null;uniqueIndex:idx_product_prices_product_currency" json:"product_id"`
+	Currency       string        `gorm:"size:3;not null;uniqueIndex:idx_product_prices_product_currency" json:"currency"`
+	Price          money.Amount  `gorm:"not null" json:"price"`
+	CompareAtPrice *money.Amount `json:"compare_at_price"`
+	UpdatedBy      *uuid.UUID    `json:"updated_by"`
+	CreatedAt      time.Time     `json:"created_at"`
+	UpdatedAt      time.Time     `json:"updated_at"`
+}
+
+// ExchangeRate is how many units of Currency one unit of the store base
+// currency buys. Rate is kept as decimal text so it converts exactly.
+type ExchangeRate struct {
+	Currency  string     `gorm:"primaryKey;size:3" json:"currency"`
+	Rate      string     `gorm:"not null" json:"rate"`
+	UpdatedBy *uuid.UUID `json:"updated_by"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+// TaxClassStandard is the tax class of products that don't name one.
+const TaxClassStandard = "standard"
+
+// TaxZone is a country, optionally narrowed to a region, with its tax rates.
+type TaxZone struct {
+	ID        string    `gorm:"primaryKey" json:"id"`
+	Name      string    `gorm:"not null" json:"name"`
+	Country   string    `gorm:"size:2;not null;index" json:"country"`
+	Region    string    `json:"region"`
+	Rates     []TaxRate `gorm:"foreignKey:TaxZoneID" json:"rates"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// TaxRate is a percentage charged on one tax class within a zone. Rate is
+// decimal text (e.g. "7.25") so it applies exactly.
+type TaxRate struct {
+	ID        string `gorm:"primaryKey" json:"id"`
+	TaxZoneID string `gorm:"index;not null" json:"tax_zone_id"`
+	Name      string `gorm:"not null" json:"name"`
+	TaxClass  string `gorm:"not null" json:"tax_class"`
+	Rate      string `gorm:"not null" json:"rate"`
+}
+
+// Address is a postal address stored inline on orders.
+type Address struct {
+	FullName   string `json:"full_name" binding:"required"`
+	Line1      string `json:"line1" binding:"required"`
+	Line2      string `json:"line2"`
+	City       string `json:"city" binding:"required"`
+	Region     string `json:"region"`
+	PostalCode string `json:"postal_code"`
+	Country    string `json:"country" binding:"required,len=2"` // ISO 3166-1 alpha-2
+	Phone      string `json:"phone"`
+}
+
+// UserAddress is an entry of a customer's address book.
+type UserAddress struct {
+	ID        string    `gorm:"primaryKey" json:"id"`
+	UserID    uuid.UUID `gorm:"index;not null" json:"user_id"`
+	Label     string    `json:"label"`
+	Address   `gorm:"embedded"`
+	IsDefault bool      `gorm:"not null;default:false" json:"is_default"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// CountryList is a set of ISO country codes stored as comma-separated text.
+type CountryList []string
+
+// GormDataType stores the list as text.
+func (CountryList) GormDataType() string {
+	return "text"
+}
+
+// Value implements driver.Valuer.
+func (l CountryList) Value() (driver.Value, error) {
+	return strings.Join(l, ","), nil
+}
+
+// Scan implements sql.Scanner.
+func (l *CountryList) Scan(value interface{}) error {
+	var raw string
+	switch v := value.(type) {
+	case string:
+		raw = v
+	case []byte:
+		raw = string(v)
+	case nil:
+	default:
+		return fmt.Errorf("cannot scan %T into CountryList", value)
+	}
+	*l = nil
+	if raw != "" {
+		*l = strings.Split(raw, ",")
+	}
+	return nil
+}
+
+// Contains reports whether country is in the list.
+func (l CountryList) Contains(country string) bool {
+	for _, c := range l {
+		if strings.EqualFold(c, country) {
+			return true
+		}
+	}
+	return false
+}
+
+// ShippingZone groups the countries that share shipping methods. A zone
+// without countries covers every country no other zone lists.
+type ShippingZone struct {
+	ID        string           `gorm:"primaryKey" json:"id"`
+	Name      string           `gorm:"not null" json:"name"`
+	Countries CountryList      `json:"countries"`
+	Methods   []ShippingMethod `gorm:"foreignKey:ShippingZoneID" json:"methods"`
+	CreatedAt time.Time        `json:"created_at"`
+	UpdatedAt time.Time        `json:"updated_at"`
 }
 
+// Shipping rate types
+const (
+	ShippingRateFlat     = "flat"      // Price per order
+	ShippingRateWeight   = "weight"    // Price plus PerKg for every started kilogram
+	ShippingRateFreeOver = "free_over" // Price, or free when the subtotal reaches FreeOver
+)
+
+// ShippingMethod is a delivery option of a zone. Prices are in the store base currency.
+type ShippingMethod struct {
+	ID             string        `gorm:"primaryKey" json:"id"`
+	ShippingZoneID string        `gorm:"index;not null" json:"shipping_zone_id"`
+	Name           string        `gorm:"not null" json:"name"`
+	RateType       string        `gorm:"not null" json:"rate_type"`
+	Price          money.Amount  `gorm:"not null" json:"price"`
+	PerKg          money.Amount  `gorm:"not null;default:0" json:"per_kg"`
+	FreeOver       *money.Amount `json:"free_over"`
+	Active         bool          `gorm:"not null" json:"active"`
+	CreatedAt      time.Time     `json:"created_at"`
+	UpdatedAt      time.Time     `json:"updated_at"`
+}
+
+// Order lifecycle states; see the transition table in controllers/order_status_controller.go.
+const (
+	OrderStatusPending    = "pending"
+	OrderStatusPaid       = "paid"
+	OrderStatusProcessing = "processing"
+	OrderStatusShipped    = "shipped"
+	OrderStatusDelivered  = "delivered"
+	OrderStatusCancelled  = "cancelled"
+	OrderStatusRefunded   = "refunded"
+	OrderStatusFailed     = "failed"
+)
+
+// Order refund states
+const (
+	RefundStatusPartial = "partially_refunded"
+	RefundStatusFull    = "refunded"
+)
+
 type Order struct {
-	ID         string      `gorm:"primaryKey" json:"id" json:"id"`
-	UserID     uuid.UUID   `json:"user_id"`
-	TotalPrice float64     `json:"total_price"`
-	Status     string      `json:"status"`
-	Items      []OrderItem `gorm:"foreignKey:OrderID" json:"items"`
-	CreatedAt  time.Time
+	ID              string     `gorm:"primaryKey" json:"id" json:"id"`
+	UserID          *uuid.UUID `json:"user_id"`
+	GuestEmail      string     `gorm:"index" json:"guest_email,omitempty"`
+	ShippingAddress Address    `gorm:"embedded;embeddedPrefix:shipping_" json:"shipping_address"`
+	Currency        string     `gorm:"size:3;not null" json:"currency"`
+	ExchangeRate    string     `json:"exchange_rate"` // base-to-order-currency rate at purchase
+	// Subtotal is the sum of the lines as charged. With TaxesIncluded the tax is
+	// part of it; otherwise it is added on top. TotalPrice also adds ShippingTotal.
+	Subtotal      money.Amount   `json:"subtotal"`
+	TaxTotal      money.Amount   `json:"tax_total"`
+	TaxesIncluded bool           `json:"taxes_included"`
+	TaxLines      []OrderTaxLine `gorm:"foreignKey:OrderID" json:"tax_lines"`
+	// DiscountTotal sums the discount lines, including waived shipping, so
+	// TotalPrice = Subtotal - DiscountTotal + ShippingTotal (+ TaxTotal unless included).
+	CouponCode    string          `json:"coupon_code,omitempty"`
+	DiscountTotal money.Amount    `json:"discount_total"`
+	Discounts     []OrderDiscount `gorm:"foreignKey:OrderID" json:"discounts"`
+	// The delivery option chosen at checkout; the name is a snapshot.
+	ShippingMethodID   *string      `json:"shipping_method_id"`
+	ShippingMethodName string       `json:"shipping_method_name"`
+	ShippingTotal      money.Amount `json:"shipping_total"`
+	TotalPrice         money.Amount `json:"total_price"` // grand total
+	// TenderTotal is paid with gift cards and store credit; the payment
+	// provider charges the rest of TotalPrice.
+	TenderTotal money.Amount  `json:"tender_total"`
+	Tenders     []OrderTender `gorm:"foreignKey:OrderID" json:"tenders,omitempty"`
+	GiftCards   []GiftCard    `gorm:"foreignKey:OrderID" json:"gift_cards,omitempty"` // bought with this order
+	// Loyalty points spent on the order's discount and earned once it was paid
+	PointsRedeemed int          `gorm:"not null;default:0" json:"points_redeemed"`
+	PointsEarned   int          `gorm:"not null;default:0" json:"points_earned"`
+	Status         string       `gorm:"index" json:"status"`
+	Items          []OrderItem  `gorm:"foreignKey:OrderID" json:"items"`
+	Events         []OrderEvent `gorm:"foreignKey:OrderID" json:"events,omitempty"`
+	// RefundStatus is derived from the order's refunds: "", "partially_refunded" or "refunded".
+	RefundStatus   string       `json:"refund_status,omitempty"`
+	RefundedAmount money.Amount `json:"refunded_amount"`
+	Refunds        []Refund     `gorm:"foreignKey:OrderID" json:"refunds,omitempty"`
+	// FulfillmentStatus is derived from the order's shipments; see the Fulfillment* constants.
+	FulfillmentStatus string     `gorm:"not null;default:unfulfilled" json:"fulfillment_status"`
+	Shipments         []Shipment `gorm:"foreignKey:OrderID" json:"shipments,omitempty"`
+	CreatedAt         time.Time
+}
+
+// OrderEvent is one entry of an order's status timeline.
+type OrderEvent struct {
+	ID         string     `gorm:"primaryKey" json:"id"`
+	OrderID    string     `gorm:"index" json:"order_id"`
+	FromStatus string     `json:"from_status"`
+	ToStatus   string     `json:"to_status"`
+	Reason     string     `json:"reason"`
+	ActorID    *uuid.UUID `json:"actor_id"`
+	CreatedAt  time.Time  `json:"created_at"`
 }
 
+// OrderItem is one line of an order. The product fields are a snapshot taken at
+// purchase time, so receipts survive later product edits and deletions.
 type OrderItem struct {
+	ID              string       `gorm:"primaryKey" json:"id"`
+	OrderID         string       `json:"order_id"`
+	ProductID       uuid.UUID    `json:"product_id"`
+	ProductName     string       `json:"product_name"`
+	ProductSKU      *string      `json:"product_sku"`
+	ProductImageURL string       `json:"product_image_url"`
+	Quantity        int          `json:"quantity"`
+	UnitPrice       money.Amount `json:"unit_price"`
+	TaxClass        string       `json:"tax_class"`
+	TaxAmount       money.Amount `json:"tax_amount"`      // tax on the whole line
+	DiscountAmount  money.Amount `json:"discount_amount"` // promotions on the whole line, before tax
+	GiftCard        bool         `json:"gift_card,omitempty"`
+}
+
+// Fulfillment states of an order, derived from how many of its units have
+// shipped and been delivered.
+const (
+	FulfillmentUnfulfilled      = "unfulfilled"
+	FulfillmentPartiallyShipped = "partially_shipped"
+	FulfillmentShipped          = "shipped"
+	FulfillmentDelivered        = "delivered"
+)
+
+// Shipment is one package sent for some or all of an order's items.
+type Shipment struct {
+	ID             string         `gorm:"primaryKey" json:"id"`
+	OrderID        string         `gorm:"index" json:"order_id"`
+	Carrier        string         `json:"carrier"`
+	TrackingNumber string         `json:"tracking_number"`
+	TrackingURL    string         `json:"tracking_url"`
+	ShippedAt      time.Time      `json:"shipped_at"`
+	DeliveredAt    *time.Time     `json:"delivered_at"`
+	Items          []ShipmentItem `gorm:"foreignKey:ShipmentID" json:"items"`
+	CreatedBy      *uuid.UUID     `json:"created_by"`
+	CreatedAt      time.Time      `json:"created_at"`
+	UpdatedAt      time.Time      `json:"updated_at"`
+}
+
+// ShipmentItem is the quantity of an order line packed in a shipment.
+type ShipmentItem struct {
+	ID          string `gorm:"primaryKey" json:"id"`
+	ShipmentID  string `gorm:"index" json:"shipment_id"`
+	OrderItemID string `gorm:"index" json:"order_item_id"`
+	Quantity    int    `json:"quantity"`
+}
+
+// OrderTaxLine is the tax an order collected under one rate, kept for invoices.
+type OrderTaxLine struct {
+	ID      string       `gorm:"primaryKey" json:"id"`
+	OrderID string       `gorm:"index" json:"order_id"`
+	Name    string       `json:"name"`
+	Rate    string       `json:"rate"` // percent
+	Amount  money.Amount `json:"amount"`
+}
+
+// StringList is a list of strings stored as a JSON array in a text column.
+type StringList []string
+
+// GormDataType stores the list as text.
+func (StringList) GormDataType() string {
+	return "text"
+}
+
+// Value implements driver.Valuer.
+func (l StringList) Value() (driver.Value, error) {
+	if l == nil {
+		l = StringList{}
+	}
+	b, err := json.Marshal([]string(l))
+	return string(b), err
+}
+
+// Scan implements sql.Scanner.
+func (l *StringList) Scan(value interface{}) error {
+	var raw []byte
+	switch v := value.(type) {
+	case string:
+		raw = []byte(v)
+	case []byte:
+		raw = v
+	case nil:
+		*l = nil
+		return nil
+	default:
+		return fmt.Errorf("cannot scan %T into StringList", value)
+	}
+	return json.Unmarshal(raw, (*[]string)(l))
+}
+
+// Contains reports whether s is in the list, ignoring case.
+func (l StringList) Contains(s string) bool {
+	for _, v := range l {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Promotion discount types
+const (
+	DiscountPercent      = "percent"       // Percent off the matching lines
+	DiscountFixed        = "fixed"         // Amount off the matching lines, spread over them
+	DiscountFreeShipping = "free_shipping" // the order's shipping cost is waived
+	DiscountPoints       = "points"        // loyalty points redeemed at checkout, spread like a fixed discount
+)
+
+// Promotion is a discount applied to every qualifying order, or only to orders
+// quoting its coupon Code. Amounts are in the store base currency. Empty
+// ProductIDs and Categories mean the whole order qualifies.
+type Promotion struct {
+	ID           string       `gorm:"primaryKey" json:"id"`
+	Name         string       `gorm:"not null" json:"name"`
+	Code         *string      `gorm:"uniqueIndex" json:"code"` // nil for automatic promotions
+	DiscountType string       `gorm:"not null" json:"discount_type"`
+	Percent      string       `json:"percent,omitempty"`
+	Amount       money.Amount `gorm:"not null;default:0" json:"amount"`
+	MinSubtotal  money.Amount `gorm:"not null;default:0" json:"min_subtotal"`
+	ProductIDs   StringList   `json:"product_ids"`
+	Categories   StringList   `json:"categories"`
+	UsageLimit   *int         `json:"usage_limit"`    // across all customers
+	PerUserLimit *int         `json:"per_user_limit"` // per account; guests can't use these promotions
+	TimesUsed    int          `gorm:"not null;default:0" json:"times_used"`
+	StartsAt     *time.Time   `json:"starts_at"`
+	EndsAt       *time.Time   `json:"ends_at"`
+	Active       bool         `gorm:"not null" json:"active"`
+	CreatedAt    time.Time    `json:"created_at"`
+	UpdatedAt    time.Time    `json:"updated_at"`
+}
+
+// PromotionRedemption records a promotion used by an order, for usage limits.
+type PromotionRedemption struct {
+	ID          string     `gorm:"primaryKey" json:"id"`
+	PromotionID string     `gorm:"index" json:"promotion_id"`
+	OrderID     string     `gorm:"index" json:"order_id"`
+	UserID      *uuid.UUID `gorm:"index" json:"user_id"`
+	GuestEmail  string     `gorm:"index" json:"guest_email,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// OrderDiscount is the amount one promotion, or the loyalty points redeemed,
+// took off an order. The name and code are snapshots.
+type OrderDiscount struct {
+	ID          string       `gorm:"primaryKey" json:"id"`
+	OrderID     string       `gorm:"index" json:"order_id"`
+	PromotionID *string      `json:"promotion_id"` // nil for redeemed loyalty points
+	Name        string       `json:"name"`
+	Code        string       `json:"code,omitempty"`
+	Type        string       `json:"type"`
+	Amount      money.Amount `json:"amount"`
+}
+
+// ImportJob tracks a bulk product import and its per-row validation report.
+type ImportJob struct {
+	ID         string     `gorm:"primaryKey" json:"id"`
+	Filename   string     `json:"filename"`
+	Format     string     `json:"format"`
+	DryRun     bool       `json:"dry_run"`
+	Status     string     `json:"status"`
+	TotalRows  int        `json:"total_rows"`
+	Created    int        `json:"created"`
+	Updated    int        `json:"updated"`
+	Failed     int        `json:"failed"`
+	Errors     string     `gorm:"type:text" json:"-"`
+	UserID     *uuid.UUID `json:"user_id"`
+	CreatedAt  time.Time
+	FinishedAt *time.Time `json:"finished_at"`
+}
+
+// ProductPriceChange is one entry in a product's price history.
+type ProductPriceChange struct {
+	ID               string       `gorm:"primaryKey" json:"id"`
+	ProductID        string       `gorm:"index" json:"product_id"`
+	OldPrice         money.Amount `json:"old_price"`
+	NewPrice         money.Amount `json:"new_price"`
+	Source           string       `json:"source"`
+	ScheduledPriceID *string      `json:"scheduled_price_id"`
+	ChangedBy        *uuid.UUID   `json:"changed_by"`
+	CreatedAt        time.Time
+}
+
+// ScheduledPrice is a future price applied to a product between StartsAt and EndsAt.
+type ScheduledPrice struct {
+	ID          string        `gorm:"primaryKey" json:"id"`
+	ProductID   string        `gorm:"index" json:"product_id"`
+	Price       money.Amount  `json:"price"`
+	StartsAt    time.Time     `json:"starts_at"`
+	EndsAt      *time.Time    `json:"ends_at"`
+	Status      string        `gorm:"index" json:"status"`
+	RevertPrice *money.Amount `json:"revert_price"`
+	CreatedBy   *uuid.UUID    `json:"created_by"`
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// Cart is a server-side shopping cart owned by a user.
+type Cart struct {
+	ID        string     `gorm:"primaryKey" json:"id"`
+	UserID    *uuid.UUID `gorm:"uniqueIndex" json:"user_id"`
+	Items     []CartItem `gorm:"foreignKey:CartID" json:"items"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// CartItem is a product and quantity held in a cart; prices are always read live.
+type CartItem struct {
 	ID        string    `gorm:"primaryKey" json:"id"`
-	OrderID   string    `json:"order_id"`
-	ProductID uuid.UUID `json:"product_id"`
+	CartID    string    `gorm:"uniqueIndex:idx_cart_items_cart_product" json:"cart_id"`
+	ProductID uuid.UUID `gorm:"uniqueIndex:idx_cart_items_cart_product" json:"product_id"`
 	Quantity  int       `json:"quantity"`
-	UnitPrice float64   `json:"unit_price"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// IdempotencyKey remembers the response to a request sent with an Idempotency-Key header.
+type IdempotencyKey struct {
+	ID              string    `gorm:"primaryKey" json:"id"`
+	Scope           string    `gorm:"uniqueIndex:idx_idempotency_keys_scope_key" json:"scope"`
+	Key             string    `gorm:"uniqueIndex:idx_idempotency_keys_scope_key" json:"key"`
+	Fingerprint     string    `json:"fingerprint"`
+	Completed       bool      `json:"completed"`
+	ResponseStatus  int       `json:"response_status"`
+	ResponseHeaders string    `gorm:"type:text" json:"-"` // JSON of the headers sent again on replay
+	ResponseBody    string    `gorm:"type:text" json:"-"`
+	CreatedAt       time.Time `gorm:"index"`
+}
+
+// Payment lifecycle
+const (
+	PaymentStatusPending   = "pending"
+	PaymentStatusSucceeded = "succeeded"
+	PaymentStatusFailed    = "failed"
+	PaymentStatusExpired   = "expired"
+	// PaymentStatusVoided is an authorization that arrived after the order was closed; it is never captured.
+	PaymentStatusVoided = "voided"
+)
+
+// Payment is an attempt to collect an order's total through the payment provider.
+type Payment struct {
+	ID             string       `gorm:"primaryKey" json:"id"`
+	OrderID        string       `gorm:"index" json:"order_id"`
+	Provider       string       `json:"provider"`
+	IntentID       string       `gorm:"uniqueIndex" json:"intent_id"`
+	ClientSecret   string       `json:"client_secret"`
+	Amount         money.Amount `json:"amount"`
+	Currency       string       `json:"currency"`
+	Status         string       `gorm:"index" json:"status"`
+	FailureMessage string       `json:"failure_message,omitempty"`
+	ExpiresAt      time.Time    `json:"expires_at"`
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+// Card payout states of a refund. Refunds with nothing going back to the card have none.
+const (
+	ProviderRefundPending   = "pending"
+	ProviderRefundSucceeded = "succeeded"
+	ProviderRefundFailed    = "failed"
+)
+
+// Refund is money given back on a paid order, for some lines or a custom amount.
+type Refund struct {
+	ID               string       `gorm:"primaryKey" json:"id"`
+	OrderID          string       `gorm:"index" json:"order_id"`
+	PaymentID        *string      `json:"payment_id"` // nil when nothing went back to the card
+	ProviderRefundID string       `json:"provider_refund_id"`
+	Amount           money.Amount `json:"amount"`
+	// Amount is split between the card payment, the order's gift card and
+	// store credit tenders, and store credit the customer asked for instead.
+	ProviderAmount    money.Amount `json:"provider_amount"`
+	TenderAmount      money.Amount `json:"tender_amount"`
+	StoreCreditAmount money.Amount `json:"store_credit_amount"`
+	// ProviderStatus tracks paying ProviderAmount back to the card, which
+	// happens after the refund is committed and can be retried.
+	ProviderStatus string       `gorm:"size:20;not null;default:''" json:"provider_status,omitempty"`
+	ProviderError  string       `json:"provider_error,omitempty"`
+	Reason         string       `json:"reason"`
+	Restock        bool         `json:"restock"`
+	Items          []RefundItem `gorm:"foreignKey:RefundID" json:"items"`
+	CreatedBy      *uuid.UUID   `json:"created_by"`
+	CreatedAt      time.Time    `json:"created_at"`
+}
+
+// RefundItem is the quantity of an order line covered by a refund.
+type RefundItem struct {
+	ID          string       `gorm:"primaryKey" json:"id"`
+	RefundID    string       `gorm:"index" json:"refund_id"`
+	OrderItemID string       `gorm:"index" json:"order_item_id"`
+	Quantity    int          `json:"quantity"`
+	Amount      money.Amount `json:"amount"`
+}
+
+// Return request lifecycle
+const (
+	ReturnStatusRequested = "requested"
+	ReturnStatusApproved  = "approved"
+	ReturnStatusRejected  = "rejected"
+	ReturnStatusReceived  = "received"
+)
+
+// What happens to received return items
+const (
+	ReturnDispositionRestock  = "restock"
+	ReturnDispositionWriteOff = "write_off"
+)
+
+// ReturnRequest is a customer's request to send back some order lines (an RMA).
+type ReturnRequest struct {
+	ID          string        `gorm:"primaryKey" json:"id"`
+	OrderID     string        `gorm:"index" json:"order_id"`
+	UserID      *uuid.UUID    `gorm:"index" json:"user_id"`
+	Status      string        `gorm:"index" json:"status"`
+	Reason      string        `json:"reason"`
+	AdminNote   string        `json:"admin_note,omitempty"`
+	Disposition string        `json:"disposition,omitempty"`
+	RefundID    *string       `json:"refund_id"`
+	Items       []ReturnItem  `gorm:"foreignKey:ReturnRequestID" json:"items"`
+	Photos      []ReturnPhoto `gorm:"foreignKey:ReturnRequestID" json:"photos"`
+	CreatedAt   time.Time     `json:"created_at"`
+	UpdatedAt   time.Time     `json:"updated_at"`
+}
+
+// ReturnItem is a quantity of an order line included in a return request.
+type ReturnItem struct {
+	ID              string `gorm:"primaryKey" json:"id"`
+	ReturnRequestID string `gorm:"index" json:"return_request_id"`
+	OrderItemID     string `gorm:"index" json:"order_item_id"`
+	Quantity        int    `json:"quantity"`
+}
+
+// ReturnPhoto is a picture the customer attached to a return request.
+type ReturnPhoto struct {
+	ID              string `gorm:"primaryKey" json:"id"`
+	ReturnRequestID string `gorm:"index" json:"return_request_id"`
+	URL             string `json:"url"`
+}
+
+// Kinds of gift card and store credit ledger entries
+const (
+	LedgerIssue   = "issue"   // gift card bought or issued by an admin
+	LedgerGrant   = "grant"   // goodwill store credit or a correction from support
+	LedgerRedeem  = "redeem"  // spent on an order
+	LedgerRelease = "release" // given back when an unpaid order is cancelled or fails
+	LedgerRefund  = "refund"  // an order refund paid back
+	LedgerVoid    = "void"    // a bought gift card refunded before use
+)
+
+// GiftCard is a prepaid balance anyone holding its code can spend, in the
+// currency it was issued in. Cards bought in an order reference its line.
+type GiftCard struct {
+	ID             string                `gorm:"primaryKey" json:"id"`
+	Code           string                `gorm:"uniqueIndex;not null" json:"code"`
+	Currency       string                `gorm:"size:3;not null" json:"currency"`
+	InitialBalance money.Amount          `gorm:"not null" json:"initial_balance"`
+	Balance        money.Amount          `gorm:"not null" json:"balance"`
+	OrderID        *string               `gorm:"index" json:"order_id,omitempty"`
+	OrderItemID    *string               `gorm:"index" json:"order_item_id,omitempty"`
+	PurchaserID    *uuid.UUID            `json:"purchaser_id,omitempty"`
+	RecipientEmail string                `json:"recipient_email,omitempty"`
+	Note           string                `json:"note,omitempty"`
+	ExpiresAt      *time.Time            `json:"expires_at"`
+	Active         bool                  `gorm:"not null" json:"active"`
+	CreatedBy      *uuid.UUID            `json:"created_by,omitempty"`
+	Transactions   []GiftCardTransaction `gorm:"foreignKey:GiftCardID" json:"transactions,omitempty"`
+	CreatedAt      time.Time             `json:"created_at"`
+	UpdatedAt      time.Time             `json:"updated_at"`
+}
+
+// GiftCardTransaction is one change to a gift card's balance; Amount is
+// negative when the balance went down.
+type GiftCardTransaction struct {
+	ID           string       `gorm:"primaryKey" json:"id"`
+	GiftCardID   string       `gorm:"index" json:"gift_card_id"`
+	Type         string       `json:"type"`
+	Amount       money.Amount `json:"amount"`
+	BalanceAfter money.Amount `json:"balance_after"`
+	OrderID      *string      `gorm:"index" json:"order_id,omitempty"`
+	RefundID     *string      `json:"refund_id,omitempty"`
+	Note         string       `json:"note,omitempty"`
+	CreatedBy    *uuid.UUID   `json:"created_by,omitempty"`
+	CreatedAt    time.Time    `json:"created_at"`
+}
+
+// StoreCreditAccount is a customer's store credit balance in one currency.
+type StoreCreditAccount struct {
+	ID        string       `gorm:"primaryKey" json:"id"`
+	UserID    uuid.UUID    `gorm:"uniqueIndex:idx_store_credit_accounts_user_currency;not null" json:"user_id"`
+	Currency  string       `gorm:"size:3;uniqueIndex:idx_store_credit_accounts_user_currency;not null" json:"currency"`
+	Balance   money.Amount `gorm:"not null;default:0" json:"balance"`
+	CreatedAt time.Time    `json:"created_at"`
+	UpdatedAt time.Time    `json:"updated_at"`
+}
+
+// StoreCreditTransaction is one entry of a store credit ledger; Amount is
+// negative when the balance went down.
+type StoreCreditTransaction struct {
+	ID           string       `gorm:"primaryKey" json:"id"`
+	AccountID    string       `gorm:"index" json:"account_id"`
+	UserID       uuid.UUID    `gorm:"index" json:"user_id"`
+	Currency     string       `gorm:"size:3" json:"currency"`
+	Type         string       `json:"type"`
+	Amount       money.Amount `json:"amount"`
+	BalanceAfter money.Amount `json:"balance_after"`
+	OrderID      *string      `gorm:"index" json:"order_id,omitempty"`
+	RefundID     *string      `json:"refund_id,omitempty"`
+	Note         string       `json:"note,omitempty"`
+	CreatedBy    *uuid.UUID   `json:"created_by,omitempty"`
+	CreatedAt    time.Time    `json:"created_at"`
+}
+
+// Order tender types
+const (
+	TenderGiftCard    = "gift_card"
+	TenderStoreCredit = "store_credit"
+)
+
+// OrderTender is part of an order paid with a gift card or store credit.
+type OrderTender struct {
+	ID             string       `gorm:"primaryKey" json:"id"`
+	OrderID        string       `gorm:"index" json:"order_id"`
+	Type           string       `json:"type"`
+	GiftCardID     *string      `json:"gift_card_id,omitempty"`
+	CodeLast4      string       `json:"code_last4,omitempty"`
+	Amount         money.Amount `json:"amount"`
+	RefundedAmount money.Amount `json:"refunded_amount"`
+	CreatedAt      time.Time    `json:"created_at"`
+}
+
+// LoyaltyRule sets how many points each unit of base currency spent earns.
+// A product rule beats a category rule, which beats the rule with neither.
+type LoyaltyRule struct {
+	ID            string    `gorm:"primaryKey" json:"id"`
+	Name          string    `gorm:"not null" json:"name"`
+	ProductID     *string   `gorm:"index" json:"product_id"`
+	Category      string    `gorm:"index" json:"category,omitempty"`
+	PointsPerUnit string    `gorm:"not null" json:"points_per_unit"` // decimal text, e.g. "1" or "2.5"; "0" earns nothing
+	Active        bool      `gorm:"not null" json:"active"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// LoyaltyAccount is a customer's spendable points: the sum of the
+// remaining points of their unexpired lots. It is negative while refunds have
+// taken back more earned points than the customer had left.
+type LoyaltyAccount struct {
+	UserID    uuid.UUID `gorm:"primaryKey" json:"user_id"`
+	Balance   int       `gorm:"not null;default:0" json:"balance"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Kinds of loyalty ledger entries
+const (
+	PointsEarn    = "earn"    // awarded for a paid order
+	PointsRedeem  = "redeem"  // spent on an order discount
+	PointsReverse = "reverse" // earned points taken back after a refund or cancellation
+	PointsRestore = "restore" // redeemed points given back after a refund or cancellation
+	PointsExpire  = "expire"  // a lot ran out of time
+	PointsAdjust  = "adjust"  // a correction by support
+)
+
+// LoyaltyTransaction is one entry of a customer's points ledger. Entries that
+// add points are lots: Remaining counts what is left of them, and they are
+// spent oldest first and expire as a whole.
+type LoyaltyTransaction struct {
+	ID           string     `gorm:"primaryKey" json:"id"`
+	UserID       uuid.UUID  `gorm:"index" json:"user_id"`
+	Type         string     `json:"type"`
+	Points       int        `json:"points"` // negative when points were taken off
+	BalanceAfter int        `json:"balance_after"`
+	Remaining    int        `gorm:"not null;default:0" json:"remaining,omitempty"`
+	OrderID      *string    `gorm:"index" json:"order_id,omitempty"`
+	Note         string     `json:"note,omitempty"`
+	CreatedBy    *uuid.UUID `json:"created_by,omitempty"`
+	ExpiresAt    *time.Time `gorm:"-" json:"expires_at,omitempty"` // set for lots when shown
+	CreatedAt    time.Time  `json:"created_at"`
 }
@@ -0,0 +1,157 @@
+package controllers
+
+import (
+	"errors"
+	"kalebecommerce/config"
+	"kalebecommerce/utils"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// addressInput is the body of CreateAddress and UpdateAddress.
+type addressInput struct {
+	config.Address
+	Label     string `json:"label"`
+	IsDefault bool   `json:"is_default"`
+}
+
+// Errors returned when an order names no usable shipping address.
+var (
+	errAddressRequired = errors.New("a shipping address is required")
+	errAddressNotFound = errors.New("address not found in your address book")
+)
+
+// normalizeAddress uppercases the country and region codes used for tax and shipping.
+func normalizeAddress(a config.Address) config.Address {
+	a.Country = strings.ToUpper(a.Country)
+	a.Region = strings.ToUpper(strings.TrimSpace(a.Region))
+	return a
+}
+
+// resolveShippingAddress picks the address of a signed-in customer's order: a
+// saved address by ID, an address sent inline, or the default saved address.
+func resolveShippingAddress(db *gorm.DB, uid uuid.UUID, addressID string, inline *config.Address) (config.Address, error) {
+	var saved config.UserAddress
+	switch {
+	case addressID != "":
+		if err := db.First(&saved, "id = ? AND user_id = ?", addressID, uid).Error; err != nil {
+			return config.Address{}, errAddressNotFound
+		}
+	case inline != nil:
+		return normalizeAddress(*inline), nil
+	default:
+		if err := db.First(&saved, "user_id = ? AND is_default = ?", uid, true).Error; err != nil {
+			return config.Address{}, errAddressRequired
+		}
+	}
+	return saved.Address, nil
+}
+
+// saveUserAddress writes addr, keeping exactly one default per customer.
+func saveUserAddress(db *gorm.DB, addr *config.UserAddress) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var others int64
+		tx.Model(&config.UserAddress{}).Where("user_id = ? AND id <> ?", addr.UserID, addr.ID).Count(&others)
+		if others == 0 {
+			addr.IsDefault = true
+		}
+		if addr.IsDefault {
+			if err := tx.Model(&config.UserAddress{}).Where("user_id = ? AND id <> ?", addr.UserID, addr.ID).
+				Update("is_default", false).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Save(addr).Error
+	})
+}
+
+// ListAddresses - the caller's address book, default first
+func ListAddresses(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var addresses []config.UserAddress
+		db.Where("user_id = ?", c.GetString("user_id")).Order("is_default DESC, created_at").Find(&addresses)
+		utils.JSON(c, http.StatusOK, true, "addresses listed", addresses, nil)
+	}
+}
+
+// CreateAddress - adds an address; the first one becomes the default
+func CreateAddress(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var in addressInput
+		if err := c.ShouldBindJSON(&in); err != nil {
+			utils.JSON(c, http.StatusBadRequest, false, "validation error", nil, err.Error())
+			return
+		}
+		uid, _ := uuid.Parse(c.GetString("user_id"))
+		addr := config.UserAddress{
+			ID:        uuid.New().String(),
+			UserID:    uid,
+			Label:     strings.TrimSpace(in.Label),
+			Address:   normalizeAddress(in.Address),
+			IsDefault: in.IsDefault,
+		}
+		if err := saveUserAddress(db, &addr); err != nil {
+			utils.JSON(c, http.StatusInternalServerError, false, "failed to save address", nil, err.Error())
+			return
+		}
+		utils.JSON(c, http.StatusCreated, true, "address saved", addr, nil)
+	}
+}
+
+// UpdateAddress - replaces one of the caller's addresses
+func UpdateAddress(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var addr config.UserAddress
+		if err := db.First(&addr, "id = ? AND user_id = ?", c.Param("id"), c.GetString("user_id")).Error; err != nil {
+			utils.JSON(c, http.StatusNotFound, false, "address not found", nil, nil)
+			return
+		}
+		var in addressInput
+		if err := c.ShouldBindJSON(&in); err != nil {
+			utils.JSON(c, http.StatusBadRequest, false, "validation error", nil, err.Error())
+			return
+		}
+		addr.Label = strings.TrimSpace(in.Label)
+		addr.Address = normalizeAddress(in.Address)
+		// The default can move to another address but not be cleared outright
+		addr.IsDefault = addr.IsDefault || in.IsDefault
+		if err := saveUserAddress(db, &addr); err != nil {
+			utils.JSON(c, http.StatusInternalServerError, false, "failed to save address", nil, err.Error())
+			return
+		}
+		utils.JSON(c, http.StatusOK, true, "address saved", addr, nil)
+	}
+}
+
+// DeleteAddress - removes an address; the newest remaining one becomes the default
+func DeleteAddress(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var addr config.UserAddress
+		if err := db.First(&addr, "id = ? AND user_id = ?", c.Param("id"), c.GetString("user_id")).Error; err != nil {
+			utils.JSON(c, http.StatusNotFound, false, "address not found", nil, nil)
+			return
+		}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Delete(&addr).Error; err != nil {
+				return err
+			}
+			if !addr.IsDefault {
+				return nil
+			}
+			var next config.UserAddress
+			if err := tx.Where("user_id = ?", addr.UserID).Order("created_at DESC").First(&next).Error; err != nil {
+				return nil
+			}
+			return tx.Model(&next).Update("is_default", true).Error
+		})
+		if err != nil {
+			utils.JSON(c, http.StatusInternalServerError, false, "failed to delete address", nil, err.Error())
+			return
+		}
+		utils.JSON(c, http.StatusOK, true, "address deleted", nil, nil)
+	}
+}
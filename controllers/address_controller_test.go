@@ -0,0 +1,77 @@
+package controllers
+
+import (
+	"encoding/json"
+	"kalebecommerce/config"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddressBook_KeepsOneDefault(t *testing.T) {
+	db := setupTestDB(t)
+	router := setupRouter()
+	userID := uuid.New().String()
+	router.GET("/addresses", mockAuthMiddleware(userID), ListAddresses(db))
+	router.POST("/addresses", mockAuthMiddleware(userID), CreateAddress(db))
+	router.PUT("/addresses/:id", mockAuthMiddleware(userID), UpdateAddress(db))
+	router.DELETE("/addresses/:id", mockAuthMiddleware(userID), DeleteAddress(db))
+	router.PUT("/other/addresses/:id", mockAuthMiddleware(uuid.New().String()), UpdateAddress(db))
+
+	create := func(body string) config.UserAddress {
+		w := sendJSON(router, "POST", "/addresses", body)
+		assert.Equal(t, http.StatusCreated, w.Code)
+		var response struct {
+			Object config.UserAddress `json:"object"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return response.Object
+	}
+	list := func() []config.UserAddress {
+		req, _ := http.NewRequest("GET", "/addresses", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var response struct {
+			Object []config.UserAddress `json:"object"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return response.Object
+	}
+
+	assert.Equal(t, http.StatusBadRequest, sendJSON(router, "POST", "/addresses", `{"label":"Home","city":"Addis Ababa"}`).Code)
+
+	home := create(`{"label":"Home","full_name":"Abebe Kebede","line1":"Bole Road 12","city":"Addis Ababa","country":"et"}`)
+	assert.True(t, home.IsDefault) // the first address is the default
+	assert.Equal(t, "ET", home.Country)
+
+	office := create(`{"label":"Office","full_name":"Abebe Kebede","line1":"Kenyatta Ave 3","city":"Nairobi","country":"KE","is_default":true}`)
+	assert.True(t, office.IsDefault)
+	if found := list(); assert.Len(t, found, 2) {
+		assert.Equal(t, office.ID, found[0].ID)
+		assert.False(t, found[1].IsDefault)
+	}
+
+	body := `{"label":"Old office","full_name":"Abebe Kebede","line1":"Kenyatta Ave 3","city":"Nairobi","country":"KE"}`
+	assert.Equal(t, http.StatusNotFound, sendJSON(router, "PUT", "/other/addresses/"+office.ID, body).Code)
+	assert.Equal(t, http.StatusOK, sendJSON(router, "PUT", "/addresses/"+office.ID, body).Code)
+
+	// Deleting the default hands it to the remaining address
+	req, _ := http.NewRequest("DELETE", "/addresses/"+office.ID, nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	if found := list(); assert.Len(t, found, 1) {
+		assert.Equal(t, home.ID, found[0].ID)
+		assert.True(t, found[0].IsDefault)
+	}
+
+	// Orders can ship to a saved address by ID
+	address, err := resolveShippingAddress(db, uuid.MustParse(userID), home.ID, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "Bole Road 12", address.Line1)
+	_, err = resolveShippingAddress(db, uuid.New(), home.ID, nil)
+	assert.ErrorIs(t, err, errAddressNotFound)
+}
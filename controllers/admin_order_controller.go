@@ -0,0 +1,172 @@
+package controllers
+
+import (
+	"fmt"
+	"kalebecommerce/config"
+	"kalebecommerce/money"
+	"kalebecommerce/utils"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// orderSortColumns maps the accepted ?sort= values to columns.
+var orderSortColumns = map[string]string{
+	"created_at":  "orders.created_at",
+	"total_price": "orders.total_price",
+	"status":      "orders.status",
+}
+
+// parseOrderDate accepts RFC 3339 timestamps or plain dates; a plain "to" date
+// includes the whole day.
+func parseOrderDate(raw string, endOfDay bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", raw)
+	if err != nil {
+		return t, fmt.Errorf("must be an RFC 3339 timestamp or YYYY-MM-DD date")
+	}
+	if endOfDay {
+		t = t.Add(24*time.Hour - time.Nanosecond)
+	}
+	return t, nil
+}
+
+// filterOrders applies the admin order filters from the query string and
+// returns per-parameter errors for values that can't be parsed.
+func filterOrders(c *gin.Context, query *gorm.DB) (*gorm.DB, map[string]string) {
+	errs := map[string]string{}
+
+	if raw := c.Query("status"); raw != "" {
+		statuses := strings.Split(raw, ",")
+		for _, s := range statuses {
+			if _, ok := orderTransitions[s]; !ok {
+				errs["status"] = "unknown order status " + s
+			}
+		}
+		query = query.Where("orders.status IN ?", statuses)
+	}
+	if raw := c.Query("fulfillment_status"); raw != "" {
+		statuses := strings.Split(raw, ",")
+		for _, s := range statuses {
+			if !fulfillmentStatuses[s] {
+				errs["fulfillment_status"] = "unknown fulfillment status " + s
+			}
+		}
+		query = query.Where("orders.fulfillment_status IN ?", statuses)
+	}
+	if raw := c.Query("from"); raw != "" {
+		if t, err := parseOrderDate(raw, false); err != nil {
+			errs["from"] = err.Error()
+		} else {
+			query = query.Where("orders.created_at >= ?", t)
+		}
+	}
+	if raw := c.Query("to"); raw != "" {
+		if t, err := parseOrderDate(raw, true); err != nil {
+			errs["to"] = err.Error()
+		} else {
+			query = query.Where("orders.created_at <= ?", t)
+		}
+	}
+	if email := strings.ToLower(c.Query("email")); email != "" {
+		pattern := "%" + email + "%"
+		query = query.Where("LOWER(orders.guest_email) LIKE ? OR orders.user_id IN (?)", pattern,
+			query.Session(&gorm.Session{NewDB: true}).Model(&config.User{}).Select("id").Where("LOWER(email) LIKE ?", pattern))
+	}
+	if productID := c.Query("product_id"); productID != "" {
+		query = query.Where("orders.id IN (?)",
+			query.Session(&gorm.Session{NewDB: true}).Model(&config.OrderItem{}).Select("order_id").Where("product_id = ?", productID))
+	}
+	if product := strings.ToLower(c.Query("product")); product != "" {
+		pattern := "%" + product + "%"
+		query = query.Where("orders.id IN (?)",
+			query.Session(&gorm.Session{NewDB: true}).Model(&config.OrderItem{}).Select("order_id").
+				Where("LOWER(product_name) LIKE ? OR LOWER(product_sku) LIKE ?", pattern, pattern))
+	}
+	for param, op := range map[string]string{"min_total": ">=", "max_total": "<="} {
+		raw := c.Query(param)
+		if raw == "" {
+			continue
+		}
+		v, err := money.Parse(raw)
+		if err != nil {
+			errs[param] = param + " must be an amount with at most 2 decimals"
+			continue
+		}
+		query = query.Where("orders.total_price "+op+" ?", v)
+	}
+	return query, errs
+}
+
+// AdminListOrders (Admin) - all orders, filtered, sorted and paginated
+func AdminListOrders(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+		limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+		if page < 1 {
+			page = 1
+		}
+		if limit < 1 || limit > 100 {
+			limit = 20
+		}
+
+		query, errs := filterOrders(c, db.Model(&config.Order{}))
+
+		sort := c.DefaultQuery("sort", "-created_at")
+		direction := "ASC"
+		if strings.HasPrefix(sort, "-") {
+			sort, direction = sort[1:], "DESC"
+		}
+		column, ok := orderSortColumns[sort]
+		if !ok {
+			errs["sort"] = "sort must be created_at, total_price or status, optionally prefixed with -"
+		}
+		if len(errs) > 0 {
+			utils.JSON(c, http.StatusBadRequest, false, "validation error", nil, errs)
+			return
+		}
+
+		var total int64
+		if err := query.Count(&total).Error; err != nil {
+			utils.JSON(c, http.StatusInternalServerError, false, "failed to fetch orders", nil, err.Error())
+			return
+		}
+		var orders []config.Order
+		err := query.Preload("Items").Preload("TaxLines").Order(column + " " + direction).Order("orders.id").
+			Offset((page - 1) * limit).Limit(limit).Find(&orders).Error
+		if err != nil {
+			utils.JSON(c, http.StatusInternalServerError, false, "failed to fetch orders", nil, err.Error())
+			return
+		}
+
+		utils.JSON(c, http.StatusOK, true, "orders listed",
+			gin.H{
+				"currentPage": page,
+				"pageSize":    limit,
+				"totalOrders": total,
+				"orders":      orders,
+			}, nil)
+	}
+}
+
+// AdminGetOrder (Admin) - any order with its items, timeline and refunds
+func AdminGetOrder(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var order config.Order
+		err := db.Preload("Items").Preload("TaxLines").Preload("Discounts").Preload("Tenders").Preload("GiftCards").Preload("Shipments.Items").
+			Preload("Events", func(tx *gorm.DB) *gorm.DB { return tx.Order("created_at, id") }).
+			Preload("Refunds.Items").
+			First(&order, "id = ?", c.Param("id")).Error
+		if err != nil {
+			utils.JSON(c, http.StatusNotFound, false, "order not found", nil, nil)
+			return
+		}
+		utils.JSON(c, http.StatusOK, true, "order retrieved", order, nil)
+	}
+}
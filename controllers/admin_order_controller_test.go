@@ -0,0 +1,111 @@
+package controllers
+
+import (
+	"encoding/json"
+	"kalebecommerce/config"
+	"kalebecommerce/money"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// adminOrdersResponse decodes a page of the admin order list
+func adminOrdersResponse(t *testing.T, w *httptest.ResponseRecorder) (int64, []config.Order) {
+	var response struct {
+		Object struct {
+			TotalOrders int64          `json:"totalOrders"`
+			Orders      []config.Order `json:"orders"`
+		} `json:"object"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	return response.Object.TotalOrders, response.Object.Orders
+}
+
+func TestAdminListOrders_Filters(t *testing.T) {
+	db := setupTestDB(t)
+	router := setupRouter()
+	router.GET("/admin/orders", mockAdminAuthMiddleware(), AdminListOrders(db))
+
+	userID := uuid.New()
+	db.Create(&config.User{ID: userID.String(), Username: "abebe", Email: "abebe@example.com"})
+	day := time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+	mugID := uuid.New()
+
+	orders := []config.Order{
+		{ID: uuid.New().String(), UserID: &userID, Status: config.OrderStatusPaid, TotalPrice: money.MustParse("40.00"), CreatedAt: day},
+		{ID: uuid.New().String(), GuestEmail: "guest@example.com", Status: config.OrderStatusPending, TotalPrice: money.MustParse("15.00"), CreatedAt: day.AddDate(0, 0, 1)},
+		{ID: uuid.New().String(), UserID: &userID, Status: config.OrderStatusShipped, TotalPrice: money.MustParse("120.00"), CreatedAt: day.AddDate(0, 0, 5)},
+	}
+	for _, o := range orders {
+		db.Create(&o)
+	}
+	db.Create(&config.OrderItem{ID: uuid.New().String(), OrderID: orders[1].ID, ProductID: mugID, ProductName: "Coffee Mug", Quantity: 1, UnitPrice: money.MustParse("15.00")})
+
+	cases := []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{orders[2].ID, orders[1].ID, orders[0].ID}},
+		{"?status=paid,shipped&sort=total_price", []string{orders[0].ID, orders[2].ID}},
+		{"?from=2025-03-10&to=2025-03-11", []string{orders[1].ID, orders[0].ID}},
+		{"?email=ABEBE@", []string{orders[2].ID, orders[0].ID}},
+		{"?email=guest&status=pending", []string{orders[1].ID}},
+		{"?product=mug", []string{orders[1].ID}},
+		{"?product_id=" + mugID.String(), []string{orders[1].ID}},
+		{"?min_total=20&max_total=100", []string{orders[0].ID}},
+		{"?sort=-total_price&limit=1&page=2", []string{orders[0].ID}},
+	}
+	for _, tc := range cases {
+		req, _ := http.NewRequest("GET", "/admin/orders"+tc.query, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code, tc.query)
+
+		_, got := adminOrdersResponse(t, w)
+		ids := []string{}
+		for _, o := range got {
+			ids = append(ids, o.ID)
+		}
+		assert.Equal(t, tc.want, ids, tc.query)
+	}
+}
+
+func TestAdminListOrders_InvalidFilters(t *testing.T) {
+	db := setupTestDB(t)
+	router := setupRouter()
+	router.GET("/admin/orders", mockAdminAuthMiddleware(), AdminListOrders(db))
+
+	req, _ := http.NewRequest("GET", "/admin/orders?status=lost&from=yesterday&sort=name", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "unknown order status lost")
+	assert.Contains(t, w.Body.String(), `"from"`)
+	assert.Contains(t, w.Body.String(), `"sort"`)
+}
+
+func TestAdminGetOrder_IncludesTimeline(t *testing.T) {
+	db := setupTestDB(t)
+	router := setupRouter()
+	router.GET("/admin/orders/:id", mockAdminAuthMiddleware(), AdminGetOrder(db))
+
+	order := config.Order{ID: uuid.New().String(), GuestEmail: "guest@example.com", Status: config.OrderStatusPending}
+	db.Create(&order)
+	recordOrderEvent(db, order.ID, "", config.OrderStatusPending, "order placed", nil)
+
+	req, _ := http.NewRequest("GET", "/admin/orders/"+order.ID, nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "order placed")
+
+	req, _ = http.NewRequest("GET", "/admin/orders/"+uuid.New().String(), nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -3,6 +3,7 @@ package controllers
 import (
 	"kalebecommerce/config"
 	"kalebecommerce/utils"
+	"log"
 	"net/http"
 	"time"
 
@@ -27,7 +28,7 @@ type LoginInput struct {
 
 // --- Register Handler ---
 
-func Register(db *gorm.DB) gin.HandlerFunc {
+func Register(db *gorm.DB, cfg *config.Config) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var input RegisterInput
 		if err := c.ShouldBindJSON(&input); err != nil {
@@ -72,6 +73,11 @@ func Register(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
+		// 5. Carry over a guest cart, if the request has one
+		if err := mergeGuestCart(db, cfg, c, uuid.MustParse(user.ID)); err != nil {
+			log.Printf("failed to merge guest cart for user %s: %v", user.ID, err)
+		}
+
 		utils.JSON(c, http.StatusCreated, true, "user created",
 			gin.H{"id": user.ID, "username": user.Username, "email": user.Email}, nil)
 	}
@@ -115,6 +121,11 @@ func Login(db *gorm.DB, cfg *config.Config) gin.HandlerFunc {
 			return
 		}
 
+		// 3. Carry over a guest cart, if the request has one
+		if err := mergeGuestCart(db, cfg, c, uuid.MustParse(user.ID)); err != nil {
+			log.Printf("failed to merge guest cart for user %s: %v", user.ID, err)
+		}
+
 		utils.JSON(c, http.StatusOK, true, "login successful", gin.H{"token": signed}, nil)
 	}
 }
@@ -16,8 +16,8 @@ import (
 func TestRegister_Success(t *testing.T) {
 	db := setupTestDB(t)
 	router := setupRouter()
-	// Assuming Register is defined as func Register(db *gorm.DB) gin.HandlerFunc
-	router.POST("/register", Register(db))
+	// Assuming Register is defined as func Register(db *gorm.DB, cfg *config.Config) gin.HandlerFunc
+	router.POST("/register", Register(db, mockConfig()))
 
 	body := RegisterInput{
 		Username: "kaleb",
@@ -40,8 +40,8 @@ func TestRegister_Success(t *testing.T) {
 func TestRegister_WeakPassword(t *testing.T) {
 	db := setupTestDB(t)
 	router := setupRouter()
-	// Assuming Register is defined as func Register(db *gorm.DB) gin.HandlerFunc
-	router.POST("/register", Register(db))
+	// Assuming Register is defined as func Register(db *gorm.DB, cfg *config.Config) gin.HandlerFunc
+	router.POST("/register", Register(db, mockConfig()))
 
 	body := RegisterInput{
 		Username: "weakuser",
@@ -65,8 +65,8 @@ func TestRegister_WeakPassword(t *testing.T) {
 func TestRegister_DuplicateUser(t *testing.T) {
 	db := setupTestDB(t)
 	router := setupRouter()
-	// Assuming Register is defined as func Register(db *gorm.DB) gin.HandlerFunc
-	router.POST("/register", Register(db))
+	// Assuming Register is defined as func Register(db *gorm.DB, cfg *config.Config) gin.HandlerFunc
+	router.POST("/register", Register(db, mockConfig()))
 
 	user := config.User{
 		ID:       uuid.New().String(),
@@ -0,0 +1,500 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"kalebecommerce/config"
+	"kalebecommerce/money"
+	"kalebecommerce/utils"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// errCartEmpty is returned when a cart has nothing left to check out.
+var errCartEmpty = errors.New("cart is empty")
+
+// cartLineView is a cart item enriched with the live product data.
+type cartLineView struct {
+	ProductID      string       `json:"product_id"`
+	Name           string       `json:"name"`
+	ImageURL       string       `json:"image_url"`
+	UnitPrice      money.Amount `json:"unit_price"`
+	Quantity       int          `json:"quantity"`
+	LineTotal      money.Amount `json:"line_total"`
+	AvailableStock int          `json:"available_stock"`
+	Warning        string       `json:"warning,omitempty"`
+}
+
+// cartView is the cart as returned to clients.
+type cartView struct {
+	ID          string         `json:"id"`
+	CartToken   string         `json:"cart_token,omitempty"`
+	Items       []cartLineView `json:"items"`
+	ItemCount   int            `json:"item_count"`
+	Currency    string         `json:"currency"`
+	Subtotal    money.Amount   `json:"subtotal"`
+	CanCheckout bool           `json:"can_checkout"`
+}
+
+// findUserCart loads the user's cart with its items, creating an empty one when asked to.
+func findUserCart(db *gorm.DB, uid uuid.UUID, create bool) (*config.Cart, error) {
+	var cart config.Cart
+	err := db.Preload("Items").First(&cart, "user_id = ?", uid).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) && create {
+		cart = config.Cart{ID: uuid.New().String(), UserID: &uid}
+		err = db.Create(&cart).Error
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &cart, nil
+}
+
+// Guest carts are identified by a signed token sent as a header or cookie.
+const (
+	cartTokenHeader = "X-Cart-Token"
+	cartTokenCookie = "cart_token"
+	cartTokenMaxAge = 30 * 24 * 60 * 60 // 30 days, in seconds
+)
+
+// guestCartID returns the guest cart ID carried by a valid cart token, if any.
+func guestCartID(c *gin.Context, cfg *config.Config) (string, bool) {
+	token := c.GetHeader(cartTokenHeader)
+	if token == "" {
+		token, _ = c.Cookie(cartTokenCookie)
+	}
+	value, ok := utils.VerifySignedValue(token, cfg.JWTSecret)
+	if !ok || !strings.HasPrefix(value, "cart:") {
+		return "", false
+	}
+	return strings.TrimPrefix(value, "cart:"), true
+}
+
+// issueCartToken hands a signed guest cart token to the client as header and cookie.
+func issueCartToken(c *gin.Context, cfg *config.Config, cartID string) {
+	token := utils.SignValue("cart:"+cartID, cfg.JWTSecret)
+	c.Header(cartTokenHeader, token)
+	c.SetCookie(cartTokenCookie, token, cartTokenMaxAge, "/", "", false, true)
+}
+
+// resolveCart returns the caller's cart: the account cart for logged-in users,
+// otherwise the guest cart named by the cart token (a new one is started when asked to).
+func resolveCart(db *gorm.DB, cfg *config.Config, c *gin.Context, create bool) (*config.Cart, error) {
+	if uid := currentUserID(c); uid != nil {
+		return findUserCart(db, *uid, create)
+	}
+
+	if cartID, ok := guestCartID(c, cfg); ok {
+		var cart config.Cart
+		err := db.Preload("Items").First(&cart, "id = ? AND user_id IS NULL", cartID).Error
+		if err == nil {
+			return &cart, nil
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+	}
+	if !create {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	cart := config.Cart{ID: uuid.New().String()}
+	if err := db.Create(&cart).Error; err != nil {
+		return nil, err
+	}
+	issueCartToken(c, cfg, cart.ID)
+	return &cart, nil
+}
+
+// mergedQuantity resolves a product held in both carts according to the configured strategy.
+func mergedQuantity(strategy string, account, guest int) int {
+	switch strategy {
+	case "max":
+		if guest > account {
+			return guest
+		}
+		return account
+	case "guest":
+		return guest
+	case "account":
+		return account
+	default:
+		return account + guest
+	}
+}
+
+// mergeGuestCart moves the items of the request's guest cart into the user's account
+// cart, then deletes the guest cart. It is a no-op when no valid cart token was sent.
+func mergeGuestCart(db *gorm.DB, cfg *config.Config, c *gin.Context, uid uuid.UUID) error {
+	cartID, ok := guestCartID(c, cfg)
+	if !ok {
+		return nil
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var guest config.Cart
+		if err := tx.Preload("Items").First(&guest, "id = ? AND user_id IS NULL", cartID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil
+			}
+			return err
+		}
+
+		account, err := findUserCart(tx, uid, false)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			// No account cart yet: the guest cart simply becomes it
+			return tx.Model(&guest).Update("user_id", uid).Error
+		}
+		if err != nil {
+			return err
+		}
+
+		existing := make(map[uuid.UUID]config.CartItem)
+		for _, item := range account.Items {
+			existing[item.ProductID] = item
+		}
+		for _, item := range guest.Items {
+			if mine, ok := existing[item.ProductID]; ok {
+				qty := mergedQuantity(cfg.CartMergeStrategy, mine.Quantity, item.Quantity)
+				if err := tx.Model(&mine).Update("quantity", qty).Error; err != nil {
+					return err
+				}
+				continue
+			}
+			if err := tx.Model(&item).Update("cart_id", account.ID).Error; err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Where("cart_id = ?", guest.ID).Delete(&config.CartItem{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&guest).Error
+	})
+	if err == nil {
+		c.SetCookie(cartTokenCookie, "", -1, "/", "", false, true)
+	}
+	return err
+}
+
+// buildCartView prices every line with the current product data in pr's
+// currency and flags stock problems.
+func buildCartView(db *gorm.DB, cart *config.Cart, pr *pricing) cartView {
+	view := cartView{ID: cart.ID, Items: []cartLineView{}, Currency: pr.Currency, CanCheckout: len(cart.Items) > 0}
+
+	for _, item := range cart.Items {
+		line := cartLineView{ProductID: item.ProductID.String(), Quantity: item.Quantity}
+
+		var p config.Product
+		if err := db.First(&p, "id = ?", item.ProductID).Error; err != nil {
+			line.Warning = "product is no longer available"
+			view.CanCheckout = false
+			view.Items = append(view.Items, line)
+			continue
+		}
+
+		price, err := pr.unitPrice(db, p)
+		if err != nil {
+			line.Warning = "price is unavailable"
+			view.CanCheckout = false
+			view.Items = append(view.Items, line)
+			continue
+		}
+
+		line.Name = p.Name
+		line.ImageURL = p.ImageURL
+		line.UnitPrice = price
+		line.AvailableStock = p.Stock
+		line.LineTotal = price.Mul(item.Quantity)
+		switch {
+		case p.Stock == 0:
+			line.Warning = "out of stock"
+			view.CanCheckout = false
+		case p.Stock < item.Quantity:
+			line.Warning = fmt.Sprintf("only %d left in stock", p.Stock)
+			view.CanCheckout = false
+		}
+
+		view.ItemCount += item.Quantity
+		view.Subtotal += line.LineTotal
+		view.Items = append(view.Items, line)
+	}
+	return view
+}
+
+// respondCart reloads the cart and writes its view; guests also get their cart token back.
+func respondCart(c *gin.Context, db *gorm.DB, cfg *config.Config, pr *pricing, cart *config.Cart, msg string) {
+	db.Preload("Items").First(cart, "id = ?", cart.ID)
+	view := buildCartView(db, cart, pr)
+	if cart.UserID == nil {
+		view.CartToken = utils.SignValue("cart:"+cart.ID, cfg.JWTSecret)
+	}
+	utils.JSON(c, http.StatusOK, true, msg, view, nil)
+}
+
+// GetCart - the caller's cart with live prices in ?currency=, subtotal and stock warnings
+func GetCart(db *gorm.DB, cfg *config.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		pr, ok := requestPricing(c, db, cfg)
+		if !ok {
+			return
+		}
+		cart, err := resolveCart(db, cfg, c, false)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			// Nothing stored yet: an empty cart is only created on the first write
+			utils.JSON(c, http.StatusOK, true, "cart retrieved", cartView{Items: []cartLineView{}, Currency: pr.Currency}, nil)
+			return
+		}
+		if err != nil {
+			utils.JSON(c, http.StatusInternalServerError, false, "failed to load cart", nil, err.Error())
+			return
+		}
+		respondCart(c, db, cfg, pr, cart, "cart retrieved")
+	}
+}
+
+// AddCartItem - adds a quantity of a product to the cart
+func AddCartItem(db *gorm.DB, cfg *config.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		pr, ok := requestPricing(c, db, cfg)
+		if !ok {
+			return
+		}
+		var in orderLine
+		if err := c.ShouldBindJSON(&in); err != nil {
+			utils.JSON(c, http.StatusBadRequest, false, "validation error", nil, err.Error())
+			return
+		}
+
+		var p config.Product
+		if err := db.First(&p, "id = ?", in.ProductID).Error; err != nil {
+			utils.JSON(c, http.StatusNotFound, false, "product not found", nil, nil)
+			return
+		}
+
+		cart, err := resolveCart(db, cfg, c, true)
+		if err != nil {
+			utils.JSON(c, http.StatusInternalServerError, false, "failed to load cart", nil, err.Error())
+			return
+		}
+
+		pid, _ := uuid.Parse(p.ID)
+		var item config.CartItem
+		err = db.Where("cart_id = ? AND product_id = ?", cart.ID, pid).First(&item).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			item = config.CartItem{ID: uuid.New().String(), CartID: cart.ID, ProductID: pid, Quantity: in.Quantity}
+			err = db.Create(&item).Error
+		} else if err == nil {
+			err = db.Model(&item).Update("quantity", gorm.Expr("quantity + ?", in.Quantity)).Error
+		}
+		if err != nil {
+			utils.JSON(c, http.StatusInternalServerError, false, "failed to update cart", nil, err.Error())
+			return
+		}
+		respondCart(c, db, cfg, pr, cart, "item added to cart")
+	}
+}
+
+// UpdateCartItem - sets the quantity of a cart line; 0 removes it
+func UpdateCartItem(db *gorm.DB, cfg *config.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		pr, ok := requestPricing(c, db, cfg)
+		if !ok {
+			return
+		}
+		pid, err := uuid.Parse(c.Param("productId"))
+		if err != nil {
+			utils.JSON(c, http.StatusBadRequest, false, "invalid product id", nil, nil)
+			return
+		}
+		var in struct {
+			Quantity *int `json:"quantity" binding:"required,min=0"`
+		}
+		if err := c.ShouldBindJSON(&in); err != nil {
+			utils.JSON(c, http.StatusBadRequest, false, "validation error", nil, err.Error())
+			return
+		}
+
+		cart, err := resolveCart(db, cfg, c, false)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			utils.JSON(c, http.StatusNotFound, false, "cart not found", nil, nil)
+			return
+		}
+		if err != nil {
+			utils.JSON(c, http.StatusInternalServerError, false, "failed to load cart", nil, err.Error())
+			return
+		}
+
+		query := db.Model(&config.CartItem{}).Where("cart_id = ? AND product_id = ?", cart.ID, pid)
+		var res *gorm.DB
+		if *in.Quantity == 0 {
+			res = query.Delete(&config.CartItem{})
+		} else {
+			res = query.Update("quantity", *in.Quantity)
+		}
+		if res.Error != nil {
+			utils.JSON(c, http.StatusInternalServerError, false, "failed to update cart", nil, res.Error.Error())
+			return
+		}
+		if res.RowsAffected == 0 {
+			utils.JSON(c, http.StatusNotFound, false, "item not in cart", nil, nil)
+			return
+		}
+		respondCart(c, db, cfg, pr, cart, "cart updated")
+	}
+}
+
+// RemoveCartItem - removes a product from the cart
+func RemoveCartItem(db *gorm.DB, cfg *config.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		pr, ok := requestPricing(c, db, cfg)
+		if !ok {
+			return
+		}
+		pid, err := uuid.Parse(c.Param("productId"))
+		if err != nil {
+			utils.JSON(c, http.StatusBadRequest, false, "invalid product id", nil, nil)
+			return
+		}
+		cart, err := resolveCart(db, cfg, c, false)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			utils.JSON(c, http.StatusNotFound, false, "cart not found", nil, nil)
+			return
+		}
+		if err != nil {
+			utils.JSON(c, http.StatusInternalServerError, false, "failed to load cart", nil, err.Error())
+			return
+		}
+
+		res := db.Where("cart_id = ? AND product_id = ?", cart.ID, pid).Delete(&config.CartItem{})
+		if res.Error != nil {
+			utils.JSON(c, http.StatusInternalServerError, false, "failed to update cart", nil, res.Error.Error())
+			return
+		}
+		if res.RowsAffected == 0 {
+			utils.JSON(c, http.StatusNotFound, false, "item not in cart", nil, nil)
+			return
+		}
+		respondCart(c, db, cfg, pr, cart, "item removed from cart")
+	}
+}
+
+// ClearCart - removes every item from the cart
+func ClearCart(db *gorm.DB, cfg *config.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		pr, ok := requestPricing(c, db, cfg)
+		if !ok {
+			return
+		}
+		cart, err := resolveCart(db, cfg, c, false)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			utils.JSON(c, http.StatusNotFound, false, "cart not found", nil, nil)
+			return
+		}
+		if err != nil {
+			utils.JSON(c, http.StatusInternalServerError, false, "failed to load cart", nil, err.Error())
+			return
+		}
+		if err := db.Where("cart_id = ?", cart.ID).Delete(&config.CartItem{}).Error; err != nil {
+			utils.JSON(c, http.StatusInternalServerError, false, "failed to clear cart", nil, err.Error())
+			return
+		}
+		respondCart(c, db, cfg, pr, cart, "cart cleared")
+	}
+}
+
+// CheckoutCart - turns the cart into an order and empties it, in one transaction.
+// Signed-in customers send a shipping method and, optionally, an address as for
+// PlaceOrder; guests send an email, shipping address and method.
+func CheckoutCart(db *gorm.DB, cfg *config.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		pr, ok := requestPricing(c, db, cfg)
+		if !ok {
+			return
+		}
+		cart, err := resolveCart(db, cfg, c, false)
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			utils.JSON(c, http.StatusInternalServerError, false, "failed to load cart", nil, err.Error())
+			return
+		}
+		if cart == nil || len(cart.Items) == 0 {
+			utils.JSON(c, http.StatusBadRequest, false, "cart is empty", nil, nil)
+			return
+		}
+
+		order := config.Order{ID: uuid.New().String(), UserID: cart.UserID, Status: config.OrderStatusPending}
+		var shipping checkoutInput
+		var tenders tenderRequest
+		if cart.UserID == nil {
+			var in guestCheckoutInput
+			if err := c.ShouldBindJSON(&in); err != nil {
+				utils.JSON(c, http.StatusBadRequest, false, "validation error", nil, err.Error())
+				return
+			}
+			order.GuestEmail = strings.ToLower(in.Email)
+			order.ShippingAddress = normalizeAddress(in.ShippingAddress)
+			order.ShippingMethodID = &in.ShippingMethodID
+			order.CouponCode = in.CouponCode
+			tenders.GiftCardCodes = in.GiftCardCodes
+		} else {
+			if err := c.ShouldBindJSON(&shipping); err != nil {
+				utils.JSON(c, http.StatusBadRequest, false, "validation error", nil, err.Error())
+				return
+			}
+			order.ShippingMethodID = &shipping.ShippingMethodID
+			order.CouponCode = shipping.CouponCode
+			tenders = shipping.tenderRequest
+		}
+
+		err = db.Transaction(func(tx *gorm.DB) error {
+			// Lock the cart and read its items again: a concurrent checkout of the
+			// same cart waits here and then finds it emptied
+			var locked config.Cart
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&locked, "id = ?", cart.ID).Error; err != nil {
+				return err
+			}
+			var items []config.CartItem
+			if err := tx.Where("cart_id = ?", cart.ID).Find(&items).Error; err != nil {
+				return err
+			}
+			if len(items) == 0 {
+				return errCartEmpty
+			}
+			lines := make([]orderLine, 0, len(items))
+			for _, item := range items {
+				lines = append(lines, orderLine{ProductID: item.ProductID.String(), Quantity: item.Quantity})
+			}
+
+			if order.UserID != nil {
+				address, err := resolveShippingAddress(tx, *order.UserID, shipping.AddressID, shipping.ShippingAddress)
+				if err != nil {
+					return err
+				}
+				order.ShippingAddress = address
+			}
+			if err := createOrder(tx, cfg, &order, lines, pr, tenders); err != nil {
+				return err
+			}
+			return tx.Where("cart_id = ?", cart.ID).Delete(&config.CartItem{}).Error
+		})
+		if errors.Is(err, errCartEmpty) {
+			utils.JSON(c, http.StatusBadRequest, false, "cart is empty", nil, nil)
+			return
+		}
+		if err != nil {
+			respondOrderError(c, err)
+			return
+		}
+		snapshotOrderImages(db, &order)
+		if order.UserID == nil {
+			respondGuestOrder(c, cfg, order)
+			return
+		}
+		utils.JSON(c, http.StatusCreated, true, "order placed successfully", order, nil)
+	}
+}
@@ -0,0 +1,229 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"kalebecommerce/config"
+	"kalebecommerce/money"
+	"kalebecommerce/utils"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// cartResponse decodes the cart view from a response body
+func cartResponse(t *testing.T, w *httptest.ResponseRecorder) cartView {
+	var response struct {
+		Object cartView `json:"object"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	return response.Object
+}
+
+func TestAddCartItem_MergesQuantitiesAndWarnsOnStock(t *testing.T) {
+	db := setupTestDB(t)
+	router := setupRouter()
+	testUserID := uuid.New().String()
+	productID := uuid.New().String()
+	db.Create(&config.Product{ID: productID, Name: "Mug", Price: money.MustParse("8.00"), Stock: 3})
+
+	router.POST("/cart/items", mockAuthMiddleware(testUserID), AddCartItem(db, mockConfig()))
+
+	for i := 0; i < 2; i++ {
+		jsonBody, _ := json.Marshal(OrderItemRequest{ProductID: productID, Quantity: 2})
+		req, _ := http.NewRequest("POST", "/cart/items", bytes.NewBuffer(jsonBody))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		if i == 1 {
+			view := cartResponse(t, w)
+			assert.Len(t, view.Items, 1)
+			assert.Equal(t, 4, view.Items[0].Quantity)
+			assert.Equal(t, money.MustParse("32.00"), view.Subtotal)
+			assert.Equal(t, "only 3 left in stock", view.Items[0].Warning)
+			assert.False(t, view.CanCheckout)
+		}
+	}
+}
+
+func TestUpdateCartItem_ZeroRemovesLine(t *testing.T) {
+	db := setupTestDB(t)
+	router := setupRouter()
+	testUserID := uuid.New()
+	productID := uuid.New()
+	db.Create(&config.Product{ID: productID.String(), Name: "Mug", Price: money.MustParse("8.00"), Stock: 3})
+	cart := config.Cart{ID: uuid.New().String(), UserID: &testUserID}
+	db.Create(&cart)
+	db.Create(&config.CartItem{ID: uuid.New().String(), CartID: cart.ID, ProductID: productID, Quantity: 1})
+
+	router.PUT("/cart/items/:productId", mockAuthMiddleware(testUserID.String()), UpdateCartItem(db, mockConfig()))
+
+	req, _ := http.NewRequest("PUT", "/cart/items/"+productID.String(), bytes.NewBufferString(`{"quantity": 0}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, cartResponse(t, w).Items)
+}
+
+func TestCartItemRoutes_RejectInvalidProductID(t *testing.T) {
+	db := setupTestDB(t)
+	router := setupRouter()
+	testUserID := uuid.New()
+	cart := config.Cart{ID: uuid.New().String(), UserID: &testUserID}
+	db.Create(&cart)
+
+	router.PUT("/cart/items/:productId", mockAuthMiddleware(testUserID.String()), UpdateCartItem(db, mockConfig()))
+	router.DELETE("/cart/items/:productId", mockAuthMiddleware(testUserID.String()), RemoveCartItem(db, mockConfig()))
+
+	w := sendJSON(router, "PUT", "/cart/items/not-a-uuid", `{"quantity": 1}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "invalid product id")
+
+	w = sendJSON(router, "DELETE", "/cart/items/not-a-uuid", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "invalid product id")
+}
+
+func TestCheckoutCart_PlacesOrderAndEmptiesCart(t *testing.T) {
+	db := setupTestDB(t)
+	router := setupRouter()
+	testUserID := uuid.New()
+	productID := uuid.New()
+	db.Create(&config.Product{ID: productID.String(), Name: "Mug", Price: money.MustParse("8.00"), Stock: 5})
+	cart := config.Cart{ID: uuid.New().String(), UserID: &testUserID}
+	db.Create(&cart)
+	db.Create(&config.CartItem{ID: uuid.New().String(), CartID: cart.ID, ProductID: productID, Quantity: 2})
+
+	// No address in the body: the default one from the address book is used
+	db.Create(&config.UserAddress{ID: uuid.New().String(), UserID: testUserID, Address: testAddress, IsDefault: true})
+
+	router.POST("/cart/checkout", mockAuthMiddleware(testUserID.String()), CheckoutCart(db, mockConfig()))
+
+	w := sendJSON(router, "POST", "/cart/checkout", `{"shipping_method_id":"`+testShippingMethod(db)+`"}`)
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Contains(t, w.Body.String(), "order placed successfully")
+
+	var order config.Order
+	db.Preload("Items").Last(&order)
+	assert.Equal(t, money.MustParse("16.00"), order.TotalPrice)
+	assert.Equal(t, "Addis Ababa", order.ShippingAddress.City)
+	assert.Equal(t, "Test Post", order.ShippingMethodName)
+	assert.Len(t, order.Items, 1)
+
+	var p config.Product
+	db.First(&p, "id = ?", productID)
+	assert.Equal(t, 3, p.Stock)
+
+	var remaining int64
+	db.Model(&config.CartItem{}).Where("cart_id = ?", cart.ID).Count(&remaining)
+	assert.Equal(t, int64(0), remaining)
+}
+
+func TestCheckoutCart_EmptyCart(t *testing.T) {
+	db := setupTestDB(t)
+	router := setupRouter()
+	router.POST("/cart/checkout", mockAuthMiddleware(uuid.New().String()), CheckoutCart(db, mockConfig()))
+
+	req, _ := http.NewRequest("POST", "/cart/checkout", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "cart is empty")
+}
+
+func TestAddCartItem_GuestGetsCartToken(t *testing.T) {
+	db := setupTestDB(t)
+	router := setupRouter()
+	productID := uuid.New().String()
+	db.Create(&config.Product{ID: productID, Name: "Mug", Price: money.MustParse("8.00"), Stock: 3})
+
+	router.POST("/cart/items", AddCartItem(db, mockConfig()))
+	router.GET("/cart", GetCart(db, mockConfig()))
+
+	jsonBody, _ := json.Marshal(OrderItemRequest{ProductID: productID, Quantity: 1})
+	req, _ := http.NewRequest("POST", "/cart/items", bytes.NewBuffer(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	token := w.Header().Get(cartTokenHeader)
+	assert.NotEmpty(t, token)
+	assert.Equal(t, token, cartResponse(t, w).CartToken)
+
+	// The token alone identifies the guest cart on later requests
+	req, _ = http.NewRequest("GET", "/cart", nil)
+	req.Header.Set(cartTokenHeader, token)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Len(t, cartResponse(t, w).Items, 1)
+
+	// A tampered token is ignored
+	req, _ = http.NewRequest("GET", "/cart", nil)
+	req.Header.Set(cartTokenHeader, token+"x")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Empty(t, cartResponse(t, w).Items)
+}
+
+func TestLogin_MergesGuestCart(t *testing.T) {
+	db := setupTestDB(t)
+	cfg := mockConfig()
+	cfg.CartMergeStrategy = "sum"
+	router := setupRouter()
+	router.POST("/login", Login(db, cfg))
+
+	hash, _ := utils.HashPassword("Strong@123")
+	userID := uuid.New()
+	db.Create(&config.User{ID: userID.String(), Username: "kaleb", Email: "kaleb@example.com", Password: hash})
+
+	mug, lamp := uuid.New(), uuid.New()
+	db.Create(&config.Product{ID: mug.String(), Name: "Mug", Price: money.MustParse("8.00"), Stock: 10})
+	db.Create(&config.Product{ID: lamp.String(), Name: "Lamp", Price: money.MustParse("30.00"), Stock: 10})
+
+	account := config.Cart{ID: uuid.New().String(), UserID: &userID}
+	guest := config.Cart{ID: uuid.New().String()}
+	db.Create(&account)
+	db.Create(&guest)
+	db.Create(&config.CartItem{ID: uuid.New().String(), CartID: account.ID, ProductID: mug, Quantity: 1})
+	db.Create(&config.CartItem{ID: uuid.New().String(), CartID: guest.ID, ProductID: mug, Quantity: 2})
+	db.Create(&config.CartItem{ID: uuid.New().String(), CartID: guest.ID, ProductID: lamp, Quantity: 1})
+
+	jsonBody, _ := json.Marshal(LoginInput{Email: "kaleb@example.com", Password: "Strong@123"})
+	req, _ := http.NewRequest("POST", "/login", bytes.NewBuffer(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(cartTokenHeader, utils.SignValue("cart:"+guest.ID, cfg.JWTSecret))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var items []config.CartItem
+	db.Where("cart_id = ?", account.ID).Find(&items)
+	assert.Len(t, items, 2)
+	for _, item := range items {
+		if item.ProductID == mug {
+			assert.Equal(t, 3, item.Quantity)
+		}
+	}
+
+	var guestCarts int64
+	db.Model(&config.Cart{}).Where("id = ?", guest.ID).Count(&guestCarts)
+	assert.Equal(t, int64(0), guestCarts)
+}
+
+func TestMergedQuantity_Strategies(t *testing.T) {
+	assert.Equal(t, 5, mergedQuantity("sum", 2, 3))
+	assert.Equal(t, 3, mergedQuantity("max", 2, 3))
+	assert.Equal(t, 3, mergedQuantity("guest", 2, 3))
+	assert.Equal(t, 2, mergedQuantity("account", 2, 3))
+}
@@ -0,0 +1,405 @@
+package controllers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"kalebecommerce/config"
+	"kalebecommerce/money"
+	"kalebecommerce/utils"
+	"math/big"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// currencyPattern matches ISO 4217 alphabetic currency codes.
+var currencyPattern = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// errUnsupportedCurrency is returned for currencies without an exchange rate.
+var errUnsupportedCurrency = errors.New("unsupported currency")
+
+// pricing turns base catalog prices into the currency a request asked for.
+type pricing struct {
+	Currency string
+	Rate     string // decimal text as stored, "1" for the base currency
+	rate     *big.Rat
+	base     bool
+}
+
+// loadPricing resolves currency against the store base currency and the
+// exchange-rate table. An empty currency means the base currency.
+func loadPricing(db *gorm.DB, cfg *config.Config, currency string) (*pricing, error) {
+	base := strings.ToUpper(cfg.Currency)
+	currency = strings.ToUpper(strings.TrimSpace(currency))
+	if currency == "" || currency == base {
+		return &pricing{Currency: base, Rate: "1", rate: big.NewRat(1, 1), base: true}, nil
+	}
+
+	var er config.ExchangeRate
+	if err := db.First(&er, "currency = ?", currency).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w: %s", errUnsupportedCurrency, currency)
+		}
+		return nil, err
+	}
+	rate, err := money.ParseRate(er.Rate)
+	if err != nil {
+		return nil, err
+	}
+	return &pricing{Currency: currency, Rate: er.Rate, rate: rate}, nil
+}
+
+// requestPricing resolves the ?currency= query parameter. It writes the error
+// response and returns false when the store doesn't sell in that currency.
+func requestPricing(c *gin.Context, db *gorm.DB, cfg *config.Config) (*pricing, bool) {
+	pr, err := loadPricing(db, cfg, c.Query("currency"))
+	if errors.Is(err, errUnsupportedCurrency) {
+		utils.JSON(c, http.StatusBadRequest, false, "unsupported currency", nil, err.Error())
+		return nil, false
+	}
+	if err != nil {
+		utils.JSON(c, http.StatusInternalServerError, false, "failed to load exchange rate", nil, err.Error())
+		return nil, false
+	}
+	return pr, true
+}
+
+// overrides loads the per-currency prices set for the given products.
+func (pr *pricing) overrides(db *gorm.DB, productIDs []string) (map[string]config.ProductPrice, error) {
+	found := make(map[string]config.ProductPrice)
+	if pr.base || len(productIDs) == 0 {
+		return found, nil
+	}
+	var rows []config.ProductPrice
+	if err := db.Where("currency = ? AND product_id IN ?", pr.Currency, productIDs).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		found[row.ProductID] = row
+	}
+	return found, nil
+}
+
+// priceOf is a product's price and compare-at price in the pricing currency.
+// An override wins; otherwise the base prices are converted with the rate.
+func (pr *pricing) priceOf(p config.Product, overrides map[string]config.ProductPrice) (money.Amount, *money.Amount) {
+	if pr.base {
+		return p.Price, p.CompareAtPrice
+	}
+	if o, ok := overrides[p.ID]; ok {
+		return o.Price, o.CompareAtPrice
+	}
+	price := p.Price.Convert(pr.rate)
+	if p.CompareAtPrice == nil {
+		return price, nil
+	}
+	compareAt := p.CompareAtPrice.Convert(pr.rate)
+	return price, &compareAt
+}
+
+// convert turns a base-currency amount into the pricing currency.
+func (pr *pricing) convert(a money.Amount) money.Amount {
+	if pr.base {
+		return a
+	}
+	return a.Convert(pr.rate)
+}
+
+// unitPrice is priceOf for a single product read inside tx.
+func (pr *pricing) unitPrice(tx *gorm.DB, p config.Product) (money.Amount, error) {
+	overrides, err := pr.overrides(tx, []string{p.ID})
+	if err != nil {
+		return 0, err
+	}
+	price, _ := pr.priceOf(p, overrides)
+	return price, nil
+}
+
+// localize rewrites the products' prices into the pricing currency for display.
+func (pr *pricing) localize(db *gorm.DB, products []config.Product) error {
+	ids := make([]string, 0, len(products))
+	for _, p := range products {
+		ids = append(ids, p.ID)
+	}
+	overrides, err := pr.overrides(db, ids)
+	if err != nil {
+		return err
+	}
+	for i := range products {
+		products[i].Price, products[i].CompareAtPrice = pr.priceOf(products[i], overrides)
+		products[i].Currency = pr.Currency
+	}
+	return nil
+}
+
+// localizedETag is the entity tag of a product as shown in the pricing currency.
+// Rates and overrides don't bump the product version, so the shown prices are
+// part of the tag.
+func localizedETag(p config.Product, pr *pricing) string {
+	if pr.base {
+		return productETag(p)
+	}
+	compareAt := ""
+	if p.CompareAtPrice != nil {
+		compareAt = p.CompareAtPrice.String()
+	}
+	return fmt.Sprintf(`"%d-%s-%s-%s"`, p.Version, pr.Currency, p.Price, compareAt)
+}
+
+// parseCurrencyCode validates a currency code for the admin endpoints; the
+// base currency can't be given a rate or an override.
+func parseCurrencyCode(raw string, cfg *config.Config) (string, error) {
+	code := strings.ToUpper(strings.TrimSpace(raw))
+	if !currencyPattern.MatchString(code) {
+		return "", errors.New("currency must be a 3-letter ISO 4217 code")
+	}
+	if code == strings.ToUpper(cfg.Currency) {
+		return "", fmt.Errorf("%s is the store base currency", code)
+	}
+	return code, nil
+}
+
+// ListExchangeRates (Admin) - the base currency and every configured rate
+func ListExchangeRates(db *gorm.DB, cfg *config.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var rates []config.ExchangeRate
+		db.Order("currency").Find(&rates)
+		utils.JSON(c, http.StatusOK, true, "exchange rates listed",
+			gin.H{"base_currency": strings.ToUpper(cfg.Currency), "rates": rates}, nil)
+	}
+}
+
+// saveExchangeRates upserts rates, stamping the acting admin.
+func saveExchangeRates(tx *gorm.DB, rates []config.ExchangeRate) error {
+	return tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "currency"}},
+		DoUpdates: clause.AssignmentColumns([]string{"rate", "updated_by", "updated_at"}),
+	}).Create(&rates).Error
+}
+
+// SetExchangeRate (Admin) - creates or replaces the rate of one currency
+func SetExchangeRate(db *gorm.DB, cfg *config.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		code, err := parseCurrencyCode(c.Param("currency"), cfg)
+		if err != nil {
+			utils.JSON(c, http.StatusBadRequest, false, "invalid currency", nil, err.Error())
+			return
+		}
+		var in struct {
+			Rate json.Number `json:"rate" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&in); err != nil {
+			utils.JSON(c, http.StatusBadRequest, false, "validation error", nil, err.Error())
+			return
+		}
+		if _, err := money.ParseRate(in.Rate.String()); err != nil {
+			utils.JSON(c, http.StatusBadRequest, false, "validation error", nil, "rate must be a positive decimal with at most 12 places")
+			return
+		}
+
+		rate := config.ExchangeRate{Currency: code, Rate: in.Rate.String(), UpdatedBy: currentUserID(c), UpdatedAt: time.Now()}
+		if err := saveExchangeRates(db, []config.ExchangeRate{rate}); err != nil {
+			utils.JSON(c, http.StatusInternalServerError, false, "failed to save exchange rate", nil, err.Error())
+			return
+		}
+		utils.JSON(c, http.StatusOK, true, "exchange rate saved", rate, nil)
+	}
+}
+
+// DeleteExchangeRate (Admin) - stops selling in a currency
+func DeleteExchangeRate(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		res := db.Delete(&config.ExchangeRate{}, "currency = ?", strings.ToUpper(c.Param("currency")))
+		if res.Error != nil {
+			utils.JSON(c, http.StatusInternalServerError, false, "failed to delete exchange rate", nil, res.Error.Error())
+			return
+		}
+		if res.RowsAffected == 0 {
+			utils.JSON(c, http.StatusNotFound, false, "exchange rate not found", nil, nil)
+			return
+		}
+		utils.JSON(c, http.StatusOK, true, "exchange rate deleted", nil, nil)
+	}
+}
+
+// ImportExchangeRates (Admin) - upserts rates from a CSV upload with
+// "currency,rate" columns. Nothing is saved if any row is invalid.
+func ImportExchangeRates(db *gorm.DB, cfg *config.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		file, err := c.FormFile("file")
+		if err != nil {
+			utils.JSON(c, http.StatusBadRequest, false, "file error", nil, "a CSV file is required in the 'file' field")
+			return
+		}
+		f, err := file.Open()
+		if err != nil {
+			utils.JSON(c, http.StatusBadRequest, false, "file error", nil, err.Error())
+			return
+		}
+		defer f.Close()
+
+		reader := csv.NewReader(f)
+		reader.TrimLeadingSpace = true
+		header, err := reader.Read()
+		if err != nil {
+			utils.JSON(c, http.StatusBadRequest, false, "invalid CSV", nil, "the file has no header row")
+			return
+		}
+		columns := make(map[string]int)
+		for i, name := range header {
+			columns[strings.ToLower(strings.TrimSpace(name))] = i
+		}
+		currencyCol, okCurrency := columns["currency"]
+		rateCol, okRate := columns["rate"]
+		if !okCurrency || !okRate {
+			utils.JSON(c, http.StatusBadRequest, false, "invalid CSV", nil, "the header must contain currency and rate columns")
+			return
+		}
+
+		now := time.Now()
+		var rates []config.ExchangeRate
+		var rowErrors []importRowError
+		seen := make(map[string]bool)
+		for row := 2; ; row++ {
+			record, err := reader.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				rowErrors = append(rowErrors, importRowError{Row: row, Errors: []string{err.Error()}})
+				continue
+			}
+
+			var errs []string
+			code, err := parseCurrencyCode(csvField(record, currencyCol), cfg)
+			if err != nil {
+				errs = append(errs, err.Error())
+			} else if seen[code] {
+				errs = append(errs, code+" appears more than once")
+			}
+			rate := strings.TrimSpace(csvField(record, rateCol))
+			if _, err := money.ParseRate(rate); err != nil {
+				errs = append(errs, "rate must be a positive decimal with at most 12 places")
+			}
+			if len(errs) > 0 {
+				rowErrors = append(rowErrors, importRowError{Row: row, Errors: errs})
+				continue
+			}
+			seen[code] = true
+			rates = append(rates, config.ExchangeRate{Currency: code, Rate: rate, UpdatedBy: currentUserID(c), UpdatedAt: now})
+		}
+
+		if len(rowErrors) > 0 {
+			utils.JSON(c, http.StatusBadRequest, false, "invalid exchange rates", gin.H{"errors": rowErrors}, nil)
+			return
+		}
+		if len(rates) == 0 {
+			utils.JSON(c, http.StatusBadRequest, false, "invalid CSV", nil, "the file has no rates")
+			return
+		}
+		if err := saveExchangeRates(db, rates); err != nil {
+			utils.JSON(c, http.StatusInternalServerError, false, "failed to save exchange rates", nil, err.Error())
+			return
+		}
+		utils.JSON(c, http.StatusOK, true, "exchange rates imported", gin.H{"updated": len(rates), "rates": rates}, nil)
+	}
+}
+
+// csvField returns a CSV record column, or "" when the row is short.
+func csvField(record []string, i int) string {
+	if i < len(record) {
+		return record[i]
+	}
+	return ""
+}
+
+// ListProductPrices (Admin) - a product's per-currency price overrides
+func ListProductPrices(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var prices []config.ProductPrice
+		db.Where("product_id = ?", c.Param("id")).Order("currency").Find(&prices)
+		utils.JSON(c, http.StatusOK, true, "product prices listed", prices, nil)
+	}
+}
+
+// SetProductPrice (Admin) - sets a product's price in one currency
+func SetProductPrice(db *gorm.DB, cfg *config.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		code, err := parseCurrencyCode(c.Param("currency"), cfg)
+		if err != nil {
+			utils.JSON(c, http.StatusBadRequest, false, "invalid currency", nil, err.Error())
+			return
+		}
+		var in struct {
+			Price          money.Amount  `json:"price"`
+			CompareAtPrice *money.Amount `json:"compare_at_price"`
+		}
+		if err := c.ShouldBindJSON(&in); err != nil {
+			utils.JSON(c, http.StatusBadRequest, false, "validation error", nil, err.Error())
+			return
+		}
+		if in.Price <= 0 {
+			utils.JSON(c, http.StatusBadRequest, false, "validation error", nil, "price must be greater than 0")
+			return
+		}
+		if err := validateCompareAtPrice(in.CompareAtPrice, in.Price); err != nil {
+			utils.JSON(c, http.StatusBadRequest, false, "validation error", nil, err.Error())
+			return
+		}
+
+		var p config.Product
+		if err := db.First(&p, "id = ?", c.Param("id")).Error; err != nil {
+			utils.JSON(c, http.StatusNotFound, false, "product not found", nil, nil)
+			return
+		}
+		// Without a rate the currency can't be chosen, so the override would never show
+		if err := db.First(&config.ExchangeRate{}, "currency = ?", code).Error; err != nil {
+			utils.JSON(c, http.StatusBadRequest, false, "unsupported currency", nil, "add an exchange rate for "+code+" first")
+			return
+		}
+
+		price := config.ProductPrice{
+			ID:             uuid.New().String(),
+			ProductID:      p.ID,
+			Currency:       code,
+			Price:          in.Price,
+			CompareAtPrice: in.CompareAtPrice,
+			UpdatedBy:      currentUserID(c),
+		}
+		err = db.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "product_id"}, {Name: "currency"}},
+			DoUpdates: clause.AssignmentColumns([]string{"price", "compare_at_price", "updated_by", "updated_at"}),
+		}).Create(&price).Error
+		if err != nil {
+			utils.JSON(c, http.StatusInternalServerError, false, "failed to save product price", nil, err.Error())
+			return
+		}
+		db.First(&price, "product_id = ? AND currency = ?", p.ID, code)
+		utils.JSON(c, http.StatusOK, true, "product price saved", price, nil)
+	}
+}
+
+// DeleteProductPrice (Admin) - removes an override; the converted base price applies again
+func DeleteProductPrice(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		res := db.Delete(&config.ProductPrice{}, "product_id = ? AND currency = ?", c.Param("id"), strings.ToUpper(c.Param("currency")))
+		if res.Error != nil {
+			utils.JSON(c, http.StatusInternalServerError, false, "failed to delete product price", nil, res.Error.Error())
+			return
+		}
+		if res.RowsAffected == 0 {
+			utils.JSON(c, http.StatusNotFound, false, "product price not found", nil, nil)
+			return
+		}
+		utils.JSON(c, http.StatusOK, true, "product price deleted", nil, nil)
+	}
+}
@@ -0,0 +1,177 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"kalebecommerce/config"
+	"kalebecommerce/money"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetExchangeRate_ConvertsCatalogPrices(t *testing.T) {
+	db := setupTestDB(t)
+	router := setupRouter()
+	cfg := storeTestConfig()
+	router.PUT("/admin/exchange-rates/:currency", mockAdminAuthMiddleware(), SetExchangeRate(db, cfg))
+	router.GET("/products/:id", GetProduct(db, cfg))
+
+	productID := uuid.New().String()
+	was := money.MustParse("12.00")
+	db.Create(&config.Product{ID: productID, Name: "Kettle", Price: money.MustParse("10.05"), CompareAtPrice: &was, Stock: 1})
+
+	assert.Equal(t, http.StatusBadRequest, sendJSON(router, "PUT", "/admin/exchange-rates/USD", `{"rate":"1"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, sendJSON(router, "PUT", "/admin/exchange-rates/ETB", `{"rate":"-2"}`).Code)
+	assert.Equal(t, http.StatusOK, sendJSON(router, "PUT", "/admin/exchange-rates/etb", `{"rate":"56.25"}`).Code)
+	assert.Equal(t, http.StatusOK, sendJSON(router, "PUT", "/admin/exchange-rates/EUR", `{"rate":0.333}`).Code)
+
+	req, _ := http.NewRequest("GET", "/products/"+productID+"?currency=ETB", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"price":"565.31"`) // 10.05 * 56.25 = 565.3125
+	assert.Contains(t, w.Body.String(), `"compare_at_price":"675.00"`)
+	assert.Contains(t, w.Body.String(), `"currency":"ETB"`)
+
+	// 10.05 * 0.333 = 3.34665, rounded half up
+	req, _ = http.NewRequest("GET", "/products/"+productID+"?currency=eur", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Contains(t, w.Body.String(), `"price":"3.35"`)
+
+	req, _ = http.NewRequest("GET", "/products/"+productID+"?currency=KES", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "unsupported currency")
+}
+
+func TestProductPriceOverride_WinsOverConvertedPrice(t *testing.T) {
+	db := setupTestDB(t)
+	router := setupRouter()
+	cfg := storeTestConfig()
+	router.PUT("/admin/products/:id/prices/:currency", mockAdminAuthMiddleware(), SetProductPrice(db, cfg))
+	router.DELETE("/admin/products/:id/prices/:currency", mockAdminAuthMiddleware(), DeleteProductPrice(db))
+	router.GET("/products", ListOrSearchProducts(db, cfg, nil))
+
+	kettle := uuid.New().String()
+	db.Create(&config.Product{ID: kettle, Name: "Kettle", Price: money.MustParse("10.00"), Stock: 1})
+	db.Create(&config.Product{ID: uuid.New().String(), Name: "Lamp", Price: money.MustParse("20.00"), Stock: 1})
+
+	// No rate yet: the override would be unreachable
+	assert.Equal(t, http.StatusBadRequest, sendJSON(router, "PUT", "/admin/products/"+kettle+"/prices/EUR", `{"price":"9.49"}`).Code)
+
+	db.Create(&config.ExchangeRate{Currency: "EUR", Rate: "0.9"})
+	assert.Equal(t, http.StatusBadRequest, sendJSON(router, "PUT", "/admin/products/"+kettle+"/prices/EUR", `{"price":"9.49","compare_at_price":"9.00"}`).Code)
+	assert.Equal(t, http.StatusOK, sendJSON(router, "PUT", "/admin/products/"+kettle+"/prices/EUR", `{"price":"9.99"}`).Code)
+	assert.Equal(t, http.StatusOK, sendJSON(router, "PUT", "/admin/products/"+kettle+"/prices/EUR", `{"price":"9.49"}`).Code)
+
+	listPrices := func() map[string]string {
+		req, _ := http.NewRequest("GET", "/products?currency=EUR", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		var response struct {
+			Object struct {
+				Currency string `json:"currency"`
+				Products []struct {
+					Name     string `json:"name"`
+					Price    string `json:"price"`
+					Currency string `json:"currency"`
+				} `json:"products"`
+			} `json:"object"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		assert.Equal(t, "EUR", response.Object.Currency)
+		prices := make(map[string]string)
+		for _, p := range response.Object.Products {
+			assert.Equal(t, "EUR", p.Currency)
+			prices[p.Name] = p.Price
+		}
+		return prices
+	}
+	assert.Equal(t, map[string]string{"Kettle": "9.49", "Lamp": "18.00"}, listPrices())
+
+	var count int64
+	db.Model(&config.ProductPrice{}).Where("product_id = ?", kettle).Count(&count)
+	assert.Equal(t, int64(1), count)
+
+	req, _ := http.NewRequest("DELETE", "/admin/products/"+kettle+"/prices/EUR", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, map[string]string{"Kettle": "9.00", "Lamp": "18.00"}, listPrices())
+}
+
+func TestPlaceOrder_RecordsCurrencyAndRate(t *testing.T) {
+	db := setupTestDB(t)
+	router := setupRouter()
+	userID := uuid.New().String()
+	productID := uuid.New()
+	db.Create(&config.Product{ID: productID.String(), Name: "Mug", Price: money.MustParse("8.00"), Stock: 5})
+	db.Create(&config.ExchangeRate{Currency: "KES", Rate: "129.5"})
+
+	router.POST("/orders", mockAuthMiddleware(userID), PlaceOrder(db, storeTestConfig()))
+
+	jsonBody := orderRequestJSON(db, OrderItemRequest{ProductID: productID.String(), Quantity: 2})
+	req, _ := http.NewRequest("POST", "/orders?currency=KES", bytes.NewBuffer(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	var response struct {
+		Object config.Order `json:"object"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	assert.Equal(t, "KES", response.Object.Currency)
+	assert.Equal(t, "129.5", response.Object.ExchangeRate)
+	assert.Equal(t, money.MustParse("2072.00"), response.Object.TotalPrice)
+
+	// A later rate change doesn't touch the placed order
+	db.Model(&config.ExchangeRate{}).Where("currency = ?", "KES").Update("rate", "140")
+	var stored config.Order
+	db.Preload("Items").First(&stored, "id = ?", response.Object.ID)
+	assert.Equal(t, "129.5", stored.ExchangeRate)
+	assert.Equal(t, money.MustParse("1036.00"), stored.Items[0].UnitPrice)
+}
+
+func TestImportExchangeRates_CSV(t *testing.T) {
+	db := setupTestDB(t)
+	router := setupRouter()
+	cfg := storeTestConfig()
+	router.POST("/admin/exchange-rates/import", mockAdminAuthMiddleware(), ImportExchangeRates(db, cfg))
+
+	post := func(content string) *httptest.ResponseRecorder {
+		body, contentType := createImportUpload(t, "rates.csv", content)
+		req, _ := http.NewRequest("POST", "/admin/exchange-rates/import", body)
+		req.Header.Set("Content-Type", contentType)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// One bad row rejects the whole file
+	w := post("currency,rate\nETB,56.25\nusd,1\nKES,abc\n")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), `"row":3`)
+	assert.Contains(t, w.Body.String(), `"row":4`)
+	var count int64
+	db.Model(&config.ExchangeRate{}).Count(&count)
+	assert.Equal(t, int64(0), count)
+
+	w = post("currency,rate\nETB,56.25\nkes,129.5\n")
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = post("rate,currency\n57,ETB\n")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var etb config.ExchangeRate
+	db.First(&etb, "currency = ?", "ETB")
+	assert.Equal(t, "57", etb.Rate)
+	db.Model(&config.ExchangeRate{}).Count(&count)
+	assert.Equal(t, int64(2), count)
+}
@@ -0,0 +1,458 @@
+package controllers
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"kalebecommerce/config"
+	"kalebecommerce/money"
+	"kalebecommerce/utils"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	// errInvalidGiftCard is returned when a gift card can't pay for an order.
+	errInvalidGiftCard = errors.New("invalid gift card")
+	// errInsufficientBalance is returned when a gift card or store credit
+	// balance would go below zero.
+	errInsufficientBalance = errors.New("insufficient balance")
+)
+
+// giftCardAlphabet leaves out characters that are easy to misread (0/O, 1/I).
+const giftCardAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// newGiftCardCode returns a random code in four groups, like K7QM-3RTA-WX2P-9HNC.
+func newGiftCardCode() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	var code strings.Builder
+	for i, b := range buf {
+		if i > 0 && i%4 == 0 {
+			code.WriteByte('-')
+		}
+		// 256 is a multiple of 32, so every character is equally likely
+		code.WriteByte(giftCardAlphabet[int(b)%len(giftCardAlphabet)])
+	}
+	return code.String(), nil
+}
+
+// normalizeGiftCardCode accepts codes typed in any case, with or without dashes and spaces.
+func normalizeGiftCardCode(raw string) string {
+	var plain strings.Builder
+	for _, r := range strings.ToUpper(raw) {
+		if r != '-' && r != ' ' {
+			plain.WriteRune(r)
+		}
+	}
+	var code strings.Builder
+	for i, r := range plain.String() {
+		if i > 0 && i%4 == 0 {
+			code.WriteByte('-')
+		}
+		code.WriteRune(r)
+	}
+	return code.String()
+}
+
+// codeLast4 is the part of a gift card code that is safe to show on orders.
+func codeLast4(code string) string {
+	if len(code) < 4 {
+		return code
+	}
+	return code[len(code)-4:]
+}
+
+// issueGiftCard stores a new card with a fresh code and its opening ledger entry.
+func issueGiftCard(tx *gorm.DB, card *config.GiftCard, note string) error {
+	code, err := newGiftCardCode()
+	if err != nil {
+		return err
+	}
+	card.ID = uuid.New().String()
+	card.Code = code
+	card.Balance = card.InitialBalance
+	card.Active = true
+	if err := tx.Create(card).Error; err != nil {
+		return err
+	}
+	entry := config.GiftCardTransaction{
+		ID:           uuid.New().String(),
+		GiftCardID:   card.ID,
+		Type:         config.LedgerIssue,
+		Amount:       card.InitialBalance,
+		BalanceAfter: card.InitialBalance,
+		OrderID:      card.OrderID,
+		Note:         note,
+		CreatedBy:    card.CreatedBy,
+	}
+	if err := tx.Create(&entry).Error; err != nil {
+		return err
+	}
+	card.Transactions = []config.GiftCardTransaction{entry}
+	return nil
+}
+
+// adjustGiftCard changes a card's balance by delta and records entry in its
+// ledger. The conditional UPDATE keeps concurrent redemptions from taking
+// the balance below zero.
+func adjustGiftCard(tx *gorm.DB, cardID string, delta money.Amount, entry config.GiftCardTransaction) error {
+	res := tx.Model(&config.GiftCard{}).Where("id = ? AND balance + ? >= 0", cardID, delta).
+		Update("balance", gorm.Expr("balance + ?", delta))
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return errInsufficientBalance
+	}
+	var card config.GiftCard
+	if err := tx.Select("balance").First(&card, "id = ?", cardID).Error; err != nil {
+		return err
+	}
+	entry.ID = uuid.New().String()
+	entry.GiftCardID = cardID
+	entry.Amount = delta
+	entry.BalanceAfter = card.Balance
+	return tx.Create(&entry).Error
+}
+
+// tenderRequest is how much of an order the customer pays with gift cards and
+// store credit, and how many loyalty points they redeem for a discount. Cards
+// are used in the order given, then store credit.
+type tenderRequest struct {
+	GiftCardCodes  []string `json:"gift_card_codes"`
+	UseStoreCredit bool     `json:"use_store_credit"`
+	RedeemPoints   int      `json:"redeem_points" binding:"min=0"`
+}
+
+// addTender records part of an order paid with a gift card or store credit.
+func addTender(tx *gorm.DB, order *config.Order, tender config.OrderTender) error {
+	tender.ID = uuid.New().String()
+	tender.OrderID = order.ID
+	if err := tx.Create(&tender).Error; err != nil {
+		return err
+	}
+	order.Tenders = append(order.Tenders, tender)
+	order.TenderTotal += tender.Amount
+	return nil
+}
+
+// applyTenders pays as much of a priced order as the requested gift cards and
+// store credit cover, taking the amounts off their balances.
+func applyTenders(tx *gorm.DB, order *config.Order, in tenderRequest) error {
+	now := time.Now()
+	due := order.TotalPrice
+	for _, raw := range in.GiftCardCodes {
+		code := normalizeGiftCardCode(raw)
+		var card config.GiftCard
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&card, "code = ?", code).Error
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			return fmt.Errorf("%w: no gift card ends in %s", errInvalidGiftCard, codeLast4(code))
+		case err != nil:
+			return err
+		case !card.Active || card.ExpiresAt != nil && !card.ExpiresAt.After(now):
+			return fmt.Errorf("%w: gift card ending in %s is no longer valid", errInvalidGiftCard, codeLast4(code))
+		case card.Currency != order.Currency:
+			return fmt.Errorf("%w: gift card ending in %s is in %s, the order is in %s", errInvalidGiftCard, codeLast4(code), card.Currency, order.Currency)
+		case card.Balance == 0:
+			return fmt.Errorf("%w: gift card ending in %s has no balance left", errInvalidGiftCard, codeLast4(code))
+		}
+
+		take := min(card.Balance, due)
+		if take == 0 {
+			continue
+		}
+		entry := config.GiftCardTransaction{Type: config.LedgerRedeem, OrderID: &order.ID, CreatedBy: order.UserID}
+		if err := adjustGiftCard(tx, card.ID, -take, entry); err != nil {
+			return err
+		}
+		tender := config.OrderTender{Type: config.TenderGiftCard, GiftCardID: &card.ID, CodeLast4: codeLast4(card.Code), Amount: take}
+		if err := addTender(tx, order, tender); err != nil {
+			return err
+		}
+		due -= take
+	}
+
+	if !in.UseStoreCredit || order.UserID == nil || due == 0 {
+		return nil
+	}
+	var account config.StoreCreditAccount
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		First(&account, "user_id = ? AND currency = ?", *order.UserID, order.Currency).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	take := min(account.Balance, due)
+	if take == 0 {
+		return nil
+	}
+	entry := config.StoreCreditTransaction{Type: config.LedgerRedeem, OrderID: &order.ID, CreatedBy: order.UserID}
+	if err := adjustStoreCredit(tx, *order.UserID, order.Currency, -take, entry); err != nil {
+		return err
+	}
+	return addTender(tx, order, config.OrderTender{Type: config.TenderStoreCredit, Amount: take})
+}
+
+// creditTender pays amount back onto the gift card or store credit account a tender came from.
+func creditTender(tx *gorm.DB, order *config.Order, tender *config.OrderTender, amount money.Amount, kind string, refundID *string) error {
+	if tender.Type == config.TenderGiftCard {
+		entry := config.GiftCardTransaction{Type: kind, OrderID: &order.ID, RefundID: refundID}
+		if err := adjustGiftCard(tx, *tender.GiftCardID, amount, entry); err != nil {
+			return err
+		}
+	} else {
+		entry := config.StoreCreditTransaction{Type: kind, OrderID: &order.ID, RefundID: refundID}
+		if err := adjustStoreCredit(tx, *order.UserID, order.Currency, amount, entry); err != nil {
+			return err
+		}
+	}
+	tender.RefundedAmount += amount
+	return tx.Model(tender).Update("refunded_amount", tender.RefundedAmount).Error
+}
+
+// releaseTenders gives back the gift card and store credit an unpaid order
+// held when it is cancelled or fails.
+func releaseTenders(tx *gorm.DB, order *config.Order) error {
+	var tenders []config.OrderTender
+	if err := tx.Where("order_id = ?", order.ID).Find(&tenders).Error; err != nil {
+		return err
+	}
+	for i := range tenders {
+		if left := tenders[i].Amount - tenders[i].RefundedAmount; left > 0 {
+			if err := creditTender(tx, order, &tenders[i], left, config.LedgerRelease, nil); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// refundTenders pays up to amount of a refund back onto the order's tenders,
+// newest first, and returns how much it placed.
+func refundTenders(tx *gorm.DB, order *config.Order, refund *config.Refund, amount money.Amount) (money.Amount, error) {
+	var tenders []config.OrderTender
+	if err := tx.Where("order_id = ?", order.ID).Order("created_at DESC, id").Find(&tenders).Error; err != nil {
+		return 0, err
+	}
+	var placed money.Amount
+	for i := range tenders {
+		take := min(tenders[i].Amount-tenders[i].RefundedAmount, amount-placed)
+		if take <= 0 {
+			continue
+		}
+		if err := creditTender(tx, order, &tenders[i], take, config.LedgerRefund, &refund.ID); err != nil {
+			return 0, err
+		}
+		placed += take
+	}
+	return placed, nil
+}
+
+// issueOrderGiftCards creates one card per gift card unit of an order once it
+// is paid, worth what the unit cost. Orders that already have their cards are left alone.
+func issueOrderGiftCards(tx *gorm.DB, order *config.Order) error {
+	var items []config.OrderItem
+	if err := tx.Where("order_id = ? AND gift_card = ?", order.ID, true).Find(&items).Error; err != nil {
+		return err
+	}
+	for _, item := range items {
+		var issued int64
+		tx.Model(&config.GiftCard{}).Where("order_item_id = ?", item.ID).Count(&issued)
+		for n := int(issued); n < item.Quantity; n++ {
+			card := config.GiftCard{
+				Currency:       order.Currency,
+				InitialBalance: item.UnitPrice,
+				OrderID:        &order.ID,
+				OrderItemID:    &item.ID,
+				PurchaserID:    order.UserID,
+				RecipientEmail: order.GuestEmail,
+			}
+			if err := issueGiftCard(tx, &card, "bought with order "+order.ID); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// voidRefundedGiftCards cancels the cards bought with refunded gift card
+// units. Only untouched cards can be taken back.
+func voidRefundedGiftCards(tx *gorm.DB, refund *config.Refund) error {
+	for _, ri := range refund.Items {
+		var issued int64
+		tx.Model(&config.GiftCard{}).Where("order_item_id = ?", ri.OrderItemID).Count(&issued)
+		if issued == 0 {
+			continue
+		}
+		var cards []config.GiftCard
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("order_item_id = ? AND active = ? AND balance = initial_balance", ri.OrderItemID, true).
+			Order("created_at, id").Limit(ri.Quantity).Find(&cards).Error
+		if err != nil {
+			return err
+		}
+		if len(cards) < ri.Quantity {
+			return fmt.Errorf("%w: gift cards of order item %s have already been used", errInvalidRefund, ri.OrderItemID)
+		}
+		for _, card := range cards {
+			entry := config.GiftCardTransaction{Type: config.LedgerVoid, OrderID: card.OrderID, RefundID: &refund.ID, CreatedBy: refund.CreatedBy}
+			if err := adjustGiftCard(tx, card.ID, -card.Balance, entry); err != nil {
+				return err
+			}
+			if err := tx.Model(&card).Update("active", false).Error; err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// GetGiftCard - balance and history of a gift card, for whoever holds its code
+func GetGiftCard(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var card config.GiftCard
+		err := db.Preload("Transactions", func(tx *gorm.DB) *gorm.DB { return tx.Order("created_at, id") }).
+			First(&card, "code = ?", normalizeGiftCardCode(c.Param("code"))).Error
+		if err != nil {
+			utils.JSON(c, http.StatusNotFound, false, "gift card not found", nil, nil)
+			return
+		}
+		history := make([]gin.H, 0, len(card.Transactions))
+		for _, t := range card.Transactions {
+			history = append(history, gin.H{"type": t.Type, "amount": t.Amount, "balance_after": t.BalanceAfter, "created_at": t.CreatedAt})
+		}
+		utils.JSON(c, http.StatusOK, true, "gift card retrieved", gin.H{
+			"code":         card.Code,
+			"currency":     card.Currency,
+			"balance":      card.Balance,
+			"expires_at":   card.ExpiresAt,
+			"active":       card.Active,
+			"transactions": history,
+		}, nil)
+	}
+}
+
+// ListGiftCards (Admin) - gift cards, newest first; ?code= finds one card
+func ListGiftCards(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		query := db.Order("created_at DESC")
+		if code := c.Query("code"); code != "" {
+			query = query.Where("code = ?", normalizeGiftCardCode(code))
+		}
+		var cards []config.GiftCard
+		if err := query.Limit(200).Find(&cards).Error; err != nil {
+			utils.JSON(c, http.StatusInternalServerError, false, "failed to fetch gift cards", nil, err.Error())
+			return
+		}
+		utils.JSON(c, http.StatusOK, true, "gift cards listed", cards, nil)
+	}
+}
+
+// AdminGetGiftCard (Admin) - a gift card with its full ledger
+func AdminGetGiftCard(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var card config.GiftCard
+		err := db.Preload("Transactions", func(tx *gorm.DB) *gorm.DB { return tx.Order("created_at, id") }).
+			First(&card, "id = ?", c.Param("id")).Error
+		if err != nil {
+			utils.JSON(c, http.StatusNotFound, false, "gift card not found", nil, nil)
+			return
+		}
+		utils.JSON(c, http.StatusOK, true, "gift card retrieved", card, nil)
+	}
+}
+
+// IssueGiftCard (Admin) - creates a gift card, e.g. for a promotion or a customer
+// who bought one in store
+func IssueGiftCard(db *gorm.DB, cfg *config.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var in struct {
+			Amount         money.Amount `json:"amount" binding:"required"`
+			Currency       string       `json:"currency"`
+			RecipientEmail string       `json:"recipient_email" binding:"omitempty,email"`
+			Note           string       `json:"note"`
+			ExpiresAt      *time.Time   `json:"expires_at"`
+		}
+		if err := c.ShouldBindJSON(&in); err != nil {
+			utils.JSON(c, http.StatusBadRequest, false, "validation error", nil, err.Error())
+			return
+		}
+		if in.Amount <= 0 {
+			utils.JSON(c, http.StatusBadRequest, false, "validation error", nil, "amount must be greater than 0")
+			return
+		}
+		if in.ExpiresAt != nil && !in.ExpiresAt.After(time.Now()) {
+			utils.JSON(c, http.StatusBadRequest, false, "validation error", nil, "expires_at must be in the future")
+			return
+		}
+		pr, err := loadPricing(db, cfg, in.Currency)
+		if err != nil {
+			utils.JSON(c, http.StatusBadRequest, false, "unsupported currency", nil, err.Error())
+			return
+		}
+
+		card := config.GiftCard{
+			Currency:       pr.Currency,
+			InitialBalance: in.Amount,
+			RecipientEmail: strings.ToLower(in.RecipientEmail),
+			Note:           in.Note,
+			ExpiresAt:      in.ExpiresAt,
+			CreatedBy:      currentUserID(c),
+		}
+		if err := db.Transaction(func(tx *gorm.DB) error { return issueGiftCard(tx, &card, in.Note) }); err != nil {
+			utils.JSON(c, http.StatusInternalServerError, false, "failed to issue gift card", nil, err.Error())
+			return
+		}
+		utils.JSON(c, http.StatusCreated, true, "gift card issued", card, nil)
+	}
+}
+
+// UpdateGiftCard (Admin) - deactivates or reactivates a card, or changes its expiry and note
+func UpdateGiftCard(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var in struct {
+			Active    *bool      `json:"active"`
+			ExpiresAt *time.Time `json:"expires_at"`
+			Note      *string    `json:"note"`
+		}
+		if err := c.ShouldBindJSON(&in); err != nil {
+			utils.JSON(c, http.StatusBadRequest, false, "validation error", nil, err.Error())
+			return
+		}
+		var card config.GiftCard
+		if err := db.First(&card, "id = ?", c.Param("id")).Error; err != nil {
+			utils.JSON(c, http.StatusNotFound, false, "gift card not found", nil, nil)
+			return
+		}
+		updates := map[string]interface{}{}
+		if in.Active != nil {
+			updates["active"] = *in.Active
+		}
+		if in.ExpiresAt != nil {
+			updates["expires_at"] = *in.ExpiresAt
+		}
+		if in.Note != nil {
+			updates["note"] = *in.Note
+		}
+		if len(updates) > 0 {
+			if err := db.Model(&card).Updates(updates).Error; err != nil {
+				utils.JSON(c, http.StatusInternalServerError, false, "failed to update gift card", nil, err.Error())
+				return
+			}
+		}
+		db.First(&card, "id = ?", card.ID)
+		utils.JSON(c, http.StatusOK, true, "gift card updated", card, nil)
+	}
+}
@@ -0,0 +1,187 @@
+package controllers
+
+import (
+	"encoding/json"
+	"kalebecommerce/config"
+	"kalebecommerce/money"
+	"kalebecommerce/payments"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+// placeTenderOrder places an order for productID paid partly or fully with
+// tenders, or discounted with loyalty points.
+func placeTenderOrder(db *gorm.DB, userID, productID string, quantity int, tenders tenderRequest) *httptest.ResponseRecorder {
+	router := setupRouter()
+	router.POST("/orders", mockAuthMiddleware(userID), PlaceOrder(db, storeTestConfig()))
+	body, _ := json.Marshal(map[string]interface{}{
+		"items":              []OrderItemRequest{{ProductID: productID, Quantity: quantity}},
+		"shipping_address":   testAddress,
+		"shipping_method_id": testShippingMethod(db),
+		"gift_card_codes":    tenders.GiftCardCodes,
+		"use_store_credit":   tenders.UseStoreCredit,
+		"redeem_points":      tenders.RedeemPoints,
+	})
+	return sendJSON(router, "POST", "/orders", string(body))
+}
+
+// decodeOrder reads the order out of a PlaceOrder response.
+func decodeOrder(w *httptest.ResponseRecorder) config.Order {
+	var response struct {
+		Object config.Order `json:"object"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	return response.Object
+}
+
+func TestGiftCards_BuyRedeemRefund(t *testing.T) {
+	db := setupTestDB(t)
+	mock := payments.NewMockProvider()
+	cardProduct, mugID := uuid.New().String(), uuid.New().String()
+	db.Create(&config.Product{ID: cardProduct, Name: "Gift card 25", Price: money.MustParse("25.00"), Stock: 100, GiftCard: true})
+	db.Create(&config.Product{ID: mugID, Name: "Mug", Price: money.MustParse("10.00"), Stock: 20})
+
+	// Buying a card issues it once the order is paid
+	buyer := uuid.New().String()
+	bought := placePaidTestOrder(t, db, mock, buyer, cardProduct, 1)
+	router := setupRouter()
+	router.GET("/orders/:id", mockAuthMiddleware(buyer), GetOrder(db))
+	router.GET("/gift-cards/:code", mockAuthMiddleware(buyer), GetGiftCard(db))
+	router.POST("/admin/orders/:id/refunds", mockAdminAuthMiddleware(), CreateRefund(db, mock))
+	req, _ := http.NewRequest("GET", "/orders/"+bought.ID, nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	paid := decodeOrder(w)
+	if !assert.Len(t, paid.GiftCards, 1) {
+		return
+	}
+	code := paid.GiftCards[0].Code
+	assert.Equal(t, money.MustParse("25.00"), paid.GiftCards[0].Balance)
+
+	// Partial redemption: 10.00 of 25.00, nothing left to pay so the order is paid
+	shopper := uuid.New().String()
+	w = placeTenderOrder(db, shopper, mugID, 1, tenderRequest{GiftCardCodes: []string{"  " + code[:9] + code[10:]}})
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	first := decodeOrder(w)
+	assert.Equal(t, config.OrderStatusPaid, first.Status)
+	assert.Equal(t, money.MustParse("10.00"), first.TenderTotal)
+	assert.Equal(t, codeLast4(code), first.Tenders[0].CodeLast4)
+
+	// The remaining 15.00 covers part of a 30.00 order; the provider charges the rest
+	w = placeTenderOrder(db, shopper, mugID, 3, tenderRequest{GiftCardCodes: []string{code}})
+	assert.Equal(t, http.StatusCreated, w.Code)
+	second := decodeOrder(w)
+	assert.Equal(t, config.OrderStatusPending, second.Status)
+	assert.Equal(t, money.MustParse("15.00"), second.TenderTotal)
+	payment := startTestPayment(t, db, storeTestConfig(), mock, shopper, second.ID)
+	assert.Equal(t, money.MustParse("15.00"), payment.Amount)
+	event, _ := mock.Simulate(payment.IntentID, true)
+	assert.NoError(t, applyPaymentEvent(db, mock, *event))
+
+	w = placeTenderOrder(db, shopper, mugID, 1, tenderRequest{GiftCardCodes: []string{code}})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "no balance left")
+	assert.Equal(t, http.StatusBadRequest, placeTenderOrder(db, shopper, mugID, 1, tenderRequest{GiftCardCodes: []string{"NOPE-NOPE"}}).Code)
+
+	// A full refund goes to the card payment first, then back onto the gift card
+	w = sendJSON(router, "POST", "/admin/orders/"+second.ID+"/refunds", `{"reason":"changed mind"}`)
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var refund struct {
+		Object struct {
+			Refund config.Refund `json:"refund"`
+		} `json:"object"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &refund)
+	assert.Equal(t, money.MustParse("15.00"), refund.Object.Refund.ProviderAmount)
+	assert.Equal(t, money.MustParse("15.00"), refund.Object.Refund.TenderAmount)
+
+	req, _ = http.NewRequest("GET", "/gift-cards/"+code, nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	var card struct {
+		Object struct {
+			Balance      money.Amount `json:"balance"`
+			Transactions []struct {
+				Type string `json:"type"`
+			} `json:"transactions"`
+		} `json:"object"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &card)
+	assert.Equal(t, money.MustParse("15.00"), card.Object.Balance)
+	assert.Len(t, card.Object.Transactions, 4) // issue, two redemptions, refund
+
+	// A used card can't be refunded from the order that bought it
+	w = sendJSON(router, "POST", "/admin/orders/"+bought.ID+"/refunds", `{"reason":"not wanted"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "already been used")
+}
+
+func TestStoreCredit_GrantRedeemRefund(t *testing.T) {
+	db := setupTestDB(t)
+	mock := payments.NewMockProvider()
+	cfg := storeTestConfig()
+	userID := uuid.New().String()
+	db.Create(&config.User{ID: userID, Username: "credit", Email: "credit@example.com", Password: "x"})
+	mugID := uuid.New().String()
+	db.Create(&config.Product{ID: mugID, Name: "Mug", Price: money.MustParse("10.00"), Stock: 20})
+
+	router := setupRouter()
+	router.POST("/admin/users/:id/store-credit", mockAdminAuthMiddleware(), GrantStoreCredit(db, cfg))
+	router.GET("/store-credit", mockAuthMiddleware(userID), GetStoreCredit(db))
+	router.POST("/orders/:id/cancel", mockAuthMiddleware(userID), CancelOrder(db, mock))
+	router.POST("/admin/orders/:id/refunds", mockAdminAuthMiddleware(), CreateRefund(db, mock))
+	balance := func() money.Amount {
+		var account config.StoreCreditAccount
+		db.First(&account, "user_id = ? AND currency = ?", userID, "USD")
+		return account.Balance
+	}
+
+	assert.Equal(t, http.StatusCreated, sendJSON(router, "POST", "/admin/users/"+userID+"/store-credit", `{"amount":"30.00","note":"late delivery"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, sendJSON(router, "POST", "/admin/users/"+userID+"/store-credit", `{"amount":"-50.00","note":"too much"}`).Code)
+	assert.Equal(t, http.StatusNotFound, sendJSON(router, "POST", "/admin/users/"+uuid.New().String()+"/store-credit", `{"amount":"5.00","note":"nobody"}`).Code)
+
+	// 20.00 of credit pays a whole order
+	w := placeTenderOrder(db, userID, mugID, 2, tenderRequest{UseStoreCredit: true})
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	paid := decodeOrder(w)
+	assert.Equal(t, config.OrderStatusPaid, paid.Status)
+	assert.Equal(t, money.MustParse("10.00"), balance())
+
+	// The last 10.00 is held by a pending order and released when it's cancelled
+	w = placeTenderOrder(db, userID, mugID, 3, tenderRequest{UseStoreCredit: true})
+	pending := decodeOrder(w)
+	assert.Equal(t, money.MustParse("10.00"), pending.TenderTotal)
+	assert.Equal(t, money.Amount(0), balance())
+	assert.Equal(t, http.StatusOK, sendJSON(router, "POST", "/orders/"+pending.ID+"/cancel", `{}`).Code)
+	assert.Equal(t, money.MustParse("10.00"), balance())
+
+	// A card-paid order refunded to store credit
+	card := placePaidTestOrder(t, db, mock, userID, mugID, 1)
+	w = sendJSON(router, "POST", "/admin/orders/"+card.ID+"/refunds", `{"reason":"goodwill","store_credit":true}`)
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	assert.Equal(t, money.MustParse("20.00"), balance())
+	// Nothing went back to the card, so the refund has no payment
+	var withoutPayment int64
+	db.Model(&config.Refund{}).Where("order_id = ? AND payment_id IS NULL", card.ID).Count(&withoutPayment)
+	assert.Equal(t, int64(1), withoutPayment)
+
+	req, _ := http.NewRequest("GET", "/store-credit", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	var statement struct {
+		Object struct {
+			Transactions []config.StoreCreditTransaction `json:"transactions"`
+		} `json:"object"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &statement)
+	types := []string{}
+	for _, entry := range statement.Object.Transactions {
+		types = append(types, entry.Type)
+	}
+	assert.Equal(t, []string{config.LedgerRefund, config.LedgerRelease, config.LedgerRedeem, config.LedgerRedeem, config.LedgerGrant}, types)
+}
@@ -0,0 +1,127 @@
+package controllers
+
+import (
+	"kalebecommerce/config"
+	"kalebecommerce/utils"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// guestCheckoutInput is the contact data a guest supplies instead of an account,
+// with the shipping method, an optional coupon and gift cards for the order.
+type guestCheckoutInput struct {
+	Email            string         `json:"email" binding:"required,email"`
+	ShippingAddress  config.Address `json:"shipping_address" binding:"required"`
+	ShippingMethodID string         `json:"shipping_method_id" binding:"required"`
+	CouponCode       string         `json:"coupon_code"`
+	GiftCardCodes    []string       `json:"gift_card_codes"`
+}
+
+// guestOrderToken signs an order ID so a guest can look the order up without logging in.
+func guestOrderToken(cfg *config.Config, orderID string) string {
+	return utils.SignValue("order:"+orderID, cfg.JWTSecret)
+}
+
+// respondGuestOrder returns a freshly placed guest order along with its lookup link.
+func respondGuestOrder(c *gin.Context, cfg *config.Config, order config.Order) {
+	token := guestOrderToken(cfg, order.ID)
+	utils.JSON(c, http.StatusCreated, true, "order placed successfully", gin.H{
+		"order":        order,
+		"lookup_token": token,
+		"lookup_url":   strings.TrimRight(cfg.StoreURL, "/") + "/api/guest/orders/" + order.ID + "?token=" + token,
+	}, nil)
+}
+
+// ClaimGuestOrder - attaches a guest order to the current account. The signed
+// lookup token proves the caller placed it; a matching email alone doesn't,
+// since nobody verified that the account owns that address.
+func ClaimGuestOrder(db *gorm.DB, cfg *config.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		orderID := c.Param("id")
+		value, ok := utils.VerifySignedValue(c.Query("token"), cfg.JWTSecret)
+		if !ok || value != "order:"+orderID {
+			utils.JSON(c, http.StatusForbidden, false, "invalid order lookup token", nil, nil)
+			return
+		}
+		uid := currentUserID(c)
+		if uid == nil {
+			utils.JSON(c, http.StatusUnauthorized, false, "unauthorized", nil, nil)
+			return
+		}
+
+		res := db.Model(&config.Order{}).Where("id = ? AND user_id IS NULL", orderID).Update("user_id", *uid)
+		if res.Error != nil {
+			utils.JSON(c, http.StatusInternalServerError, false, "failed to claim order", nil, res.Error.Error())
+			return
+		}
+		var order config.Order
+		if err := db.Preload("Items").First(&order, "id = ?", orderID).Error; err != nil {
+			utils.JSON(c, http.StatusNotFound, false, "order not found", nil, nil)
+			return
+		}
+		if res.RowsAffected == 0 && (order.UserID == nil || *order.UserID != *uid) {
+			utils.JSON(c, http.StatusConflict, false, "order already belongs to an account", nil, nil)
+			return
+		}
+		utils.JSON(c, http.StatusOK, true, "order attached to your account", order, nil)
+	}
+}
+
+// PlaceGuestOrder - places an order with an email, shipping address and method but no account
+func PlaceGuestOrder(db *gorm.DB, cfg *config.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		pr, ok := requestPricing(c, db, cfg)
+		if !ok {
+			return
+		}
+		var in struct {
+			guestCheckoutInput
+			Items []orderLine `json:"items" binding:"required,min=1,dive"`
+		}
+		if err := c.ShouldBindJSON(&in); err != nil {
+			utils.JSON(c, http.StatusBadRequest, false, "validation error", nil, err.Error())
+			return
+		}
+
+		order := config.Order{
+			ID:               uuid.New().String(),
+			GuestEmail:       strings.ToLower(in.Email),
+			ShippingAddress:  normalizeAddress(in.ShippingAddress),
+			ShippingMethodID: &in.ShippingMethodID,
+			CouponCode:       in.CouponCode,
+			Status:           config.OrderStatusPending,
+		}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			return createOrder(tx, cfg, &order, in.Items, pr, tenderRequest{GiftCardCodes: in.GiftCardCodes})
+		})
+		if err != nil {
+			respondOrderError(c, err)
+			return
+		}
+		snapshotOrderImages(db, &order)
+		respondGuestOrder(c, cfg, order)
+	}
+}
+
+// GetGuestOrder - looks up a guest order with the signed token from its lookup link
+func GetGuestOrder(db *gorm.DB, cfg *config.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		orderID := c.Param("id")
+		value, ok := utils.VerifySignedValue(c.Query("token"), cfg.JWTSecret)
+		if !ok || value != "order:"+orderID {
+			utils.JSON(c, http.StatusForbidden, false, "invalid order lookup token", nil, nil)
+			return
+		}
+
+		var order config.Order
+		if err := db.Preload("Items").Preload("TaxLines").Preload("Discounts").Preload("Tenders").Preload("GiftCards").Preload("Shipments.Items").First(&order, "id = ?", orderID).Error; err != nil {
+			utils.JSON(c, http.StatusNotFound, false, "order not found", nil, nil)
+			return
+		}
+		utils.JSON(c, http.StatusOK, true, "order retrieved", order, nil)
+	}
+}
@@ -0,0 +1,157 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"kalebecommerce/config"
+	"kalebecommerce/money"
+	"kalebecommerce/utils"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+const guestAddressJSON = `{"full_name":"Abebe Kebede","line1":"Bole Road 12","city":"Addis Ababa","country":"ET"}`
+
+// guestOrderResponse decodes the order and lookup data from a guest checkout response
+type guestOrderResponse struct {
+	Order       config.Order `json:"order"`
+	LookupToken string       `json:"lookup_token"`
+	LookupURL   string       `json:"lookup_url"`
+}
+
+func TestPlaceGuestOrder_ReturnsLookupLink(t *testing.T) {
+	db := setupTestDB(t)
+	router := setupRouter()
+	productID := uuid.New().String()
+	db.Create(&config.Product{ID: productID, Name: "Mug", Price: money.MustParse("8.00"), Stock: 5})
+
+	router.POST("/guest/orders", PlaceGuestOrder(db, mockConfig()))
+	router.GET("/guest/orders/:id", GetGuestOrder(db, mockConfig()))
+
+	body := `{"email":"Guest@Example.com","shipping_address":` + guestAddressJSON +
+		`,"shipping_method_id":"` + testShippingMethod(db) + `","items":[{"productId":"` + productID + `","quantity":2}]}`
+	req, _ := http.NewRequest("POST", "/guest/orders", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+	var response struct {
+		Object guestOrderResponse `json:"object"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	placed := response.Object
+	assert.Nil(t, placed.Order.UserID)
+	assert.Equal(t, "guest@example.com", placed.Order.GuestEmail)
+	assert.Equal(t, "Addis Ababa", placed.Order.ShippingAddress.City)
+	assert.Equal(t, money.MustParse("16.00"), placed.Order.TotalPrice)
+	assert.Contains(t, placed.LookupURL, "/api/guest/orders/"+placed.Order.ID+"?token=")
+
+	// The signed token opens the order
+	req, _ = http.NewRequest("GET", "/guest/orders/"+placed.Order.ID+"?token="+placed.LookupToken, nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "order retrieved")
+
+	// A token for another order does not
+	other := guestOrderToken(mockConfig(), uuid.New().String())
+	req, _ = http.NewRequest("GET", "/guest/orders/"+placed.Order.ID+"?token="+other, nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestPlaceGuestOrder_ValidationFailure(t *testing.T) {
+	db := setupTestDB(t)
+	router := setupRouter()
+	router.POST("/guest/orders", PlaceGuestOrder(db, mockConfig()))
+
+	body := `{"email":"not-an-email","shipping_address":` + guestAddressJSON +
+		`,"shipping_method_id":"` + testShippingMethod(db) + `","items":[{"productId":"` + uuid.New().String() + `","quantity":1}]}`
+	req, _ := http.NewRequest("POST", "/guest/orders", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "validation error")
+}
+
+func TestCheckoutCart_GuestRequiresEmailAndAddress(t *testing.T) {
+	db := setupTestDB(t)
+	cfg := mockConfig()
+	router := setupRouter()
+	productID := uuid.New()
+	db.Create(&config.Product{ID: productID.String(), Name: "Mug", Price: money.MustParse("8.00"), Stock: 5})
+	cart := config.Cart{ID: uuid.New().String()}
+	db.Create(&cart)
+	db.Create(&config.CartItem{ID: uuid.New().String(), CartID: cart.ID, ProductID: productID, Quantity: 1})
+
+	router.POST("/cart/checkout", CheckoutCart(db, cfg))
+
+	req, _ := http.NewRequest("POST", "/cart/checkout", nil)
+	req.Header.Set(cartTokenHeader, utils.SignValue("cart:"+cart.ID, cfg.JWTSecret))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	body := `{"email":"guest@example.com","shipping_address":` + guestAddressJSON +
+		`,"shipping_method_id":"` + testShippingMethod(db) + `"}`
+	req, _ = http.NewRequest("POST", "/cart/checkout", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(cartTokenHeader, utils.SignValue("cart:"+cart.ID, cfg.JWTSecret))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Contains(t, w.Body.String(), "lookup_token")
+}
+
+func TestRegister_DoesNotAttachGuestOrdersByEmail(t *testing.T) {
+	db := setupTestDB(t)
+	router := setupRouter()
+	router.POST("/register", Register(db, mockConfig()))
+
+	guestOrder := config.Order{ID: uuid.New().String(), GuestEmail: "kaleb@example.com", Status: "pending"}
+	db.Create(&guestOrder)
+
+	jsonBody, _ := json.Marshal(RegisterInput{Username: "kaleb", Email: "Kaleb@example.com", Password: "Strong@123"})
+	req, _ := http.NewRequest("POST", "/register", bytes.NewBuffer(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	var order config.Order
+	db.First(&order, "id = ?", guestOrder.ID)
+	assert.Nil(t, order.UserID)
+}
+
+func TestClaimGuestOrder_RequiresLookupToken(t *testing.T) {
+	db := setupTestDB(t)
+	cfg := mockConfig()
+	owner, other := uuid.New(), uuid.New()
+	router := setupRouter()
+	router.POST("/guest/orders/:id/claim", mockAuthMiddleware(owner.String()), ClaimGuestOrder(db, cfg))
+	router.POST("/other/guest/orders/:id/claim", mockAuthMiddleware(other.String()), ClaimGuestOrder(db, cfg))
+
+	guestOrder := config.Order{ID: uuid.New().String(), GuestEmail: "kaleb@example.com", Status: "pending"}
+	db.Create(&guestOrder)
+	token := guestOrderToken(cfg, guestOrder.ID)
+
+	assert.Equal(t, http.StatusForbidden, sendJSON(router, "POST", "/guest/orders/"+guestOrder.ID+"/claim?token=forged", `{}`).Code)
+	assert.Equal(t, http.StatusOK, sendJSON(router, "POST", "/guest/orders/"+guestOrder.ID+"/claim?token="+token, `{}`).Code)
+	var order config.Order
+	db.First(&order, "id = ?", guestOrder.ID)
+	if assert.NotNil(t, order.UserID) {
+		assert.Equal(t, owner, *order.UserID)
+	}
+
+	// Claiming again is harmless; nobody else can take it over
+	assert.Equal(t, http.StatusOK, sendJSON(router, "POST", "/guest/orders/"+guestOrder.ID+"/claim?token="+token, `{}`).Code)
+	assert.Equal(t, http.StatusConflict, sendJSON(router, "POST", "/other/guest/orders/"+guestOrder.ID+"/claim?token="+token, `{}`).Code)
+}
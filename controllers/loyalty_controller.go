@@ -0,0 +1,569 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"kalebecommerce/config"
+	"kalebecommerce/money"
+	"kalebecommerce/utils"
+	"log"
+	"math/big"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// errInvalidPoints is returned when an order can't redeem the loyalty points it asked for.
+var errInvalidPoints = errors.New("invalid points redemption")
+
+var pointsRatePattern = regexp.MustCompile(`^[0-9]{1,6}(\.[0-9]{1,4})?$`)
+
+// parsePointsRate reads a rule's points per unit of base currency.
+func parsePointsRate(s string) (*big.Rat, error) {
+	if !pointsRatePattern.MatchString(s) {
+		return nil, fmt.Errorf("invalid points rate %q", s)
+	}
+	rate, _ := new(big.Rat).SetString(s)
+	return rate, nil
+}
+
+// changePoints moves a customer's balance by delta and returns the new
+// balance, opening the account on first use. The conditional UPDATE keeps
+// concurrent redemptions from taking the balance below zero; only overdraw
+// may, for points that have to be taken back even though they were spent.
+func changePoints(tx *gorm.DB, userID uuid.UUID, delta int, overdraw bool) (int, error) {
+	opened := config.LoyaltyAccount{UserID: userID}
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&opened).Error; err != nil {
+		return 0, err
+	}
+	query := tx.Model(&config.LoyaltyAccount{}).Where("user_id = ?", userID)
+	if !overdraw {
+		query = query.Where("balance + ? >= 0", delta)
+	}
+	res := query.Update("balance", gorm.Expr("balance + ?", delta))
+	if res.Error != nil {
+		return 0, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return 0, errInsufficientBalance
+	}
+	var account config.LoyaltyAccount
+	if err := tx.First(&account, "user_id = ?", userID).Error; err != nil {
+		return 0, err
+	}
+	return account.Balance, nil
+}
+
+// recordPoints appends entry to a customer's points ledger.
+func recordPoints(tx *gorm.DB, userID uuid.UUID, balance int, entry config.LoyaltyTransaction) error {
+	entry.ID = uuid.New().String()
+	entry.UserID = userID
+	entry.BalanceAfter = balance
+	return tx.Create(&entry).Error
+}
+
+// creditPoints adds points to a customer as a new lot. Points that pay off a
+// negative balance are used up straight away and don't enter the lot.
+func creditPoints(tx *gorm.DB, userID uuid.UUID, points int, entry config.LoyaltyTransaction) error {
+	balance, err := changePoints(tx, userID, points, false)
+	if err != nil {
+		return err
+	}
+	entry.Points = points
+	entry.Remaining = min(points, max(balance, 0))
+	return recordPoints(tx, userID, balance, entry)
+}
+
+// debitPoints takes points off a customer, out of their oldest lots first.
+// The lot with ID first, when given, is used before any other. With overdraw
+// the balance may go negative once the lots run out; later credits pay it off.
+func debitPoints(tx *gorm.DB, userID uuid.UUID, points int, first string, overdraw bool, entry config.LoyaltyTransaction) error {
+	balance, err := changePoints(tx, userID, -points, overdraw)
+	if err != nil {
+		return err
+	}
+	var lots []config.LoyaltyTransaction
+	if err := tx.Where("user_id = ? AND remaining > 0", userID).Order("created_at, id").Find(&lots).Error; err != nil {
+		return err
+	}
+	sort.SliceStable(lots, func(a, b int) bool { return lots[a].ID == first && lots[b].ID != first })
+	left := points
+	for _, lot := range lots {
+		if left == 0 {
+			break
+		}
+		take := min(lot.Remaining, left)
+		err := tx.Model(&config.LoyaltyTransaction{}).Where("id = ?", lot.ID).
+			Update("remaining", gorm.Expr("remaining - ?", take)).Error
+		if err != nil {
+			return err
+		}
+		left -= take
+	}
+	entry.Points = -points
+	return recordPoints(tx, userID, balance, entry)
+}
+
+// loyaltyRules are the active earning rules, keyed by what they apply to.
+type loyaltyRules struct {
+	products   map[string]*big.Rat
+	categories map[string]*big.Rat
+	fallback   *big.Rat
+}
+
+// loadLoyaltyRules reads the active rules. When two rules have the same
+// scope, the older one wins.
+func loadLoyaltyRules(tx *gorm.DB) (*loyaltyRules, error) {
+	var rows []config.LoyaltyRule
+	if err := tx.Where("active = ?", true).Order("created_at, id").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	rules := &loyaltyRules{products: map[string]*big.Rat{}, categories: map[string]*big.Rat{}}
+	for _, row := range rows {
+		rate, err := parsePointsRate(row.PointsPerUnit)
+		if err != nil {
+			continue
+		}
+		switch {
+		case row.ProductID != nil:
+			if _, ok := rules.products[*row.ProductID]; !ok {
+				rules.products[*row.ProductID] = rate
+			}
+		case row.Category != "":
+			if _, ok := rules.categories[row.Category]; !ok {
+				rules.categories[row.Category] = rate
+			}
+		case rules.fallback == nil:
+			rules.fallback = rate
+		}
+	}
+	return rules, nil
+}
+
+// rate is the points per unit of base currency a product earns, or nil.
+func (r *loyaltyRules) rate(productID, category string) *big.Rat {
+	if rate, ok := r.products[productID]; ok {
+		return rate
+	}
+	if rate, ok := r.categories[category]; ok && category != "" {
+		return rate
+	}
+	return r.fallback
+}
+
+// awardPoints credits a customer for a paid order: each line earns its rule's
+// rate on what was charged for it, in the base currency, rounded down over the
+// whole order. Gift cards earn nothing, and guest orders have no account.
+func awardPoints(tx *gorm.DB, order *config.Order) error {
+	if order.UserID == nil || order.PointsEarned > 0 {
+		return nil
+	}
+	var items []config.OrderItem
+	if err := tx.Where("order_id = ? AND gift_card = ?", order.ID, false).Find(&items).Error; err != nil {
+		return err
+	}
+	rules, err := loadLoyaltyRules(tx)
+	if err != nil {
+		return err
+	}
+	ids := make([]string, 0, len(items))
+	for _, item := range items {
+		ids = append(ids, item.ProductID.String())
+	}
+	var products []config.Product
+	if err := tx.Select("id", "category").Where("id IN ?", ids).Find(&products).Error; err != nil {
+		return err
+	}
+	categories := map[string]string{}
+	for _, p := range products {
+		categories[p.ID] = p.Category
+	}
+
+	total := new(big.Rat)
+	for _, item := range items {
+		perUnit := rules.rate(item.ProductID.String(), categories[item.ProductID.String()])
+		if perUnit == nil {
+			continue
+		}
+		line := new(big.Rat).SetInt64(int64(lineRemaining(item)))
+		total.Add(total, line.Mul(line, perUnit))
+	}
+	// total is in cents of the order currency: back to whole base units
+	rate, err := money.ParseRate(order.ExchangeRate)
+	if err != nil {
+		rate = big.NewRat(1, 1)
+	}
+	total.Quo(total, rate).Quo(total, big.NewRat(100, 1))
+	points := int(new(big.Int).Quo(total.Num(), total.Denom()).Int64())
+	if points <= 0 {
+		return nil
+	}
+
+	entry := config.LoyaltyTransaction{Type: config.PointsEarn, OrderID: &order.ID, Note: "order paid"}
+	if err := creditPoints(tx, *order.UserID, points, entry); err != nil {
+		return err
+	}
+	order.PointsEarned = points
+	return tx.Model(order).Update("points_earned", points).Error
+}
+
+// redeemPoints takes the points a customer redeems off the order's lines as a
+// discount worth LoyaltyPointValue each. Gift card lines can't be paid with points.
+func redeemPoints(tx *gorm.DB, cfg *config.Config, order *config.Order, points int, pr *pricing) error {
+	if points == 0 {
+		return nil
+	}
+	if order.UserID == nil {
+		return fmt.Errorf("%w: sign in to redeem points", errInvalidPoints)
+	}
+	value := pr.convert(cfg.LoyaltyPointValue.Mul(points))
+	var lines []int
+	var eligible money.Amount
+	for i, item := range order.Items {
+		if !item.GiftCard {
+			lines = append(lines, i)
+			eligible += lineRemaining(item)
+		}
+	}
+	if value <= 0 {
+		return fmt.Errorf("%w: points have no value in this store", errInvalidPoints)
+	}
+	if value > eligible {
+		return fmt.Errorf("%w: %d points are worth %s, more than the %s they can pay for",
+			errInvalidPoints, points, value, eligible)
+	}
+	// No account yet is a zero balance
+	var account config.LoyaltyAccount
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&account, "user_id = ?", *order.UserID).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	if account.Balance < points {
+		return fmt.Errorf("%w: only %d points available", errInvalidPoints, account.Balance)
+	}
+
+	entry := config.LoyaltyTransaction{Type: config.PointsRedeem, OrderID: &order.ID, Note: "redeemed at checkout"}
+	if err := debitPoints(tx, *order.UserID, points, "", false, entry); err != nil {
+		return err
+	}
+	amount := spreadDiscount(order, lines, value)
+	order.Discounts = append(order.Discounts, config.OrderDiscount{
+		ID:      uuid.New().String(),
+		OrderID: order.ID,
+		Name:    fmt.Sprintf("%d loyalty points", points),
+		Type:    config.DiscountPoints,
+		Amount:  amount,
+	})
+	order.DiscountTotal += amount
+	order.PointsRedeemed = points
+	return nil
+}
+
+// settleOrderPoints takes back the share of an order's earned points that was
+// refunded, and gives back the same share of the points redeemed on it; all
+// of both once the order is cancelled or failed. It tops up what the ledger
+// already shows for the order, so it can run after every refund. Earned points
+// the customer already spent are still taken back, leaving a negative balance
+// that the customer's next points pay off.
+func settleOrderPoints(tx *gorm.DB, order *config.Order, cancelled bool) error {
+	if order.UserID == nil || order.PointsEarned == 0 && order.PointsRedeemed == 0 {
+		return nil
+	}
+	share := big.NewRat(1, 1)
+	if !cancelled {
+		if order.TotalPrice <= 0 {
+			return nil
+		}
+		share = big.NewRat(int64(min(order.RefundedAmount, order.TotalPrice)), int64(order.TotalPrice))
+	}
+	portion := func(points int) int {
+		r := new(big.Rat).Mul(big.NewRat(int64(points), 1), share)
+		return int(new(big.Int).Quo(r.Num(), r.Denom()).Int64())
+	}
+
+	var settled []struct {
+		Type   string
+		Points int
+	}
+	err := tx.Model(&config.LoyaltyTransaction{}).Select("type, SUM(points) AS points").
+		Where("order_id = ?", order.ID).Group("type").Scan(&settled).Error
+	if err != nil {
+		return err
+	}
+	done := map[string]int{}
+	for _, row := range settled {
+		done[row.Type] = row.Points
+	}
+
+	if restore := portion(order.PointsRedeemed) - done[config.PointsRestore]; restore > 0 {
+		entry := config.LoyaltyTransaction{Type: config.PointsRestore, OrderID: &order.ID, Note: "order refunded or cancelled"}
+		if err := creditPoints(tx, *order.UserID, restore, entry); err != nil {
+			return err
+		}
+	}
+	// Reversals are negative entries
+	reverse := portion(order.PointsEarned) + done[config.PointsReverse]
+	if reverse <= 0 {
+		return nil
+	}
+	var earned config.LoyaltyTransaction
+	tx.Where("order_id = ? AND type = ?", order.ID, config.PointsEarn).First(&earned)
+	entry := config.LoyaltyTransaction{Type: config.PointsReverse, OrderID: &order.ID, Note: "order refunded or cancelled"}
+	return debitPoints(tx, *order.UserID, reverse, earned.ID, true, entry)
+}
+
+// StartPointsExpiry expires loyalty points older than LoyaltyPointsExpiryDays,
+// every interval until the process exits. Nothing expires when it is 0.
+func StartPointsExpiry(db *gorm.DB, cfg *config.Config, interval time.Duration) {
+	if cfg.LoyaltyPointsExpiryDays <= 0 {
+		return
+	}
+	period := time.Duration(cfg.LoyaltyPointsExpiryDays) * 24 * time.Hour
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if err := expirePoints(db, time.Now(), period); err != nil {
+				log.Printf("points expiry: %v", err)
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+// expirePoints takes what is left of every lot older than period off its
+// owner's balance. A lot that can't be expired is logged and retried on the
+// next run.
+func expirePoints(db *gorm.DB, now time.Time, period time.Duration) error {
+	var lots []config.LoyaltyTransaction
+	if err := db.Where("remaining > 0 AND created_at <= ?", now.Add(-period)).Find(&lots).Error; err != nil {
+		return err
+	}
+	for _, l := range lots {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			var lot config.LoyaltyTransaction
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&lot, "id = ?", l.ID).Error; err != nil {
+				return err
+			}
+			if lot.Remaining == 0 {
+				return nil
+			}
+			entry := config.LoyaltyTransaction{Type: config.PointsExpire, Points: -lot.Remaining, OrderID: lot.OrderID, Note: "points expired"}
+			balance, err := changePoints(tx, lot.UserID, -lot.Remaining, false)
+			if err != nil {
+				return err
+			}
+			if err := tx.Model(&lot).Update("remaining", 0).Error; err != nil {
+				return err
+			}
+			return recordPoints(tx, lot.UserID, balance, entry)
+		})
+		if err != nil {
+			log.Printf("points expiry: lot %s: %v", l.ID, err)
+		}
+	}
+	return nil
+}
+
+// loyaltyStatement is a customer's balance, their ledger newest first, and
+// the lots still holding points with when they expire.
+func loyaltyStatement(db *gorm.DB, cfg *config.Config, userID string) (gin.H, error) {
+	var account config.LoyaltyAccount
+	db.First(&account, "user_id = ?", userID)
+	var entries []config.LoyaltyTransaction
+	if err := db.Where("user_id = ?", userID).Order("created_at DESC, id").Find(&entries).Error; err != nil {
+		return nil, err
+	}
+	lots := []config.LoyaltyTransaction{}
+	for i := len(entries) - 1; i >= 0; i-- {
+		lot := entries[i]
+		if lot.Remaining == 0 {
+			continue
+		}
+		if cfg.LoyaltyPointsExpiryDays > 0 {
+			expires := lot.CreatedAt.AddDate(0, 0, cfg.LoyaltyPointsExpiryDays)
+			lot.ExpiresAt = &expires
+		}
+		lots = append(lots, lot)
+	}
+	return gin.H{
+		"balance":      account.Balance,
+		"point_value":  cfg.LoyaltyPointValue,
+		"lots":         lots,
+		"transactions": entries,
+	}, nil
+}
+
+// GetLoyalty - the current user's points balance, expiring lots and history
+func GetLoyalty(db *gorm.DB, cfg *config.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		statement, err := loyaltyStatement(db, cfg, c.GetString("user_id"))
+		if err != nil {
+			utils.JSON(c, http.StatusInternalServerError, false, "failed to fetch loyalty points", nil, err.Error())
+			return
+		}
+		utils.JSON(c, http.StatusOK, true, "loyalty points retrieved", statement, nil)
+	}
+}
+
+// AdminGetLoyalty (Admin) - a customer's points balance, expiring lots and history
+func AdminGetLoyalty(db *gorm.DB, cfg *config.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		statement, err := loyaltyStatement(db, cfg, c.Param("id"))
+		if err != nil {
+			utils.JSON(c, http.StatusInternalServerError, false, "failed to fetch loyalty points", nil, err.Error())
+			return
+		}
+		utils.JSON(c, http.StatusOK, true, "loyalty points retrieved", statement, nil)
+	}
+}
+
+// AdjustLoyaltyPoints (Admin) - adds points to a customer, or takes some back
+// with a negative number
+func AdjustLoyaltyPoints(db *gorm.DB, cfg *config.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var in struct {
+			Points int    `json:"points" binding:"required"`
+			Note   string `json:"note" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&in); err != nil {
+			utils.JSON(c, http.StatusBadRequest, false, "validation error", nil, err.Error())
+			return
+		}
+		var user config.User
+		if err := db.First(&user, "id = ?", c.Param("id")).Error; err != nil {
+			utils.JSON(c, http.StatusNotFound, false, "user not found", nil, nil)
+			return
+		}
+		userID, _ := uuid.Parse(user.ID)
+
+		entry := config.LoyaltyTransaction{Type: config.PointsAdjust, Note: strings.TrimSpace(in.Note), CreatedBy: currentUserID(c)}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if in.Points > 0 {
+				return creditPoints(tx, userID, in.Points, entry)
+			}
+			return debitPoints(tx, userID, -in.Points, "", false, entry)
+		})
+		if errors.Is(err, errInsufficientBalance) {
+			utils.JSON(c, http.StatusBadRequest, false, "insufficient balance", nil, "points can't go below zero")
+			return
+		}
+		if err != nil {
+			utils.JSON(c, http.StatusInternalServerError, false, "failed to adjust points", nil, err.Error())
+			return
+		}
+		statement, err := loyaltyStatement(db, cfg, user.ID)
+		if err != nil {
+			utils.JSON(c, http.StatusInternalServerError, false, "failed to fetch loyalty points", nil, err.Error())
+			return
+		}
+		utils.JSON(c, http.StatusCreated, true, "points adjusted", statement, nil)
+	}
+}
+
+// loyaltyRuleInput is the body of CreateLoyaltyRule and UpdateLoyaltyRule.
+// A rule applies to one product, one category, or, with neither, to everything else.
+type loyaltyRuleInput struct {
+	Name          string  `json:"name" binding:"required"`
+	ProductID     *string `json:"product_id" binding:"omitempty,uuid"`
+	Category      string  `json:"category"`
+	PointsPerUnit string  `json:"points_per_unit" binding:"required"`
+	Active        *bool   `json:"active"`
+}
+
+// bindLoyaltyRule validates the request body into rule.
+func bindLoyaltyRule(c *gin.Context, db *gorm.DB, rule *config.LoyaltyRule) error {
+	var in loyaltyRuleInput
+	if err := c.ShouldBindJSON(&in); err != nil {
+		return err
+	}
+	rule.Name = strings.TrimSpace(in.Name)
+	rule.Category = strings.TrimSpace(in.Category)
+	if in.ProductID != nil && rule.Category != "" {
+		return errors.New("a rule applies to a product or a category, not both")
+	}
+	if in.ProductID != nil {
+		var count int64
+		db.Model(&config.Product{}).Where("id = ?", *in.ProductID).Count(&count)
+		if count == 0 {
+			return errors.New("product not found")
+		}
+	}
+	rule.ProductID = in.ProductID
+	if _, err := parsePointsRate(in.PointsPerUnit); err != nil {
+		return errors.New("points_per_unit must be a number from 0 with at most 4 decimals")
+	}
+	rule.PointsPerUnit = in.PointsPerUnit
+	if in.Active != nil {
+		rule.Active = *in.Active
+	}
+	return nil
+}
+
+// ListLoyaltyRules (Admin) - every loyalty earning rule, oldest first
+func ListLoyaltyRules(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var rules []config.LoyaltyRule
+		db.Order("created_at, id").Find(&rules)
+		utils.JSON(c, http.StatusOK, true, "loyalty rules listed", rules, nil)
+	}
+}
+
+// CreateLoyaltyRule (Admin) - adds a points-per-unit rule for a product, a category or the whole store
+func CreateLoyaltyRule(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		rule := config.LoyaltyRule{ID: uuid.New().String(), Active: true}
+		if err := bindLoyaltyRule(c, db, &rule); err != nil {
+			utils.JSON(c, http.StatusBadRequest, false, "validation error", nil, err.Error())
+			return
+		}
+		if err := db.Create(&rule).Error; err != nil {
+			utils.JSON(c, http.StatusInternalServerError, false, "failed to save loyalty rule", nil, err.Error())
+			return
+		}
+		utils.JSON(c, http.StatusCreated, true, "loyalty rule created", rule, nil)
+	}
+}
+
+// UpdateLoyaltyRule (Admin) - replaces a loyalty rule; paid orders keep the points they earned
+func UpdateLoyaltyRule(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var rule config.LoyaltyRule
+		if err := db.First(&rule, "id = ?", c.Param("id")).Error; err != nil {
+			utils.JSON(c, http.StatusNotFound, false, "loyalty rule not found", nil, nil)
+			return
+		}
+		if err := bindLoyaltyRule(c, db, &rule); err != nil {
+			utils.JSON(c, http.StatusBadRequest, false, "validation error", nil, err.Error())
+			return
+		}
+		if err := db.Save(&rule).Error; err != nil {
+			utils.JSON(c, http.StatusInternalServerError, false, "failed to save loyalty rule", nil, err.Error())
+			return
+		}
+		utils.JSON(c, http.StatusOK, true, "loyalty rule updated", rule, nil)
+	}
+}
+
+// DeleteLoyaltyRule (Admin) - removes a loyalty rule
+func DeleteLoyaltyRule(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		res := db.Delete(&config.LoyaltyRule{}, "id = ?", c.Param("id"))
+		if res.Error != nil {
+			utils.JSON(c, http.StatusInternalServerError, false, "failed to delete loyalty rule", nil, res.Error.Error())
+			return
+		}
+		if res.RowsAffected == 0 {
+			utils.JSON(c, http.StatusNotFound, false, "loyalty rule not found", nil, nil)
+			return
+		}
+		utils.JSON(c, http.StatusOK, true, "loyalty rule deleted", nil, nil)
+	}
+}
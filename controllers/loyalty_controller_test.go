@@ -0,0 +1,158 @@
+package controllers
+
+import (
+	"encoding/json"
+	"kalebecommerce/config"
+	"kalebecommerce/money"
+	"kalebecommerce/payments"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoyalty_EarnRedeemReverseExpire(t *testing.T) {
+	db := setupTestDB(t)
+	mock := payments.NewMockProvider()
+	cfg := storeTestConfig()
+	userID := uuid.New().String()
+	db.Create(&config.User{ID: userID, Username: "loyal", Email: "loyal@example.com", Password: "x"})
+	mugID, teeID := uuid.New().String(), uuid.New().String()
+	db.Create(&config.Product{ID: mugID, Name: "Mug", Price: money.MustParse("10.00"), Stock: 20, Category: "kitchen"})
+	db.Create(&config.Product{ID: teeID, Name: "Tee", Price: money.MustParse("15.00"), Stock: 20, Category: "apparel"})
+
+	router := setupRouter()
+	router.POST("/admin/loyalty-rules", mockAdminAuthMiddleware(), CreateLoyaltyRule(db))
+	router.POST("/admin/users/:id/loyalty", mockAdminAuthMiddleware(), AdjustLoyaltyPoints(db, cfg))
+	router.GET("/loyalty", mockAuthMiddleware(userID), GetLoyalty(db, cfg))
+	router.POST("/orders/:id/cancel", mockAuthMiddleware(userID), CancelOrder(db, mock))
+	router.POST("/admin/orders/:id/refunds", mockAdminAuthMiddleware(), CreateRefund(db, mock))
+	balance := func() int {
+		var account config.LoyaltyAccount
+		db.First(&account, "user_id = ?", userID)
+		return account.Balance
+	}
+
+	// 1 point per unit by default, 2 for kitchen, none for the tee
+	assert.Equal(t, http.StatusCreated, sendJSON(router, "POST", "/admin/loyalty-rules", `{"name":"Base","points_per_unit":"1"}`).Code)
+	assert.Equal(t, http.StatusCreated, sendJSON(router, "POST", "/admin/loyalty-rules", `{"name":"Kitchen","category":"kitchen","points_per_unit":"2"}`).Code)
+	assert.Equal(t, http.StatusCreated, sendJSON(router, "POST", "/admin/loyalty-rules", `{"name":"No tees","product_id":"`+teeID+`","points_per_unit":"0"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, sendJSON(router, "POST", "/admin/loyalty-rules", `{"name":"Both","product_id":"`+teeID+`","category":"kitchen","points_per_unit":"1"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, sendJSON(router, "POST", "/admin/loyalty-rules", `{"name":"Negative","points_per_unit":"-1"}`).Code)
+
+	// Points are earned once the order is paid, not when it's placed
+	earning := placeTestOrder(t, db, userID, mugID, 3)
+	assert.Equal(t, 0, balance())
+	placePaidTestOrder(t, db, mock, userID, teeID, 1)
+	assert.Equal(t, 0, balance())
+	payment := startTestPayment(t, db, cfg, mock, userID, earning.ID)
+	event, _ := mock.Simulate(payment.IntentID, true)
+	assert.NoError(t, applyPaymentEvent(db, mock, *event))
+	assert.Equal(t, 60, balance())
+
+	// 40 points take 4.00 off a mug; the redemption is given back when the order is cancelled
+	w := placeTenderOrder(db, userID, mugID, 1, tenderRequest{RedeemPoints: 40})
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	redeemed := decodeOrder(w)
+	assert.Equal(t, 40, redeemed.PointsRedeemed)
+	assert.Equal(t, money.MustParse("4.00"), redeemed.DiscountTotal)
+	assert.Equal(t, config.DiscountPoints, redeemed.Discounts[0].Type)
+	assert.Nil(t, redeemed.Discounts[0].PromotionID)
+	var withoutPromotion int64
+	db.Model(&config.OrderDiscount{}).Where("order_id = ? AND promotion_id IS NULL", redeemed.ID).Count(&withoutPromotion)
+	assert.Equal(t, int64(1), withoutPromotion)
+	assert.Equal(t, 20, balance())
+
+	w = placeTenderOrder(db, userID, mugID, 1, tenderRequest{RedeemPoints: 21})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "only 20 points available")
+	assert.Equal(t, http.StatusOK, sendJSON(router, "POST", "/orders/"+redeemed.ID+"/cancel", `{}`).Code)
+	assert.Equal(t, 60, balance())
+
+	// Refunding a third of the order takes back a third of what it earned
+	var paid config.Order
+	db.First(&paid, "id = ?", earning.ID)
+	third := paid.TotalPrice / 3
+	w = sendJSON(router, "POST", "/admin/orders/"+earning.ID+"/refunds", `{"amount":"`+third.String()+`","reason":"one mug broke"}`)
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	assert.Equal(t, 40, balance())
+	w = sendJSON(router, "POST", "/admin/orders/"+earning.ID+"/refunds", `{"reason":"changed mind"}`)
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	assert.Equal(t, 0, balance())
+
+	// Old points expire
+	assert.Equal(t, http.StatusCreated, sendJSON(router, "POST", "/admin/users/"+userID+"/loyalty", `{"points":25,"note":"welcome"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, sendJSON(router, "POST", "/admin/users/"+userID+"/loyalty", `{"points":-30,"note":"too much"}`).Code)
+	assert.NoError(t, expirePoints(db, time.Now(), 24*time.Hour))
+	assert.Equal(t, 25, balance())
+	db.Model(&config.LoyaltyTransaction{}).Where("type = ?", config.PointsAdjust).Update("created_at", time.Now().AddDate(0, 0, -2))
+	assert.NoError(t, expirePoints(db, time.Now(), 24*time.Hour))
+	assert.Equal(t, 0, balance())
+
+	// Points already spent are still taken back: the balance goes negative and
+	// the next points pay it off before they can be spent
+	spender := placeTestOrder(t, db, userID, mugID, 2)
+	payment = startTestPayment(t, db, cfg, mock, userID, spender.ID)
+	event, _ = mock.Simulate(payment.IntentID, true)
+	assert.NoError(t, applyPaymentEvent(db, mock, *event))
+	assert.Equal(t, 40, balance())
+	w = placeTenderOrder(db, userID, mugID, 1, tenderRequest{RedeemPoints: 30})
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	assert.Equal(t, 10, balance())
+	w = sendJSON(router, "POST", "/admin/orders/"+spender.ID+"/refunds", `{"reason":"returned"}`)
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	assert.Equal(t, -30, balance())
+	assert.Equal(t, http.StatusCreated, sendJSON(router, "POST", "/admin/users/"+userID+"/loyalty", `{"points":50,"note":"goodwill"}`).Code)
+	assert.Equal(t, 20, balance())
+	var goodwill config.LoyaltyTransaction
+	db.Where("user_id = ? AND note = ?", userID, "goodwill").First(&goodwill)
+	assert.Equal(t, 20, goodwill.Remaining)
+	db.Model(&goodwill).Update("created_at", time.Now().AddDate(0, 0, -2))
+	assert.NoError(t, expirePoints(db, time.Now(), 24*time.Hour))
+	assert.Equal(t, 0, balance())
+
+	req, _ := http.NewRequest("GET", "/loyalty", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	var statement struct {
+		Object struct {
+			Balance      int                         `json:"balance"`
+			Lots         []config.LoyaltyTransaction `json:"lots"`
+			Transactions []config.LoyaltyTransaction `json:"transactions"`
+		} `json:"object"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &statement)
+	assert.Equal(t, 0, statement.Object.Balance)
+	assert.Empty(t, statement.Object.Lots)
+	types := map[string]int{}
+	for _, entry := range statement.Object.Transactions {
+		types[entry.Type] += entry.Points
+	}
+	assert.Equal(t, map[string]int{
+		config.PointsEarn: 100, config.PointsRedeem: -70, config.PointsRestore: 40,
+		config.PointsReverse: -100, config.PointsAdjust: 75, config.PointsExpire: -45,
+	}, types)
+}
+
+func TestExpirePoints_ContinuesPastFailures(t *testing.T) {
+	db := setupTestDB(t)
+	broken, healthy := uuid.New(), uuid.New()
+	old := time.Now().AddDate(0, 0, -2)
+	// The first lot claims more than its owner's balance, so it can't expire
+	db.Create(&config.LoyaltyAccount{UserID: broken, Balance: 5})
+	db.Create(&config.LoyaltyAccount{UserID: healthy, Balance: 10})
+	db.Create(&config.LoyaltyTransaction{ID: uuid.New().String(), UserID: broken, Type: config.PointsAdjust, Points: 10, Remaining: 10, CreatedAt: old})
+	db.Create(&config.LoyaltyTransaction{ID: uuid.New().String(), UserID: healthy, Type: config.PointsAdjust, Points: 10, Remaining: 10, CreatedAt: old.Add(time.Minute)})
+
+	assert.NoError(t, expirePoints(db, time.Now(), 24*time.Hour))
+	balance := func(userID uuid.UUID) int {
+		var account config.LoyaltyAccount
+		db.First(&account, "user_id = ?", userID)
+		return account.Balance
+	}
+	assert.Equal(t, 0, balance(healthy))
+	assert.Equal(t, 5, balance(broken))
+}
@@ -3,9 +3,11 @@ package controllers
 // Order controller content from previous scaffoldpackage controllers
 
 import (
+	"errors"
 	"fmt"
 	"kalebecommerce/config"
 	"kalebecommerce/utils"
+	"log"
 	"net/http"
 	"strings"
 
@@ -15,12 +17,162 @@ import (
 	"gorm.io/gorm/clause"
 )
 
-// PlaceOrder - user places an order with product IDs & quantities
-func PlaceOrder(db *gorm.DB) gin.HandlerFunc {
+// orderLine is one requested product and quantity.
+type orderLine struct {
+	ProductID string `json:"productId" binding:"required,uuid"`
+	Quantity  int    `json:"quantity" binding:"required,min=1"`
+}
+
+// snapshotOrderImages freezes the product images of a placed order's lines. Product
+// images are overwritten on update and removed on delete, so each line keeps its own
+// copy. It runs once the order is committed, keeping file I/O out of the transaction.
+func snapshotOrderImages(db *gorm.DB, order *config.Order) {
+	for i := range order.Items {
+		item := &order.Items[i]
+		if item.ProductImageURL == "" {
+			continue
+		}
+		url, err := utils.SnapshotImage(item.ProductImageURL)
+		if err != nil {
+			log.Printf("failed to snapshot image of product %s: %v", item.ProductID, err)
+			url = ""
+		}
+		if url == item.ProductImageURL {
+			continue
+		}
+		if err := db.Model(&config.OrderItem{}).Where("id = ?", item.ID).Update("product_image_url", url).Error; err != nil {
+			log.Printf("failed to save image snapshot of order item %s: %v", item.ID, err)
+			continue
+		}
+		item.ProductImageURL = url
+	}
+}
+
+// createOrder persists order and its lines inside tx, locking each product
+// row to check and decrement stock. Lines are priced in pr's currency and the
+// rate used is kept on the order; taxes and the shipping method's cost follow
+// the shipping address. Any error rolls the whole order back.
+func createOrder(tx *gorm.DB, cfg *config.Config, order *config.Order, lines []orderLine, pr *pricing, tenders tenderRequest) error {
+	order.Currency = pr.Currency
+	order.ExchangeRate = pr.Rate
+	order.FulfillmentStatus = config.FulfillmentUnfulfilled
+	if err := tx.Create(order).Error; err != nil {
+		return err
+	}
+
+	weightGrams := 0
+	products := make([]config.Product, 0, len(lines))
+	for _, item := range lines {
+		pid, _ := uuid.Parse(item.ProductID)
+		var p config.Product
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&p, "id = ?", pid).Error; err != nil {
+			return err
+		}
+
+		if p.Stock < item.Quantity {
+			return fmt.Errorf("insufficient stock for %s", p.Name)
+		}
+		p.Stock -= item.Quantity
+		p.Version++
+		if err := tx.Save(&p).Error; err != nil {
+			return err
+		}
+		price, err := pr.unitPrice(tx, p)
+		if err != nil {
+			return err
+		}
+
+		order.Items = append(order.Items, config.OrderItem{
+			ID:              uuid.New().String(),
+			OrderID:         order.ID,
+			ProductID:       pid,
+			ProductName:     p.Name,
+			ProductSKU:      p.SKU,
+			ProductImageURL: p.ImageURL, // replaced by a snapshot once committed
+			Quantity:        item.Quantity,
+			UnitPrice:       price,
+			TaxClass:        p.TaxClass,
+			GiftCard:        p.GiftCard,
+		})
+		products = append(products, p)
+		order.Subtotal += price.Mul(item.Quantity)
+		weightGrams += p.WeightGrams * item.Quantity
+	}
+
+	if err := applyPromotions(tx, order, products, pr); err != nil {
+		return err
+	}
+	if err := redeemPoints(tx, cfg, order, tenders.RedeemPoints, pr); err != nil {
+		return err
+	}
+	if err := applyOrderTaxes(tx, cfg, order); err != nil {
+		return err
+	}
+	if err := applyShipping(tx, order, weightGrams, pr); err != nil {
+		return err
+	}
+	waiveShipping(order)
+	if err := applyTenders(tx, order, tenders); err != nil {
+		return err
+	}
+	for i := range order.Items {
+		if err := tx.Create(&order.Items[i]).Error; err != nil {
+			return err
+		}
+	}
+	for i := range order.Discounts {
+		if err := tx.Create(&order.Discounts[i]).Error; err != nil {
+			return err
+		}
+	}
+	if err := tx.Omit("Items", "Events", "TaxLines", "Discounts").Save(order).Error; err != nil {
+		return err
+	}
+	if err := recordOrderEvent(tx, order.ID, "", order.Status, "order placed", order.UserID); err != nil {
+		return err
+	}
+	// Nothing left for the payment provider to charge
+	if order.TenderTotal > 0 && order.TenderTotal == order.TotalPrice {
+		return transitionOrder(tx, order, config.OrderStatusPaid, "paid with gift card or store credit", order.UserID)
+	}
+	return nil
+}
+
+// respondOrderError maps a failed createOrder to an HTTP response.
+func respondOrderError(c *gin.Context, err error) {
+	switch {
+	case strings.Contains(err.Error(), "insufficient stock"):
+		utils.JSON(c, http.StatusBadRequest, false, "insufficient stock", nil, err.Error())
+	case errors.Is(err, errAddressRequired), errors.Is(err, errAddressNotFound):
+		utils.JSON(c, http.StatusBadRequest, false, "invalid shipping address", nil, err.Error())
+	case errors.Is(err, errInvalidGiftCard):
+		utils.JSON(c, http.StatusBadRequest, false, "invalid gift card", nil, err.Error())
+	case errors.Is(err, errInsufficientBalance):
+		utils.JSON(c, http.StatusConflict, false, "insufficient balance", nil, "the balance changed while placing the order, please retry")
+	case errors.Is(err, errInvalidPoints):
+		utils.JSON(c, http.StatusBadRequest, false, "invalid points redemption", nil, err.Error())
+	case errors.Is(err, errInvalidCoupon):
+		utils.JSON(c, http.StatusBadRequest, false, "invalid coupon", nil, err.Error())
+	case errors.Is(err, errShippingUnavailable):
+		utils.JSON(c, http.StatusBadRequest, false, "invalid shipping method", nil, err.Error())
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		utils.JSON(c, http.StatusBadRequest, false, "product not found", nil, err.Error())
+	default:
+		utils.JSON(c, http.StatusInternalServerError, false, "failed to place order", nil, err.Error())
+	}
+}
+
+// PlaceOrder - user places an order with product IDs & quantities, a shipping
+// address, a shipping method and an optional coupon, priced in ?currency=
+func PlaceOrder(db *gorm.DB, cfg *config.Config) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var req []struct {
-			ProductID string `json:"productId" binding:"required,uuid"`
-			Quantity  int    `json:"quantity" binding:"required,min=1"`
+		pr, ok := requestPricing(c, db, cfg)
+		if !ok {
+			return
+		}
+		var req struct {
+			checkoutInput
+			Items []orderLine `json:"items" binding:"required,min=1,dive"`
 		}
 		if err := c.ShouldBindJSON(&req); err != nil {
 			utils.JSON(c, http.StatusBadRequest, false, "validation error", nil, err.Error())
@@ -30,54 +182,28 @@ func PlaceOrder(db *gorm.DB) gin.HandlerFunc {
 		userID := c.GetString("user_id")
 		uid, _ := uuid.Parse(userID)
 
+		order := config.Order{
+			ID:               uuid.New().String(),
+			UserID:           &uid,
+			Status:           config.OrderStatusPending,
+			ShippingMethodID: &req.ShippingMethodID,
+			CouponCode:       req.CouponCode,
+		}
 		err := db.Transaction(func(tx *gorm.DB) error {
-			var total float64
-			order := config.Order{ID: uuid.New().String(), UserID: uid, Status: "pending"}
-			if err := tx.Create(&order).Error; err != nil {
+			address, err := resolveShippingAddress(tx, uid, req.AddressID, req.ShippingAddress)
+			if err != nil {
 				return err
 			}
-
-			for _, item := range req {
-				pid, _ := uuid.Parse(item.ProductID)
-				var p config.Product
-				if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&p, "id = ?", pid).Error; err != nil {
-					return err
-				}
-
-				if p.Stock < item.Quantity {
-					return fmt.Errorf("insufficient stock for %s", p.Name)
-				}
-				p.Stock -= item.Quantity
-				if err := tx.Save(&p).Error; err != nil {
-					return err
-				}
-
-				oi := config.OrderItem{
-					ID:        uuid.New().String(),
-					OrderID:   order.ID,
-					ProductID: pid,
-					Quantity:  item.Quantity,
-					UnitPrice: p.Price,
-				}
-				if err := tx.Create(&oi).Error; err != nil {
-					return err
-				}
-				total += float64(item.Quantity) * p.Price
-			}
-
-			order.TotalPrice = total
-			return tx.Save(&order).Error
+			order.ShippingAddress = address
+			return createOrder(tx, cfg, &order, req.Items, pr, req.tenderRequest)
 		})
 
 		if err != nil {
-			if strings.Contains(err.Error(), "insufficient stock") {
-				utils.JSON(c, http.StatusBadRequest, false, "insufficient stock", nil, err.Error())
-				return
-			}
-			utils.JSON(c, http.StatusInternalServerError, false, "failed to place order", nil, err.Error())
+			respondOrderError(c, err)
 			return
 		}
-		utils.JSON(c, http.StatusCreated, true, "order placed successfully", nil, nil)
+		snapshotOrderImages(db, &order)
+		utils.JSON(c, http.StatusCreated, true, "order placed successfully", order, nil)
 	}
 }
 
@@ -88,7 +214,7 @@ func ListOrders(db *gorm.DB) gin.HandlerFunc {
 		uid, _ := uuid.Parse(userID)
 
 		var orders []config.Order
-		if err := db.Preload("Items").Where("user_id = ?", uid).Find(&orders).Error; err != nil {
+		if err := db.Preload("Items").Preload("TaxLines").Preload("Discounts").Preload("Tenders").Preload("GiftCards").Preload("Shipments.Items").Where("user_id = ?", uid).Find(&orders).Error; err != nil {
 			utils.JSON(c, http.StatusInternalServerError, false, "failed to fetch orders", nil, err.Error())
 			return
 		}
@@ -96,3 +222,21 @@ func ListOrders(db *gorm.DB) gin.HandlerFunc {
 		utils.JSON(c, http.StatusOK, true, "orders retrieved", orders, nil)
 	}
 }
+
+// GetOrder - a single order of the current user, with item snapshots and timeline
+func GetOrder(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uid := currentUserID(c)
+
+		var order config.Order
+		err := db.Preload("Items").Preload("TaxLines").Preload("Discounts").Preload("Tenders").Preload("GiftCards").Preload("Shipments.Items").
+			Preload("Events", func(tx *gorm.DB) *gorm.DB { return tx.Order("created_at, id") }).
+			First(&order, "id = ?", c.Param("id")).Error
+		// Other users' orders are reported as missing rather than forbidden
+		if err != nil || uid == nil || order.UserID == nil || *order.UserID != *uid {
+			utils.JSON(c, http.StatusNotFound, false, "order not found", nil, nil)
+			return
+		}
+		utils.JSON(c, http.StatusOK, true, "order retrieved", order, nil)
+	}
+}
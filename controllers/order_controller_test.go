@@ -4,10 +4,17 @@ import (
 	"bytes"
 	"encoding/json"
 	"kalebecommerce/config"
+	"kalebecommerce/middleware"
+	"kalebecommerce/money"
+	"kalebecommerce/utils"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 )
@@ -18,6 +25,15 @@ type OrderItemRequest struct {
 	Quantity  int    `json:"quantity" binding:"required,min=1"`
 }
 
+// OrderRequest struct to match the full PlaceOrder body
+type OrderRequest struct {
+	Items            []OrderItemRequest `json:"items"`
+	AddressID        string             `json:"address_id,omitempty"`
+	ShippingAddress  *config.Address    `json:"shipping_address,omitempty"`
+	ShippingMethodID string             `json:"shipping_method_id"`
+	CouponCode       string             `json:"coupon_code,omitempty"`
+}
+
 // TestPlaceOrder_Success tests successful order placement
 func TestPlaceOrder_Success(t *testing.T) {
 	db := setupTestDB(t)
@@ -30,15 +46,15 @@ func TestPlaceOrder_Success(t *testing.T) {
 	db.Create(&user)
 
 	// 2. Create a Product with stock
-	product := config.Product{ID: testProductID.String(), Name: "Test Product", Price: 100.00, Stock: 5}
+	product := config.Product{ID: testProductID.String(), Name: "Test Product", Price: money.MustParse("100.00"), Stock: 5}
 	db.Create(&product)
 
-	router.POST("/orders", mockAuthMiddleware(testUserID), PlaceOrder(db))
+	router.POST("/orders", mockAuthMiddleware(testUserID), PlaceOrder(db, mockConfig()))
 
 	requestBody := []OrderItemRequest{
 		{ProductID: testProductID.String(), Quantity: 2}, // Order 2 units
 	}
-	jsonBody, _ := json.Marshal(requestBody)
+	jsonBody := orderRequestJSON(db, requestBody...)
 
 	req, _ := http.NewRequest("POST", "/orders", bytes.NewBuffer(jsonBody))
 	req.Header.Set("Content-Type", "application/json")
@@ -53,13 +69,36 @@ func TestPlaceOrder_Success(t *testing.T) {
 	// Verify database state: Order created, Stock updated
 	var order config.Order
 	db.Last(&order)
-	assert.Equal(t, float64(200.00), order.TotalPrice) // 2 * 100.00
+	assert.Equal(t, money.MustParse("200.00"), order.TotalPrice) // 2 * 100.00
 
 	var updatedProduct config.Product
 	db.First(&updatedProduct, "id = ?", testProductID)
 	assert.Equal(t, 3, updatedProduct.Stock) // Initial 5 - 2 ordered = 3
 }
 
+// TestPlaceOrder_ExactDecimalTotals checks totals are summed in cents and
+// returned as decimal strings, so 3 x 0.10 is exactly 0.30.
+func TestPlaceOrder_ExactDecimalTotals(t *testing.T) {
+	db := setupTestDB(t)
+	router := setupRouter()
+	testUserID := uuid.New().String()
+	testProductID := uuid.New()
+	db.Create(&config.Product{ID: testProductID.String(), Name: "Sticker", Price: money.MustParse("0.10"), Stock: 5})
+
+	router.POST("/orders", mockAuthMiddleware(testUserID), PlaceOrder(db, storeTestConfig()))
+
+	jsonBody := orderRequestJSON(db, OrderItemRequest{ProductID: testProductID.String(), Quantity: 3})
+	req, _ := http.NewRequest("POST", "/orders", bytes.NewBuffer(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Contains(t, w.Body.String(), `"total_price":"0.30"`)
+	assert.Contains(t, w.Body.String(), `"unit_price":"0.10"`)
+	assert.Contains(t, w.Body.String(), `"currency":"USD"`)
+}
+
 // TestPlaceOrder_InsufficientStock tests stock check failure
 func TestPlaceOrder_InsufficientStock(t *testing.T) {
 	db := setupTestDB(t)
@@ -68,15 +107,15 @@ func TestPlaceOrder_InsufficientStock(t *testing.T) {
 	testProductID := uuid.New()
 
 	// 1. Create Product with LOW stock
-	product := config.Product{ID: testProductID.String(), Name: "Low Stock Item", Price: 50.00, Stock: 1}
+	product := config.Product{ID: testProductID.String(), Name: "Low Stock Item", Price: money.MustParse("50.00"), Stock: 1}
 	db.Create(&product)
 
-	router.POST("/orders", mockAuthMiddleware(testUserID), PlaceOrder(db))
+	router.POST("/orders", mockAuthMiddleware(testUserID), PlaceOrder(db, mockConfig()))
 
 	requestBody := []OrderItemRequest{
 		{ProductID: testProductID.String(), Quantity: 5}, // Request 5 units, but only 1 in stock
 	}
-	jsonBody, _ := json.Marshal(requestBody)
+	jsonBody := orderRequestJSON(db, requestBody...)
 
 	req, _ := http.NewRequest("POST", "/orders", bytes.NewBuffer(jsonBody))
 	req.Header.Set("Content-Type", "application/json")
@@ -100,11 +139,13 @@ func TestPlaceOrder_ValidationFailure(t *testing.T) {
 	router := setupRouter()
 	testUserID := uuid.New().String()
 
-	router.POST("/orders", mockAuthMiddleware(testUserID), PlaceOrder(db))
+	router.POST("/orders", mockAuthMiddleware(testUserID), PlaceOrder(db, mockConfig()))
 
 	// Invalid Request: Quantity 0 (min=1 validation fails)
-	requestBody := []map[string]interface{}{
-		{"productId": uuid.New().String(), "quantity": 0},
+	requestBody := map[string]interface{}{
+		"items":              []map[string]interface{}{{"productId": uuid.New().String(), "quantity": 0}},
+		"shipping_address":   testAddress,
+		"shipping_method_id": testShippingMethod(db),
 	}
 	jsonBody, _ := json.Marshal(requestBody)
 
@@ -118,3 +159,220 @@ func TestPlaceOrder_ValidationFailure(t *testing.T) {
 	assert.Equal(t, http.StatusBadRequest, w.Code)
 	assert.Contains(t, w.Body.String(), "validation error")
 }
+
+// TestPlaceOrder_IdempotencyKeyReplaysResponse ensures a retried order is placed only once
+func TestPlaceOrder_IdempotencyKeyReplaysResponse(t *testing.T) {
+	db := setupTestDB(t)
+	router := setupRouter()
+	testUserID := uuid.New().String()
+	testProductID := uuid.New()
+	db.Create(&config.Product{ID: testProductID.String(), Name: "Test Product", Price: money.MustParse("100.00"), Stock: 5})
+
+	router.POST("/orders", mockAuthMiddleware(testUserID), middleware.Idempotency(db, time.Hour), PlaceOrder(db, mockConfig()))
+
+	send := func(quantity int) *httptest.ResponseRecorder {
+		jsonBody := orderRequestJSON(db, OrderItemRequest{ProductID: testProductID.String(), Quantity: quantity})
+		req, _ := http.NewRequest("POST", "/orders", bytes.NewBuffer(jsonBody))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(middleware.IdempotencyKeyHeader, "retry-123")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	first := send(2)
+	assert.Equal(t, http.StatusCreated, first.Code)
+
+	// Same key and body: the stored response is replayed
+	second := send(2)
+	assert.Equal(t, http.StatusCreated, second.Code)
+	assert.Equal(t, "true", second.Header().Get("Idempotent-Replayed"))
+	assert.Equal(t, first.Body.String(), second.Body.String())
+
+	// Same key, different body: rejected
+	third := send(1)
+	assert.Equal(t, http.StatusUnprocessableEntity, third.Code)
+
+	var count int64
+	db.Model(&config.Order{}).Count(&count)
+	assert.Equal(t, int64(1), count)
+
+	var updatedProduct config.Product
+	db.First(&updatedProduct, "id = ?", testProductID)
+	assert.Equal(t, 3, updatedProduct.Stock)
+}
+
+// TestPlaceGuestOrder_IdempotencyKeysArePerClient ensures guests sharing a key don't see each other's orders
+func TestPlaceGuestOrder_IdempotencyKeysArePerClient(t *testing.T) {
+	db := setupTestDB(t)
+	router := setupRouter()
+	productID := uuid.New().String()
+	db.Create(&config.Product{ID: productID, Name: "Mug", Price: money.MustParse("8.00"), Stock: 5})
+	router.POST("/guest/orders", middleware.Idempotency(db, time.Hour), PlaceGuestOrder(db, mockConfig()))
+
+	body := `{"email":"guest@example.com","shipping_address":` + guestAddressJSON +
+		`,"shipping_method_id":"` + testShippingMethod(db) + `","items":[{"productId":"` + productID + `","quantity":1}]}`
+	send := func(remoteAddr string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/guest/orders", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(middleware.IdempotencyKeyHeader, "order-1")
+		req.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	first := send("192.0.2.1:1234")
+	second := send("192.0.2.2:1234")
+	assert.Equal(t, http.StatusCreated, first.Code)
+	assert.Equal(t, http.StatusCreated, second.Code)
+	assert.Empty(t, second.Header().Get("Idempotent-Replayed"))
+	assert.NotEqual(t, first.Body.String(), second.Body.String())
+	assert.Equal(t, "true", send("192.0.2.1:1234").Header().Get("Idempotent-Replayed"))
+}
+
+// TestIdempotency_PanicReleasesKey ensures a crashed request can be retried with the same key
+func TestIdempotency_PanicReleasesKey(t *testing.T) {
+	db := setupTestDB(t)
+	router := setupRouter()
+	crash := true
+	router.POST("/orders", mockAuthMiddleware(uuid.New().String()), middleware.Idempotency(db, time.Hour), func(c *gin.Context) {
+		if crash {
+			panic("boom")
+		}
+		c.JSON(http.StatusCreated, gin.H{"ok": true})
+	})
+	send := func() *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/orders", bytes.NewBufferString(`{}`))
+		req.Header.Set(middleware.IdempotencyKeyHeader, "crash-1")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusInternalServerError, send().Code)
+	crash = false
+	assert.Equal(t, http.StatusCreated, send().Code)
+}
+
+// TestIdempotency_ReplaysHeadersAndChecksQuery ensures a replay sends the stored
+// cookies and headers again, and a different query string isn't a retry
+func TestIdempotency_ReplaysHeadersAndChecksQuery(t *testing.T) {
+	db := setupTestDB(t)
+	router := setupRouter()
+	calls := 0
+	router.POST("/cart/checkout", mockAuthMiddleware(uuid.New().String()), middleware.Idempotency(db, time.Hour), func(c *gin.Context) {
+		calls++
+		c.SetCookie("cart_token", "", -1, "/", "", false, true)
+		c.Header("Location", "/orders/1")
+		c.JSON(http.StatusCreated, gin.H{"ok": true})
+	})
+	send := func(path string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", path, bytes.NewBufferString(`{}`))
+		req.Header.Set(middleware.IdempotencyKeyHeader, "checkout-1")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	first := send("/cart/checkout?currency=EUR")
+	assert.Equal(t, http.StatusCreated, first.Code)
+
+	replayed := send("/cart/checkout?currency=EUR")
+	assert.Equal(t, http.StatusCreated, replayed.Code)
+	assert.Equal(t, "true", replayed.Header().Get("Idempotent-Replayed"))
+	assert.Equal(t, first.Header().Get("Set-Cookie"), replayed.Header().Get("Set-Cookie"))
+	assert.Equal(t, "/orders/1", replayed.Header().Get("Location"))
+	assert.Equal(t, first.Header().Get("Content-Type"), replayed.Header().Get("Content-Type"))
+
+	assert.Equal(t, http.StatusUnprocessableEntity, send("/cart/checkout?currency=USD").Code)
+	assert.Equal(t, 1, calls)
+}
+
+// TestGetOrder_KeepsProductSnapshot ensures order lines survive product edits and deletion
+func TestGetOrder_KeepsProductSnapshot(t *testing.T) {
+	db := setupTestDB(t)
+	router := setupRouter()
+	testUserID := uuid.New().String()
+	testProductID := uuid.New()
+	sku := "MUG-1"
+
+	imagePath := filepath.Join(utils.UploadDir, testProductID.String()+".jpg")
+	os.MkdirAll(utils.UploadDir, 0755)
+	os.WriteFile(imagePath, []byte("original image"), 0644)
+	defer os.RemoveAll("uploads")
+
+	db.Create(&config.Product{ID: testProductID.String(), SKU: &sku, Name: "Mug", Price: money.MustParse("8.00"), Stock: 5, ImageURL: "/" + imagePath})
+
+	router.POST("/orders", mockAuthMiddleware(testUserID), PlaceOrder(db, mockConfig()))
+	router.GET("/orders/:id", mockAuthMiddleware(testUserID), GetOrder(db))
+	router.GET("/other/orders/:id", mockAuthMiddleware(uuid.New().String()), GetOrder(db))
+
+	jsonBody := orderRequestJSON(db, OrderItemRequest{ProductID: testProductID.String(), Quantity: 1})
+	req, _ := http.NewRequest("POST", "/orders", bytes.NewBuffer(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	var order config.Order
+	db.Last(&order)
+
+	// The product image is replaced, then the product is deleted
+	os.WriteFile(imagePath, []byte("new image"), 0644)
+	db.Delete(&config.Product{}, "id = ?", testProductID)
+
+	req, _ = http.NewRequest("GET", "/orders/"+order.ID, nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response struct {
+		Object config.Order `json:"object"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	if assert.Len(t, response.Object.Items, 1) {
+		item := response.Object.Items[0]
+		assert.Equal(t, "Mug", item.ProductName)
+		assert.Equal(t, "MUG-1", *item.ProductSKU)
+		snapshot, err := os.ReadFile("." + item.ProductImageURL)
+		assert.NoError(t, err)
+		assert.Equal(t, "original image", string(snapshot))
+	}
+	assert.Len(t, response.Object.Events, 1)
+
+	// Another user can't see the order
+	req, _ = http.NewRequest("GET", "/other/orders/"+order.ID, nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestPlaceOrder_SnapshotsOnlyUploadedImages(t *testing.T) {
+	db := setupTestDB(t)
+	router := setupRouter()
+	testUserID := uuid.New().String()
+	hostedID, outsideID := uuid.New().String(), uuid.New().String()
+	db.Create(&config.Product{ID: hostedID, Name: "Mug", Price: money.MustParse("8.00"), Stock: 5, ImageURL: "https://cdn.example.com/mug.jpg"})
+	db.Create(&config.Product{ID: outsideID, Name: "Tee", Price: money.MustParse("12.00"), Stock: 5, ImageURL: "/" + utils.UploadDir + "/../../go.mod"})
+	defer os.RemoveAll("uploads")
+
+	router.POST("/orders", mockAuthMiddleware(testUserID), PlaceOrder(db, mockConfig()))
+	jsonBody := orderRequestJSON(db, OrderItemRequest{ProductID: hostedID, Quantity: 1}, OrderItemRequest{ProductID: outsideID, Quantity: 1})
+	req, _ := http.NewRequest("POST", "/orders", bytes.NewBuffer(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+	// Hosted images are kept as they are; paths outside the upload directory aren't copied
+	var items []config.OrderItem
+	db.Find(&items)
+	images := map[string]string{}
+	for _, item := range items {
+		images[item.ProductID.String()] = item.ProductImageURL
+	}
+	assert.Equal(t, map[string]string{hostedID: "https://cdn.example.com/mug.jpg", outsideID: ""}, images)
+	_, err := os.Stat(utils.SnapshotDir)
+	assert.True(t, os.IsNotExist(err))
+}
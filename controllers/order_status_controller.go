@@ -0,0 +1,309 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"kalebecommerce/config"
+	"kalebecommerce/payments"
+	"kalebecommerce/utils"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// orderTransitions lists the statuses each order status may move to.
+// cancelled, refunded and failed are final.
+var orderTransitions = map[string][]string{
+	config.OrderStatusPending:    {config.OrderStatusPaid, config.OrderStatusCancelled, config.OrderStatusFailed},
+	config.OrderStatusPaid:       {config.OrderStatusProcessing, config.OrderStatusCancelled, config.OrderStatusRefunded},
+	config.OrderStatusProcessing: {config.OrderStatusShipped, config.OrderStatusCancelled, config.OrderStatusRefunded},
+	config.OrderStatusShipped:    {config.OrderStatusDelivered, config.OrderStatusRefunded},
+	config.OrderStatusDelivered:  {config.OrderStatusRefunded},
+	config.OrderStatusCancelled:  {},
+	config.OrderStatusRefunded:   {},
+	config.OrderStatusFailed:     {},
+}
+
+// errIllegalTransition is returned when an order can't move to the requested status.
+var errIllegalTransition = errors.New("illegal order status transition")
+
+// canTransition reports whether an order in status from may move to status to.
+func canTransition(from, to string) bool {
+	for _, next := range orderTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// recordOrderEvent appends an entry to the order's timeline.
+func recordOrderEvent(tx *gorm.DB, orderID, from, to, reason string, actor *uuid.UUID) error {
+	return tx.Create(&config.OrderEvent{
+		ID:         uuid.New().String(),
+		OrderID:    orderID,
+		FromStatus: from,
+		ToStatus:   to,
+		Reason:     reason,
+		ActorID:    actor,
+	}).Error
+}
+
+// flowStatuses are only reached through their own flows: a captured payment,
+// a full refund, or an expired or declined payment. Admins can't set them directly.
+var flowStatuses = map[string]bool{
+	config.OrderStatusPaid:     true,
+	config.OrderStatusRefunded: true,
+	config.OrderStatusFailed:   true,
+}
+
+// customerCancellable are the statuses in which customers may still cancel their own orders.
+var customerCancellable = map[string]bool{
+	config.OrderStatusPending: true,
+	config.OrderStatusPaid:    true,
+}
+
+// transitionOrder moves a locked order to status to and records the change.
+// Cancelled and failed orders put their items back in stock and give back
+// their promotion uses, plus their gift card and store credit if they were
+// never paid, and settle their loyalty points. Paid orders get their gift
+// cards issued and earn points.
+func transitionOrder(tx *gorm.DB, order *config.Order, to, reason string, actor *uuid.UUID) error {
+	from := order.Status
+	if !canTransition(from, to) {
+		return fmt.Errorf("%w: %s to %s", errIllegalTransition, from, to)
+	}
+	if err := tx.Model(order).Update("status", to).Error; err != nil {
+		return err
+	}
+	if to == config.OrderStatusCancelled || to == config.OrderStatusFailed {
+		if err := restoreOrderStock(tx, order.ID); err != nil {
+			return err
+		}
+		if err := releasePromotions(tx, order.ID); err != nil {
+			return err
+		}
+		if from == config.OrderStatusPending {
+			if err := releaseTenders(tx, order); err != nil {
+				return err
+			}
+		}
+		if err := settleOrderPoints(tx, order, true); err != nil {
+			return err
+		}
+	}
+	if to == config.OrderStatusPaid {
+		if err := issueOrderGiftCards(tx, order); err != nil {
+			return err
+		}
+		if err := awardPoints(tx, order); err != nil {
+			return err
+		}
+	}
+	order.Status = to
+	return recordOrderEvent(tx, order.ID, from, to, reason, actor)
+}
+
+// cancelOrder cancels a locked order. Paid orders are refunded in full in the
+// same transaction; the returned refund's card share is paid out with
+// payOutRefund after the transaction commits. It is nil when nothing was refunded.
+func cancelOrder(tx *gorm.DB, order *config.Order, reason string, actor *uuid.UUID) (*config.Refund, error) {
+	paid := refundableStatuses[order.Status]
+	if err := transitionOrder(tx, order, config.OrderStatusCancelled, reason, actor); err != nil {
+		return nil, err
+	}
+	if !paid || order.RefundedAmount >= order.TotalPrice {
+		return nil, nil
+	}
+	return issueRefund(tx, order, refundRequest{Reason: reason}, actor)
+}
+
+// restoreOrderStock returns every item of an order to stock, except units a
+// refund already restocked. Products deleted since the order was placed are skipped.
+func restoreOrderStock(tx *gorm.DB, orderID string) error {
+	var items []config.OrderItem
+	if err := tx.Where("order_id = ?", orderID).Find(&items).Error; err != nil {
+		return err
+	}
+	restocked, err := refundedQuantities(tx, orderID, true)
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		quantity := item.Quantity - restocked[item.ID]
+		if quantity <= 0 {
+			continue
+		}
+		err := tx.Model(&config.Product{}).Where("id = ?", item.ProductID).Updates(map[string]interface{}{
+			"stock":   gorm.Expr("stock + ?", quantity),
+			"version": gorm.Expr("version + 1"),
+		}).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lockOrder loads an order for update inside tx.
+func lockOrder(tx *gorm.DB, id string) (*config.Order, error) {
+	var order config.Order
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&order, "id = ?", id).Error; err != nil {
+		return nil, err
+	}
+	return &order, nil
+}
+
+// respondTransitionError maps a failed status change to an HTTP response.
+func respondTransitionError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		utils.JSON(c, http.StatusNotFound, false, "order not found", nil, nil)
+	case errors.Is(err, errIllegalTransition):
+		utils.JSON(c, http.StatusConflict, false, "illegal status transition", nil, err.Error())
+	default:
+		utils.JSON(c, http.StatusInternalServerError, false, "failed to update order status", nil, err.Error())
+	}
+}
+
+// UpdateOrderStatus (Admin) - moves an order to another status, with a reason
+func UpdateOrderStatus(db *gorm.DB, provider payments.PaymentProvider) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var in struct {
+			Status string `json:"status" binding:"required"`
+			Reason string `json:"reason"`
+		}
+		if err := c.ShouldBindJSON(&in); err != nil {
+			utils.JSON(c, http.StatusBadRequest, false, "validation error", nil, err.Error())
+			return
+		}
+		if _, ok := orderTransitions[in.Status]; !ok {
+			utils.JSON(c, http.StatusBadRequest, false, "validation error", nil, gin.H{"status": "unknown order status"})
+			return
+		}
+		if flowStatuses[in.Status] {
+			utils.JSON(c, http.StatusBadRequest, false, "validation error", nil,
+				gin.H{"status": in.Status + " is set by payments, refunds or payment expiry, not by hand"})
+			return
+		}
+
+		var order *config.Order
+		var refund *config.Refund
+		err := db.Transaction(func(tx *gorm.DB) error {
+			var err error
+			if order, err = lockOrder(tx, c.Param("id")); err != nil {
+				return err
+			}
+			if in.Status == config.OrderStatusCancelled {
+				refund, err = cancelOrder(tx, order, in.Reason, currentUserID(c))
+				return err
+			}
+			return transitionOrder(tx, order, in.Status, in.Reason, currentUserID(c))
+		})
+		if err != nil {
+			respondTransitionError(c, err)
+			return
+		}
+		if err := payOutRefund(db, provider, refund); err != nil {
+			respondPayoutError(c, err, order)
+			return
+		}
+		utils.JSON(c, http.StatusOK, true, "order status updated", order, nil)
+	}
+}
+
+// GetOrderTimeline (Admin) - every status change of an order, oldest first
+func GetOrderTimeline(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var order config.Order
+		if err := db.First(&order, "id = ?", c.Param("id")).Error; err != nil {
+			utils.JSON(c, http.StatusNotFound, false, "order not found", nil, nil)
+			return
+		}
+		var events []config.OrderEvent
+		if err := db.Where("order_id = ?", order.ID).Order("created_at, id").Find(&events).Error; err != nil {
+			utils.JSON(c, http.StatusInternalServerError, false, "failed to fetch order timeline", nil, err.Error())
+			return
+		}
+		utils.JSON(c, http.StatusOK, true, "order timeline retrieved", events, nil)
+	}
+}
+
+// CancelOrder - customer cancels their own order while it is still pending or
+// paid; a paid order is refunded
+func CancelOrder(db *gorm.DB, provider payments.PaymentProvider) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var in struct {
+			Reason string `json:"reason"`
+		}
+		// The body is optional
+		c.ShouldBindJSON(&in)
+		if in.Reason == "" {
+			in.Reason = "cancelled by customer"
+		}
+
+		uid := currentUserID(c)
+		var order *config.Order
+		var refund *config.Refund
+		err := db.Transaction(func(tx *gorm.DB) error {
+			var err error
+			if order, err = lockOrder(tx, c.Param("id")); err != nil {
+				return err
+			}
+			if uid == nil || order.UserID == nil || *order.UserID != *uid {
+				return gorm.ErrRecordNotFound
+			}
+			if !customerCancellable[order.Status] {
+				return fmt.Errorf("%w: %s orders can no longer be cancelled", errIllegalTransition, order.Status)
+			}
+			refund, err = cancelOrder(tx, order, in.Reason, uid)
+			return err
+		})
+		if err != nil {
+			respondTransitionError(c, err)
+			return
+		}
+		if err := payOutRefund(db, provider, refund); err != nil {
+			respondPayoutError(c, err, order)
+			return
+		}
+		utils.JSON(c, http.StatusOK, true, "order cancelled", order, nil)
+	}
+}
+
+// AdminCancelOrder (Admin) - cancels any order that isn't final, with a reason,
+// refunding it if it was paid
+func AdminCancelOrder(db *gorm.DB, provider payments.PaymentProvider) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var in struct {
+			Reason string `json:"reason" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&in); err != nil {
+			utils.JSON(c, http.StatusBadRequest, false, "validation error", nil, err.Error())
+			return
+		}
+
+		var order *config.Order
+		var refund *config.Refund
+		err := db.Transaction(func(tx *gorm.DB) error {
+			var err error
+			if order, err = lockOrder(tx, c.Param("id")); err != nil {
+				return err
+			}
+			refund, err = cancelOrder(tx, order, in.Reason, currentUserID(c))
+			return err
+		})
+		if err != nil {
+			respondTransitionError(c, err)
+			return
+		}
+		if err := payOutRefund(db, provider, refund); err != nil {
+			respondPayoutError(c, err, order)
+			return
+		}
+		utils.JSON(c, http.StatusOK, true, "order cancelled", order, nil)
+	}
+}
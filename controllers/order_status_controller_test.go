@@ -0,0 +1,188 @@
+package controllers
+
+import (
+	"bytes"
+	"kalebecommerce/config"
+	"kalebecommerce/money"
+	"kalebecommerce/payments"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpdateOrderStatus_RecordsTimeline(t *testing.T) {
+	db := setupTestDB(t)
+	router := setupRouter()
+	adminID := uuid.New().String()
+	order := config.Order{ID: uuid.New().String(), Status: config.OrderStatusPaid}
+	db.Create(&order)
+
+	router.PUT("/admin/orders/:id/status", mockAuthMiddleware(adminID), UpdateOrderStatus(db, payments.NewMockProvider()))
+	router.GET("/admin/orders/:id/timeline", GetOrderTimeline(db))
+
+	for _, status := range []string{config.OrderStatusProcessing, config.OrderStatusShipped} {
+		req, _ := http.NewRequest("PUT", "/admin/orders/"+order.ID+"/status",
+			bytes.NewBufferString(`{"status":"`+status+`","reason":"manual update"}`))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+
+	var updated config.Order
+	db.First(&updated, "id = ?", order.ID)
+	assert.Equal(t, config.OrderStatusShipped, updated.Status)
+
+	var events []config.OrderEvent
+	db.Where("order_id = ?", order.ID).Order("created_at").Find(&events)
+	if assert.Len(t, events, 2) {
+		assert.Equal(t, config.OrderStatusPaid, events[0].FromStatus)
+		assert.Equal(t, config.OrderStatusProcessing, events[0].ToStatus)
+		assert.Equal(t, "manual update", events[1].Reason)
+		assert.Equal(t, adminID, events[1].ActorID.String())
+	}
+
+	req, _ := http.NewRequest("GET", "/admin/orders/"+order.ID+"/timeline", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "order timeline retrieved")
+}
+
+func TestUpdateOrderStatus_RejectsIllegalTransition(t *testing.T) {
+	db := setupTestDB(t)
+	router := setupRouter()
+	order := config.Order{ID: uuid.New().String(), Status: config.OrderStatusPending}
+	db.Create(&order)
+
+	router.PUT("/admin/orders/:id/status", mockAdminAuthMiddleware(), UpdateOrderStatus(db, payments.NewMockProvider()))
+
+	req, _ := http.NewRequest("PUT", "/admin/orders/"+order.ID+"/status", bytes.NewBufferString(`{"status":"shipped"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Contains(t, w.Body.String(), "illegal status transition")
+
+	var unchanged config.Order
+	db.First(&unchanged, "id = ?", order.ID)
+	assert.Equal(t, config.OrderStatusPending, unchanged.Status)
+
+	// Payments, refunds and expiry own these statuses
+	for _, status := range []string{config.OrderStatusPaid, config.OrderStatusFailed, config.OrderStatusRefunded} {
+		req, _ = http.NewRequest("PUT", "/admin/orders/"+order.ID+"/status", bytes.NewBufferString(`{"status":"`+status+`"}`))
+		req.Header.Set("Content-Type", "application/json")
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code, status)
+	}
+}
+
+func TestPlaceOrder_RecordsInitialEvent(t *testing.T) {
+	db := setupTestDB(t)
+	router := setupRouter()
+	testUserID := uuid.New().String()
+	productID := uuid.New().String()
+	db.Create(&config.Product{ID: productID, Name: "Mug", Price: money.MustParse("8.00"), Stock: 5})
+
+	router.POST("/orders", mockAuthMiddleware(testUserID), PlaceOrder(db, mockConfig()))
+
+	req, _ := http.NewRequest("POST", "/orders", bytes.NewBuffer(orderRequestJSON(db, OrderItemRequest{ProductID: productID, Quantity: 1})))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	var event config.OrderEvent
+	assert.NoError(t, db.First(&event).Error)
+	assert.Equal(t, "", event.FromStatus)
+	assert.Equal(t, config.OrderStatusPending, event.ToStatus)
+}
+
+func TestCancelOrder_RestoresStock(t *testing.T) {
+	db := setupTestDB(t)
+	router := setupRouter()
+	testUserID := uuid.New().String()
+	productID := uuid.New().String()
+	db.Create(&config.Product{ID: productID, Name: "Mug", Price: money.MustParse("8.00"), Stock: 5})
+
+	router.POST("/orders", mockAuthMiddleware(testUserID), PlaceOrder(db, mockConfig()))
+	router.POST("/orders/:id/cancel", mockAuthMiddleware(testUserID), CancelOrder(db, payments.NewMockProvider()))
+
+	req, _ := http.NewRequest("POST", "/orders", bytes.NewBuffer(orderRequestJSON(db, OrderItemRequest{ProductID: productID, Quantity: 3})))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	var order config.Order
+	db.Last(&order)
+
+	req, _ = http.NewRequest("POST", "/orders/"+order.ID+"/cancel", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "order cancelled")
+
+	var p config.Product
+	db.First(&p, "id = ?", productID)
+	assert.Equal(t, 5, p.Stock)
+
+	// A cancelled order can't be cancelled (and restocked) again
+	req, _ = http.NewRequest("POST", "/orders/"+order.ID+"/cancel", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	db.First(&p, "id = ?", productID)
+	assert.Equal(t, 5, p.Stock)
+}
+
+func TestCancelOrder_RejectsOtherUsersAndShippedOrders(t *testing.T) {
+	db := setupTestDB(t)
+	router := setupRouter()
+	ownerID := uuid.New()
+	shipped := config.Order{ID: uuid.New().String(), UserID: &ownerID, Status: config.OrderStatusProcessing}
+	db.Create(&shipped)
+
+	router.POST("/orders/:id/cancel", mockAuthMiddleware(ownerID.String()), CancelOrder(db, payments.NewMockProvider()))
+	router.POST("/other/orders/:id/cancel", mockAuthMiddleware(uuid.New().String()), CancelOrder(db, payments.NewMockProvider()))
+
+	req, _ := http.NewRequest("POST", "/other/orders/"+shipped.ID+"/cancel", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	req, _ = http.NewRequest("POST", "/orders/"+shipped.ID+"/cancel", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusConflict, w.Code)
+}
+
+func TestAdminCancelOrder_RequiresReason(t *testing.T) {
+	db := setupTestDB(t)
+	router := setupRouter()
+	order := config.Order{ID: uuid.New().String(), Status: config.OrderStatusProcessing}
+	db.Create(&order)
+
+	router.POST("/admin/orders/:id/cancel", mockAdminAuthMiddleware(), AdminCancelOrder(db, payments.NewMockProvider()))
+
+	req, _ := http.NewRequest("POST", "/admin/orders/"+order.ID+"/cancel", bytes.NewBufferString(`{}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	req, _ = http.NewRequest("POST", "/admin/orders/"+order.ID+"/cancel", bytes.NewBufferString(`{"reason":"out of stock at supplier"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var event config.OrderEvent
+	db.Where("order_id = ? AND to_status = ?", order.ID, config.OrderStatusCancelled).First(&event)
+	assert.Equal(t, "out of stock at supplier", event.Reason)
+}
@@ -0,0 +1,306 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"kalebecommerce/config"
+	"kalebecommerce/money"
+	"kalebecommerce/payments"
+	"kalebecommerce/utils"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	// errNotAwaitingPayment is returned when the order is no longer pending
+	errNotAwaitingPayment = errors.New("order is not awaiting payment")
+	// errPaymentProvider wraps failures to create an intent at the provider
+	errPaymentProvider = errors.New("payment provider error")
+)
+
+// startPayment creates a payment intent for a pending order, or returns the
+// one still open for it. The order row stays locked from the check until the
+// payment is stored, so concurrent requests can't open two intents.
+func startPayment(c *gin.Context, db *gorm.DB, cfg *config.Config, provider payments.PaymentProvider, order *config.Order) {
+	var payment config.Payment
+	created := false
+	err := db.Transaction(func(tx *gorm.DB) error {
+		locked, err := lockOrder(tx, order.ID)
+		if err != nil {
+			return err
+		}
+		if locked.Status != config.OrderStatusPending {
+			return fmt.Errorf("%w: order is %s", errNotAwaitingPayment, locked.Status)
+		}
+
+		err = tx.Where("order_id = ? AND status = ? AND expires_at > ?", locked.ID, config.PaymentStatusPending, time.Now()).
+			First(&payment).Error
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		// Gift cards and store credit already cover TenderTotal
+		due := locked.TotalPrice - locked.TenderTotal
+		intent, err := provider.CreateIntent(locked.ID, money.New(due, locked.Currency))
+		if err != nil {
+			return fmt.Errorf("%w: %v", errPaymentProvider, err)
+		}
+		payment = config.Payment{
+			ID:           uuid.New().String(),
+			OrderID:      locked.ID,
+			Provider:     provider.Name(),
+			IntentID:     intent.ID,
+			ClientSecret: intent.ClientSecret,
+			Amount:       due,
+			Currency:     locked.Currency,
+			Status:       config.PaymentStatusPending,
+			ExpiresAt:    time.Now().Add(cfg.PaymentExpiry),
+		}
+		created = true
+		return tx.Create(&payment).Error
+	})
+
+	switch {
+	case errors.Is(err, errNotAwaitingPayment):
+		utils.JSON(c, http.StatusConflict, false, "order is not awaiting payment", nil, err.Error())
+	case errors.Is(err, errPaymentProvider):
+		utils.JSON(c, http.StatusBadGateway, false, "failed to start payment", nil, err.Error())
+	case err != nil:
+		utils.JSON(c, http.StatusInternalServerError, false, "failed to start payment", nil, err.Error())
+	case !created:
+		utils.JSON(c, http.StatusOK, true, "payment already started", payment, nil)
+	default:
+		utils.JSON(c, http.StatusCreated, true, "payment started", payment, nil)
+	}
+}
+
+// PayOrder - starts paying for one of the current user's orders
+func PayOrder(db *gorm.DB, cfg *config.Config, provider payments.PaymentProvider) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uid := currentUserID(c)
+		var order config.Order
+		err := db.First(&order, "id = ?", c.Param("id")).Error
+		if err != nil || uid == nil || order.UserID == nil || *order.UserID != *uid {
+			utils.JSON(c, http.StatusNotFound, false, "order not found", nil, nil)
+			return
+		}
+		startPayment(c, db, cfg, provider, &order)
+	}
+}
+
+// PayGuestOrder - starts paying for a guest order, authorized by its lookup token
+func PayGuestOrder(db *gorm.DB, cfg *config.Config, provider payments.PaymentProvider) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		orderID := c.Param("id")
+		value, ok := utils.VerifySignedValue(c.Query("token"), cfg.JWTSecret)
+		if !ok || value != "order:"+orderID {
+			utils.JSON(c, http.StatusForbidden, false, "invalid order lookup token", nil, nil)
+			return
+		}
+		var order config.Order
+		if err := db.First(&order, "id = ?", orderID).Error; err != nil {
+			utils.JSON(c, http.StatusNotFound, false, "order not found", nil, nil)
+			return
+		}
+		startPayment(c, db, cfg, provider, &order)
+	}
+}
+
+// applyPaymentEvent settles a pending payment from a provider event. Events for
+// payments that were already settled are ignored, so redelivery is harmless.
+// Capturing is idempotent at the provider, so when the transaction fails after
+// the capture the redelivered event captures again and settles the payment.
+func applyPaymentEvent(db *gorm.DB, provider payments.PaymentProvider, event payments.Event) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var payment config.Payment
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&payment, "intent_id = ?", event.IntentID).Error; err != nil {
+			return err
+		}
+		if payment.Status != config.PaymentStatusPending {
+			return nil
+		}
+		order, err := lockOrder(tx, payment.OrderID)
+		if err != nil {
+			return err
+		}
+
+		switch event.Type {
+		case payments.EventPaymentAuthorized:
+			// The order was cancelled or expired meanwhile: leave the authorization uncaptured
+			if order.Status != config.OrderStatusPending {
+				payment.Status = config.PaymentStatusVoided
+				return tx.Save(&payment).Error
+			}
+			if err := provider.Capture(payment.IntentID); err != nil {
+				return err
+			}
+			payment.Status = config.PaymentStatusSucceeded
+			if err := tx.Save(&payment).Error; err != nil {
+				return err
+			}
+			return transitionOrder(tx, order, config.OrderStatusPaid, "payment captured", nil)
+		case payments.EventPaymentFailed:
+			payment.Status = config.PaymentStatusFailed
+			payment.FailureMessage = event.Message
+			if err := tx.Save(&payment).Error; err != nil {
+				return err
+			}
+			if order.Status != config.OrderStatusPending {
+				return nil
+			}
+			return transitionOrder(tx, order, config.OrderStatusFailed, "payment failed: "+event.Message, nil)
+		default:
+			return nil
+		}
+	})
+}
+
+// PaymentWebhook - receives signed payment events from the provider
+func PaymentWebhook(db *gorm.DB, cfg *config.Config, provider payments.PaymentProvider) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			utils.JSON(c, http.StatusBadRequest, false, "failed to read body", nil, err.Error())
+			return
+		}
+		if !payments.VerifyWebhook(body, c.GetHeader(payments.SignatureHeader), cfg.PaymentWebhookSecret) {
+			utils.JSON(c, http.StatusUnauthorized, false, "invalid webhook signature", nil, nil)
+			return
+		}
+
+		var event payments.Event
+		if err := json.Unmarshal(body, &event); err != nil || event.IntentID == "" {
+			utils.JSON(c, http.StatusBadRequest, false, "invalid webhook event", nil, nil)
+			return
+		}
+		if err := applyPaymentEvent(db, provider, event); err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				utils.JSON(c, http.StatusNotFound, false, "payment not found", nil, nil)
+				return
+			}
+			// A 5xx makes the provider deliver the event again later
+			utils.JSON(c, http.StatusInternalServerError, false, "failed to apply payment event", nil, err.Error())
+			return
+		}
+		utils.JSON(c, http.StatusOK, true, "event processed", nil, nil)
+	}
+}
+
+// MockPaymentAction - pays (:outcome = succeed) or declines (fail) a mock intent
+// and delivers the signed webhook event, standing in for a hosted checkout page
+func MockPaymentAction(db *gorm.DB, cfg *config.Config, mock *payments.MockProvider) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		outcome := c.Param("outcome")
+		if outcome != "succeed" && outcome != "fail" {
+			utils.JSON(c, http.StatusBadRequest, false, "outcome must be succeed or fail", nil, nil)
+			return
+		}
+		event, err := mock.Simulate(c.Param("intentId"), outcome == "succeed")
+		if err != nil {
+			utils.JSON(c, http.StatusBadRequest, false, "failed to simulate payment", nil, err.Error())
+			return
+		}
+		if err := applyPaymentEvent(db, mock, *event); err != nil {
+			utils.JSON(c, http.StatusInternalServerError, false, "failed to apply payment event", nil, err.Error())
+			return
+		}
+
+		// The signed event can be replayed against the webhook endpoint
+		body, _ := json.Marshal(event)
+		utils.JSON(c, http.StatusOK, true, "payment simulated", gin.H{
+			"event":     event,
+			"signature": payments.SignWebhook(body, cfg.PaymentWebhookSecret),
+		}, nil)
+	}
+}
+
+// StartPaymentExpiry fails orders whose payment expired, or that were never
+// paid for within cfg.PaymentExpiry, every interval until the process exits.
+func StartPaymentExpiry(db *gorm.DB, cfg *config.Config, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if err := expirePayments(db, time.Now(), cfg.PaymentExpiry); err != nil {
+				log.Printf("payment expiry: %v", err)
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+// expirePayments marks overdue pending payments expired and fails their orders,
+// along with pending orders placed more than period ago that nobody started
+// paying for. Failing an order releases the reserved stock. An order or payment
+// that can't be expired is logged and retried on the next run.
+func expirePayments(db *gorm.DB, now time.Time, period time.Duration) error {
+	var overdue []config.Payment
+	err := db.Where("status = ? AND expires_at <= ?", config.PaymentStatusPending, now).
+		Order("expires_at").Find(&overdue).Error
+	if err != nil {
+		return err
+	}
+	for _, p := range overdue {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			var payment config.Payment
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&payment, "id = ?", p.ID).Error; err != nil {
+				return err
+			}
+			if payment.Status != config.PaymentStatusPending {
+				return nil
+			}
+			payment.Status = config.PaymentStatusExpired
+			if err := tx.Save(&payment).Error; err != nil {
+				return err
+			}
+			return failUnpaidOrder(tx, payment.OrderID, now)
+		})
+		if err != nil {
+			log.Printf("payment expiry: payment %s: %v", p.ID, err)
+		}
+	}
+
+	var unpaid []config.Order
+	err = db.Where("status = ? AND created_at <= ?", config.OrderStatusPending, now.Add(-period)).
+		Where("NOT EXISTS (SELECT 1 FROM payments WHERE payments.order_id = orders.id AND payments.status = ? AND payments.expires_at > ?)",
+			config.PaymentStatusPending, now).
+		Order("created_at").Find(&unpaid).Error
+	if err != nil {
+		return err
+	}
+	for _, o := range unpaid {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			return failUnpaidOrder(tx, o.ID, now)
+		})
+		if err != nil {
+			log.Printf("payment expiry: order %s: %v", o.ID, err)
+		}
+	}
+	return nil
+}
+
+// failUnpaidOrder locks a pending order and fails it unless one of its payments
+// is still open.
+func failUnpaidOrder(tx *gorm.DB, orderID string, now time.Time) error {
+	order, err := lockOrder(tx, orderID)
+	if err != nil || order.Status != config.OrderStatusPending {
+		return err
+	}
+	// A newer attempt is still open, so the order keeps waiting for it
+	var open int64
+	err = tx.Model(&config.Payment{}).
+		Where("order_id = ? AND status = ? AND expires_at > ?", order.ID, config.PaymentStatusPending, now).
+		Count(&open).Error
+	if err != nil || open > 0 {
+		return err
+	}
+	return transitionOrder(tx, order, config.OrderStatusFailed, "payment expired", nil)
+}
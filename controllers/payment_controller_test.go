@@ -0,0 +1,246 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"kalebecommerce/config"
+	"kalebecommerce/money"
+	"kalebecommerce/payments"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+// placeTestOrder places an order for userID through PlaceOrder and returns it
+func placeTestOrder(t *testing.T, db *gorm.DB, userID, productID string, quantity int) config.Order {
+	router := setupRouter()
+	router.POST("/orders", mockAuthMiddleware(userID), PlaceOrder(db, storeTestConfig()))
+
+	jsonBody := orderRequestJSON(db, OrderItemRequest{ProductID: productID, Quantity: quantity})
+	req, _ := http.NewRequest("POST", "/orders", bytes.NewBuffer(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	var response struct {
+		Object config.Order `json:"object"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	return response.Object
+}
+
+// startTestPayment calls PayOrder and decodes the payment
+func startTestPayment(t *testing.T, db *gorm.DB, cfg *config.Config, provider payments.PaymentProvider, userID, orderID string) config.Payment {
+	router := setupRouter()
+	router.POST("/orders/:id/pay", mockAuthMiddleware(userID), PayOrder(db, cfg, provider))
+
+	req, _ := http.NewRequest("POST", "/orders/"+orderID+"/pay", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	var response struct {
+		Object config.Payment `json:"object"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	return response.Object
+}
+
+func TestPayOrder_MockPaymentMarksOrderPaid(t *testing.T) {
+	db := setupTestDB(t)
+	cfg := storeTestConfig()
+	mock := payments.NewMockProvider()
+	userID := uuid.New().String()
+	productID := uuid.New().String()
+	db.Create(&config.Product{ID: productID, Name: "Mug", Price: money.MustParse("8.00"), Stock: 5})
+
+	order := placeTestOrder(t, db, userID, productID, 2)
+	payment := startTestPayment(t, db, cfg, mock, userID, order.ID)
+	assert.Equal(t, money.MustParse("16.00"), payment.Amount)
+	assert.Equal(t, config.PaymentStatusPending, payment.Status)
+
+	router := setupRouter()
+	router.POST("/payments/mock/:intentId/:outcome", MockPaymentAction(db, cfg, mock))
+	req, _ := http.NewRequest("POST", "/payments/mock/"+payment.IntentID+"/succeed", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var paid config.Order
+	db.First(&paid, "id = ?", order.ID)
+	assert.Equal(t, config.OrderStatusPaid, paid.Status)
+
+	var settled config.Payment
+	db.First(&settled, "id = ?", payment.ID)
+	assert.Equal(t, config.PaymentStatusSucceeded, settled.Status)
+}
+
+func TestPayOrder_ReusesOpenPaymentAndRejectsSettledOrders(t *testing.T) {
+	db := setupTestDB(t)
+	cfg := storeTestConfig()
+	mock := payments.NewMockProvider()
+	userID := uuid.New().String()
+	productID := uuid.New().String()
+	db.Create(&config.Product{ID: productID, Name: "Mug", Price: money.MustParse("8.00"), Stock: 5})
+
+	order := placeTestOrder(t, db, userID, productID, 1)
+	payment := startTestPayment(t, db, cfg, mock, userID, order.ID)
+
+	router := setupRouter()
+	router.POST("/orders/:id/pay", mockAuthMiddleware(userID), PayOrder(db, cfg, mock))
+	w := sendJSON(router, "POST", "/orders/"+order.ID+"/pay", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var response struct {
+		Object config.Payment `json:"object"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	assert.Equal(t, payment.ID, response.Object.ID)
+
+	var count int64
+	db.Model(&config.Payment{}).Where("order_id = ?", order.ID).Count(&count)
+	assert.Equal(t, int64(1), count)
+
+	db.Model(&config.Order{}).Where("id = ?", order.ID).Update("status", config.OrderStatusPaid)
+	w = sendJSON(router, "POST", "/orders/"+order.ID+"/pay", "")
+	assert.Equal(t, http.StatusConflict, w.Code)
+}
+
+func TestApplyPaymentEvent_AlreadyCapturedIntent(t *testing.T) {
+	db := setupTestDB(t)
+	cfg := storeTestConfig()
+	mock := payments.NewMockProvider()
+	userID := uuid.New().String()
+	productID := uuid.New().String()
+	db.Create(&config.Product{ID: productID, Name: "Mug", Price: money.MustParse("8.00"), Stock: 5})
+
+	order := placeTestOrder(t, db, userID, productID, 1)
+	payment := startTestPayment(t, db, cfg, mock, userID, order.ID)
+	event, err := mock.Simulate(payment.IntentID, true)
+	assert.NoError(t, err)
+
+	// Captured by an earlier delivery whose transaction didn't commit
+	assert.NoError(t, mock.Capture(payment.IntentID))
+	assert.NoError(t, applyPaymentEvent(db, mock, *event))
+
+	var paid config.Order
+	db.First(&paid, "id = ?", order.ID)
+	assert.Equal(t, config.OrderStatusPaid, paid.Status)
+}
+
+func TestPaymentWebhook_FailedPaymentReleasesStock(t *testing.T) {
+	db := setupTestDB(t)
+	cfg := storeTestConfig()
+	mock := payments.NewMockProvider()
+	userID := uuid.New().String()
+	productID := uuid.New().String()
+	db.Create(&config.Product{ID: productID, Name: "Mug", Price: money.MustParse("8.00"), Stock: 5})
+
+	order := placeTestOrder(t, db, userID, productID, 2)
+	payment := startTestPayment(t, db, cfg, mock, userID, order.ID)
+	event, err := mock.Simulate(payment.IntentID, false)
+	assert.NoError(t, err)
+	body, _ := json.Marshal(event)
+
+	router := setupRouter()
+	router.POST("/payments/webhook", PaymentWebhook(db, cfg, mock))
+
+	// Unsigned events are rejected
+	req, _ := http.NewRequest("POST", "/payments/webhook", bytes.NewBuffer(body))
+	req.Header.Set(payments.SignatureHeader, "bogus")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// Delivering the same signed event twice is harmless
+	for i := 0; i < 2; i++ {
+		req, _ = http.NewRequest("POST", "/payments/webhook", bytes.NewBuffer(body))
+		req.Header.Set(payments.SignatureHeader, payments.SignWebhook(body, cfg.PaymentWebhookSecret))
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+
+	var failed config.Order
+	db.First(&failed, "id = ?", order.ID)
+	assert.Equal(t, config.OrderStatusFailed, failed.Status)
+
+	var p config.Product
+	db.First(&p, "id = ?", productID)
+	assert.Equal(t, 5, p.Stock)
+}
+
+func TestExpirePayments_ContinuesPastFailures(t *testing.T) {
+	db := setupTestDB(t)
+	cfg := storeTestConfig()
+	mock := payments.NewMockProvider()
+	userID := uuid.New().String()
+	productID := uuid.New().String()
+	db.Create(&config.Product{ID: productID, Name: "Mug", Price: money.MustParse("8.00"), Stock: 5})
+
+	// A payment whose order is gone can't be expired; it comes first
+	db.Create(&config.Payment{ID: uuid.New().String(), OrderID: uuid.New().String(), IntentID: "orphan",
+		Status: config.PaymentStatusPending, ExpiresAt: time.Now().Add(-time.Hour)})
+	order := placeTestOrder(t, db, userID, productID, 2)
+	startTestPayment(t, db, cfg, mock, userID, order.ID)
+
+	assert.NoError(t, expirePayments(db, time.Now().Add(time.Hour), cfg.PaymentExpiry))
+	var expired config.Order
+	db.First(&expired, "id = ?", order.ID)
+	assert.Equal(t, config.OrderStatusFailed, expired.Status)
+}
+
+func TestExpirePayments_FailsUnpaidOrders(t *testing.T) {
+	db := setupTestDB(t)
+	cfg := storeTestConfig()
+	mock := payments.NewMockProvider()
+	userID := uuid.New().String()
+	productID := uuid.New().String()
+	db.Create(&config.Product{ID: productID, Name: "Mug", Price: money.MustParse("8.00"), Stock: 5})
+
+	order := placeTestOrder(t, db, userID, productID, 3)
+	startTestPayment(t, db, cfg, mock, userID, order.ID)
+
+	assert.NoError(t, expirePayments(db, time.Now(), cfg.PaymentExpiry))
+	var pending config.Order
+	db.First(&pending, "id = ?", order.ID)
+	assert.Equal(t, config.OrderStatusPending, pending.Status)
+
+	assert.NoError(t, expirePayments(db, time.Now().Add(time.Hour), cfg.PaymentExpiry))
+	var expired config.Order
+	db.First(&expired, "id = ?", order.ID)
+	assert.Equal(t, config.OrderStatusFailed, expired.Status)
+
+	var p config.Product
+	db.First(&p, "id = ?", productID)
+	assert.Equal(t, 5, p.Stock)
+}
+
+func TestExpirePayments_FailsOrdersNeverPaidFor(t *testing.T) {
+	db := setupTestDB(t)
+	cfg := storeTestConfig()
+	userID := uuid.New().String()
+	productID := uuid.New().String()
+	db.Create(&config.Product{ID: productID, Name: "Mug", Price: money.MustParse("8.00"), Stock: 5})
+
+	order := placeTestOrder(t, db, userID, productID, 3)
+
+	assert.NoError(t, expirePayments(db, time.Now(), cfg.PaymentExpiry))
+	var pending config.Order
+	db.First(&pending, "id = ?", order.ID)
+	assert.Equal(t, config.OrderStatusPending, pending.Status)
+
+	assert.NoError(t, expirePayments(db, time.Now().Add(time.Hour), cfg.PaymentExpiry))
+	var expired config.Order
+	db.First(&expired, "id = ?", order.ID)
+	assert.Equal(t, config.OrderStatusFailed, expired.Status)
+
+	var p config.Product
+	db.First(&p, "id = ?", productID)
+	assert.Equal(t, 5, p.Stock)
+}
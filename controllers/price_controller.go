@@ -0,0 +1,267 @@
+package controllers
+
+import (
+	"encoding/json"
+	"kalebecommerce/config"
+	"kalebecommerce/money"
+	"kalebecommerce/utils"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Scheduled price lifecycle
+const (
+	scheduleStatusScheduled = "scheduled"
+	scheduleStatusActive    = "active"
+	scheduleStatusEnded     = "ended"
+	scheduleStatusCancelled = "cancelled"
+)
+
+// recordPriceChange appends an entry to the product's price history.
+func recordPriceChange(tx *gorm.DB, productID string, oldPrice, newPrice money.Amount, source string, actor *uuid.UUID, scheduleID *string) error {
+	return tx.Create(&config.ProductPriceChange{
+		ID:               uuid.New().String(),
+		ProductID:        productID,
+		OldPrice:         oldPrice,
+		NewPrice:         newPrice,
+		Source:           source,
+		ScheduledPriceID: scheduleID,
+		ChangedBy:        actor,
+	}).Error
+}
+
+// setScheduledProductPrice changes a locked product's price on behalf of a schedule.
+func setScheduledProductPrice(tx *gorm.DB, p *config.Product, price money.Amount, sp *config.ScheduledPrice) error {
+	if p.Price == price {
+		return nil
+	}
+	if err := recordPriceChange(tx, p.ID, p.Price, price, "schedule", nil, &sp.ID); err != nil {
+		return err
+	}
+	return tx.Model(p).Updates(map[string]interface{}{"price": price, "version": gorm.Expr("version + 1")}).Error
+}
+
+// ListPriceHistory (Admin) - every recorded price change of a product, newest first
+func ListPriceHistory(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var history []config.ProductPriceChange
+		if err := db.Where("product_id = ?", c.Param("id")).Order("created_at DESC").Find(&history).Error; err != nil {
+			utils.JSON(c, http.StatusInternalServerError, false, "failed to fetch price history", nil, err.Error())
+			return
+		}
+		utils.JSON(c, http.StatusOK, true, "price history retrieved", history, nil)
+	}
+}
+
+// CreateScheduledPrice (Admin) - schedules a future price for a product
+func CreateScheduledPrice(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var in struct {
+			Price    json.Number `json:"price" binding:"required"`
+			StartsAt time.Time   `json:"starts_at" binding:"required"`
+			EndsAt   *time.Time  `json:"ends_at"`
+		}
+		if err := c.ShouldBindJSON(&in); err != nil {
+			utils.JSON(c, http.StatusBadRequest, false, "validation error", nil, err.Error())
+			return
+		}
+
+		price, err := parseProductPrice(in.Price.String())
+		if err != nil {
+			utils.JSON(c, http.StatusBadRequest, false, "validation error", nil, gin.H{"price": err.Error()})
+			return
+		}
+		if in.EndsAt != nil && !in.EndsAt.After(in.StartsAt) {
+			utils.JSON(c, http.StatusBadRequest, false, "validation error", nil, gin.H{"ends_at": "ends_at must be after starts_at"})
+			return
+		}
+
+		var p config.Product
+		if err := db.First(&p, "id = ?", c.Param("id")).Error; err != nil {
+			utils.JSON(c, http.StatusNotFound, false, "product not found", nil, nil)
+			return
+		}
+
+		sp := config.ScheduledPrice{
+			ID:        uuid.New().String(),
+			ProductID: p.ID,
+			Price:     price,
+			StartsAt:  in.StartsAt,
+			EndsAt:    in.EndsAt,
+			Status:    scheduleStatusScheduled,
+			CreatedBy: currentUserID(c),
+		}
+		if err := db.Create(&sp).Error; err != nil {
+			utils.JSON(c, http.StatusInternalServerError, false, "failed to schedule price", nil, err.Error())
+			return
+		}
+		utils.JSON(c, http.StatusCreated, true, "price scheduled", sp, nil)
+	}
+}
+
+// ListScheduledPrices (Admin) - all schedules of a product
+func ListScheduledPrices(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var schedules []config.ScheduledPrice
+		if err := db.Where("product_id = ?", c.Param("id")).Order("starts_at").Find(&schedules).Error; err != nil {
+			utils.JSON(c, http.StatusInternalServerError, false, "failed to fetch scheduled prices", nil, err.Error())
+			return
+		}
+		utils.JSON(c, http.StatusOK, true, "scheduled prices retrieved", schedules, nil)
+	}
+}
+
+// CancelScheduledPrice (Admin) - cancels a schedule, reverting the price if it is already live
+func CancelScheduledPrice(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var sp config.ScheduledPrice
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+				First(&sp, "id = ? AND product_id = ?", c.Param("scheduleId"), c.Param("id")).Error; err != nil {
+				return err
+			}
+			if sp.Status == scheduleStatusActive {
+				if err := endScheduledPrice(tx, &sp); err != nil {
+					return err
+				}
+			} else if sp.Status != scheduleStatusScheduled {
+				return nil
+			}
+			sp.Status = scheduleStatusCancelled
+			return tx.Save(&sp).Error
+		})
+		if err == gorm.ErrRecordNotFound {
+			utils.JSON(c, http.StatusNotFound, false, "scheduled price not found", nil, nil)
+			return
+		}
+		if err != nil {
+			utils.JSON(c, http.StatusInternalServerError, false, "failed to cancel scheduled price", nil, err.Error())
+			return
+		}
+		utils.JSON(c, http.StatusOK, true, "scheduled price cancelled", sp, nil)
+	}
+}
+
+// StartPriceScheduler applies due scheduled prices every interval until the process exits.
+func StartPriceScheduler(db *gorm.DB, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if err := applyScheduledPrices(db, time.Now()); err != nil {
+				log.Printf("price scheduler: %v", err)
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+// applyScheduledPrices ends expired schedules first, then activates due ones,
+// so back-to-back sales hand over cleanly. A schedule that fails is logged
+// and retried on the next run; it doesn't hold up the others.
+func applyScheduledPrices(db *gorm.DB, now time.Time) error {
+	var ending []config.ScheduledPrice
+	if err := db.Where("status = ? AND ends_at <= ?", scheduleStatusActive, now).Find(&ending).Error; err != nil {
+		return err
+	}
+	for _, due := range ending {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			sp, err := lockSchedule(tx, due.ID, scheduleStatusActive)
+			if err != nil || sp == nil {
+				return err
+			}
+			if err := endScheduledPrice(tx, sp); err != nil {
+				return err
+			}
+			sp.Status = scheduleStatusEnded
+			return tx.Save(sp).Error
+		})
+		if err != nil {
+			log.Printf("price scheduler: ending schedule %s: %v", due.ID, err)
+		}
+	}
+
+	// Schedules whose whole window passed while the scheduler was down are skipped
+	err := db.Model(&config.ScheduledPrice{}).
+		Where("status = ? AND ends_at <= ?", scheduleStatusScheduled, now).
+		Update("status", scheduleStatusEnded).Error
+	if err != nil {
+		return err
+	}
+
+	var starting []config.ScheduledPrice
+	if err := db.Where("status = ? AND starts_at <= ?", scheduleStatusScheduled, now).Order("starts_at").Find(&starting).Error; err != nil {
+		return err
+	}
+	for _, due := range starting {
+		if err := db.Transaction(func(tx *gorm.DB) error { return activateScheduledPrice(tx, due.ID) }); err != nil {
+			log.Printf("price scheduler: activating schedule %s: %v", due.ID, err)
+		}
+	}
+	return nil
+}
+
+// lockSchedule locks a schedule and returns it if it is still in status, or
+// nil when another scheduler or an admin already moved it on.
+func lockSchedule(tx *gorm.DB, id, status string) (*config.ScheduledPrice, error) {
+	var sp config.ScheduledPrice
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&sp, "id = ?", id).Error; err != nil {
+		return nil, err
+	}
+	if sp.Status != status {
+		return nil, nil
+	}
+	return &sp, nil
+}
+
+// activateScheduledPrice puts a schedule live, remembering the price to return to.
+func activateScheduledPrice(tx *gorm.DB, id string) error {
+	sp, err := lockSchedule(tx, id, scheduleStatusScheduled)
+	if err != nil || sp == nil {
+		return err
+	}
+	var p config.Product
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&p, "id = ?", sp.ProductID).Error; err != nil {
+		sp.Status = scheduleStatusCancelled
+		return tx.Save(sp).Error
+	}
+
+	revert := p.Price
+	// An overlapping live schedule is superseded; its original price is carried over
+	var previous []config.ScheduledPrice
+	tx.Where("product_id = ? AND status = ? AND id <> ?", sp.ProductID, scheduleStatusActive, sp.ID).Find(&previous)
+	for _, prev := range previous {
+		if prev.RevertPrice != nil {
+			revert = *prev.RevertPrice
+		}
+		prev.Status = scheduleStatusEnded
+		if err := tx.Save(&prev).Error; err != nil {
+			return err
+		}
+	}
+
+	if err := setScheduledProductPrice(tx, &p, sp.Price, sp); err != nil {
+		return err
+	}
+	sp.RevertPrice = &revert
+	sp.Status = scheduleStatusActive
+	return tx.Save(sp).Error
+}
+
+// endScheduledPrice restores the pre-sale price, unless an admin changed it meanwhile.
+func endScheduledPrice(tx *gorm.DB, sp *config.ScheduledPrice) error {
+	var p config.Product
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&p, "id = ?", sp.ProductID).Error; err != nil {
+		return nil
+	}
+	if sp.RevertPrice == nil || p.Price != sp.Price {
+		return nil
+	}
+	return setScheduledProductPrice(tx, &p, *sp.RevertPrice, sp)
+}
@@ -0,0 +1,101 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"kalebecommerce/config"
+	"kalebecommerce/money"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func TestUpdateProduct_RecordsPriceHistory(t *testing.T) {
+	db := setupTestDB(t)
+	router := setupRouter()
+	adminID := uuid.New()
+	productID := uuid.New()
+	db.Create(&config.Product{ID: productID.String(), Name: "Kettle", Price: money.MustParse("40.00"), Stock: 3})
+
+	router.PATCH("/admin/products/:id", mockAuthMiddleware(adminID.String()), UpdateProduct(db))
+
+	req, _ := http.NewRequest("PATCH", "/admin/products/"+productID.String(), strings.NewReader(`{"price": 35}`))
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+	req.Header.Set("If-Match", `"1"`)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var history []config.ProductPriceChange
+	db.Where("product_id = ?", productID.String()).Find(&history)
+	assert.Len(t, history, 1)
+	assert.Equal(t, money.MustParse("40.00"), history[0].OldPrice)
+	assert.Equal(t, money.MustParse("35.00"), history[0].NewPrice)
+	assert.Equal(t, "manual", history[0].Source)
+	assert.Equal(t, adminID, *history[0].ChangedBy)
+}
+
+func TestCreateScheduledPrice_ValidationFailure(t *testing.T) {
+	db := setupTestDB(t)
+	router := setupRouter()
+	productID := uuid.New()
+	db.Create(&config.Product{ID: productID.String(), Name: "Kettle", Price: money.MustParse("40.00"), Stock: 3})
+
+	router.POST("/admin/products/:id/scheduled-prices", mockAdminAuthMiddleware(), CreateScheduledPrice(db))
+
+	body := `{"price": "30", "starts_at": "2030-01-02T00:00:00Z", "ends_at": "2030-01-01T00:00:00Z"}`
+	req, _ := http.NewRequest("POST", "/admin/products/"+productID.String()+"/scheduled-prices", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "ends_at must be after starts_at")
+}
+
+func TestApplyScheduledPrices_ActivatesAndReverts(t *testing.T) {
+	db := setupTestDB(t)
+	productID := uuid.New().String()
+	db.Create(&config.Product{ID: productID, Name: "Kettle", Price: money.MustParse("40.00"), Stock: 3})
+
+	start := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(24 * time.Hour)
+	db.Create(&config.ScheduledPrice{
+		ID: uuid.New().String(), ProductID: productID, Price: money.MustParse("30.00"),
+		StartsAt: start, EndsAt: &end, Status: scheduleStatusScheduled,
+	})
+
+	// Before the window nothing happens
+	assert.NoError(t, applyScheduledPrices(db, start.Add(-time.Minute)))
+	var p config.Product
+	db.First(&p, "id = ?", productID)
+	assert.Equal(t, money.MustParse("40.00"), p.Price)
+
+	// Inside the window the sale price is live
+	assert.NoError(t, applyScheduledPrices(db, start.Add(time.Minute)))
+	db.First(&p, "id = ?", productID)
+	assert.Equal(t, money.MustParse("30.00"), p.Price)
+	assert.Equal(t, 2, p.Version)
+
+	// A second scheduler that picked up the same schedule leaves it alone
+	var sp config.ScheduledPrice
+	db.First(&sp, "product_id = ?", productID)
+	assert.NoError(t, db.Transaction(func(tx *gorm.DB) error { return activateScheduledPrice(tx, sp.ID) }))
+	db.First(&sp, "id = ?", sp.ID)
+	assert.Equal(t, money.MustParse("40.00"), *sp.RevertPrice)
+
+	// After the window the original price is restored
+	assert.NoError(t, applyScheduledPrices(db, end.Add(time.Minute)))
+	db.First(&p, "id = ?", productID)
+	assert.Equal(t, money.MustParse("40.00"), p.Price)
+
+	var history []config.ProductPriceChange
+	db.Where("product_id = ?", productID).Order("created_at").Find(&history)
+	assert.Len(t, history, 2)
+	assert.Equal(t, "schedule", history[0].Source)
+}
@@ -1,12 +1,18 @@
 package controllers
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 
 	"kalebecommerce/config"
+	"kalebecommerce/money"
 	"kalebecommerce/utils"
 	"net/http"
 	"os"
+	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
 
@@ -16,6 +22,146 @@ import (
 	"gorm.io/gorm"
 )
 
+// parseProductPrice validates a price value; shared by every product write path.
+func parseProductPrice(raw string) (money.Amount, error) {
+	price, err := money.Parse(raw)
+	if err != nil || price <= 0 {
+		return 0, errors.New("price must be a valid number greater than 0")
+	}
+	return price, nil
+}
+
+// parseProductStock validates a stock value; shared by every product write path.
+func parseProductStock(raw string) (int, error) {
+	stock, err := strconv.Atoi(strings.TrimSpace(raw))
+	if err != nil || stock < 0 {
+		return 0, errors.New("stock must be a valid non-negative integer")
+	}
+	return stock, nil
+}
+
+// productMeasureFields are the shipping weight (grams) and dimensions (millimetres) of a product.
+var productMeasureFields = []string{"weight_grams", "length_mm", "width_mm", "height_mm"}
+
+// parseProductMeasure validates a weight or dimension; empty means 0.
+func parseProductMeasure(key, raw string) (int, error) {
+	if strings.TrimSpace(raw) == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(raw))
+	if err != nil || n < 0 {
+		return 0, errors.New(key + " must be a valid non-negative integer")
+	}
+	return n, nil
+}
+
+// setProductMeasure stores a parsed weight or dimension on p.
+func setProductMeasure(p *config.Product, key string, n int) {
+	switch key {
+	case "weight_grams":
+		p.WeightGrams = n
+	case "length_mm":
+		p.LengthMM = n
+	case "width_mm":
+		p.WidthMM = n
+	case "height_mm":
+		p.HeightMM = n
+	}
+}
+
+// parseCompareAtPrice validates an optional compare-at (list) price; empty means none.
+func parseCompareAtPrice(raw string) (*money.Amount, error) {
+	if strings.TrimSpace(raw) == "" {
+		return nil, nil
+	}
+	compareAt, err := money.Parse(raw)
+	if err != nil || compareAt <= 0 {
+		return nil, errors.New("compare_at_price must be a valid number greater than 0")
+	}
+	return &compareAt, nil
+}
+
+// taxClassPattern limits tax class names to short lowercase identifiers.
+var taxClassPattern = regexp.MustCompile(`^[a-z0-9_-]{1,32}$`)
+
+// parseTaxClass validates a product tax class; empty means the standard class.
+func parseTaxClass(raw string) (string, error) {
+	class := strings.ToLower(strings.TrimSpace(raw))
+	if class == "" {
+		return config.TaxClassStandard, nil
+	}
+	if !taxClassPattern.MatchString(class) {
+		return "", errors.New("tax_class must be up to 32 letters, digits, '-' or '_'")
+	}
+	return class, nil
+}
+
+// parseGiftCardFlag reads the gift_card field; empty means a regular product.
+func parseGiftCardFlag(raw string) (bool, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return false, nil
+	}
+	flag, err := strconv.ParseBool(raw)
+	if err != nil {
+		return false, errors.New("gift_card must be true or false")
+	}
+	return flag, nil
+}
+
+// validateCompareAtPrice ensures a compare-at price is above the selling price.
+func validateCompareAtPrice(compareAt *money.Amount, price money.Amount) error {
+	if compareAt != nil && *compareAt <= price {
+		return errors.New("compare_at_price must be greater than price")
+	}
+	return nil
+}
+
+// optionalSKU turns an empty SKU into NULL so the unique index ignores it.
+func optionalSKU(raw string) *string {
+	sku := strings.TrimSpace(raw)
+	if sku == "" {
+		return nil
+	}
+	return &sku
+}
+
+// productETag is the entity tag of a product's current version.
+func productETag(p config.Product) string {
+	return fmt.Sprintf(`"%d"`, p.Version)
+}
+
+// etagMatches reports whether an If-Match / If-None-Match header lists etag.
+// If-None-Match uses weak comparison, so "W/" prefixes are ignored when weak is set.
+func etagMatches(header, etag string, weak bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if weak {
+			candidate = strings.TrimPrefix(candidate, "W/")
+		}
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// requireIfMatch enforces optimistic concurrency on product writes.
+// It writes the error response and returns false when the precondition fails.
+func requireIfMatch(c *gin.Context, p config.Product) bool {
+	ifMatch := c.GetHeader("If-Match")
+	if ifMatch == "" {
+		utils.JSON(c, http.StatusPreconditionRequired, false, "precondition required", nil, "If-Match header with the product ETag is required")
+		return false
+	}
+	if !etagMatches(ifMatch, productETag(p), false) {
+		c.Header("ETag", productETag(p))
+		utils.JSON(c, http.StatusPreconditionFailed, false, "product was modified", nil, "the product changed since it was read; fetch it again and retry")
+		return false
+	}
+	return true
+}
+
 // CreateProduct (Admin) - Now accepts multipart/form-data
 func CreateProduct(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -26,6 +172,10 @@ func CreateProduct(db *gorm.DB) gin.HandlerFunc {
 			Price       string `form:"price" binding:"required"` // Read as string, convert later
 			Stock       string `form:"stock" binding:"required"` // Read as string, convert later
 			Category    string `form:"category"`
+			SKU         string `form:"sku"`
+			CompareAt   string `form:"compare_at_price"`
+			TaxClass    string `form:"tax_class"`
+			GiftCard    string `form:"gift_card"`
 		}
 
 		// Use c.ShouldBind to handle form data binding
@@ -35,16 +185,41 @@ func CreateProduct(db *gorm.DB) gin.HandlerFunc {
 		}
 
 		// Convert string fields to their correct types
-		price, err := strconv.ParseFloat(in.Price, 64)
-		if err != nil || price <= 0 {
-			utils.JSON(c, http.StatusBadRequest, false, "validation error", nil, "price must be a valid number greater than 0")
+		price, err := parseProductPrice(in.Price)
+		if err != nil {
+			utils.JSON(c, http.StatusBadRequest, false, "validation error", nil, err.Error())
+			return
+		}
+		stock, err := parseProductStock(in.Stock)
+		if err != nil {
+			utils.JSON(c, http.StatusBadRequest, false, "validation error", nil, err.Error())
+			return
+		}
+		compareAt, err := parseCompareAtPrice(in.CompareAt)
+		if err == nil {
+			err = validateCompareAtPrice(compareAt, price)
+		}
+		if err != nil {
+			utils.JSON(c, http.StatusBadRequest, false, "validation error", nil, err.Error())
 			return
 		}
-		stock, err := strconv.Atoi(in.Stock)
-		if err != nil || stock < 0 {
-			utils.JSON(c, http.StatusBadRequest, false, "validation error", nil, "stock must be a valid non-negative integer")
+		taxClass, err := parseTaxClass(in.TaxClass)
+		if err != nil {
+			utils.JSON(c, http.StatusBadRequest, false, "validation error", nil, err.Error())
+			return
+		}
+		giftCard, err := parseGiftCardFlag(in.GiftCard)
+		if err != nil {
+			utils.JSON(c, http.StatusBadRequest, false, "validation error", nil, err.Error())
 			return
 		}
+		measures := make(map[string]int)
+		for _, key := range productMeasureFields {
+			if measures[key], err = parseProductMeasure(key, c.PostForm(key)); err != nil {
+				utils.JSON(c, http.StatusBadRequest, false, "validation error", nil, err.Error())
+				return
+			}
+		}
 
 		// Handle file upload
 		file, err := c.FormFile("image")
@@ -65,24 +240,213 @@ func CreateProduct(db *gorm.DB) gin.HandlerFunc {
 		// If err == http.ErrMissingFile, we proceed with an empty imageURL
 
 		p := config.Product{
-			ID:          uuid.New().String(),
-			Name:        in.Name,
-			Description: in.Description,
-			Price:       price,
-			Stock:       stock,
-			Category:    in.Category,
-			ImageURL:    imageURL, // Store the path
+			ID:             uuid.New().String(),
+			SKU:            optionalSKU(in.SKU),
+			Name:           in.Name,
+			Description:    in.Description,
+			Price:          price,
+			CompareAtPrice: compareAt,
+			Stock:          stock,
+			Category:       in.Category,
+			TaxClass:       taxClass,
+			GiftCard:       giftCard,
+			ImageURL:       imageURL, // Store the path
+		}
+		for key, n := range measures {
+			setProductMeasure(&p, key, n)
 		}
 
 		if err := db.Create(&p).Error; err != nil {
 			utils.JSON(c, http.StatusInternalServerError, false, "failed to create product", nil, err.Error())
 			return
 		}
+		c.Header("ETag", productETag(p))
 		utils.JSON(c, http.StatusCreated, true, "product created", p, nil)
 	}
 }
 
-// UpdateProduct (Admin) - Now handles image update via form data
+// updatableProductFields are the form fields UpdateProduct reads.
+var updatableProductFields = []string{"name", "description", "category", "sku", "price", "compare_at_price", "stock", "tax_class",
+	"weight_grams", "length_mm", "width_mm", "height_mm", "gift_card"}
+
+// isMergePatch reports whether the request carries a JSON merge patch instead of form data.
+func isMergePatch(c *gin.Context) bool {
+	ct := c.ContentType()
+	return ct == "application/merge-patch+json" || ct == "application/json"
+}
+
+// applyProductField validates one incoming field and records it in updates.
+// A nil value means the client explicitly cleared the field.
+func applyProductField(key string, value *string, updates map[string]interface{}, errs map[string]string) {
+	switch key {
+	case "name":
+		if value == nil || strings.TrimSpace(*value) == "" {
+			errs[key] = "name cannot be empty"
+			return
+		}
+		updates[key] = strings.TrimSpace(*value)
+	case "description", "category":
+		if value == nil {
+			updates[key] = ""
+			return
+		}
+		updates[key] = *value
+	case "image_url":
+		// The file behind an uploaded image is removed with the product, so
+		// only hosted URLs and images already in the upload directory are taken
+		if value == nil || *value == "" {
+			updates[key] = ""
+			return
+		}
+		if !utils.ValidImageURL(*value) {
+			errs[key] = "image_url must be an http(s) URL or an uploaded image"
+			return
+		}
+		updates[key] = *value
+	case "sku":
+		if value == nil || strings.TrimSpace(*value) == "" {
+			updates[key] = nil
+			return
+		}
+		updates[key] = strings.TrimSpace(*value)
+	case "price":
+		if value == nil {
+			errs[key] = "price cannot be cleared"
+			return
+		}
+		price, err := parseProductPrice(*value)
+		if err != nil {
+			errs[key] = err.Error()
+			return
+		}
+		updates[key] = price
+	case "compare_at_price":
+		if value == nil {
+			updates[key] = (*money.Amount)(nil)
+			return
+		}
+		compareAt, err := parseCompareAtPrice(*value)
+		if err != nil {
+			errs[key] = err.Error()
+			return
+		}
+		updates[key] = compareAt
+	case "stock":
+		if value == nil {
+			errs[key] = "stock cannot be cleared"
+			return
+		}
+		stock, err := parseProductStock(*value)
+		if err != nil {
+			errs[key] = err.Error()
+			return
+		}
+		updates[key] = stock
+	case "tax_class":
+		raw := ""
+		if value != nil {
+			raw = *value
+		}
+		class, err := parseTaxClass(raw)
+		if err != nil {
+			errs[key] = err.Error()
+			return
+		}
+		updates[key] = class
+	case "weight_grams", "length_mm", "width_mm", "height_mm":
+		raw := ""
+		if value != nil {
+			raw = *value
+		}
+		n, err := parseProductMeasure(key, raw)
+		if err != nil {
+			errs[key] = err.Error()
+			return
+		}
+		updates[key] = n
+	case "gift_card":
+		raw := ""
+		if value != nil {
+			raw = *value
+		}
+		flag, err := parseGiftCardFlag(raw)
+		if err != nil {
+			errs[key] = err.Error()
+			return
+		}
+		updates[key] = flag
+	default:
+		errs[key] = "field cannot be updated"
+	}
+}
+
+// productUpdatesFromForm reads the fields present in the form; an empty value clears the field.
+func productUpdatesFromForm(c *gin.Context) (map[string]interface{}, map[string]string) {
+	updates := make(map[string]interface{})
+	errs := make(map[string]string)
+	for _, key := range updatableProductFields {
+		if value, ok := c.GetPostForm(key); ok {
+			applyProductField(key, &value, updates, errs)
+		}
+	}
+	return updates, errs
+}
+
+// productUpdatesFromMergePatch applies RFC 7396 semantics: absent keys are untouched, null clears.
+func productUpdatesFromMergePatch(body []byte) (map[string]interface{}, map[string]string) {
+	updates := make(map[string]interface{})
+	errs := make(map[string]string)
+
+	var patch map[string]json.RawMessage
+	if err := json.Unmarshal(body, &patch); err != nil {
+		errs["body"] = "body must be a JSON object"
+		return updates, errs
+	}
+
+	for key, raw := range patch {
+		if string(raw) == "null" {
+			applyProductField(key, nil, updates, errs)
+			continue
+		}
+
+		var value string
+		if err := json.Unmarshal(raw, &value); err != nil {
+			// Numbers are accepted for prices and stock, and booleans for
+			// gift_card, and validated as text
+			var number json.Number
+			var flag bool
+			switch {
+			case key == "gift_card" && json.Unmarshal(raw, &flag) == nil:
+				value = strconv.FormatBool(flag)
+			case (key == "price" || key == "compare_at_price" || key == "stock") && json.Unmarshal(raw, &number) == nil:
+				value = number.String()
+			default:
+				errs[key] = "invalid value type"
+				continue
+			}
+		}
+		applyProductField(key, &value, updates, errs)
+	}
+	return updates, errs
+}
+
+// resultingPrice is the price a product will have once updates are applied.
+func resultingPrice(p config.Product, updates map[string]interface{}) money.Amount {
+	if price, ok := updates["price"].(money.Amount); ok {
+		return price
+	}
+	return p.Price
+}
+
+// resultingCompareAt is the compare-at price a product will have once updates are applied.
+func resultingCompareAt(p config.Product, updates map[string]interface{}) *money.Amount {
+	if compareAt, ok := updates["compare_at_price"]; ok {
+		return compareAt.(*money.Amount)
+	}
+	return p.CompareAtPrice
+}
+
+// UpdateProduct (Admin) - accepts multipart form data or an application/merge-patch+json body
 func UpdateProduct(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		id := c.Param("id")
@@ -97,85 +461,103 @@ func UpdateProduct(db *gorm.DB) gin.HandlerFunc {
 			utils.JSON(c, http.StatusNotFound, false, "product not found", nil, nil)
 			return
 		}
+		if !requireIfMatch(c, p) {
+			return
+		}
 
-		// Handle file upload (optional image replacement)
-		file, err := c.FormFile("image")
-		var imageURL string
-		if err == nil {
-			// File was provided, save it
-			// Use existing product ID to keep filename consistent (and potentially overwrite old image)
-			imageURL, err = utils.SaveUploadedFile(file, p.ID)
+		// Collect field changes from either a JSON merge patch or the multipart form
+		var updates map[string]interface{}
+		var fieldErrors map[string]string
+		isPatch := isMergePatch(c)
+		if isPatch {
+			body, err := io.ReadAll(c.Request.Body)
 			if err != nil {
-				utils.JSON(c, http.StatusInternalServerError, false, "failed to save image", nil, err.Error())
+				utils.JSON(c, http.StatusBadRequest, false, "invalid request body", nil, err.Error())
 				return
 			}
-			// Update the ImageURL in the product object
-			p.ImageURL = imageURL
-		} else if err != http.ErrMissingFile {
-			// Handle other file related errors (like size limit)
-			utils.JSON(c, http.StatusBadRequest, false, "file error", nil, err.Error())
-			return
-		}
-
-		// Bind all other fields from the form
-		var in struct {
-			Name        string `form:"name"`
-			Description string `form:"description"`
-			Price       string `form:"price"`
-			Stock       string `form:"stock"`
-			Category    string `form:"category"`
-		}
-
-		if err := c.ShouldBind(&in); err != nil {
-			// Note: Gin's form binding requires explicit checks for partial updates on empty fields.
-			// For simplicity and robust parsing, we'll manually check for non-empty string updates.
-		}
-
-		updates := make(map[string]interface{})
-
-		if in.Name != "" {
-			updates["name"] = in.Name
+			updates, fieldErrors = productUpdatesFromMergePatch(body)
+		} else {
+			updates, fieldErrors = productUpdatesFromForm(c)
 		}
-		if in.Description != "" {
-			updates["description"] = in.Description
+		if sku, ok := updates["sku"].(string); ok {
+			var clash int64
+			db.Model(&config.Product{}).Where("sku = ? AND id <> ?", sku, p.ID).Count(&clash)
+			if clash > 0 {
+				fieldErrors["sku"] = "sku is already used by another product"
+			}
 		}
-		if in.Category != "" {
-			updates["category"] = in.Category
+		if err := validateCompareAtPrice(resultingCompareAt(p, updates), resultingPrice(p, updates)); err != nil {
+			fieldErrors["compare_at_price"] = err.Error()
 		}
-
-		// Handle Price update
-		if in.Price != "" {
-			price, err := strconv.ParseFloat(in.Price, 64)
-			if err == nil && price > 0 {
-				updates["price"] = price
-			}
+		if len(fieldErrors) > 0 {
+			utils.JSON(c, http.StatusBadRequest, false, "validation error", nil, fieldErrors)
+			return
 		}
 
-		// Handle Stock update
-		if in.Stock != "" {
-			stock, err := strconv.Atoi(in.Stock)
-			if err == nil && stock >= 0 {
-				updates["stock"] = stock
+		// Handle file upload (optional image replacement, form data only). The
+		// file is staged under a temporary name and only replaces the live image
+		// once the versioned update below succeeds.
+		var staged, imageURL string
+		if !isPatch {
+			file, err := c.FormFile("image")
+			if err == nil {
+				staged, err = utils.SaveUploadedFileTo(file, utils.UploadDir, p.ID+".upload-"+uuid.New().String())
+				if err != nil {
+					utils.JSON(c, http.StatusInternalServerError, false, "failed to save image", nil, err.Error())
+					return
+				}
+				// Nothing left to remove once it has been renamed into place
+				defer os.Remove(strings.TrimPrefix(staged, "/"))
+				// Use existing product ID to keep filename consistent (and potentially overwrite old image)
+				imageURL = "/" + filepath.Join(utils.UploadDir, p.ID+filepath.Ext(file.Filename))
+				updates["image_url"] = imageURL
+			} else if err != http.ErrMissingFile {
+				// Handle other file related errors (like size limit)
+				utils.JSON(c, http.StatusBadRequest, false, "file error", nil, err.Error())
+				return
 			}
 		}
 
-		// Add image update if a file was provided
-		if imageURL != "" {
-			updates["image_url"] = imageURL
-		}
-
-		if len(updates) == 0 && err == http.ErrMissingFile {
+		if len(updates) == 0 {
+			c.Header("ETag", productETag(p))
 			utils.JSON(c, http.StatusOK, true, "no fields to update", p, nil)
 			return
 		}
 
-		if err := db.Model(&p).Updates(updates).Error; err != nil {
+		// Only apply the update if nobody else bumped the version in the meantime
+		updates["version"] = gorm.Expr("version + 1")
+		var updated int64
+		err = db.Transaction(func(tx *gorm.DB) error {
+			res := tx.Model(&config.Product{}).Where("id = ? AND version = ?", p.ID, p.Version).Updates(updates)
+			if res.Error != nil {
+				return res.Error
+			}
+			updated = res.RowsAffected
+			if updated == 0 {
+				return nil
+			}
+			if price, ok := updates["price"].(money.Amount); ok && price != p.Price {
+				if err := recordPriceChange(tx, p.ID, p.Price, price, "manual", currentUserID(c), nil); err != nil {
+					return err
+				}
+			}
+			if staged != "" {
+				return os.Rename(strings.TrimPrefix(staged, "/"), strings.TrimPrefix(imageURL, "/"))
+			}
+			return nil
+		})
+		if err != nil {
 			utils.JSON(c, http.StatusInternalServerError, false, "update failed", nil, err.Error())
 			return
 		}
+		if updated == 0 {
+			utils.JSON(c, http.StatusPreconditionFailed, false, "product was modified", nil, "the product changed since it was read; fetch it again and retry")
+			return
+		}
 
 		// Reload the product to ensure the response is up-to-date
 		db.First(&p, "id = ?", pid)
+		c.Header("ETag", productETag(p))
 		utils.JSON(c, http.StatusOK, true, "product updated", p, nil)
 	}
 }
@@ -190,27 +572,39 @@ func DeleteProduct(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
-		// OPTIONAL: Delete the associated image file from disk
 		var p config.Product
-		// FIX: Separate the short declaration from the boolean expression
-		if err := db.First(&p, "id = ?", pid).Error; err == nil && p.ImageURL != "" {
-			// Prepend "." to handle relative path from root
-			if err := os.Remove("." + p.ImageURL); err != nil {
-				fmt.Printf("Warning: Failed to delete file %s: %v\n", p.ImageURL, err)
-				// Continue deletion even if file deletion fails
-			}
+		if err := db.First(&p, "id = ?", pid).Error; err != nil {
+			utils.JSON(c, http.StatusNotFound, false, "product not found", nil, nil)
+			return
+		}
+		if !requireIfMatch(c, p) {
+			return
 		}
 
-		if err := db.Delete(&config.Product{}, "id = ?", pid).Error; err != nil {
-			utils.JSON(c, http.StatusInternalServerError, false, "delete failed", nil, err.Error())
+		res := db.Where("id = ? AND version = ?", p.ID, p.Version).Delete(&config.Product{})
+		if res.Error != nil {
+			utils.JSON(c, http.StatusInternalServerError, false, "delete failed", nil, res.Error.Error())
+			return
+		}
+		if res.RowsAffected == 0 {
+			utils.JSON(c, http.StatusPreconditionFailed, false, "product was modified", nil, "the product changed since it was read; fetch it again and retry")
 			return
 		}
+
+		// OPTIONAL: Delete the associated image file from disk, never anything
+		// outside the upload directory
+		if imagePath, ok := utils.UploadedImagePath(p.ImageURL); ok {
+			if err := os.Remove(imagePath); err != nil {
+				fmt.Printf("Warning: Failed to delete file %s: %v\n", p.ImageURL, err)
+				// Continue even if file deletion fails
+			}
+		}
 		utils.JSON(c, http.StatusOK, true, "product deleted", nil, nil)
 	}
 }
 
-// GetProduct (Public)
-func GetProduct(db *gorm.DB) gin.HandlerFunc {
+// GetProduct (Public) - prices are shown in ?currency= (default: store currency)
+func GetProduct(db *gorm.DB, cfg *config.Config) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		id := c.Param("id")
 		pid, err := uuid.Parse(id)
@@ -218,39 +612,76 @@ func GetProduct(db *gorm.DB) gin.HandlerFunc {
 			utils.JSON(c, http.StatusBadRequest, false, "invalid product id", nil, nil)
 			return
 		}
+		pr, ok := requestPricing(c, db, cfg)
+		if !ok {
+			return
+		}
 
 		var product config.Product
 		if err := db.First(&product, "id = ?", pid).Error; err != nil {
 			utils.JSON(c, http.StatusNotFound, false, "product not found", nil, nil)
 			return
 		}
+		products := []config.Product{product}
+		if err := pr.localize(db, products); err != nil {
+			utils.JSON(c, http.StatusInternalServerError, false, "failed to price product", nil, err.Error())
+			return
+		}
+		product = products[0]
+
+		etag := localizedETag(product, pr)
+		c.Header("ETag", etag)
+		if etagMatches(c.GetHeader("If-None-Match"), etag, true) {
+			c.Status(http.StatusNotModified)
+			return
+		}
 		utils.JSON(c, http.StatusOK, true, "product retrieved", product, nil)
 	}
 }
 
-// ListProducts (Public)
-func ListOrSearchProducts(db *gorm.DB, productCache *cache.Cache) gin.HandlerFunc {
+// filterProducts applies the catalog filter query parameters shared by listing and export.
+func filterProducts(c *gin.Context, query *gorm.DB) *gorm.DB {
+	search := strings.ToLower(c.DefaultQuery("search", ""))
+	if search != "" {
+		query = query.Where("LOWER(name) LIKE ?", "%"+search+"%")
+	}
+	switch c.Query("on_sale") {
+	case "true":
+		query = query.Where("compare_at_price IS NOT NULL AND compare_at_price > price")
+	case "false":
+		query = query.Where("compare_at_price IS NULL OR compare_at_price <= price")
+	}
+	return query
+}
+
+// ListProducts (Public) - prices are shown in ?currency= (default: store currency)
+func ListOrSearchProducts(db *gorm.DB, cfg *config.Config, productCache *cache.Cache) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		pr, ok := requestPricing(c, db, cfg)
+		if !ok {
+			return
+		}
 		page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
 		limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10"))
-		search := strings.ToLower(c.DefaultQuery("search", ""))
 		offset := (page - 1) * limit
 
 		var products []config.Product
-		query := db.Model(&config.Product{})
-		if search != "" {
-			query = query.Where("LOWER(name) LIKE ?", "%"+search+"%")
-		}
+		query := filterProducts(c, db.Model(&config.Product{}))
 
 		var total int64
 		query.Count(&total)
 		query.Offset(offset).Limit(limit).Find(&products)
+		if err := pr.localize(db, products); err != nil {
+			utils.JSON(c, http.StatusInternalServerError, false, "failed to price products", nil, err.Error())
+			return
+		}
 
 		utils.JSON(c, http.StatusOK, true, "products listed",
 			gin.H{
 				"currentPage":   page,
 				"pageSize":      limit,
 				"totalProducts": total,
+				"currency":      pr.Currency,
 				"products":      products,
 			}, nil)
 	}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"kalebecommerce/config"
+	"kalebecommerce/money"
 	"kalebecommerce/utils"
 	"mime/multipart"
 	"net/http"
@@ -117,6 +118,30 @@ func TestCreateProduct_ValidationFailure(t *testing.T) {
 	assert.Contains(t, w.Body.String(), "validation error")
 }
 
+func TestCreateProduct_CompareAtPriceMustExceedPrice(t *testing.T) {
+	db := setupTestDB(t)
+	router := setupRouter()
+	router.POST("/admin/products", mockAdminAuthMiddleware(), CreateProduct(db))
+
+	fields := map[string]string{
+		"name":             "Sneakers",
+		"description":      "Running shoes",
+		"price":            "80",
+		"compare_at_price": "60",
+		"stock":            "5",
+	}
+	body, contentType := createMultipartForm(t, fields, "", "")
+
+	req, _ := http.NewRequest("POST", "/admin/products", body)
+	req.Header.Set("Content-Type", contentType)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "compare_at_price must be greater than price")
+}
+
 // --- 2. TestUpdateProduct (Admin Route) ---
 
 func TestUpdateProduct_Success_WithImageUpdate(t *testing.T) {
@@ -131,7 +156,7 @@ func TestUpdateProduct_Success_WithImageUpdate(t *testing.T) {
 	defer os.RemoveAll(utils.UploadDir)
 
 	db.Create(&config.Product{
-		ID: productID.String(), Name: "Old Name", Price: 10.00, Stock: 5, ImageURL: "/" + originalImageURL,
+		ID: productID.String(), Name: "Old Name", Price: money.MustParse("10.00"), Stock: 5, ImageURL: "/" + originalImageURL,
 	})
 
 	router.PUT("/admin/products/:id", mockAdminAuthMiddleware(), UpdateProduct(db))
@@ -148,6 +173,7 @@ func TestUpdateProduct_Success_WithImageUpdate(t *testing.T) {
 	url := fmt.Sprintf("/admin/products/%s", productID.String())
 	req, _ := http.NewRequest("PUT", url, body)
 	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("If-Match", `"1"`)
 
 	w := httptest.NewRecorder()
 	router.ServeHTTP(w, req)
@@ -159,12 +185,14 @@ func TestUpdateProduct_Success_WithImageUpdate(t *testing.T) {
 	var updatedProduct config.Product
 	db.First(&updatedProduct, "id = ?", productID)
 	assert.Equal(t, "New Name", updatedProduct.Name)
-	assert.Equal(t, 20.00, updatedProduct.Price)
+	assert.Equal(t, money.MustParse("20.00"), updatedProduct.Price)
 	assert.NotEqual(t, "/"+originalImageURL, updatedProduct.ImageURL, "ImageURL should be updated")
 	assert.True(t, strings.HasSuffix(updatedProduct.ImageURL, ".gif"), "ImageURL should reflect the new file extension")
 
-	// Check that the new file exists on disk
+	// Check that the new file exists on disk and no staged upload is left behind
 	assert.FileExists(t, "."+updatedProduct.ImageURL)
+	staged, _ := filepath.Glob(filepath.Join(utils.UploadDir, "*.upload-*"))
+	assert.Empty(t, staged)
 }
 
 func TestUpdateProduct_Success_NoImageUpdate(t *testing.T) {
@@ -173,7 +201,7 @@ func TestUpdateProduct_Success_NoImageUpdate(t *testing.T) {
 	// 1. Create a product to update
 	productID := uuid.New()
 	db.Create(&config.Product{
-		ID: productID.String(), Name: "Old Name", Price: 10.00, Stock: 5, ImageURL: "/uploads/products/static.png",
+		ID: productID.String(), Name: "Old Name", Price: money.MustParse("10.00"), Stock: 5, ImageURL: "/uploads/products/static.png",
 	})
 	originalImageURL := "/uploads/products/static.png" // Keep original image URL
 
@@ -190,6 +218,7 @@ func TestUpdateProduct_Success_NoImageUpdate(t *testing.T) {
 	url := fmt.Sprintf("/admin/products/%s", productID.String())
 	req, _ := http.NewRequest("PUT", url, body)
 	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("If-Match", `"1"`)
 
 	w := httptest.NewRecorder()
 	router.ServeHTTP(w, req)
@@ -200,7 +229,7 @@ func TestUpdateProduct_Success_NoImageUpdate(t *testing.T) {
 	var updatedProduct config.Product
 	db.First(&updatedProduct, "id = ?", productID)
 	assert.Equal(t, "New Name Only", updatedProduct.Name)
-	assert.Equal(t, 10.00, updatedProduct.Price) // Price should be unchanged
+	assert.Equal(t, money.MustParse("10.00"), updatedProduct.Price) // Price should be unchanged
 	assert.Equal(t, 10, updatedProduct.Stock)
 	assert.Equal(t, originalImageURL, updatedProduct.ImageURL, "ImageURL should remain the same")
 }
@@ -226,6 +255,170 @@ func TestUpdateProduct_NotFound(t *testing.T) {
 	assert.Contains(t, w.Body.String(), "product not found")
 }
 
+func TestUpdateProduct_MissingIfMatch(t *testing.T) {
+	db := setupTestDB(t)
+	router := setupRouter()
+	productID := uuid.New()
+	db.Create(&config.Product{ID: productID.String(), Name: "Old Name", Price: money.MustParse("10.00"), Stock: 5})
+
+	router.PUT("/admin/products/:id", mockAdminAuthMiddleware(), UpdateProduct(db))
+
+	body, contentType := createMultipartForm(t, map[string]string{"name": "New Name"}, "", "")
+	req, _ := http.NewRequest("PUT", "/admin/products/"+productID.String(), body)
+	req.Header.Set("Content-Type", contentType)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusPreconditionRequired, w.Code)
+}
+
+func TestUpdateProduct_StaleIfMatch(t *testing.T) {
+	db := setupTestDB(t)
+	router := setupRouter()
+	productID := uuid.New()
+	// Another admin already saved version 2
+	db.Create(&config.Product{ID: productID.String(), Name: "Old Name", Price: money.MustParse("10.00"), Stock: 5, Version: 2})
+
+	router.PUT("/admin/products/:id", mockAdminAuthMiddleware(), UpdateProduct(db))
+
+	body, contentType := createMultipartForm(t, map[string]string{"name": "Lost Update"}, "", "")
+	req, _ := http.NewRequest("PUT", "/admin/products/"+productID.String(), body)
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("If-Match", `"1"`)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+	assert.Equal(t, `"2"`, w.Header().Get("ETag"))
+
+	var unchanged config.Product
+	db.First(&unchanged, "id = ?", productID)
+	assert.Equal(t, "Old Name", unchanged.Name)
+}
+
+func TestUpdateProduct_BumpsVersion(t *testing.T) {
+	db := setupTestDB(t)
+	router := setupRouter()
+	productID := uuid.New()
+	db.Create(&config.Product{ID: productID.String(), Name: "Old Name", Price: money.MustParse("10.00"), Stock: 5})
+
+	router.PUT("/admin/products/:id", mockAdminAuthMiddleware(), UpdateProduct(db))
+
+	body, contentType := createMultipartForm(t, map[string]string{"name": "New Name"}, "", "")
+	req, _ := http.NewRequest("PUT", "/admin/products/"+productID.String(), body)
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("If-Match", `"1"`)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"2"`, w.Header().Get("ETag"))
+}
+
+func TestUpdateProduct_MergePatchClearsField(t *testing.T) {
+	db := setupTestDB(t)
+	router := setupRouter()
+	productID := uuid.New()
+	db.Create(&config.Product{ID: productID.String(), Name: "Lamp", Price: money.MustParse("10.00"), Stock: 5, Category: "Lighting"})
+
+	router.PATCH("/admin/products/:id", mockAdminAuthMiddleware(), UpdateProduct(db))
+
+	patch := `{"category": null, "price": 12.5}`
+	req, _ := http.NewRequest("PATCH", "/admin/products/"+productID.String(), strings.NewReader(patch))
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+	req.Header.Set("If-Match", `"1"`)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var updatedProduct config.Product
+	db.First(&updatedProduct, "id = ?", productID)
+	assert.Equal(t, "", updatedProduct.Category)
+	assert.Equal(t, money.MustParse("12.50"), updatedProduct.Price)
+	assert.Equal(t, "Lamp", updatedProduct.Name) // Absent keys are untouched
+}
+
+func TestUpdateProduct_MergePatchFieldErrors(t *testing.T) {
+	db := setupTestDB(t)
+	router := setupRouter()
+	productID := uuid.New()
+	db.Create(&config.Product{ID: productID.String(), Name: "Lamp", Price: money.MustParse("10.00"), Stock: 5})
+
+	router.PATCH("/admin/products/:id", mockAdminAuthMiddleware(), UpdateProduct(db))
+
+	patch := `{"price": "-3", "stock": 1.5, "name": null, "image_url": "/uploads/products/../../go.mod"}`
+	req, _ := http.NewRequest("PATCH", "/admin/products/"+productID.String(), strings.NewReader(patch))
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+	req.Header.Set("If-Match", `"1"`)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	var response struct {
+		Errors map[string]string `json:"errors"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	assert.Equal(t, "price must be a valid number greater than 0", response.Errors["price"])
+	assert.Equal(t, "stock must be a valid non-negative integer", response.Errors["stock"])
+	assert.Equal(t, "name cannot be empty", response.Errors["name"])
+	assert.Equal(t, "image_url must be an http(s) URL or an uploaded image", response.Errors["image_url"])
+
+	var unchanged config.Product
+	db.First(&unchanged, "id = ?", productID)
+	assert.Equal(t, money.MustParse("10.00"), unchanged.Price)
+}
+
+func TestUpdateProduct_MergePatchRejectsFractionalCents(t *testing.T) {
+	db := setupTestDB(t)
+	router := setupRouter()
+	productID := uuid.New()
+	db.Create(&config.Product{ID: productID.String(), Name: "Lamp", Price: money.MustParse("10.00"), Stock: 5})
+
+	router.PATCH("/admin/products/:id", mockAdminAuthMiddleware(), UpdateProduct(db))
+
+	req, _ := http.NewRequest("PATCH", "/admin/products/"+productID.String(), strings.NewReader(`{"price": 10.005}`))
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+	req.Header.Set("If-Match", `"1"`)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	req, _ = http.NewRequest("PATCH", "/admin/products/"+productID.String(), strings.NewReader(`{"price": "19.99"}`))
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+	req.Header.Set("If-Match", `"1"`)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"price":"19.99"`)
+}
+
+func TestUpdateProduct_FormRejectsInvalidPrice(t *testing.T) {
+	db := setupTestDB(t)
+	router := setupRouter()
+	productID := uuid.New()
+	db.Create(&config.Product{ID: productID.String(), Name: "Lamp", Price: money.MustParse("10.00"), Stock: 5, Category: "Lighting"})
+
+	router.PUT("/admin/products/:id", mockAdminAuthMiddleware(), UpdateProduct(db))
+
+	body, contentType := createMultipartForm(t, map[string]string{"price": "free", "category": ""}, "", "")
+	req, _ := http.NewRequest("PUT", "/admin/products/"+productID.String(), body)
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("If-Match", `"1"`)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), `"price":"price must be a valid number greater than 0"`)
+}
+
 // --- 3. TestDeleteProduct (Admin Route) ---
 
 func TestDeleteProduct_Success_WithImageDeletion(t *testing.T) {
@@ -239,7 +432,7 @@ func TestDeleteProduct_Success_WithImageDeletion(t *testing.T) {
 	defer os.RemoveAll(utils.UploadDir)
 
 	db.Create(&config.Product{
-		ID: productID.String(), Name: "Delete Me", Price: 1.00, Stock: 1, ImageURL: "/" + imagePath,
+		ID: productID.String(), Name: "Delete Me", Price: money.MustParse("1.00"), Stock: 1, ImageURL: "/" + imagePath,
 	})
 
 	assert.FileExists(t, imagePath, "Precondition: Image file must exist before deletion test.")
@@ -248,6 +441,7 @@ func TestDeleteProduct_Success_WithImageDeletion(t *testing.T) {
 
 	url := fmt.Sprintf("/admin/products/%s", productID.String())
 	req, _ := http.NewRequest("DELETE", url, nil)
+	req.Header.Set("If-Match", `"1"`)
 
 	w := httptest.NewRecorder()
 	router.ServeHTTP(w, req)
@@ -264,19 +458,42 @@ func TestDeleteProduct_Success_WithImageDeletion(t *testing.T) {
 	assert.NoFileExists(t, imagePath, "Image file should be deleted from disk.")
 }
 
+func TestDeleteProduct_KeepsFilesOutsideUploadDir(t *testing.T) {
+	db := setupTestDB(t)
+	router := setupRouter()
+	productID := uuid.New()
+	outside := "outside-" + productID.String() + ".txt"
+	os.WriteFile(outside, []byte("not an upload"), 0644)
+	defer os.Remove(outside)
+
+	db.Create(&config.Product{
+		ID: productID.String(), Name: "Sneaky", Price: money.MustParse("1.00"), Stock: 1, ImageURL: "/" + utils.UploadDir + "/../../" + outside,
+	})
+
+	router.DELETE("/admin/products/:id", mockAdminAuthMiddleware(), DeleteProduct(db))
+	req, _ := http.NewRequest("DELETE", "/admin/products/"+productID.String(), nil)
+	req.Header.Set("If-Match", `"1"`)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.FileExists(t, outside)
+}
+
 func TestDeleteProduct_Success_WithoutImage(t *testing.T) {
 	db := setupTestDB(t)
 	router := setupRouter()
 	// 1. Create a product with no image
 	productID := uuid.New()
 	db.Create(&config.Product{
-		ID: productID.String(), Name: "No Image", Price: 1.00, Stock: 1, ImageURL: "",
+		ID: productID.String(), Name: "No Image", Price: money.MustParse("1.00"), Stock: 1, ImageURL: "",
 	})
 
 	router.DELETE("/admin/products/:id", mockAdminAuthMiddleware(), DeleteProduct(db))
 
 	url := fmt.Sprintf("/admin/products/%s", productID.String())
 	req, _ := http.NewRequest("DELETE", url, nil)
+	req.Header.Set("If-Match", `"1"`)
 
 	w := httptest.NewRecorder()
 	router.ServeHTTP(w, req)
@@ -285,6 +502,26 @@ func TestDeleteProduct_Success_WithoutImage(t *testing.T) {
 	assert.Contains(t, w.Body.String(), "product deleted")
 }
 
+func TestDeleteProduct_StaleIfMatch(t *testing.T) {
+	db := setupTestDB(t)
+	router := setupRouter()
+	productID := uuid.New()
+	db.Create(&config.Product{ID: productID.String(), Name: "Keep Me", Price: money.MustParse("1.00"), Stock: 1, Version: 3})
+
+	router.DELETE("/admin/products/:id", mockAdminAuthMiddleware(), DeleteProduct(db))
+
+	req, _ := http.NewRequest("DELETE", "/admin/products/"+productID.String(), nil)
+	req.Header.Set("If-Match", `"2"`)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+
+	var stillThere config.Product
+	assert.NoError(t, db.First(&stillThere, "id = ?", productID).Error)
+}
+
 // --- 4. TestGetProduct (Public Route) ---
 // (No change needed as logic is unaffected)
 
@@ -294,10 +531,10 @@ func TestGetProduct_Success(t *testing.T) {
 	// 1. Create a product to fetch
 	productID := uuid.New()
 	db.Create(&config.Product{
-		ID: productID.String(), Name: "Fetch Test", Price: 50.00, Stock: 10,
+		ID: productID.String(), Name: "Fetch Test", Price: money.MustParse("50.00"), Stock: 10,
 	})
 
-	router.GET("/products/:id", GetProduct(db))
+	router.GET("/products/:id", GetProduct(db, mockConfig()))
 
 	url := fmt.Sprintf("/products/%s", productID.String())
 	req, _ := http.NewRequest("GET", url, nil)
@@ -310,10 +547,28 @@ func TestGetProduct_Success(t *testing.T) {
 	assert.Contains(t, w.Body.String(), "Fetch Test")
 }
 
+func TestGetProduct_NotModified(t *testing.T) {
+	db := setupTestDB(t)
+	router := setupRouter()
+	productID := uuid.New()
+	db.Create(&config.Product{ID: productID.String(), Name: "Cached", Price: money.MustParse("50.00"), Stock: 10})
+
+	router.GET("/products/:id", GetProduct(db, mockConfig()))
+
+	req, _ := http.NewRequest("GET", "/products/"+productID.String(), nil)
+	req.Header.Set("If-None-Match", `W/"1"`)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Empty(t, w.Body.String())
+}
+
 func TestGetProduct_NotFound(t *testing.T) {
 	db := setupTestDB(t)
 	router := setupRouter()
-	router.GET("/products/:id", GetProduct(db))
+	router.GET("/products/:id", GetProduct(db, mockConfig()))
 
 	nonExistentID := uuid.New().String()
 	url := fmt.Sprintf("/products/%s", nonExistentID)
@@ -333,12 +588,12 @@ func TestListOrSearchProducts_Success(t *testing.T) {
 	db := setupTestDB(t)
 	router := setupRouter()
 	cacheL := cache.New(cache.NoExpiration, cache.NoExpiration)
-	router.GET("/products", ListOrSearchProducts(db, cacheL))
+	router.GET("/products", ListOrSearchProducts(db, mockConfig(), cacheL))
 
 	// 1. Create multiple products for testing pagination/search
-	db.Create(&config.Product{ID: uuid.New().String(), Name: "Apple iPad", Price: 500, Stock: 10})
-	db.Create(&config.Product{ID: uuid.New().String(), Name: "Samsung Galaxy", Price: 700, Stock: 20})
-	db.Create(&config.Product{ID: uuid.New().String(), Name: "Apple Watch", Price: 250, Stock: 5})
+	db.Create(&config.Product{ID: uuid.New().String(), Name: "Apple iPad", Price: money.MustParse("500.00"), Stock: 10})
+	db.Create(&config.Product{ID: uuid.New().String(), Name: "Samsung Galaxy", Price: money.MustParse("700.00"), Stock: 20})
+	db.Create(&config.Product{ID: uuid.New().String(), Name: "Apple Watch", Price: money.MustParse("250.00"), Stock: 5})
 
 	req, _ := http.NewRequest("GET", "/products", nil)
 	w := httptest.NewRecorder()
@@ -363,12 +618,12 @@ func TestListOrSearchProducts_Search(t *testing.T) {
 	db := setupTestDB(t)
 	router := setupRouter()
 	cacheL := cache.New(cache.NoExpiration, cache.NoExpiration)
-	router.GET("/products", ListOrSearchProducts(db, cacheL))
+	router.GET("/products", ListOrSearchProducts(db, mockConfig(), cacheL))
 
 	// 1. Create products
-	db.Create(&config.Product{ID: uuid.New().String(), Name: "Blue Shirt", Price: 10, Stock: 1})
-	db.Create(&config.Product{ID: uuid.New().String(), Name: "Red Dress", Price: 20, Stock: 1})
-	db.Create(&config.Product{ID: uuid.New().String(), Name: "Blue Jeans", Price: 30, Stock: 1})
+	db.Create(&config.Product{ID: uuid.New().String(), Name: "Blue Shirt", Price: money.MustParse("10.00"), Stock: 1})
+	db.Create(&config.Product{ID: uuid.New().String(), Name: "Red Dress", Price: money.MustParse("20.00"), Stock: 1})
+	db.Create(&config.Product{ID: uuid.New().String(), Name: "Blue Jeans", Price: money.MustParse("30.00"), Stock: 1})
 
 	// Search for "blue" (case-insensitive)
 	req, _ := http.NewRequest("GET", "/products?search=blue", nil)
@@ -391,12 +646,12 @@ func TestListOrSearchProducts_Pagination(t *testing.T) {
 	db := setupTestDB(t)
 	router := setupRouter()
 	cacheL := cache.New(cache.NoExpiration, cache.NoExpiration)
-	router.GET("/products", ListOrSearchProducts(db, cacheL))
+	router.GET("/products", ListOrSearchProducts(db, mockConfig(), cacheL))
 
 	// 1. Create 5 products
 	for i := 1; i <= 5; i++ {
 		db.Create(&config.Product{
-			ID: uuid.New().String(), Name: fmt.Sprintf("Item %d", i), Price: float64(i), Stock: 1,
+			ID: uuid.New().String(), Name: fmt.Sprintf("Item %d", i), Price: money.Amount(i * 100), Stock: 1,
 		})
 	}
 
@@ -425,3 +680,31 @@ func TestListOrSearchProducts_Pagination(t *testing.T) {
 
 	assert.Len(t, products, 2)
 }
+
+func TestListOrSearchProducts_OnSaleFilter(t *testing.T) {
+	db := setupTestDB(t)
+	router := setupRouter()
+	cacheL := cache.New(cache.NoExpiration, cache.NoExpiration)
+	router.GET("/products", ListOrSearchProducts(db, mockConfig(), cacheL))
+
+	was := money.MustParse("80.00")
+	db.Create(&config.Product{ID: uuid.New().String(), Name: "Sale Sneakers", Price: money.MustParse("60.00"), CompareAtPrice: &was, Stock: 1})
+	db.Create(&config.Product{ID: uuid.New().String(), Name: "Full Price Boots", Price: money.MustParse("90.00"), Stock: 1})
+
+	req, _ := http.NewRequest("GET", "/products?on_sale=true", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	object := response["object"].(map[string]interface{})
+	products := object["products"].([]interface{})
+	assert.Len(t, products, 1)
+
+	product := products[0].(map[string]interface{})
+	assert.Equal(t, "Sale Sneakers", product["name"])
+	assert.Equal(t, true, product["on_sale"])
+	assert.Equal(t, float64(25), product["discount_percent"])
+}
@@ -0,0 +1,226 @@
+package controllers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"kalebecommerce/config"
+	"kalebecommerce/money"
+	"kalebecommerce/utils"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// exportFlushEvery controls how many rows are written between flushes to the client.
+const exportFlushEvery = 500
+
+// productExporter writes a catalog export one product at a time.
+type productExporter interface {
+	Begin() error
+	Write(p config.Product) error
+	End() error
+}
+
+// ExportProducts (Admin) - streams the catalog as CSV, NDJSON or a Google Merchant feed
+func ExportProducts(db *gorm.DB, cfg *config.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		format := strings.ToLower(c.DefaultQuery("format", "csv"))
+
+		var exporter productExporter
+		var contentType, ext string
+		switch format {
+		case "csv":
+			exporter, contentType, ext = &csvProductExporter{w: csv.NewWriter(c.Writer)}, "text/csv", "csv"
+		case "ndjson":
+			exporter, contentType, ext = &ndjsonProductExporter{enc: json.NewEncoder(c.Writer)}, "application/x-ndjson", "ndjson"
+		case "merchant_xml":
+			exporter, contentType, ext = &merchantXMLExporter{w: c.Writer, cfg: cfg}, "application/xml", "xml"
+		case "merchant_tsv":
+			exporter, contentType, ext = &merchantTSVExporter{w: c.Writer, cfg: cfg}, "text/tab-separated-values", "tsv"
+		default:
+			utils.JSON(c, http.StatusBadRequest, false, "unsupported format", nil, "format must be csv, ndjson, merchant_xml or merchant_tsv")
+			return
+		}
+
+		rows, err := filterProducts(c, db.Model(&config.Product{})).Order("created_at, id").Rows()
+		if err != nil {
+			utils.JSON(c, http.StatusInternalServerError, false, "export failed", nil, err.Error())
+			return
+		}
+		defer rows.Close()
+
+		c.Header("Content-Type", contentType)
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="products.%s"`, ext))
+		c.Status(http.StatusOK)
+
+		// Headers are already sent, so errors past this point can only end the stream
+		if err := exporter.Begin(); err != nil {
+			return
+		}
+		for n := 1; rows.Next(); n++ {
+			var p config.Product
+			if err := db.ScanRows(rows, &p); err != nil {
+				return
+			}
+			if err := exporter.Write(p); err != nil {
+				return
+			}
+			if n%exportFlushEvery == 0 {
+				c.Writer.Flush()
+			}
+		}
+		exporter.End()
+		c.Writer.Flush()
+	}
+}
+
+// csvProductExporter writes one CSV record per product using the import column layout.
+type csvProductExporter struct {
+	w *csv.Writer
+}
+
+func (e *csvProductExporter) Begin() error {
+	return e.w.Write(importColumns)
+}
+
+func (e *csvProductExporter) Write(p config.Product) error {
+	sku := ""
+	if p.SKU != nil {
+		sku = *p.SKU
+	}
+	compareAt := ""
+	if p.CompareAtPrice != nil {
+		compareAt = p.CompareAtPrice.String()
+	}
+	return e.w.Write([]string{
+		p.ID, sku, p.Name, p.Description,
+		p.Price.String(), compareAt, strconv.Itoa(p.Stock),
+		p.Category, p.ImageURL, p.TaxClass,
+		strconv.Itoa(p.WeightGrams), strconv.Itoa(p.LengthMM), strconv.Itoa(p.WidthMM), strconv.Itoa(p.HeightMM),
+		strconv.FormatBool(p.GiftCard),
+	})
+}
+
+func (e *csvProductExporter) End() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// ndjsonProductExporter writes the product JSON representation one object per line.
+type ndjsonProductExporter struct {
+	enc *json.Encoder
+}
+
+func (e *ndjsonProductExporter) Begin() error { return nil }
+
+func (e *ndjsonProductExporter) Write(p config.Product) error { return e.enc.Encode(p) }
+
+func (e *ndjsonProductExporter) End() error { return nil }
+
+// merchantItem is a product in the Google Merchant feed vocabulary.
+type merchantItem struct {
+	XMLName      xml.Name `xml:"item"`
+	ID           string   `xml:"g:id"`
+	Title        string   `xml:"g:title"`
+	Description  string   `xml:"g:description"`
+	Link         string   `xml:"g:link"`
+	ImageLink    string   `xml:"g:image_link,omitempty"`
+	Availability string   `xml:"g:availability"`
+	Price        string   `xml:"g:price"`
+	SalePrice    string   `xml:"g:sale_price,omitempty"`
+	MPN          string   `xml:"g:mpn,omitempty"`
+	ProductType  string   `xml:"g:product_type,omitempty"`
+	Condition    string   `xml:"g:condition"`
+}
+
+// newMerchantItem maps a product onto the merchant feed attributes.
+func newMerchantItem(p config.Product, cfg *config.Config) merchantItem {
+	baseURL := strings.TrimRight(cfg.StoreURL, "/")
+	item := merchantItem{
+		ID:           p.ID,
+		Title:        p.Name,
+		Description:  p.Description,
+		Link:         baseURL + "/products/" + p.ID,
+		Availability: "out_of_stock",
+		Price:        money.New(p.Price, cfg.Currency).String(),
+		ProductType:  p.Category,
+		Condition:    "new",
+	}
+	// On sale: the feed price is the regular (compare-at) price and the current price is the sale price
+	if p.OnSale() {
+		item.Price = money.New(*p.CompareAtPrice, cfg.Currency).String()
+		item.SalePrice = money.New(p.Price, cfg.Currency).String()
+	}
+	if p.ImageURL != "" {
+		item.ImageLink = baseURL + p.ImageURL
+	}
+	if p.Stock > 0 {
+		item.Availability = "in_stock"
+	}
+	if p.SKU != nil {
+		item.MPN = *p.SKU
+	}
+	return item
+}
+
+// merchantXMLExporter writes an RSS 2.0 feed with the Google "g:" namespace.
+type merchantXMLExporter struct {
+	w   io.Writer
+	cfg *config.Config
+	enc *xml.Encoder
+}
+
+func (e *merchantXMLExporter) Begin() error {
+	_, err := fmt.Fprint(e.w, xml.Header+`<rss version="2.0" xmlns:g="http://base.google.com/ns/1.0"><channel>`)
+	if err != nil {
+		return err
+	}
+	e.enc = xml.NewEncoder(e.w)
+	return e.enc.Encode(struct {
+		XMLName xml.Name `xml:"title"`
+		Value   string   `xml:",chardata"`
+	}{Value: e.cfg.StoreName})
+}
+
+func (e *merchantXMLExporter) Write(p config.Product) error {
+	return e.enc.Encode(newMerchantItem(p, e.cfg))
+}
+
+func (e *merchantXMLExporter) End() error {
+	_, err := fmt.Fprint(e.w, "</channel></rss>\n")
+	return err
+}
+
+// merchantTSVExporter writes the tab-separated flavour of the merchant feed.
+type merchantTSVExporter struct {
+	w   io.Writer
+	cfg *config.Config
+}
+
+var merchantTSVReplacer = strings.NewReplacer("\t", " ", "\r", " ", "\n", " ")
+
+func (e *merchantTSVExporter) writeLine(fields ...string) error {
+	for i, f := range fields {
+		fields[i] = merchantTSVReplacer.Replace(f)
+	}
+	_, err := fmt.Fprintln(e.w, strings.Join(fields, "\t"))
+	return err
+}
+
+func (e *merchantTSVExporter) Begin() error {
+	return e.writeLine("id", "title", "description", "link", "image_link", "availability", "price", "sale_price", "mpn", "product_type", "condition")
+}
+
+func (e *merchantTSVExporter) Write(p config.Product) error {
+	item := newMerchantItem(p, e.cfg)
+	return e.writeLine(item.ID, item.Title, item.Description, item.Link, item.ImageLink,
+		item.Availability, item.Price, item.SalePrice, item.MPN, item.ProductType, item.Condition)
+}
+
+func (e *merchantTSVExporter) End() error { return nil }
@@ -0,0 +1,91 @@
+package controllers
+
+import (
+	"encoding/json"
+	"kalebecommerce/config"
+	"kalebecommerce/money"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExportProducts_CSVWithSearchFilter(t *testing.T) {
+	db := setupTestDB(t)
+	router := setupRouter()
+	router.GET("/admin/products/export", mockAdminAuthMiddleware(), ExportProducts(db, mockConfig()))
+
+	db.Create(&config.Product{ID: uuid.New().String(), Name: "Blue Shirt", Price: money.MustParse("10.00"), Stock: 1})
+	db.Create(&config.Product{ID: uuid.New().String(), Name: "Red Dress", Price: money.MustParse("20.00"), Stock: 1})
+
+	req, _ := http.NewRequest("GET", "/admin/products/export?format=csv&search=blue", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/csv", w.Header().Get("Content-Type"))
+
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	assert.Len(t, lines, 2) // header + Blue Shirt
+	assert.Equal(t, strings.Join(importColumns, ","), lines[0])
+	assert.Contains(t, lines[1], "Blue Shirt")
+}
+
+func TestExportProducts_NDJSON(t *testing.T) {
+	db := setupTestDB(t)
+	router := setupRouter()
+	router.GET("/admin/products/export", mockAdminAuthMiddleware(), ExportProducts(db, mockConfig()))
+
+	for _, name := range []string{"Item A", "Item B", "Item C"} {
+		db.Create(&config.Product{ID: uuid.New().String(), Name: name, Price: money.MustParse("5.00"), Stock: 1})
+	}
+
+	req, _ := http.NewRequest("GET", "/admin/products/export?format=ndjson", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	assert.Len(t, lines, 3)
+
+	var p config.Product
+	assert.NoError(t, json.Unmarshal([]byte(lines[0]), &p))
+	assert.Equal(t, "Item A", p.Name)
+}
+
+func TestExportProducts_MerchantXML(t *testing.T) {
+	db := setupTestDB(t)
+	router := setupRouter()
+	cfg := &config.Config{StoreName: "Test Store", StoreURL: "https://shop.test", Currency: "ETB"}
+	router.GET("/admin/products/export", mockAdminAuthMiddleware(), ExportProducts(db, cfg))
+
+	productID := uuid.New().String()
+	db.Create(&config.Product{ID: productID, Name: "Coffee & Beans", Price: money.MustParse("12.50"), Stock: 0})
+
+	req, _ := http.NewRequest("GET", "/admin/products/export?format=merchant_xml", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	body := w.Body.String()
+	assert.Contains(t, body, `xmlns:g="http://base.google.com/ns/1.0"`)
+	assert.Contains(t, body, "<g:title>Coffee &amp; Beans</g:title>")
+	assert.Contains(t, body, "<g:link>https://shop.test/products/"+productID+"</g:link>")
+	assert.Contains(t, body, "<g:price>12.50 ETB</g:price>")
+	assert.Contains(t, body, "<g:availability>out_of_stock</g:availability>")
+}
+
+func TestExportProducts_UnsupportedFormat(t *testing.T) {
+	db := setupTestDB(t)
+	router := setupRouter()
+	router.GET("/admin/products/export", mockAdminAuthMiddleware(), ExportProducts(db, mockConfig()))
+
+	req, _ := http.NewRequest("GET", "/admin/products/export?format=xlsx", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
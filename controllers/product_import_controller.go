@@ -0,0 +1,471 @@
+package controllers
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"kalebecommerce/config"
+	"kalebecommerce/money"
+	"kalebecommerce/utils"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// importBackgroundThreshold is the upload size above which an import runs as a background job.
+const importBackgroundThreshold = 1 << 20 // 1 MiB
+
+// importColumns are the product fields understood by the importer (CSV headers / NDJSON keys).
+var importColumns = []string{"id", "sku", "name", "description", "price", "compare_at_price", "stock", "category", "image_url", "tax_class",
+	"weight_grams", "length_mm", "width_mm", "height_mm", "gift_card"}
+
+type importRowError struct {
+	Row    int      `json:"row"`
+	ID     string   `json:"id,omitempty"`
+	SKU    string   `json:"sku,omitempty"`
+	Errors []string `json:"errors"`
+}
+
+type importReport struct {
+	DryRun    bool             `json:"dry_run"`
+	TotalRows int              `json:"total_rows"`
+	Created   int              `json:"created"`
+	Updated   int              `json:"updated"`
+	Failed    int              `json:"failed"`
+	Errors    []importRowError `json:"errors"`
+}
+
+// ImportProducts (Admin) - bulk upsert products from a CSV or NDJSON upload
+func ImportProducts(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		file, err := c.FormFile("file")
+		if err != nil {
+			utils.JSON(c, http.StatusBadRequest, false, "file error", nil, "an import file is required in the 'file' field")
+			return
+		}
+
+		format := importFormat(c.Query("format"), file.Filename)
+		if format == "" {
+			utils.JSON(c, http.StatusBadRequest, false, "unsupported format", nil, "format must be csv or ndjson")
+			return
+		}
+
+		job := config.ImportJob{
+			ID:       uuid.New().String(),
+			Filename: file.Filename,
+			Format:   format,
+			DryRun:   c.Query("dry_run") == "true",
+			Status:   "running",
+			UserID:   currentUserID(c),
+		}
+
+		// Large files are copied out of the request and processed in the background
+		if file.Size > importBackgroundThreshold || c.Query("async") == "true" {
+			path, err := spoolImportFile(file)
+			if err != nil {
+				utils.JSON(c, http.StatusInternalServerError, false, "failed to store import file", nil, err.Error())
+				return
+			}
+			job.Status = "queued"
+			if err := db.Create(&job).Error; err != nil {
+				os.Remove(path)
+				utils.JSON(c, http.StatusInternalServerError, false, "failed to create import job", nil, err.Error())
+				return
+			}
+			go runImportJob(db, job, path)
+			utils.JSON(c, http.StatusAccepted, true, "import queued", job, nil)
+			return
+		}
+
+		src, err := file.Open()
+		if err != nil {
+			utils.JSON(c, http.StatusBadRequest, false, "file error", nil, err.Error())
+			return
+		}
+		defer src.Close()
+
+		report, err := importProducts(db, src, format, job.DryRun, job.UserID)
+		if err != nil {
+			utils.JSON(c, http.StatusBadRequest, false, "import failed", nil, err.Error())
+			return
+		}
+
+		finishImportJob(db, &job, report, nil)
+		msg := "import completed"
+		if job.DryRun {
+			msg = "dry run completed"
+		}
+		utils.JSON(c, http.StatusOK, true, msg, gin.H{"job": job, "report": report}, nil)
+	}
+}
+
+// GetImportJob (Admin) - status and error report of an import
+func GetImportJob(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var job config.ImportJob
+		if err := db.First(&job, "id = ?", c.Param("id")).Error; err != nil {
+			utils.JSON(c, http.StatusNotFound, false, "import job not found", nil, nil)
+			return
+		}
+
+		var rowErrors []importRowError
+		if job.Errors != "" {
+			if err := json.Unmarshal([]byte(job.Errors), &rowErrors); err != nil {
+				utils.JSON(c, http.StatusInternalServerError, false, "failed to read import report", nil, err.Error())
+				return
+			}
+		}
+		utils.JSON(c, http.StatusOK, true, "import job retrieved", gin.H{"job": job, "errors": rowErrors}, nil)
+	}
+}
+
+// importFormat resolves the import format from the query string or the file extension.
+func importFormat(requested, filename string) string {
+	format := strings.ToLower(requested)
+	if format == "" {
+		format = strings.TrimPrefix(strings.ToLower(filepath.Ext(filename)), ".")
+	}
+	switch format {
+	case "csv":
+		return "csv"
+	case "ndjson", "jsonl":
+		return "ndjson"
+	}
+	return ""
+}
+
+// spoolImportFile copies an upload to a temp file that outlives the request.
+func spoolImportFile(file *multipart.FileHeader) (string, error) {
+	src, err := file.Open()
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+
+	dst, err := os.CreateTemp("", "product-import-*")
+	if err != nil {
+		return "", err
+	}
+	defer dst.Close()
+
+	if _, err := io.Copy(dst, src); err != nil {
+		os.Remove(dst.Name())
+		return "", err
+	}
+	return dst.Name(), nil
+}
+
+// runImportJob processes a spooled import file and records the outcome on the job.
+func runImportJob(db *gorm.DB, job config.ImportJob, path string) {
+	defer os.Remove(path)
+
+	db.Model(&job).Update("status", "running")
+
+	f, err := os.Open(path)
+	if err != nil {
+		finishImportJob(db, &job, nil, err)
+		return
+	}
+	defer f.Close()
+
+	report, err := importProducts(db, f, job.Format, job.DryRun, job.UserID)
+	finishImportJob(db, &job, report, err)
+}
+
+// finishImportJob persists the final counters and row errors of an import.
+func finishImportJob(db *gorm.DB, job *config.ImportJob, report *importReport, importErr error) {
+	now := time.Now()
+	job.FinishedAt = &now
+	job.Status = "completed"
+
+	if importErr != nil {
+		job.Status = "failed"
+		job.Errors = fmt.Sprintf(`[{"row":0,"errors":[%q]}]`, importErr.Error())
+	} else {
+		job.TotalRows = report.TotalRows
+		job.Created = report.Created
+		job.Updated = report.Updated
+		job.Failed = report.Failed
+		if len(report.Errors) > 0 {
+			encoded, _ := json.Marshal(report.Errors)
+			job.Errors = string(encoded)
+		}
+	}
+
+	if err := db.Save(job).Error; err != nil {
+		log.Printf("failed to save import job %s: %v", job.ID, err)
+	}
+}
+
+// importProducts streams rows from r and upserts them one by one.
+// A failing row is reported and skipped; it never aborts the rest of the file.
+// A SKU may appear only once per file, so later rows can't silently overwrite earlier ones.
+func importProducts(db *gorm.DB, r io.Reader, format string, dryRun bool, actor *uuid.UUID) (*importReport, error) {
+	report := &importReport{DryRun: dryRun, Errors: []importRowError{}}
+	skuRows := map[string]int{}
+
+	handle := func(rowNum int, row map[string]string, rowErr error) {
+		report.TotalRows++
+		var action string
+		var errs []string
+		first, repeated := skuRows[row["sku"]]
+		switch {
+		case rowErr != nil:
+			errs = []string{rowErr.Error()}
+		case repeated:
+			errs = []string{fmt.Sprintf("sku %s is already used by row %d of this file", row["sku"], first)}
+		default:
+			if row["sku"] != "" {
+				skuRows[row["sku"]] = rowNum
+			}
+			action, errs = importProductRow(db, row, dryRun, actor)
+		}
+
+		switch {
+		case len(errs) > 0:
+			report.Failed++
+			report.Errors = append(report.Errors, importRowError{Row: rowNum, ID: row["id"], SKU: row["sku"], Errors: errs})
+		case action == "created":
+			report.Created++
+		case action == "updated":
+			report.Updated++
+		}
+	}
+
+	var err error
+	if format == "csv" {
+		err = readCSVRows(r, handle)
+	} else {
+		err = readNDJSONRows(r, handle)
+	}
+	return report, err
+}
+
+// readCSVRows reads a CSV file with a header line and hands each record over as a column map.
+func readCSVRows(r io.Reader, handle func(int, map[string]string, error)) error {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return errors.New("csv file must start with a header row")
+	}
+	for i := range header {
+		header[i] = strings.ToLower(strings.TrimSpace(header[i]))
+	}
+
+	for rowNum := 1; ; rowNum++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		row := map[string]string{}
+		if err != nil {
+			handle(rowNum, row, fmt.Errorf("malformed csv row: %v", err))
+			continue
+		}
+		for i, value := range record {
+			if i < len(header) {
+				row[header[i]] = strings.TrimSpace(value)
+			}
+		}
+		handle(rowNum, row, nil)
+	}
+}
+
+// readNDJSONRows reads one JSON object per line and hands each over as a column map.
+func readNDJSONRows(r io.Reader, handle func(int, map[string]string, error)) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+
+	rowNum := 0
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		rowNum++
+
+		var obj map[string]interface{}
+		decoder := json.NewDecoder(strings.NewReader(line))
+		decoder.UseNumber()
+		row := map[string]string{}
+		if err := decoder.Decode(&obj); err != nil {
+			handle(rowNum, row, fmt.Errorf("invalid JSON: %v", err))
+			continue
+		}
+		for _, key := range importColumns {
+			if value, ok := obj[key]; ok && value != nil {
+				row[key] = strings.TrimSpace(fmt.Sprint(value))
+			}
+		}
+		handle(rowNum, row, nil)
+	}
+	return scanner.Err()
+}
+
+// importProductRow validates a single row and creates or updates the matching product.
+// Rows are matched by id first, then by sku. It returns "created" or "updated".
+func importProductRow(db *gorm.DB, row map[string]string, dryRun bool, actor *uuid.UUID) (string, []string) {
+	var errs []string
+
+	var existing config.Product
+	found := false
+	if id := row["id"]; id != "" {
+		if _, err := uuid.Parse(id); err != nil {
+			return "", []string{"id must be a valid UUID"}
+		}
+		found = db.First(&existing, "id = ?", id).Error == nil
+	} else if sku := row["sku"]; sku != "" {
+		found = db.First(&existing, "sku = ?", sku).Error == nil
+	}
+
+	updates := make(map[string]interface{})
+	for _, key := range []string{"name", "description", "category", "image_url"} {
+		if value := row[key]; value != "" {
+			updates[key] = value
+		}
+	}
+	// Uploaded images are removed with their product, so nothing outside the upload directory
+	if imageURL := row["image_url"]; imageURL != "" && !utils.ValidImageURL(imageURL) {
+		errs = append(errs, "image_url must be an http(s) URL or an uploaded image")
+	}
+	if sku := row["sku"]; sku != "" {
+		var clash int64
+		db.Model(&config.Product{}).Where("sku = ? AND id <> ?", sku, existing.ID).Count(&clash)
+		if clash > 0 {
+			errs = append(errs, "sku is already used by another product")
+		}
+		updates["sku"] = sku
+	}
+	if raw := row["price"]; raw != "" {
+		if price, err := parseProductPrice(raw); err != nil {
+			errs = append(errs, err.Error())
+		} else {
+			updates["price"] = price
+		}
+	}
+	if raw := row["compare_at_price"]; raw != "" {
+		if compareAt, err := parseCompareAtPrice(raw); err != nil {
+			errs = append(errs, err.Error())
+		} else {
+			updates["compare_at_price"] = compareAt
+		}
+	}
+	if _, priced := updates["price"]; found || priced {
+		if err := validateCompareAtPrice(resultingCompareAt(existing, updates), resultingPrice(existing, updates)); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if raw := row["stock"]; raw != "" {
+		if stock, err := parseProductStock(raw); err != nil {
+			errs = append(errs, err.Error())
+		} else {
+			updates["stock"] = stock
+		}
+	}
+
+	if raw := row["tax_class"]; raw != "" {
+		if class, err := parseTaxClass(raw); err != nil {
+			errs = append(errs, err.Error())
+		} else {
+			updates["tax_class"] = class
+		}
+	}
+	if raw := row["gift_card"]; raw != "" {
+		if flag, err := parseGiftCardFlag(raw); err != nil {
+			errs = append(errs, err.Error())
+		} else {
+			updates["gift_card"] = flag
+		}
+	}
+	for _, key := range productMeasureFields {
+		if raw := row[key]; raw != "" {
+			if n, err := parseProductMeasure(key, raw); err != nil {
+				errs = append(errs, err.Error())
+			} else {
+				updates[key] = n
+			}
+		}
+	}
+
+	if !found {
+		// New products need the same fields CreateProduct requires
+		for _, key := range []string{"name", "description", "price", "stock"} {
+			if row[key] == "" {
+				errs = append(errs, key+" is required")
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return "", errs
+	}
+
+	if found {
+		if !dryRun {
+			updates["version"] = gorm.Expr("version + 1")
+			oldPrice := existing.Price
+			err := db.Transaction(func(tx *gorm.DB) error {
+				if err := tx.Model(&existing).Updates(updates).Error; err != nil {
+					return err
+				}
+				if price, ok := updates["price"].(money.Amount); ok && price != oldPrice {
+					return recordPriceChange(tx, existing.ID, oldPrice, price, "import", actor, nil)
+				}
+				return nil
+			})
+			if err != nil {
+				return "", []string{err.Error()}
+			}
+		}
+		return "updated", nil
+	}
+
+	p := config.Product{
+		ID:          row["id"],
+		SKU:         optionalSKU(row["sku"]),
+		Name:        row["name"],
+		Description: row["description"],
+		Category:    row["category"],
+		ImageURL:    row["image_url"],
+		Price:       updates["price"].(money.Amount),
+		Stock:       updates["stock"].(int),
+		TaxClass:    config.TaxClassStandard,
+	}
+	if class, ok := updates["tax_class"].(string); ok {
+		p.TaxClass = class
+	}
+	if flag, ok := updates["gift_card"].(bool); ok {
+		p.GiftCard = flag
+	}
+	for _, key := range productMeasureFields {
+		if n, ok := updates[key].(int); ok {
+			setProductMeasure(&p, key, n)
+		}
+	}
+	if compareAt, ok := updates["compare_at_price"].(*money.Amount); ok {
+		p.CompareAtPrice = compareAt
+	}
+	if p.ID == "" {
+		p.ID = uuid.New().String()
+	}
+	if !dryRun {
+		if err := db.Create(&p).Error; err != nil {
+			return "", []string{err.Error()}
+		}
+	}
+	return "created", nil
+}
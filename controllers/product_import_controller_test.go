@@ -0,0 +1,122 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"kalebecommerce/config"
+	"kalebecommerce/money"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// createImportUpload builds a multipart body carrying an import file with the given content.
+func createImportUpload(t *testing.T, filename, content string) (*bytes.Buffer, string) {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+
+	part, err := writer.CreateFormFile("file", filename)
+	assert.NoError(t, err)
+	_, err = io.WriteString(part, content)
+	assert.NoError(t, err)
+	assert.NoError(t, writer.Close())
+
+	return body, writer.FormDataContentType()
+}
+
+func TestImportProducts_CSVCreatesAndUpdatesBySKU(t *testing.T) {
+	db := setupTestDB(t)
+	router := setupRouter()
+	router.POST("/admin/products/import", mockAdminAuthMiddleware(), ImportProducts(db))
+
+	sku := "SHIRT-1"
+	db.Create(&config.Product{ID: uuid.New().String(), SKU: &sku, Name: "Old Shirt", Price: money.MustParse("10.00"), Stock: 1})
+
+	csvData := "sku,name,description,price,stock,category\n" +
+		"SHIRT-1,Blue Shirt,,15.50,20,\n" +
+		"MUG-1,Coffee Mug,Ceramic mug,8,100,Kitchen\n"
+	body, contentType := createImportUpload(t, "products.csv", csvData)
+
+	req, _ := http.NewRequest("POST", "/admin/products/import", body)
+	req.Header.Set("Content-Type", contentType)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "import completed")
+
+	var updated config.Product
+	db.First(&updated, "sku = ?", "SHIRT-1")
+	assert.Equal(t, "Blue Shirt", updated.Name)
+	assert.Equal(t, money.MustParse("15.50"), updated.Price)
+	assert.Equal(t, 20, updated.Stock)
+
+	var created config.Product
+	assert.NoError(t, db.First(&created, "sku = ?", "MUG-1").Error)
+	assert.Equal(t, "Kitchen", created.Category)
+}
+
+func TestImportProducts_DryRunReportsRowErrors(t *testing.T) {
+	db := setupTestDB(t)
+	router := setupRouter()
+	router.POST("/admin/products/import", mockAdminAuthMiddleware(), ImportProducts(db))
+
+	ndjson := `{"sku":"LAMP-1","name":"Lamp","description":"Desk lamp","price":"25","stock":3}` + "\n" +
+		`{"sku":"LAMP-2","name":"Broken","description":"Bad price","price":"-4","stock":"x"}` + "\n" +
+		`{"sku":"LAMP-1","name":"Lamp again","description":"Same SKU","price":"30","stock":1}` + "\n" +
+		`{"sku":"LAMP-3","name":"Sneaky","description":"Bad image","price":"30","stock":1,"image_url":"/uploads/products/../../go.mod"}` + "\n" +
+		`{"sku":"LAMP-4","name":"Hosted","description":"CDN image","price":"30","stock":1,"image_url":"https://cdn.example.com/lamp.jpg"}` + "\n"
+	body, contentType := createImportUpload(t, "products.ndjson", ndjson)
+
+	req, _ := http.NewRequest("POST", "/admin/products/import?dry_run=true", body)
+	req.Header.Set("Content-Type", contentType)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "dry run completed")
+
+	var response struct {
+		Object struct {
+			Report importReport `json:"report"`
+		} `json:"object"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	report := response.Object.Report
+	assert.Equal(t, 5, report.TotalRows)
+	assert.Equal(t, 2, report.Created)
+	assert.Equal(t, 3, report.Failed)
+	assert.Equal(t, 2, report.Errors[0].Row)
+	assert.Contains(t, report.Errors[0].Errors, "price must be a valid number greater than 0")
+	assert.Contains(t, report.Errors[0].Errors, "stock must be a valid non-negative integer")
+	assert.Equal(t, 3, report.Errors[1].Row)
+	assert.Equal(t, []string{"sku LAMP-1 is already used by row 1 of this file"}, report.Errors[1].Errors)
+	assert.Equal(t, 4, report.Errors[2].Row)
+	assert.Equal(t, []string{"image_url must be an http(s) URL or an uploaded image"}, report.Errors[2].Errors)
+
+	// Nothing is written during a dry run
+	var count int64
+	db.Model(&config.Product{}).Count(&count)
+	assert.Equal(t, int64(0), count)
+}
+
+func TestImportProducts_UnsupportedFormat(t *testing.T) {
+	db := setupTestDB(t)
+	router := setupRouter()
+	router.POST("/admin/products/import", mockAdminAuthMiddleware(), ImportProducts(db))
+
+	body, contentType := createImportUpload(t, "products.xlsx", "whatever")
+
+	req, _ := http.NewRequest("POST", "/admin/products/import", body)
+	req.Header.Set("Content-Type", contentType)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "unsupported format")
+}
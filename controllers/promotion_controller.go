@@ -0,0 +1,424 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"kalebecommerce/config"
+	"kalebecommerce/money"
+	"kalebecommerce/tax"
+	"kalebecommerce/utils"
+	"math/big"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var (
+	// errInvalidCoupon is returned when an order's coupon code can't be used.
+	errInvalidCoupon = errors.New("invalid coupon")
+	// errPromotionUsedUp is returned when a promotion reached one of its usage limits.
+	errPromotionUsedUp = errors.New("promotion usage limit reached")
+	// errPromotionCodeExists is returned when another promotion has the same coupon code.
+	errPromotionCodeExists = errors.New("a promotion with this code already exists")
+)
+
+var couponCodePattern = regexp.MustCompile(`^[A-Z0-9_-]{3,32}$`)
+
+// normalizeCouponCode makes coupon codes case-insensitive.
+func normalizeCouponCode(raw string) string {
+	return strings.ToUpper(strings.TrimSpace(raw))
+}
+
+// checkoutInput is how a logged-in customer ships an order, which coupon
+// they redeem and how much they pay with gift cards and store credit.
+type checkoutInput struct {
+	shippingInput
+	tenderRequest
+	CouponCode string `json:"coupon_code"`
+}
+
+// promotionMatches reports whether a product is in a promotion's scope. Gift
+// cards are never discounted.
+func promotionMatches(promo config.Promotion, p config.Product) bool {
+	if p.GiftCard {
+		return false
+	}
+	if len(promo.ProductIDs) == 0 && len(promo.Categories) == 0 {
+		return true
+	}
+	return promo.ProductIDs.Contains(p.ID) || (p.Category != "" && promo.Categories.Contains(p.Category))
+}
+
+// claimPromotion counts one use of promo by order. The global counter is
+// bumped with a conditional UPDATE, which also locks the promotion row, so
+// concurrent orders can't overshoot the limit and the per-customer count that
+// follows sees every committed redemption. Only account orders reach the
+// per-customer count; applyPromotions keeps guests away from such promotions.
+func claimPromotion(tx *gorm.DB, order *config.Order, promo config.Promotion) error {
+	res := tx.Model(&config.Promotion{}).
+		Where("id = ? AND (usage_limit IS NULL OR times_used < usage_limit)", promo.ID).
+		Update("times_used", gorm.Expr("times_used + 1"))
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return errPromotionUsedUp
+	}
+
+	if promo.PerUserLimit != nil && order.UserID != nil {
+		var used int64
+		err := tx.Model(&config.PromotionRedemption{}).
+			Where("promotion_id = ? AND user_id = ?", promo.ID, *order.UserID).Count(&used).Error
+		if err != nil {
+			return err
+		}
+		if used >= int64(*promo.PerUserLimit) {
+			if err := tx.Model(&config.Promotion{}).Where("id = ?", promo.ID).
+				Update("times_used", gorm.Expr("times_used - 1")).Error; err != nil {
+				return err
+			}
+			return errPromotionUsedUp
+		}
+	}
+
+	return tx.Create(&config.PromotionRedemption{
+		ID:          uuid.New().String(),
+		PromotionID: promo.ID,
+		OrderID:     order.ID,
+		UserID:      order.UserID,
+		GuestEmail:  order.GuestEmail,
+	}).Error
+}
+
+// releasePromotions gives back the uses of an order that was cancelled or
+// failed, so the customer can try again.
+func releasePromotions(tx *gorm.DB, orderID string) error {
+	var redemptions []config.PromotionRedemption
+	if err := tx.Where("order_id = ?", orderID).Find(&redemptions).Error; err != nil {
+		return err
+	}
+	for _, r := range redemptions {
+		if err := tx.Model(&config.Promotion{}).Where("id = ? AND times_used > 0", r.PromotionID).
+			Update("times_used", gorm.Expr("times_used - 1")).Error; err != nil {
+			return err
+		}
+	}
+	return tx.Where("order_id = ?", orderID).Delete(&config.PromotionRedemption{}).Error
+}
+
+// lineRemaining is what is left of a line after earlier discounts.
+func lineRemaining(item config.OrderItem) money.Amount {
+	return item.UnitPrice.Mul(item.Quantity) - item.DiscountAmount
+}
+
+// spreadDiscount takes amount off lines in proportion to what is left of each,
+// capped at that total, and returns what it took. The last line takes the
+// rounding remainder.
+func spreadDiscount(order *config.Order, lines []int, amount money.Amount) money.Amount {
+	var eligible money.Amount
+	for _, i := range lines {
+		eligible += lineRemaining(order.Items[i])
+	}
+	amount = min(amount, eligible)
+	if amount <= 0 {
+		return 0
+	}
+	left := amount
+	for n, i := range lines {
+		d := left
+		if n < len(lines)-1 {
+			d = amount.Convert(big.NewRat(int64(lineRemaining(order.Items[i])), int64(eligible)))
+		}
+		order.Items[i].DiscountAmount += d
+		left -= d
+	}
+	return amount
+}
+
+// lineDiscounts spreads what promo takes off the matching lines. Each line is
+// discounted from what earlier promotions left of it, so lines never go below zero.
+func lineDiscounts(promo config.Promotion, order *config.Order, lines []int, pr *pricing) (money.Amount, error) {
+	var total money.Amount
+	switch promo.DiscountType {
+	case config.DiscountPercent:
+		percent, err := tax.ParsePercent(promo.Percent)
+		if err != nil {
+			return 0, err
+		}
+		share := new(big.Rat).Quo(percent, big.NewRat(100, 1))
+		for _, i := range lines {
+			d := lineRemaining(order.Items[i]).Convert(share)
+			order.Items[i].DiscountAmount += d
+			total += d
+		}
+	case config.DiscountFixed:
+		total = spreadDiscount(order, lines, pr.convert(promo.Amount))
+	}
+	return total, nil
+}
+
+// applyPromotions takes the running automatic promotions and the order's
+// coupon off its lines, claiming one use of each. Automatic promotions that
+// don't qualify or are used up are skipped; a coupon that can't be used fails
+// the order. Free-shipping discounts are priced later by waiveShipping.
+func applyPromotions(tx *gorm.DB, order *config.Order, products []config.Product, pr *pricing) error {
+	now := time.Now()
+	code := normalizeCouponCode(order.CouponCode)
+	order.CouponCode = code
+
+	query := tx.Where("active = ? AND (starts_at IS NULL OR starts_at <= ?) AND (ends_at IS NULL OR ends_at > ?)", true, now, now)
+	if code != "" {
+		query = query.Where("code IS NULL OR code = ?", code)
+	} else {
+		query = query.Where("code IS NULL")
+	}
+	var promos []config.Promotion
+	if err := query.Order("created_at, id").Find(&promos).Error; err != nil {
+		return err
+	}
+	couponFound := false
+	for _, promo := range promos {
+		couponFound = couponFound || promo.Code != nil
+	}
+	if code != "" && !couponFound {
+		return fmt.Errorf("%w: %s is not a running coupon", errInvalidCoupon, code)
+	}
+
+	for _, promo := range promos {
+		isCoupon := promo.Code != nil
+		// A guest email isn't verified, so a per-customer limit can't hold for guests
+		if promo.PerUserLimit != nil && order.UserID == nil {
+			if isCoupon {
+				return fmt.Errorf("%w: %s is only for signed-in customers", errInvalidCoupon, code)
+			}
+			continue
+		}
+		var lines []int
+		for i, p := range products {
+			if promotionMatches(promo, p) {
+				lines = append(lines, i)
+			}
+		}
+		if len(lines) == 0 || order.Subtotal < pr.convert(promo.MinSubtotal) {
+			if isCoupon {
+				return fmt.Errorf("%w: the order doesn't qualify for %s", errInvalidCoupon, code)
+			}
+			continue
+		}
+
+		if err := claimPromotion(tx, order, promo); err != nil {
+			if errors.Is(err, errPromotionUsedUp) {
+				if isCoupon {
+					return fmt.Errorf("%w: %s has reached its usage limit", errInvalidCoupon, code)
+				}
+				continue
+			}
+			return err
+		}
+
+		amount, err := lineDiscounts(promo, order, lines, pr)
+		if err != nil {
+			return err
+		}
+		discount := config.OrderDiscount{
+			ID:          uuid.New().String(),
+			OrderID:     order.ID,
+			PromotionID: &promo.ID,
+			Name:        promo.Name,
+			Type:        promo.DiscountType,
+			Amount:      amount,
+		}
+		if isCoupon {
+			discount.Code = *promo.Code
+		}
+		order.Discounts = append(order.Discounts, discount)
+		order.DiscountTotal += amount
+	}
+	return nil
+}
+
+// waiveShipping prices the order's free-shipping discounts once shipping is known.
+func waiveShipping(order *config.Order) {
+	for i := range order.Discounts {
+		if order.Discounts[i].Type != config.DiscountFreeShipping {
+			continue
+		}
+		order.Discounts[i].Amount = order.ShippingTotal
+		order.DiscountTotal += order.ShippingTotal
+		order.TotalPrice -= order.ShippingTotal
+		return
+	}
+}
+
+// promotionInput is the body of CreatePromotion and UpdatePromotion. Amounts
+// are in the store base currency.
+type promotionInput struct {
+	Name         string       `json:"name" binding:"required"`
+	Code         string       `json:"code"`
+	DiscountType string       `json:"discount_type" binding:"required,oneof=percent fixed free_shipping"`
+	Percent      json.Number  `json:"percent"`
+	Amount       money.Amount `json:"amount"`
+	MinSubtotal  money.Amount `json:"min_subtotal"`
+	ProductIDs   []string     `json:"product_ids" binding:"dive,uuid"`
+	Categories   []string     `json:"categories"`
+	UsageLimit   *int         `json:"usage_limit" binding:"omitempty,min=1"`
+	PerUserLimit *int         `json:"per_user_limit" binding:"omitempty,min=1"`
+	StartsAt     *time.Time   `json:"starts_at"`
+	EndsAt       *time.Time   `json:"ends_at"`
+	Active       *bool        `json:"active"`
+}
+
+// bindPromotion validates the request body into promo.
+func bindPromotion(c *gin.Context, promo *config.Promotion) error {
+	var in promotionInput
+	if err := c.ShouldBindJSON(&in); err != nil {
+		return err
+	}
+	promo.Name = strings.TrimSpace(in.Name)
+	promo.Code = nil
+	if code := normalizeCouponCode(in.Code); code != "" {
+		if !couponCodePattern.MatchString(code) {
+			return errors.New("code must be 3 to 32 letters, digits, '-' or '_'")
+		}
+		promo.Code = &code
+	}
+
+	promo.DiscountType = in.DiscountType
+	promo.Percent, promo.Amount = "", 0
+	switch in.DiscountType {
+	case config.DiscountPercent:
+		percent, err := tax.ParsePercent(in.Percent.String())
+		if err != nil || percent.Sign() == 0 {
+			return errors.New("percent must be above 0 and at most 100, with at most 4 decimals")
+		}
+		promo.Percent = in.Percent.String()
+	case config.DiscountFixed:
+		if in.Amount <= 0 {
+			return errors.New("amount must be greater than 0")
+		}
+		promo.Amount = in.Amount
+	}
+	if in.MinSubtotal < 0 {
+		return errors.New("min_subtotal cannot be negative")
+	}
+	promo.MinSubtotal = in.MinSubtotal
+
+	promo.ProductIDs = config.StringList(in.ProductIDs)
+	promo.Categories = nil
+	for _, category := range in.Categories {
+		if category = strings.TrimSpace(category); category != "" {
+			promo.Categories = append(promo.Categories, category)
+		}
+	}
+	promo.UsageLimit = in.UsageLimit
+	promo.PerUserLimit = in.PerUserLimit
+	if in.StartsAt != nil && in.EndsAt != nil && !in.EndsAt.After(*in.StartsAt) {
+		return errors.New("ends_at must be after starts_at")
+	}
+	promo.StartsAt = in.StartsAt
+	promo.EndsAt = in.EndsAt
+	if in.Active != nil {
+		promo.Active = *in.Active
+	}
+	return nil
+}
+
+// savePromotion writes promo, refusing a coupon code another promotion uses.
+func savePromotion(db *gorm.DB, promo *config.Promotion) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if promo.Code != nil {
+			var clash int64
+			tx.Model(&config.Promotion{}).Where("code = ? AND id <> ?", *promo.Code, promo.ID).Count(&clash)
+			if clash > 0 {
+				return errPromotionCodeExists
+			}
+		}
+		return tx.Save(promo).Error
+	})
+}
+
+// respondPromotionSave maps a failed savePromotion to an HTTP response.
+func respondPromotionSave(c *gin.Context, err error) {
+	if errors.Is(err, errPromotionCodeExists) {
+		utils.JSON(c, http.StatusConflict, false, "coupon code exists", nil, err.Error())
+		return
+	}
+	utils.JSON(c, http.StatusInternalServerError, false, "failed to save promotion", nil, err.Error())
+}
+
+// ListPromotions (Admin) - every promotion with its usage count, newest first
+func ListPromotions(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var promos []config.Promotion
+		db.Order("created_at DESC").Find(&promos)
+		utils.JSON(c, http.StatusOK, true, "promotions listed", promos, nil)
+	}
+}
+
+// CreatePromotion (Admin) - adds an automatic promotion, or a coupon when a code is given
+func CreatePromotion(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		promo := config.Promotion{ID: uuid.New().String(), Active: true}
+		if err := bindPromotion(c, &promo); err != nil {
+			utils.JSON(c, http.StatusBadRequest, false, "validation error", nil, err.Error())
+			return
+		}
+		if err := savePromotion(db, &promo); err != nil {
+			respondPromotionSave(c, err)
+			return
+		}
+		utils.JSON(c, http.StatusCreated, true, "promotion created", promo, nil)
+	}
+}
+
+// UpdatePromotion (Admin) - replaces a promotion's rules; its usage count is kept
+func UpdatePromotion(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var promo config.Promotion
+		if err := db.First(&promo, "id = ?", c.Param("id")).Error; err != nil {
+			utils.JSON(c, http.StatusNotFound, false, "promotion not found", nil, nil)
+			return
+		}
+		if err := bindPromotion(c, &promo); err != nil {
+			utils.JSON(c, http.StatusBadRequest, false, "validation error", nil, err.Error())
+			return
+		}
+		// Save rewrites every column, so don't let it write back a stale counter
+		if err := savePromotion(db.Omit("times_used"), &promo); err != nil {
+			respondPromotionSave(c, err)
+			return
+		}
+		db.First(&promo, "id = ?", promo.ID)
+		utils.JSON(c, http.StatusOK, true, "promotion updated", promo, nil)
+	}
+}
+
+// DeletePromotion (Admin) - removes a promotion; placed orders keep their discount lines
+func DeletePromotion(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var deleted int64
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Where("promotion_id = ?", c.Param("id")).Delete(&config.PromotionRedemption{}).Error; err != nil {
+				return err
+			}
+			res := tx.Delete(&config.Promotion{}, "id = ?", c.Param("id"))
+			deleted = res.RowsAffected
+			return res.Error
+		})
+		if err != nil {
+			utils.JSON(c, http.StatusInternalServerError, false, "failed to delete promotion", nil, err.Error())
+			return
+		}
+		if deleted == 0 {
+			utils.JSON(c, http.StatusNotFound, false, "promotion not found", nil, nil)
+			return
+		}
+		utils.JSON(c, http.StatusOK, true, "promotion deleted", nil, nil)
+	}
+}
@@ -0,0 +1,119 @@
+package controllers
+
+import (
+	"encoding/json"
+	"kalebecommerce/config"
+	"kalebecommerce/money"
+	"kalebecommerce/payments"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPromotions_DiscountsAndLimits(t *testing.T) {
+	db := setupTestDB(t)
+	cfg := storeTestConfig()
+	mugID, shirtID := uuid.New().String(), uuid.New().String()
+	db.Create(&config.Product{ID: mugID, Name: "Mug", Category: "kitchen", Price: money.MustParse("10.00"), Stock: 20})
+	db.Create(&config.Product{ID: shirtID, Name: "Shirt", Category: "apparel", Price: money.MustParse("20.00"), Stock: 20})
+	methodID := testShippingMethod(db)
+	db.Model(&config.ShippingMethod{}).Where("id = ?", methodID).Update("price", money.MustParse("4.00"))
+
+	admin := setupRouter()
+	admin.POST("/admin/promotions", mockAdminAuthMiddleware(), CreatePromotion(db))
+	admin.PUT("/admin/promotions/:id", mockAdminAuthMiddleware(), UpdatePromotion(db))
+	createPromotion := func(body string) config.Promotion {
+		w := sendJSON(admin, "POST", "/admin/promotions", body)
+		assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+		var response struct {
+			Object config.Promotion `json:"object"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return response.Object
+	}
+	createPromotion(`{"name":"Kitchen week","discount_type":"percent","percent":"10","categories":["kitchen"]}`)
+	save5 := createPromotion(`{"name":"Five off","code":"save5","discount_type":"fixed","amount":"5.00","min_subtotal":"30.00","usage_limit":2,"per_user_limit":1}`)
+	createPromotion(`{"name":"Free shipping","code":"SHIPFREE","discount_type":"free_shipping"}`)
+	createPromotion(`{"name":"Expired","code":"OLD","discount_type":"fixed","amount":"1.00","ends_at":"2020-01-01T00:00:00Z"}`)
+	assert.Equal(t, http.StatusBadRequest, sendJSON(admin, "POST", "/admin/promotions", `{"name":"Too much","discount_type":"percent","percent":"150"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, sendJSON(admin, "POST", "/admin/promotions", `{"name":"Nothing","discount_type":"fixed"}`).Code)
+	assert.Equal(t, http.StatusConflict, sendJSON(admin, "POST", "/admin/promotions", `{"name":"Copy","code":"SAVE5","discount_type":"fixed","amount":"1.00"}`).Code)
+
+	place := func(userID, coupon string, items ...OrderItemRequest) *httptest.ResponseRecorder {
+		router := setupRouter()
+		router.POST("/orders", mockAuthMiddleware(userID), PlaceOrder(db, cfg))
+		body, _ := json.Marshal(OrderRequest{Items: items, ShippingAddress: &testAddress, ShippingMethodID: methodID, CouponCode: coupon})
+		return sendJSON(router, "POST", "/orders", string(body))
+	}
+	decode := func(w *httptest.ResponseRecorder) config.Order {
+		var response struct {
+			Object config.Order `json:"object"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return response.Object
+	}
+	timesUsed := func() int {
+		var promo config.Promotion
+		db.First(&promo, "id = ?", save5.ID)
+		return promo.TimesUsed
+	}
+
+	// 10% off the mugs, then 5.00 spread over what's left of both lines
+	alice := uuid.New().String()
+	w := place(alice, "save5", OrderItemRequest{ProductID: mugID, Quantity: 2}, OrderItemRequest{ProductID: shirtID, Quantity: 1})
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	order := decode(w)
+	assert.Equal(t, "SAVE5", order.CouponCode)
+	assert.Equal(t, money.MustParse("40.00"), order.Subtotal)
+	assert.Equal(t, money.MustParse("7.00"), order.DiscountTotal)
+	assert.Equal(t, money.MustParse("37.00"), order.TotalPrice)
+	assert.Len(t, order.Discounts, 2)
+	assert.Equal(t, money.MustParse("7.00"), order.Items[0].DiscountAmount+order.Items[1].DiscountAmount)
+	assert.Equal(t, 1, timesUsed())
+
+	// Guests can't be held to a per-customer limit, so they can't use the coupon
+	guest := setupRouter()
+	guest.POST("/guest/orders", PlaceGuestOrder(db, cfg))
+	w = sendJSON(guest, "POST", "/guest/orders", `{"email":"guest@example.com","coupon_code":"SAVE5","shipping_address":`+guestAddressJSON+
+		`,"shipping_method_id":"`+methodID+`","items":[{"productId":"`+shirtID+`","quantity":2}]}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "only for signed-in customers")
+	assert.Equal(t, 1, timesUsed())
+
+	// Once per customer, and only above the minimum spend
+	w = place(alice, "SAVE5", OrderItemRequest{ProductID: shirtID, Quantity: 2})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "usage limit")
+	assert.Equal(t, http.StatusBadRequest, place(uuid.New().String(), "SAVE5", OrderItemRequest{ProductID: mugID, Quantity: 1}).Code)
+	assert.Equal(t, http.StatusBadRequest, place(uuid.New().String(), "OLD", OrderItemRequest{ProductID: mugID, Quantity: 1}).Code)
+	assert.Equal(t, http.StatusBadRequest, place(uuid.New().String(), "NOPE", OrderItemRequest{ProductID: mugID, Quantity: 1}).Code)
+	var shirt config.Product
+	db.First(&shirt, "id = ?", shirtID)
+	assert.Equal(t, 19, shirt.Stock)
+
+	// Cancelling gives the use back
+	cancel := setupRouter()
+	cancel.POST("/orders/:id/cancel", mockAuthMiddleware(alice), CancelOrder(db, payments.NewMockProvider()))
+	assert.Equal(t, http.StatusOK, sendJSON(cancel, "POST", "/orders/"+order.ID+"/cancel", `{}`).Code)
+	assert.Equal(t, 0, timesUsed())
+	assert.Equal(t, http.StatusCreated, place(alice, "SAVE5", OrderItemRequest{ProductID: shirtID, Quantity: 2}).Code)
+
+	// Editing the rules keeps the counter; the global limit is then reached
+	w = sendJSON(admin, "PUT", "/admin/promotions/"+save5.ID, `{"name":"Five off!","code":"SAVE5","discount_type":"fixed","amount":"5.00","min_subtotal":"30.00","usage_limit":2,"per_user_limit":1}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 1, timesUsed())
+	assert.Equal(t, http.StatusCreated, place(uuid.New().String(), "SAVE5", OrderItemRequest{ProductID: shirtID, Quantity: 2}).Code)
+	assert.Equal(t, http.StatusBadRequest, place(uuid.New().String(), "SAVE5", OrderItemRequest{ProductID: shirtID, Quantity: 2}).Code)
+	assert.Equal(t, 2, timesUsed())
+
+	// Free shipping waives the shipping cost
+	w = place(uuid.New().String(), "shipfree", OrderItemRequest{ProductID: shirtID, Quantity: 1})
+	assert.Equal(t, http.StatusCreated, w.Code)
+	order = decode(w)
+	assert.Equal(t, money.MustParse("4.00"), order.ShippingTotal)
+	assert.Equal(t, money.MustParse("4.00"), order.DiscountTotal)
+	assert.Equal(t, money.MustParse("20.00"), order.TotalPrice)
+}
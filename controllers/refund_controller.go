@@ -0,0 +1,440 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"kalebecommerce/config"
+	"kalebecommerce/money"
+	"kalebecommerce/payments"
+	"kalebecommerce/utils"
+	"math/big"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// refundableStatuses are the order statuses that have been paid for.
+var refundableStatuses = map[string]bool{
+	config.OrderStatusPaid:       true,
+	config.OrderStatusProcessing: true,
+	config.OrderStatusShipped:    true,
+	config.OrderStatusDelivered:  true,
+}
+
+// paidBeforeCancel reports whether a cancelled order had been paid first,
+// so it may still hold money to refund.
+func paidBeforeCancel(tx *gorm.DB, order *config.Order) (bool, error) {
+	if order.Status != config.OrderStatusCancelled {
+		return false, nil
+	}
+	var paid int64
+	err := tx.Model(&config.OrderEvent{}).
+		Where("order_id = ? AND to_status = ?", order.ID, config.OrderStatusPaid).Count(&paid).Error
+	return paid > 0, err
+}
+
+// errInvalidRefund is returned for refund requests the order can't satisfy.
+var errInvalidRefund = errors.New("invalid refund")
+
+// refundLine asks to refund quantity units of an order line.
+type refundLine struct {
+	OrderItemID string `json:"order_item_id" binding:"required"`
+	Quantity    int    `json:"quantity" binding:"required,min=1"`
+}
+
+// refundRequest is the body of CreateRefund. With neither items nor amount the
+// whole remaining balance is refunded. StoreCredit pays the refund out as
+// store credit instead of back to how the order was paid.
+type refundRequest struct {
+	Items       []refundLine  `json:"items" binding:"dive"`
+	Amount      *money.Amount `json:"amount"`
+	Reason      string        `json:"reason" binding:"required"`
+	Restock     bool          `json:"restock"`
+	StoreCredit bool          `json:"store_credit"`
+}
+
+// refundedQuantities sums the already refunded units of each line of an order,
+// or only the units that were put back in stock when restockedOnly is set.
+func refundedQuantities(tx *gorm.DB, orderID string, restockedOnly bool) (map[string]int, error) {
+	var rows []struct {
+		OrderItemID string
+		Quantity    int
+	}
+	query := tx.Model(&config.RefundItem{}).
+		Select("refund_items.order_item_id, SUM(refund_items.quantity) AS quantity").
+		Joins("JOIN refunds ON refunds.id = refund_items.refund_id").
+		Where("refunds.order_id = ?", orderID)
+	if restockedOnly {
+		query = query.Where("refunds.restock = ?", true)
+	}
+	err := query.Group("refund_items.order_item_id").Scan(&rows).Error
+	refunded := map[string]int{}
+	for _, r := range rows {
+		refunded[r.OrderItemID] = r.Quantity
+	}
+	return refunded, err
+}
+
+// refundLineAmount is what quantity units of an order line cost the customer:
+// their price less their share of the line's discount plus, when tax was added
+// on top, their share of the line's tax.
+func refundLineAmount(order *config.Order, item config.OrderItem, quantity int) money.Amount {
+	amount := item.UnitPrice.Mul(quantity)
+	if item.DiscountAmount != 0 {
+		amount -= item.DiscountAmount.Convert(big.NewRat(int64(quantity), int64(item.Quantity)))
+	}
+	if !order.TaxesIncluded && item.TaxAmount != 0 {
+		amount += item.TaxAmount.Convert(big.NewRat(int64(quantity), int64(item.Quantity)))
+	}
+	return amount
+}
+
+// buildRefund works out the lines and amount of a refund against a locked order.
+func buildRefund(tx *gorm.DB, order *config.Order, in refundRequest) (*config.Refund, error) {
+	var items []config.OrderItem
+	if err := tx.Where("order_id = ?", order.ID).Find(&items).Error; err != nil {
+		return nil, err
+	}
+	refunded, err := refundedQuantities(tx, order.ID, false)
+	if err != nil {
+		return nil, err
+	}
+	remaining := order.TotalPrice - order.RefundedAmount
+
+	refund := &config.Refund{
+		ID:      uuid.New().String(),
+		OrderID: order.ID,
+		Reason:  in.Reason,
+		Restock: in.Restock,
+	}
+
+	switch {
+	case len(in.Items) > 0 && in.Amount != nil:
+		return nil, fmt.Errorf("%w: send either items or amount, not both", errInvalidRefund)
+	case in.Amount != nil:
+		if in.Restock {
+			return nil, fmt.Errorf("%w: restock needs items", errInvalidRefund)
+		}
+		if *in.Amount <= 0 {
+			return nil, fmt.Errorf("%w: amount must be greater than 0", errInvalidRefund)
+		}
+		refund.Amount = *in.Amount
+	case len(in.Items) > 0:
+		byID := map[string]config.OrderItem{}
+		for _, item := range items {
+			byID[item.ID] = item
+		}
+		for _, line := range in.Items {
+			item, ok := byID[line.OrderItemID]
+			if !ok {
+				return nil, fmt.Errorf("%w: order item %s is not part of this order", errInvalidRefund, line.OrderItemID)
+			}
+			if refunded[item.ID]+line.Quantity > item.Quantity {
+				return nil, fmt.Errorf("%w: only %d of order item %s left to refund", errInvalidRefund, item.Quantity-refunded[item.ID], item.ID)
+			}
+			refunded[item.ID] += line.Quantity
+			refund.Items = append(refund.Items, config.RefundItem{
+				ID:          uuid.New().String(),
+				RefundID:    refund.ID,
+				OrderItemID: item.ID,
+				Quantity:    line.Quantity,
+				Amount:      refundLineAmount(order, item, line.Quantity),
+			})
+			refund.Amount += refundLineAmount(order, item, line.Quantity)
+		}
+	default:
+		// Everything not refunded yet: the remaining units and the remaining balance
+		for _, item := range items {
+			if left := item.Quantity - refunded[item.ID]; left > 0 {
+				refund.Items = append(refund.Items, config.RefundItem{
+					ID:          uuid.New().String(),
+					RefundID:    refund.ID,
+					OrderItemID: item.ID,
+					Quantity:    left,
+					Amount:      refundLineAmount(order, item, left),
+				})
+			}
+		}
+		refund.Amount = remaining
+	}
+
+	if refund.Amount <= 0 || refund.Amount > remaining {
+		return nil, fmt.Errorf("%w: %s left to refund", errInvalidRefund, remaining)
+	}
+	return refund, nil
+}
+
+// allocateRefund splits a refund between the card payment and the order's gift
+// card and store credit tenders, card first, or puts all of it on the
+// customer's store credit when asked.
+func allocateRefund(tx *gorm.DB, order *config.Order, payment *config.Payment, refund *config.Refund, toStoreCredit bool) error {
+	if toStoreCredit {
+		if order.UserID == nil {
+			return fmt.Errorf("%w: guest orders can't be refunded to store credit", errInvalidRefund)
+		}
+		entry := config.StoreCreditTransaction{Type: config.LedgerRefund, OrderID: &order.ID, RefundID: &refund.ID, Note: refund.Reason, CreatedBy: refund.CreatedBy}
+		refund.StoreCreditAmount = refund.Amount
+		return adjustStoreCredit(tx, *order.UserID, order.Currency, refund.Amount, entry)
+	}
+
+	if payment != nil {
+		var refunded money.Amount
+		if err := tx.Model(&config.Refund{}).Where("payment_id = ?", payment.ID).
+			Select("COALESCE(SUM(provider_amount), 0)").Scan(&refunded).Error; err != nil {
+			return err
+		}
+		refund.PaymentID = &payment.ID
+		refund.ProviderAmount = min(refund.Amount, payment.Amount-refunded)
+	}
+	placed, err := refundTenders(tx, order, refund, refund.Amount-refund.ProviderAmount)
+	if err != nil {
+		return err
+	}
+	refund.TenderAmount = placed
+	if refund.ProviderAmount+refund.TenderAmount != refund.Amount {
+		return fmt.Errorf("%w: only %s can be paid back", errInvalidRefund, refund.ProviderAmount+refund.TenderAmount)
+	}
+	return nil
+}
+
+// issueRefund refunds a locked order to the order's tenders, or to store
+// credit, restocking if asked, and updates the order's refund state. The card
+// share is only recorded as pending: the caller pays it out with payOutRefund
+// once the transaction has committed. Orders cancelled after they were paid can
+// still be refunded; they stay cancelled.
+func issueRefund(tx *gorm.DB, order *config.Order, in refundRequest, actor *uuid.UUID) (*config.Refund, error) {
+	if !refundableStatuses[order.Status] {
+		paid, err := paidBeforeCancel(tx, order)
+		if err != nil {
+			return nil, err
+		}
+		if !paid {
+			return nil, fmt.Errorf("%w: %s orders can't be refunded", errIllegalTransition, order.Status)
+		}
+	}
+	var payment *config.Payment
+	var captured config.Payment
+	if err := tx.Where("order_id = ? AND status = ?", order.ID, config.PaymentStatusSucceeded).First(&captured).Error; err == nil {
+		payment = &captured
+	} else if order.TenderTotal == 0 {
+		return nil, fmt.Errorf("%w: order has no captured payment", errInvalidRefund)
+	}
+
+	refund, err := buildRefund(tx, order, in)
+	if err != nil {
+		return nil, err
+	}
+	refund.CreatedBy = actor
+	// The refund row goes first: ledger entries reference it
+	if err := tx.Create(refund).Error; err != nil {
+		return nil, err
+	}
+	if err := allocateRefund(tx, order, payment, refund, in.StoreCredit); err != nil {
+		return nil, err
+	}
+	if err := voidRefundedGiftCards(tx, refund); err != nil {
+		return nil, err
+	}
+	if refund.ProviderAmount > 0 {
+		refund.ProviderStatus = config.ProviderRefundPending
+	}
+	err = tx.Model(refund).Updates(map[string]interface{}{
+		"payment_id":          refund.PaymentID,
+		"provider_status":     refund.ProviderStatus,
+		"provider_amount":     refund.ProviderAmount,
+		"tender_amount":       refund.TenderAmount,
+		"store_credit_amount": refund.StoreCreditAmount,
+	}).Error
+	if err != nil {
+		return nil, err
+	}
+	if refund.Restock {
+		if err := restockRefundItems(tx, refund.Items); err != nil {
+			return nil, err
+		}
+	}
+
+	order.RefundedAmount += refund.Amount
+	order.RefundStatus = config.RefundStatusPartial
+	if order.RefundedAmount >= order.TotalPrice {
+		order.RefundStatus = config.RefundStatusFull
+	}
+	err = tx.Model(order).Updates(map[string]interface{}{
+		"refunded_amount": order.RefundedAmount,
+		"refund_status":   order.RefundStatus,
+	}).Error
+	if err != nil {
+		return nil, err
+	}
+	if err := settleOrderPoints(tx, order, false); err != nil {
+		return nil, err
+	}
+	if order.RefundStatus == config.RefundStatusFull && order.Status != config.OrderStatusCancelled {
+		if err := transitionOrder(tx, order, config.OrderStatusRefunded, in.Reason, actor); err != nil {
+			return nil, err
+		}
+	}
+
+	return refund, nil
+}
+
+// payOutRefund pays the card share of a committed refund back through the
+// provider and records the outcome in a transaction of its own. It runs
+// outside the order's transaction, so the order isn't locked while the
+// provider answers. The call is keyed by
+// the refund, so paying out a pending or failed refund again never pays twice.
+func payOutRefund(db *gorm.DB, provider payments.PaymentProvider, refund *config.Refund) error {
+	if refund == nil || refund.PaymentID == nil || refund.ProviderStatus == "" ||
+		refund.ProviderStatus == config.ProviderRefundSucceeded {
+		return nil
+	}
+	var payment config.Payment
+	if err := db.First(&payment, "id = ?", *refund.PaymentID).Error; err != nil {
+		return err
+	}
+
+	providerRefundID, err := provider.Refund(payment.IntentID, refund.ProviderAmount, providerRefundKey(refund))
+	updates := map[string]interface{}{
+		"provider_status":    config.ProviderRefundSucceeded,
+		"provider_refund_id": providerRefundID,
+		"provider_error":     "",
+	}
+	if err != nil {
+		updates = map[string]interface{}{
+			"provider_status": config.ProviderRefundFailed,
+			"provider_error":  err.Error(),
+		}
+	}
+	finalized := db.Transaction(func(tx *gorm.DB) error {
+		var stored config.Refund
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&stored, "id = ?", refund.ID).Error; err != nil {
+			return err
+		}
+		// A concurrent retry that already succeeded is left as it is
+		if stored.ProviderStatus == config.ProviderRefundSucceeded {
+			return nil
+		}
+		return tx.Model(&stored).Updates(updates).Error
+	})
+	if finalized != nil {
+		return finalized
+	}
+	if err != nil {
+		refund.ProviderStatus, refund.ProviderError = config.ProviderRefundFailed, err.Error()
+		return fmt.Errorf("%w: %v", errPaymentProvider, err)
+	}
+	refund.ProviderStatus, refund.ProviderRefundID, refund.ProviderError = config.ProviderRefundSucceeded, providerRefundID, ""
+	return nil
+}
+
+// providerRefundKey is the idempotency key of a refund's provider call. Each
+// refund row has its own, so a retry of the same refund is paid out once and
+// a new refund for the same amount is a new payout.
+func providerRefundKey(refund *config.Refund) string {
+	return "refund_" + refund.ID
+}
+
+// respondRefundError maps a failed refund to an HTTP response.
+func respondRefundError(c *gin.Context, err error) {
+	if errors.Is(err, errInvalidRefund) {
+		utils.JSON(c, http.StatusBadRequest, false, "invalid refund", nil, err.Error())
+		return
+	}
+	respondTransitionError(c, err)
+}
+
+// respondPayoutError reports a refund that was recorded but whose card payout
+// failed. The refund keeps provider_status failed and can be retried.
+func respondPayoutError(c *gin.Context, err error, obj interface{}) {
+	status := http.StatusInternalServerError
+	if errors.Is(err, errPaymentProvider) {
+		status = http.StatusBadGateway
+	}
+	utils.JSON(c, status, false, "refund recorded, but paying it back to the card failed", obj, err.Error())
+}
+
+// CreateRefund (Admin) - refunds the whole order, some lines, or a custom amount
+// through the payment provider, optionally putting refunded lines back in stock
+func CreateRefund(db *gorm.DB, provider payments.PaymentProvider) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var in refundRequest
+		if err := c.ShouldBindJSON(&in); err != nil {
+			utils.JSON(c, http.StatusBadRequest, false, "validation error", nil, err.Error())
+			return
+		}
+
+		var refund *config.Refund
+		var order *config.Order
+		err := db.Transaction(func(tx *gorm.DB) error {
+			var err error
+			if order, err = lockOrder(tx, c.Param("id")); err != nil {
+				return err
+			}
+			refund, err = issueRefund(tx, order, in, currentUserID(c))
+			return err
+		})
+		if err != nil {
+			respondRefundError(c, err)
+			return
+		}
+		result := gin.H{"refund": refund, "order": order}
+		if err := payOutRefund(db, provider, refund); err != nil {
+			respondPayoutError(c, err, result)
+			return
+		}
+		utils.JSON(c, http.StatusCreated, true, "refund created", result, nil)
+	}
+}
+
+// RetryRefund (Admin) - pays out a refund whose card payout is pending or failed
+func RetryRefund(db *gorm.DB, provider payments.PaymentProvider) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var refund config.Refund
+		if err := db.Preload("Items").First(&refund, "id = ?", c.Param("id")).Error; err != nil {
+			utils.JSON(c, http.StatusNotFound, false, "refund not found", nil, nil)
+			return
+		}
+		if refund.ProviderStatus != config.ProviderRefundPending && refund.ProviderStatus != config.ProviderRefundFailed {
+			utils.JSON(c, http.StatusConflict, false, "refund has nothing left to pay out", nil, nil)
+			return
+		}
+		if err := payOutRefund(db, provider, &refund); err != nil {
+			respondPayoutError(c, err, refund)
+			return
+		}
+		utils.JSON(c, http.StatusOK, true, "refund paid out", refund, nil)
+	}
+}
+
+// restockRefundItems puts refunded units back in stock.
+func restockRefundItems(tx *gorm.DB, items []config.RefundItem) error {
+	for _, ri := range items {
+		var item config.OrderItem
+		if err := tx.First(&item, "id = ?", ri.OrderItemID).Error; err != nil {
+			return err
+		}
+		err := tx.Model(&config.Product{}).Where("id = ?", item.ProductID).Updates(map[string]interface{}{
+			"stock":   gorm.Expr("stock + ?", ri.Quantity),
+			"version": gorm.Expr("version + 1"),
+		}).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListRefunds (Admin) - refunds of an order, oldest first
+func ListRefunds(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var refunds []config.Refund
+		if err := db.Preload("Items").Where("order_id = ?", c.Param("id")).Order("created_at").Find(&refunds).Error; err != nil {
+			utils.JSON(c, http.StatusInternalServerError, false, "failed to fetch refunds", nil, err.Error())
+			return
+		}
+		utils.JSON(c, http.StatusOK, true, "refunds retrieved", refunds, nil)
+	}
+}
@@ -0,0 +1,246 @@
+package controllers
+
+import (
+	"errors"
+	"kalebecommerce/config"
+	"kalebecommerce/money"
+	"kalebecommerce/payments"
+	"net/http"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+// placePaidTestOrder places an order and pays for it through the mock provider
+func placePaidTestOrder(t *testing.T, db *gorm.DB, mock *payments.MockProvider, userID, productID string, quantity int) config.Order {
+	order := placeTestOrder(t, db, userID, productID, quantity)
+	payment := startTestPayment(t, db, storeTestConfig(), mock, userID, order.ID)
+
+	event, err := mock.Simulate(payment.IntentID, true)
+	assert.NoError(t, err)
+	assert.NoError(t, applyPaymentEvent(db, mock, *event))
+	return order
+}
+
+func TestCreateRefund_PartialThenFull(t *testing.T) {
+	db := setupTestDB(t)
+	mock := payments.NewMockProvider()
+	productID := uuid.New().String()
+	db.Create(&config.Product{ID: productID, Name: "Mug", Price: money.MustParse("8.00"), Stock: 5})
+	order := placePaidTestOrder(t, db, mock, uuid.New().String(), productID, 3)
+
+	router := setupRouter()
+	router.POST("/admin/orders/:id/refunds", mockAuthMiddleware(uuid.New().String()), CreateRefund(db, mock))
+
+	// One unit back, returned to stock
+	w := sendJSON(router, "POST", "/admin/orders/"+order.ID+"/refunds", `{"items":[{"order_item_id":"`+order.Items[0].ID+`","quantity":1}],"reason":"damaged","restock":true}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	var partial config.Order
+	db.First(&partial, "id = ?", order.ID)
+	assert.Equal(t, config.OrderStatusPaid, partial.Status)
+	assert.Equal(t, config.RefundStatusPartial, partial.RefundStatus)
+	assert.Equal(t, money.MustParse("8.00"), partial.RefundedAmount)
+
+	var p config.Product
+	db.First(&p, "id = ?", productID)
+	assert.Equal(t, 3, p.Stock)
+
+	// More units than are left on the line
+	w = sendJSON(router, "POST", "/admin/orders/"+order.ID+"/refunds", `{"items":[{"order_item_id":"`+order.Items[0].ID+`","quantity":3}],"reason":"oops"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// The rest of the order
+	w = sendJSON(router, "POST", "/admin/orders/"+order.ID+"/refunds", `{"reason":"customer complaint"}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	var full config.Order
+	db.First(&full, "id = ?", order.ID)
+	assert.Equal(t, config.OrderStatusRefunded, full.Status)
+	assert.Equal(t, config.RefundStatusFull, full.RefundStatus)
+	assert.Equal(t, money.MustParse("24.00"), full.RefundedAmount)
+
+	var refunds []config.Refund
+	db.Preload("Items").Where("order_id = ?", order.ID).Find(&refunds)
+	assert.Len(t, refunds, 2)
+	for _, r := range refunds {
+		assert.NotEmpty(t, r.ProviderRefundID)
+	}
+
+	// Nothing left to refund
+	w = sendJSON(router, "POST", "/admin/orders/"+order.ID+"/refunds", `{"amount":"1","reason":"again"}`)
+	assert.Equal(t, http.StatusConflict, w.Code)
+}
+
+func TestCreateRefund_CustomAmountValidation(t *testing.T) {
+	db := setupTestDB(t)
+	mock := payments.NewMockProvider()
+	productID := uuid.New().String()
+	db.Create(&config.Product{ID: productID, Name: "Mug", Price: money.MustParse("8.00"), Stock: 5})
+	order := placePaidTestOrder(t, db, mock, uuid.New().String(), productID, 1)
+	unpaid := placeTestOrder(t, db, uuid.New().String(), productID, 1)
+
+	router := setupRouter()
+	router.POST("/admin/orders/:id/refunds", mockAdminAuthMiddleware(), CreateRefund(db, mock))
+
+	assert.Equal(t, http.StatusBadRequest, sendJSON(router, "POST", "/admin/orders/"+order.ID+"/refunds", `{"amount":"2.50"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, sendJSON(router, "POST", "/admin/orders/"+order.ID+"/refunds", `{"amount":"9","reason":"too much"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, sendJSON(router, "POST", "/admin/orders/"+order.ID+"/refunds", `{"amount":"2","reason":"goodwill","restock":true}`).Code)
+	assert.Equal(t, http.StatusConflict, sendJSON(router, "POST", "/admin/orders/"+unpaid.ID+"/refunds", `{"reason":"not paid"}`).Code)
+
+	w := sendJSON(router, "POST", "/admin/orders/"+order.ID+"/refunds", `{"amount":"2.50","reason":"late delivery"}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	var refunded config.Order
+	db.First(&refunded, "id = ?", order.ID)
+	assert.Equal(t, money.MustParse("2.50"), refunded.RefundedAmount)
+	assert.Equal(t, config.RefundStatusPartial, refunded.RefundStatus)
+}
+
+func TestCancelOrder_RefundsPaidOrder(t *testing.T) {
+	db := setupTestDB(t)
+	mock := payments.NewMockProvider()
+	userID := uuid.New().String()
+	productID := uuid.New().String()
+	db.Create(&config.Product{ID: productID, Name: "Mug", Price: money.MustParse("8.00"), Stock: 5})
+	order := placePaidTestOrder(t, db, mock, userID, productID, 2)
+	unpaid := placeTestOrder(t, db, userID, productID, 1)
+
+	router := setupRouter()
+	router.POST("/orders/:id/cancel", mockAuthMiddleware(userID), CancelOrder(db, mock))
+	router.POST("/admin/orders/:id/refunds", mockAdminAuthMiddleware(), CreateRefund(db, mock))
+	for _, id := range []string{order.ID, unpaid.ID} {
+		assert.Equal(t, http.StatusOK, sendJSON(router, "POST", "/orders/"+id+"/cancel", `{}`).Code)
+	}
+
+	// The paid order got its money back and its stock returned once
+	var cancelled config.Order
+	db.First(&cancelled, "id = ?", order.ID)
+	assert.Equal(t, config.OrderStatusCancelled, cancelled.Status)
+	assert.Equal(t, config.RefundStatusFull, cancelled.RefundStatus)
+	var refund config.Refund
+	db.First(&refund, "order_id = ?", order.ID)
+	assert.Equal(t, money.MustParse("16.00"), refund.ProviderAmount)
+	assert.NotEmpty(t, refund.ProviderRefundID)
+	var p config.Product
+	db.First(&p, "id = ?", productID)
+	assert.Equal(t, 5, p.Stock)
+
+	// Nothing left on it, and nothing was ever paid on the other
+	assert.Equal(t, http.StatusBadRequest, sendJSON(router, "POST", "/admin/orders/"+order.ID+"/refunds", `{"reason":"again"}`).Code)
+	assert.Equal(t, http.StatusConflict, sendJSON(router, "POST", "/admin/orders/"+unpaid.ID+"/refunds", `{"reason":"cancelled"}`).Code)
+}
+
+func TestCreateRefund_CancelledAfterPayment(t *testing.T) {
+	db := setupTestDB(t)
+	mock := payments.NewMockProvider()
+	productID := uuid.New().String()
+	db.Create(&config.Product{ID: productID, Name: "Mug", Price: money.MustParse("8.00"), Stock: 5})
+	order := placePaidTestOrder(t, db, mock, uuid.New().String(), productID, 2)
+
+	// Cancelled without its refund, as orders were before cancelling refunded them
+	assert.NoError(t, db.Transaction(func(tx *gorm.DB) error {
+		locked, err := lockOrder(tx, order.ID)
+		if err != nil {
+			return err
+		}
+		return transitionOrder(tx, locked, config.OrderStatusCancelled, "cancelled", nil)
+	}))
+
+	router := setupRouter()
+	router.POST("/admin/orders/:id/refunds", mockAdminAuthMiddleware(), CreateRefund(db, mock))
+	w := sendJSON(router, "POST", "/admin/orders/"+order.ID+"/refunds", `{"reason":"cancelled"}`)
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+	var cancelled config.Order
+	db.First(&cancelled, "id = ?", order.ID)
+	assert.Equal(t, config.OrderStatusCancelled, cancelled.Status)
+	assert.Equal(t, config.RefundStatusFull, cancelled.RefundStatus)
+	var refund config.Refund
+	db.First(&refund, "order_id = ?", order.ID)
+	assert.Equal(t, money.MustParse("16.00"), refund.ProviderAmount)
+	assert.NotEmpty(t, refund.ProviderRefundID)
+}
+
+// failingRefunds is a provider whose refunds fail, as during a provider outage
+type failingRefunds struct{ *payments.MockProvider }
+
+func (failingRefunds) Refund(string, money.Amount, string) (string, error) {
+	return "", errors.New("provider unavailable")
+}
+
+func TestCreateRefund_ProviderFailureIsKeptForRetry(t *testing.T) {
+	db := setupTestDB(t)
+	mock := payments.NewMockProvider()
+	productID := uuid.New().String()
+	db.Create(&config.Product{ID: productID, Name: "Mug", Price: money.MustParse("8.00"), Stock: 5})
+	order := placePaidTestOrder(t, db, mock, uuid.New().String(), productID, 2)
+	var payment config.Payment
+	db.First(&payment, "order_id = ?", order.ID)
+
+	router := setupRouter()
+	router.POST("/admin/orders/:id/refunds", mockAdminAuthMiddleware(), CreateRefund(db, failingRefunds{mock}))
+	w := sendJSON(router, "POST", "/admin/orders/"+order.ID+"/refunds", `{"amount":"5.00","reason":"late"}`)
+	assert.Equal(t, http.StatusBadGateway, w.Code, w.Body.String())
+	assert.Equal(t, money.Amount(0), mock.Refunded(payment.IntentID))
+
+	// The refund is recorded and waits for its payout
+	var refund config.Refund
+	db.First(&refund, "order_id = ?", order.ID)
+	assert.Equal(t, config.ProviderRefundFailed, refund.ProviderStatus)
+	assert.Equal(t, "provider unavailable", refund.ProviderError)
+	var updated config.Order
+	db.First(&updated, "id = ?", order.ID)
+	assert.Equal(t, money.MustParse("5.00"), updated.RefundedAmount)
+
+	router.POST("/admin/refunds/:id/retry", mockAdminAuthMiddleware(), RetryRefund(db, mock))
+	w = sendJSON(router, "POST", "/admin/refunds/"+refund.ID+"/retry", "")
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, money.MustParse("5.00"), mock.Refunded(payment.IntentID))
+	db.First(&refund, "id = ?", refund.ID)
+	assert.Equal(t, config.ProviderRefundSucceeded, refund.ProviderStatus)
+	assert.Empty(t, refund.ProviderError)
+
+	w = sendJSON(router, "POST", "/admin/refunds/"+refund.ID+"/retry", "")
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, money.MustParse("5.00"), mock.Refunded(payment.IntentID))
+}
+
+func TestRetryRefund_AfterLostOutcomeDoesNotPayTwice(t *testing.T) {
+	db := setupTestDB(t)
+	mock := payments.NewMockProvider()
+	productID := uuid.New().String()
+	db.Create(&config.Product{ID: productID, Name: "Mug", Price: money.MustParse("8.00"), Stock: 5})
+	order := placePaidTestOrder(t, db, mock, uuid.New().String(), productID, 2)
+	var payment config.Payment
+	db.First(&payment, "order_id = ?", order.ID)
+
+	// The refund commits, then the provider pays out but the outcome is never recorded
+	var refund *config.Refund
+	err := db.Transaction(func(tx *gorm.DB) error {
+		locked, err := lockOrder(tx, order.ID)
+		if err != nil {
+			return err
+		}
+		amount := money.MustParse("5.00")
+		refund, err = issueRefund(tx, locked, refundRequest{Amount: &amount, Reason: "late"}, nil)
+		return err
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, config.ProviderRefundPending, refund.ProviderStatus)
+	paidOut, err := mock.Refund(payment.IntentID, refund.ProviderAmount, providerRefundKey(refund))
+	assert.NoError(t, err)
+
+	router := setupRouter()
+	router.POST("/admin/refunds/:id/retry", mockAdminAuthMiddleware(), RetryRefund(db, mock))
+	w := sendJSON(router, "POST", "/admin/refunds/"+refund.ID+"/retry", "")
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, money.MustParse("5.00"), mock.Refunded(payment.IntentID))
+
+	var stored config.Refund
+	db.First(&stored, "id = ?", refund.ID)
+	assert.Equal(t, paidOut, stored.ProviderRefundID)
+	assert.Equal(t, config.ProviderRefundSucceeded, stored.ProviderStatus)
+}
@@ -0,0 +1,352 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"kalebecommerce/config"
+	"kalebecommerce/payments"
+	"kalebecommerce/utils"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// maxReturnPhotos limits the photos attached to one return request.
+const maxReturnPhotos = 5
+
+// returnableStatuses are the order statuses in which goods can be sent back.
+var returnableStatuses = map[string]bool{
+	config.OrderStatusShipped:   true,
+	config.OrderStatusDelivered: true,
+}
+
+var (
+	// errInvalidReturn is returned for return requests the order can't satisfy.
+	errInvalidReturn = errors.New("invalid return")
+	// errReturnState is returned when a return isn't in the right status for an action.
+	errReturnState = errors.New("return request is not in the right status")
+)
+
+// returnLine asks to send back quantity units of an order line.
+type returnLine struct {
+	OrderItemID string `json:"order_item_id" binding:"required"`
+	Quantity    int    `json:"quantity" binding:"required,min=1"`
+}
+
+type returnInput struct {
+	Reason string       `json:"reason" binding:"required"`
+	Items  []returnLine `json:"items" binding:"required,min=1,dive"`
+}
+
+// bindReturnInput reads a return request from JSON, or from a multipart form
+// whose "items" field holds the JSON list of lines (used when attaching photos).
+func bindReturnInput(c *gin.Context, in *returnInput) error {
+	if c.ContentType() != binding.MIMEMultipartPOSTForm {
+		return c.ShouldBindJSON(in)
+	}
+	in.Reason = c.PostForm("reason")
+	if raw := c.PostForm("items"); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &in.Items); err != nil {
+			return fmt.Errorf("items must be a JSON list: %w", err)
+		}
+	}
+	return binding.Validator.ValidateStruct(in)
+}
+
+// openReturnQuantities sums the units of each order line in returns that are
+// still waiting for approval or delivery.
+func openReturnQuantities(tx *gorm.DB, orderID string) (map[string]int, error) {
+	var rows []struct {
+		OrderItemID string
+		Quantity    int
+	}
+	err := tx.Model(&config.ReturnItem{}).
+		Select("return_items.order_item_id, SUM(return_items.quantity) AS quantity").
+		Joins("JOIN return_requests ON return_requests.id = return_items.return_request_id").
+		Where("return_requests.order_id = ? AND return_requests.status IN ?", orderID,
+			[]string{config.ReturnStatusRequested, config.ReturnStatusApproved}).
+		Group("return_items.order_item_id").
+		Scan(&rows).Error
+	open := map[string]int{}
+	for _, r := range rows {
+		open[r.OrderItemID] = r.Quantity
+	}
+	return open, err
+}
+
+// buildReturnItems checks the requested lines against what is left to return on the order.
+func buildReturnItems(tx *gorm.DB, order *config.Order, returnID string, lines []returnLine) ([]config.ReturnItem, error) {
+	var items []config.OrderItem
+	if err := tx.Where("order_id = ?", order.ID).Find(&items).Error; err != nil {
+		return nil, err
+	}
+	byID := map[string]config.OrderItem{}
+	for _, item := range items {
+		byID[item.ID] = item
+	}
+	refunded, err := refundedQuantities(tx, order.ID, false)
+	if err != nil {
+		return nil, err
+	}
+	open, err := openReturnQuantities(tx, order.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []config.ReturnItem
+	for _, line := range lines {
+		item, ok := byID[line.OrderItemID]
+		if !ok {
+			return nil, fmt.Errorf("%w: order item %s is not part of this order", errInvalidReturn, line.OrderItemID)
+		}
+		left := item.Quantity - refunded[item.ID] - open[item.ID]
+		if line.Quantity > left {
+			return nil, fmt.Errorf("%w: only %d of order item %s can be returned", errInvalidReturn, left, item.ID)
+		}
+		open[item.ID] += line.Quantity
+		result = append(result, config.ReturnItem{
+			ID:              uuid.New().String(),
+			ReturnRequestID: returnID,
+			OrderItemID:     item.ID,
+			Quantity:        line.Quantity,
+		})
+	}
+	return result, nil
+}
+
+// respondReturnError maps a failed return action to an HTTP response.
+func respondReturnError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		utils.JSON(c, http.StatusNotFound, false, "return request not found", nil, nil)
+	case errors.Is(err, errInvalidReturn):
+		utils.JSON(c, http.StatusBadRequest, false, "invalid return", nil, err.Error())
+	case errors.Is(err, errReturnState):
+		utils.JSON(c, http.StatusConflict, false, err.Error(), nil, nil)
+	default:
+		respondRefundError(c, err)
+	}
+}
+
+// CreateReturn - customer asks to return lines of their order, optionally with photos
+func CreateReturn(db *gorm.DB, cfg *config.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var in returnInput
+		if err := bindReturnInput(c, &in); err != nil {
+			utils.JSON(c, http.StatusBadRequest, false, "validation error", nil, err.Error())
+			return
+		}
+		var photos []*multipart.FileHeader
+		if form, err := c.MultipartForm(); err == nil {
+			photos = form.File["photos"]
+		}
+		if len(photos) > maxReturnPhotos {
+			utils.JSON(c, http.StatusBadRequest, false, "validation error", nil, gin.H{"photos": fmt.Sprintf("at most %d photos", maxReturnPhotos)})
+			return
+		}
+
+		uid := currentUserID(c)
+		var order config.Order
+		err := db.First(&order, "id = ?", c.Param("id")).Error
+		if err != nil || uid == nil || order.UserID == nil || *order.UserID != *uid {
+			utils.JSON(c, http.StatusNotFound, false, "order not found", nil, nil)
+			return
+		}
+		if !returnableStatuses[order.Status] {
+			utils.JSON(c, http.StatusConflict, false, "order can't be returned", nil, "order is "+order.Status)
+			return
+		}
+		if time.Since(order.CreatedAt) > time.Duration(cfg.ReturnWindowDays)*24*time.Hour {
+			utils.JSON(c, http.StatusConflict, false, "return window has closed", nil,
+				fmt.Sprintf("returns are accepted for %d days after ordering", cfg.ReturnWindowDays))
+			return
+		}
+
+		rr := config.ReturnRequest{
+			ID:      uuid.New().String(),
+			OrderID: order.ID,
+			UserID:  uid,
+			Status:  config.ReturnStatusRequested,
+			Reason:  in.Reason,
+		}
+		err = db.Transaction(func(tx *gorm.DB) error {
+			// Lock the order so concurrent requests can't return the same units twice
+			if _, err := lockOrder(tx, order.ID); err != nil {
+				return err
+			}
+			var err error
+			if rr.Items, err = buildReturnItems(tx, &order, rr.ID, in.Items); err != nil {
+				return err
+			}
+			for _, file := range photos {
+				photoID := uuid.New().String()
+				url, err := utils.SaveUploadedFileTo(file, utils.ReturnPhotoDir, photoID)
+				if err != nil {
+					return err
+				}
+				rr.Photos = append(rr.Photos, config.ReturnPhoto{ID: photoID, ReturnRequestID: rr.ID, URL: url})
+			}
+			return tx.Create(&rr).Error
+		})
+		if err != nil {
+			// Don't leave photos of a rejected request behind
+			for _, photo := range rr.Photos {
+				os.Remove("." + photo.URL)
+			}
+			respondReturnError(c, err)
+			return
+		}
+		utils.JSON(c, http.StatusCreated, true, "return requested", rr, nil)
+	}
+}
+
+// ListOrderReturns - return requests of one of the current user's orders
+func ListOrderReturns(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uid := currentUserID(c)
+		var order config.Order
+		err := db.First(&order, "id = ?", c.Param("id")).Error
+		if err != nil || uid == nil || order.UserID == nil || *order.UserID != *uid {
+			utils.JSON(c, http.StatusNotFound, false, "order not found", nil, nil)
+			return
+		}
+		var returns []config.ReturnRequest
+		if err := db.Preload("Items").Preload("Photos").Where("order_id = ?", order.ID).Order("created_at").Find(&returns).Error; err != nil {
+			utils.JSON(c, http.StatusInternalServerError, false, "failed to fetch returns", nil, err.Error())
+			return
+		}
+		utils.JSON(c, http.StatusOK, true, "returns retrieved", returns, nil)
+	}
+}
+
+// AdminListReturns (Admin) - all return requests, optionally filtered by ?status=
+func AdminListReturns(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		query := db.Preload("Items").Preload("Photos").Order("created_at DESC")
+		if status := c.Query("status"); status != "" {
+			query = query.Where("status = ?", status)
+		}
+		var returns []config.ReturnRequest
+		if err := query.Find(&returns).Error; err != nil {
+			utils.JSON(c, http.StatusInternalServerError, false, "failed to fetch returns", nil, err.Error())
+			return
+		}
+		utils.JSON(c, http.StatusOK, true, "returns retrieved", returns, nil)
+	}
+}
+
+// lockReturn loads a return request for update and checks it is in status want.
+func lockReturn(tx *gorm.DB, id, want string) (*config.ReturnRequest, error) {
+	var rr config.ReturnRequest
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("Items").First(&rr, "id = ?", id).Error; err != nil {
+		return nil, err
+	}
+	if rr.Status != want {
+		return nil, fmt.Errorf("%w: return is %s", errReturnState, rr.Status)
+	}
+	return &rr, nil
+}
+
+// decideReturn moves a requested return to approved or rejected.
+func decideReturn(db *gorm.DB, to string, noteRequired bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var in struct {
+			Note string `json:"note"`
+		}
+		c.ShouldBindJSON(&in)
+		if noteRequired && in.Note == "" {
+			utils.JSON(c, http.StatusBadRequest, false, "validation error", nil, gin.H{"note": "note is required"})
+			return
+		}
+
+		var rr *config.ReturnRequest
+		err := db.Transaction(func(tx *gorm.DB) error {
+			var err error
+			if rr, err = lockReturn(tx, c.Param("id"), config.ReturnStatusRequested); err != nil {
+				return err
+			}
+			rr.Status, rr.AdminNote = to, in.Note
+			return tx.Model(rr).Updates(map[string]interface{}{"status": to, "admin_note": in.Note}).Error
+		})
+		if err != nil {
+			respondReturnError(c, err)
+			return
+		}
+		utils.JSON(c, http.StatusOK, true, "return "+to, rr, nil)
+	}
+}
+
+// ApproveReturn (Admin) - accepts a return request; the customer can send the goods
+func ApproveReturn(db *gorm.DB) gin.HandlerFunc {
+	return decideReturn(db, config.ReturnStatusApproved, false)
+}
+
+// RejectReturn (Admin) - declines a return request with a note for the customer
+func RejectReturn(db *gorm.DB) gin.HandlerFunc {
+	return decideReturn(db, config.ReturnStatusRejected, true)
+}
+
+// ReceiveReturn (Admin) - marks an approved return as received, restocks or writes
+// off the goods, and refunds the returned lines
+func ReceiveReturn(db *gorm.DB, provider payments.PaymentProvider) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var in struct {
+			Disposition string `json:"disposition" binding:"required,oneof=restock write_off"`
+			StoreCredit bool   `json:"store_credit"`
+		}
+		if err := c.ShouldBindJSON(&in); err != nil {
+			utils.JSON(c, http.StatusBadRequest, false, "validation error", nil, err.Error())
+			return
+		}
+
+		var rr *config.ReturnRequest
+		var refund *config.Refund
+		err := db.Transaction(func(tx *gorm.DB) error {
+			var err error
+			if rr, err = lockReturn(tx, c.Param("id"), config.ReturnStatusApproved); err != nil {
+				return err
+			}
+			order, err := lockOrder(tx, rr.OrderID)
+			if err != nil {
+				return err
+			}
+
+			req := refundRequest{
+				Reason:      "return " + rr.ID + ": " + rr.Reason,
+				Restock:     in.Disposition == config.ReturnDispositionRestock,
+				StoreCredit: in.StoreCredit,
+			}
+			for _, item := range rr.Items {
+				req.Items = append(req.Items, refundLine{OrderItemID: item.OrderItemID, Quantity: item.Quantity})
+			}
+			if refund, err = issueRefund(tx, order, req, currentUserID(c)); err != nil {
+				return err
+			}
+
+			rr.Status, rr.Disposition, rr.RefundID = config.ReturnStatusReceived, in.Disposition, &refund.ID
+			return tx.Model(rr).Updates(map[string]interface{}{
+				"status":      rr.Status,
+				"disposition": rr.Disposition,
+				"refund_id":   rr.RefundID,
+			}).Error
+		})
+		if err != nil {
+			respondReturnError(c, err)
+			return
+		}
+		result := gin.H{"return": rr, "refund": refund}
+		if err := payOutRefund(db, provider, refund); err != nil {
+			respondPayoutError(c, err, result)
+			return
+		}
+		utils.JSON(c, http.StatusOK, true, "return received", result, nil)
+	}
+}
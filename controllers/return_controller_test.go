@@ -0,0 +1,148 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"kalebecommerce/config"
+	"kalebecommerce/money"
+	"kalebecommerce/payments"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReturnWorkflow_ReceiveRefundsAndRestocks(t *testing.T) {
+	db := setupTestDB(t)
+	cfg := storeTestConfig()
+	mock := payments.NewMockProvider()
+	userID := uuid.New().String()
+	productID := uuid.New().String()
+	db.Create(&config.Product{ID: productID, Name: "Mug", Price: money.MustParse("8.00"), Stock: 5})
+	order := placePaidTestOrder(t, db, mock, userID, productID, 3)
+	db.Model(&config.Order{}).Where("id = ?", order.ID).Update("status", config.OrderStatusDelivered)
+	defer os.RemoveAll("uploads")
+
+	router := setupRouter()
+	router.POST("/orders/:id/returns", mockAuthMiddleware(userID), CreateReturn(db, cfg))
+	router.POST("/admin/returns/:id/approve", mockAdminAuthMiddleware(), ApproveReturn(db))
+	router.POST("/admin/returns/:id/receive", mockAdminAuthMiddleware(), ReceiveReturn(db, mock))
+
+	// Request a return of two units with a photo
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	writer.WriteField("reason", "arrived cracked")
+	writer.WriteField("items", `[{"order_item_id":"`+order.Items[0].ID+`","quantity":2}]`)
+	part, _ := writer.CreateFormFile("photos", "crack.jpg")
+	io.WriteString(part, "photo bytes")
+	writer.Close()
+
+	req, _ := http.NewRequest("POST", "/orders/"+order.ID+"/returns", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	var created struct {
+		Object config.ReturnRequest `json:"object"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &created)
+	rr := created.Object
+	if assert.Len(t, rr.Photos, 1) {
+		assert.FileExists(t, "."+rr.Photos[0].URL)
+	}
+
+	// The same units can't be returned twice while the first return is open
+	req, _ = http.NewRequest("POST", "/orders/"+order.ID+"/returns",
+		bytes.NewBufferString(`{"reason":"again","items":[{"order_item_id":"`+order.Items[0].ID+`","quantity":2}]}`))
+	req.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// Receiving needs approval first
+	req, _ = http.NewRequest("POST", "/admin/returns/"+rr.ID+"/receive", bytes.NewBufferString(`{"disposition":"restock"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	req, _ = http.NewRequest("POST", "/admin/returns/"+rr.ID+"/approve", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	req, _ = http.NewRequest("POST", "/admin/returns/"+rr.ID+"/receive", bytes.NewBufferString(`{"disposition":"restock"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var received config.ReturnRequest
+	db.First(&received, "id = ?", rr.ID)
+	assert.Equal(t, config.ReturnStatusReceived, received.Status)
+	assert.NotNil(t, received.RefundID)
+
+	var refunded config.Order
+	db.First(&refunded, "id = ?", order.ID)
+	assert.Equal(t, money.MustParse("16.00"), refunded.RefundedAmount)
+	assert.Equal(t, config.RefundStatusPartial, refunded.RefundStatus)
+
+	var p config.Product
+	db.First(&p, "id = ?", productID)
+	assert.Equal(t, 4, p.Stock)
+}
+
+func TestCreateReturn_WindowClosed(t *testing.T) {
+	db := setupTestDB(t)
+	userID := uuid.New()
+	order := config.Order{ID: uuid.New().String(), UserID: &userID, Status: config.OrderStatusDelivered,
+		CreatedAt: time.Now().AddDate(0, 0, -31)}
+	db.Create(&order)
+	item := config.OrderItem{ID: uuid.New().String(), OrderID: order.ID, ProductID: uuid.New(), Quantity: 1, UnitPrice: money.MustParse("8.00")}
+	db.Create(&item)
+
+	router := setupRouter()
+	router.POST("/orders/:id/returns", mockAuthMiddleware(userID.String()), CreateReturn(db, storeTestConfig()))
+
+	req, _ := http.NewRequest("POST", "/orders/"+order.ID+"/returns",
+		bytes.NewBufferString(`{"reason":"too late","items":[{"order_item_id":"`+item.ID+`","quantity":1}]}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Contains(t, w.Body.String(), "return window has closed")
+}
+
+func TestRejectReturn_RequiresNote(t *testing.T) {
+	db := setupTestDB(t)
+	rr := config.ReturnRequest{ID: uuid.New().String(), OrderID: uuid.New().String(), Status: config.ReturnStatusRequested, Reason: "changed mind"}
+	db.Create(&rr)
+
+	router := setupRouter()
+	router.POST("/admin/returns/:id/reject", mockAdminAuthMiddleware(), RejectReturn(db))
+
+	req, _ := http.NewRequest("POST", "/admin/returns/"+rr.ID+"/reject", bytes.NewBufferString(`{}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	req, _ = http.NewRequest("POST", "/admin/returns/"+rr.ID+"/reject", bytes.NewBufferString(`{"note":"item was used"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var rejected config.ReturnRequest
+	db.First(&rejected, "id = ?", rr.ID)
+	assert.Equal(t, config.ReturnStatusRejected, rejected.Status)
+	assert.Equal(t, "item was used", rejected.AdminNote)
+}
@@ -0,0 +1,357 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"kalebecommerce/config"
+	"kalebecommerce/utils"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// shippableStatuses are the order statuses in which packages may be sent.
+var shippableStatuses = map[string]bool{
+	config.OrderStatusPaid:       true,
+	config.OrderStatusProcessing: true,
+	config.OrderStatusShipped:    true,
+}
+
+// errInvalidShipment is returned for shipments the order can't satisfy.
+var errInvalidShipment = errors.New("invalid shipment")
+
+// shipmentLine packs quantity units of an order line.
+type shipmentLine struct {
+	OrderItemID string `json:"order_item_id" binding:"required"`
+	Quantity    int    `json:"quantity" binding:"required,min=1"`
+}
+
+// shipmentInput is the body of CreateShipment. Without items every unit not
+// shipped yet goes in the package; without shipped_at it left now.
+type shipmentInput struct {
+	Carrier        string         `json:"carrier" binding:"required"`
+	TrackingNumber string         `json:"tracking_number"`
+	TrackingURL    string         `json:"tracking_url"`
+	ShippedAt      *time.Time     `json:"shipped_at"`
+	Items          []shipmentLine `json:"items" binding:"dive"`
+}
+
+// shippedQuantities sums the units of each order line already in a shipment,
+// or only the delivered ones when deliveredOnly is set.
+func shippedQuantities(tx *gorm.DB, orderID string, deliveredOnly bool) (map[string]int, error) {
+	var rows []struct {
+		OrderItemID string
+		Quantity    int
+	}
+	query := tx.Model(&config.ShipmentItem{}).
+		Select("shipment_items.order_item_id, SUM(shipment_items.quantity) AS quantity").
+		Joins("JOIN shipments ON shipments.id = shipment_items.shipment_id").
+		Where("shipments.order_id = ?", orderID)
+	if deliveredOnly {
+		query = query.Where("shipments.delivered_at IS NOT NULL")
+	}
+	err := query.Group("shipment_items.order_item_id").Scan(&rows).Error
+	shipped := map[string]int{}
+	for _, r := range rows {
+		shipped[r.OrderItemID] = r.Quantity
+	}
+	return shipped, err
+}
+
+// buildShipment works out the lines of a new shipment for a locked order.
+// Refunded units are never shipped.
+func buildShipment(tx *gorm.DB, order *config.Order, in shipmentInput) (*config.Shipment, error) {
+	var items []config.OrderItem
+	if err := tx.Where("order_id = ?", order.ID).Find(&items).Error; err != nil {
+		return nil, err
+	}
+	shipped, err := shippedQuantities(tx, order.ID, false)
+	if err != nil {
+		return nil, err
+	}
+	refunded, err := refundedQuantities(tx, order.ID, false)
+	if err != nil {
+		return nil, err
+	}
+	left := func(item config.OrderItem) int {
+		return item.Quantity - shipped[item.ID] - refunded[item.ID]
+	}
+
+	shipment := &config.Shipment{
+		ID:             uuid.New().String(),
+		OrderID:        order.ID,
+		Carrier:        strings.TrimSpace(in.Carrier),
+		TrackingNumber: strings.TrimSpace(in.TrackingNumber),
+		TrackingURL:    strings.TrimSpace(in.TrackingURL),
+		ShippedAt:      time.Now(),
+	}
+	if in.ShippedAt != nil {
+		shipment.ShippedAt = *in.ShippedAt
+	}
+
+	if len(in.Items) == 0 {
+		for _, item := range items {
+			if n := left(item); n > 0 {
+				shipment.Items = append(shipment.Items, config.ShipmentItem{
+					ID: uuid.New().String(), ShipmentID: shipment.ID, OrderItemID: item.ID, Quantity: n,
+				})
+			}
+		}
+	} else {
+		byID := map[string]config.OrderItem{}
+		for _, item := range items {
+			byID[item.ID] = item
+		}
+		for _, line := range in.Items {
+			item, ok := byID[line.OrderItemID]
+			if !ok {
+				return nil, fmt.Errorf("%w: order item %s is not part of this order", errInvalidShipment, line.OrderItemID)
+			}
+			if line.Quantity > left(item) {
+				return nil, fmt.Errorf("%w: only %d of order item %s left to ship", errInvalidShipment, left(item), item.ID)
+			}
+			shipped[item.ID] += line.Quantity
+			shipment.Items = append(shipment.Items, config.ShipmentItem{
+				ID: uuid.New().String(), ShipmentID: shipment.ID, OrderItemID: item.ID, Quantity: line.Quantity,
+			})
+		}
+	}
+	if len(shipment.Items) == 0 {
+		return nil, fmt.Errorf("%w: nothing left to ship", errInvalidShipment)
+	}
+	return shipment, nil
+}
+
+// fulfillmentStatus derives an order's fulfillment from its shipped and
+// delivered units. Refunded units don't need to ship.
+func fulfillmentStatus(tx *gorm.DB, orderID string) (string, error) {
+	var items []config.OrderItem
+	if err := tx.Where("order_id = ?", orderID).Find(&items).Error; err != nil {
+		return "", err
+	}
+	shipped, err := shippedQuantities(tx, orderID, false)
+	if err != nil {
+		return "", err
+	}
+	delivered, err := shippedQuantities(tx, orderID, true)
+	if err != nil {
+		return "", err
+	}
+	refunded, err := refundedQuantities(tx, orderID, false)
+	if err != nil {
+		return "", err
+	}
+
+	anyShipped, allShipped, allDelivered := false, true, true
+	for _, item := range items {
+		due := item.Quantity - refunded[item.ID]
+		anyShipped = anyShipped || shipped[item.ID] > 0
+		allShipped = allShipped && shipped[item.ID] >= due
+		allDelivered = allDelivered && delivered[item.ID] >= due
+	}
+	switch {
+	case !anyShipped:
+		return config.FulfillmentUnfulfilled, nil
+	case allDelivered:
+		return config.FulfillmentDelivered, nil
+	case allShipped:
+		return config.FulfillmentShipped, nil
+	default:
+		return config.FulfillmentPartiallyShipped, nil
+	}
+}
+
+// fulfillmentStatuses are the known fulfillment states, for filtering.
+var fulfillmentStatuses = map[string]bool{
+	config.FulfillmentUnfulfilled:      true,
+	config.FulfillmentPartiallyShipped: true,
+	config.FulfillmentShipped:          true,
+	config.FulfillmentDelivered:        true,
+}
+
+// fulfillmentOrderStatus is the order status each fulfillment state moves an order to.
+var fulfillmentOrderStatus = map[string]string{
+	config.FulfillmentPartiallyShipped: config.OrderStatusProcessing,
+	config.FulfillmentShipped:          config.OrderStatusShipped,
+	config.FulfillmentDelivered:        config.OrderStatusDelivered,
+}
+
+// syncFulfillment stores a locked order's derived fulfillment status and walks
+// its order status forward (paid → processing → shipped → delivered) to match.
+func syncFulfillment(tx *gorm.DB, order *config.Order, actor *uuid.UUID) error {
+	status, err := fulfillmentStatus(tx, order.ID)
+	if err != nil {
+		return err
+	}
+	if err := tx.Model(order).Update("fulfillment_status", status).Error; err != nil {
+		return err
+	}
+	order.FulfillmentStatus = status
+
+	target, ok := fulfillmentOrderStatus[status]
+	if !ok {
+		return nil
+	}
+	path := []string{config.OrderStatusProcessing, config.OrderStatusShipped, config.OrderStatusDelivered}
+	for _, next := range path {
+		if canTransition(order.Status, next) {
+			if err := transitionOrder(tx, order, next, "fulfillment: "+status, actor); err != nil {
+				return err
+			}
+		}
+		if next == target {
+			break
+		}
+	}
+	return nil
+}
+
+// lockShipment loads a shipment and locks its order inside tx.
+func lockShipment(tx *gorm.DB, id string) (*config.Shipment, *config.Order, error) {
+	var shipment config.Shipment
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("Items").First(&shipment, "id = ?", id).Error; err != nil {
+		return nil, nil, err
+	}
+	order, err := lockOrder(tx, shipment.OrderID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &shipment, order, nil
+}
+
+// respondShipmentError maps a failed shipment change to an HTTP response.
+func respondShipmentError(c *gin.Context, err error) {
+	if errors.Is(err, errInvalidShipment) {
+		utils.JSON(c, http.StatusBadRequest, false, "invalid shipment", nil, err.Error())
+		return
+	}
+	respondTransitionError(c, err)
+}
+
+// CreateShipment (Admin) - records a package sent for some or all of an order's
+// remaining items and updates the order's fulfillment
+func CreateShipment(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var in shipmentInput
+		if err := c.ShouldBindJSON(&in); err != nil {
+			utils.JSON(c, http.StatusBadRequest, false, "validation error", nil, err.Error())
+			return
+		}
+
+		var shipment *config.Shipment
+		var order *config.Order
+		err := db.Transaction(func(tx *gorm.DB) error {
+			var err error
+			if order, err = lockOrder(tx, c.Param("id")); err != nil {
+				return err
+			}
+			if !shippableStatuses[order.Status] {
+				return fmt.Errorf("%w: %s orders can't be shipped", errIllegalTransition, order.Status)
+			}
+			if shipment, err = buildShipment(tx, order, in); err != nil {
+				return err
+			}
+			shipment.CreatedBy = currentUserID(c)
+			if err := tx.Create(shipment).Error; err != nil {
+				return err
+			}
+			return syncFulfillment(tx, order, currentUserID(c))
+		})
+		if err != nil {
+			respondShipmentError(c, err)
+			return
+		}
+		utils.JSON(c, http.StatusCreated, true, "shipment created", gin.H{"shipment": shipment, "order": order}, nil)
+	}
+}
+
+// ListShipments (Admin) - shipments of an order, oldest first
+func ListShipments(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var shipments []config.Shipment
+		if err := db.Preload("Items").Where("order_id = ?", c.Param("id")).Order("shipped_at, id").Find(&shipments).Error; err != nil {
+			utils.JSON(c, http.StatusInternalServerError, false, "failed to fetch shipments", nil, err.Error())
+			return
+		}
+		utils.JSON(c, http.StatusOK, true, "shipments retrieved", shipments, nil)
+	}
+}
+
+// UpdateShipment (Admin) - corrects a shipment's carrier and tracking details
+func UpdateShipment(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var in struct {
+			Carrier        string `json:"carrier" binding:"required"`
+			TrackingNumber string `json:"tracking_number"`
+			TrackingURL    string `json:"tracking_url"`
+		}
+		if err := c.ShouldBindJSON(&in); err != nil {
+			utils.JSON(c, http.StatusBadRequest, false, "validation error", nil, err.Error())
+			return
+		}
+
+		var shipment config.Shipment
+		if err := db.Preload("Items").First(&shipment, "id = ?", c.Param("id")).Error; err != nil {
+			utils.JSON(c, http.StatusNotFound, false, "shipment not found", nil, nil)
+			return
+		}
+		shipment.Carrier = strings.TrimSpace(in.Carrier)
+		shipment.TrackingNumber = strings.TrimSpace(in.TrackingNumber)
+		shipment.TrackingURL = strings.TrimSpace(in.TrackingURL)
+		if err := db.Omit("Items").Save(&shipment).Error; err != nil {
+			utils.JSON(c, http.StatusInternalServerError, false, "failed to update shipment", nil, err.Error())
+			return
+		}
+		utils.JSON(c, http.StatusOK, true, "shipment updated", shipment, nil)
+	}
+}
+
+// DeliverShipment (Admin) - marks a shipment delivered (optional delivered_at)
+// and updates the order's fulfillment
+func DeliverShipment(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var in struct {
+			DeliveredAt *time.Time `json:"delivered_at"`
+		}
+		// The body is optional
+		c.ShouldBindJSON(&in)
+		deliveredAt := time.Now()
+		if in.DeliveredAt != nil {
+			deliveredAt = *in.DeliveredAt
+		}
+
+		var shipment *config.Shipment
+		var order *config.Order
+		err := db.Transaction(func(tx *gorm.DB) error {
+			var err error
+			if shipment, order, err = lockShipment(tx, c.Param("id")); err != nil {
+				return err
+			}
+			if shipment.DeliveredAt != nil {
+				return fmt.Errorf("%w: shipment was already delivered", errInvalidShipment)
+			}
+			if deliveredAt.Before(shipment.ShippedAt) {
+				return fmt.Errorf("%w: delivered_at is before shipped_at", errInvalidShipment)
+			}
+			shipment.DeliveredAt = &deliveredAt
+			if err := tx.Model(shipment).Update("delivered_at", deliveredAt).Error; err != nil {
+				return err
+			}
+			return syncFulfillment(tx, order, currentUserID(c))
+		})
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			utils.JSON(c, http.StatusNotFound, false, "shipment not found", nil, nil)
+			return
+		}
+		if err != nil {
+			respondShipmentError(c, err)
+			return
+		}
+		utils.JSON(c, http.StatusOK, true, "shipment delivered", gin.H{"shipment": shipment, "order": order}, nil)
+	}
+}
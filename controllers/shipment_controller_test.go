@@ -0,0 +1,94 @@
+package controllers
+
+import (
+	"encoding/json"
+	"kalebecommerce/config"
+	"kalebecommerce/money"
+	"kalebecommerce/payments"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// shipmentResponse decodes CreateShipment and DeliverShipment responses
+type shipmentResponse struct {
+	Object struct {
+		Shipment config.Shipment `json:"shipment"`
+		Order    config.Order    `json:"order"`
+	} `json:"object"`
+}
+
+func TestShipments_DeriveFulfillment(t *testing.T) {
+	db := setupTestDB(t)
+	mock := payments.NewMockProvider()
+	userID := uuid.New().String()
+	productID := uuid.New().String()
+	db.Create(&config.Product{ID: productID, Name: "Mug", Price: money.MustParse("8.00"), Stock: 5})
+	order := placeTestOrder(t, db, userID, productID, 3)
+	itemID := order.Items[0].ID
+
+	router := setupRouter()
+	router.POST("/admin/orders/:id/shipments", mockAdminAuthMiddleware(), CreateShipment(db))
+	router.POST("/admin/shipments/:id/deliver", mockAdminAuthMiddleware(), DeliverShipment(db))
+	router.GET("/orders/:id", mockAuthMiddleware(userID), GetOrder(db))
+
+	// Unpaid orders can't ship
+	ship := `{"carrier":"DHL","tracking_number":"JD0001","items":[{"order_item_id":"` + itemID + `","quantity":2}]}`
+	assert.Equal(t, http.StatusConflict, sendJSON(router, "POST", "/admin/orders/"+order.ID+"/shipments", ship).Code)
+
+	payment := startTestPayment(t, db, storeTestConfig(), mock, userID, order.ID)
+	event, _ := mock.Simulate(payment.IntentID, true)
+	assert.NoError(t, applyPaymentEvent(db, mock, *event))
+
+	// First package: 2 of 3 units
+	w := sendJSON(router, "POST", "/admin/orders/"+order.ID+"/shipments", ship)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	var first shipmentResponse
+	json.Unmarshal(w.Body.Bytes(), &first)
+	assert.Equal(t, config.FulfillmentPartiallyShipped, first.Object.Order.FulfillmentStatus)
+	assert.Equal(t, config.OrderStatusProcessing, first.Object.Order.Status)
+
+	w = sendJSON(router, "POST", "/admin/orders/"+order.ID+"/shipments", ship)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "only 1 of order item")
+
+	// Second package: whatever is left
+	w = sendJSON(router, "POST", "/admin/orders/"+order.ID+"/shipments", `{"carrier":"EMS","tracking_number":"EE42"}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	var second shipmentResponse
+	json.Unmarshal(w.Body.Bytes(), &second)
+	assert.Equal(t, 1, second.Object.Shipment.Items[0].Quantity)
+	assert.Equal(t, config.FulfillmentShipped, second.Object.Order.FulfillmentStatus)
+	assert.Equal(t, config.OrderStatusShipped, second.Object.Order.Status)
+	assert.Equal(t, http.StatusBadRequest, sendJSON(router, "POST", "/admin/orders/"+order.ID+"/shipments", `{"carrier":"EMS"}`).Code)
+
+	// Delivering one package isn't enough; both are
+	assert.Equal(t, http.StatusOK, sendJSON(router, "POST", "/admin/shipments/"+first.Object.Shipment.ID+"/deliver", "").Code)
+	assert.Equal(t, http.StatusBadRequest, sendJSON(router, "POST", "/admin/shipments/"+first.Object.Shipment.ID+"/deliver", "").Code)
+	var stored config.Order
+	db.First(&stored, "id = ?", order.ID)
+	assert.Equal(t, config.FulfillmentShipped, stored.FulfillmentStatus)
+	w = sendJSON(router, "POST", "/admin/shipments/"+second.Object.Shipment.ID+"/deliver", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var delivered shipmentResponse
+	json.Unmarshal(w.Body.Bytes(), &delivered)
+	assert.Equal(t, config.FulfillmentDelivered, delivered.Object.Order.FulfillmentStatus)
+	assert.Equal(t, config.OrderStatusDelivered, delivered.Object.Order.Status)
+
+	// The customer sees both packages with tracking
+	req, _ := http.NewRequest("GET", "/orders/"+order.ID, nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	var response struct {
+		Object config.Order `json:"object"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	if assert.Len(t, response.Object.Shipments, 2) {
+		numbers := []string{response.Object.Shipments[0].TrackingNumber, response.Object.Shipments[1].TrackingNumber}
+		assert.ElementsMatch(t, []string{"JD0001", "EE42"}, numbers)
+		assert.NotNil(t, response.Object.Shipments[0].DeliveredAt)
+	}
+}
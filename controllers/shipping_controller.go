@@ -0,0 +1,358 @@
+package controllers
+
+import (
+	"errors"
+	"kalebecommerce/config"
+	"kalebecommerce/money"
+	"kalebecommerce/utils"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// shippingInput is how a signed-in customer picks where and how an order ships.
+// With neither address_id nor shipping_address the default saved address is used.
+type shippingInput struct {
+	AddressID        string          `json:"address_id"`
+	ShippingAddress  *config.Address `json:"shipping_address"`
+	ShippingMethodID string          `json:"shipping_method_id" binding:"required"`
+}
+
+// Errors returned when a shipping zone or method can't be used.
+var (
+	errShippingUnavailable = errors.New("shipping method is not available for this address")
+	errShippingZoneOverlap = errors.New("another shipping zone already covers one of these countries")
+)
+
+var countryPattern = regexp.MustCompile(`^[A-Z]{2}$`)
+
+// shippingZoneFor picks the zone that lists country, or else the catch-all
+// zone without countries. It returns nil when neither exists.
+func shippingZoneFor(db *gorm.DB, country string) (*config.ShippingZone, error) {
+	var zones []config.ShippingZone
+	if err := db.Find(&zones).Error; err != nil {
+		return nil, err
+	}
+	var fallback *config.ShippingZone
+	for i := range zones {
+		if zones[i].Countries.Contains(country) {
+			return &zones[i], nil
+		}
+		if len(zones[i].Countries) == 0 {
+			fallback = &zones[i]
+		}
+	}
+	return fallback, nil
+}
+
+// shippingCost is what m charges in pr's currency for an order with the given
+// subtotal (already in that currency) and total weight. Weight rates bill every
+// started kilogram.
+func shippingCost(m config.ShippingMethod, pr *pricing, subtotal money.Amount, weightGrams int) money.Amount {
+	switch m.RateType {
+	case config.ShippingRateWeight:
+		kg := (weightGrams + 999) / 1000
+		return pr.convert(m.Price + m.PerKg.Mul(kg))
+	case config.ShippingRateFreeOver:
+		if m.FreeOver != nil && subtotal >= pr.convert(*m.FreeOver) {
+			return 0
+		}
+	}
+	return pr.convert(m.Price)
+}
+
+// applyShipping checks the order's method against its shipping address and
+// adds the shipping cost to the order total.
+func applyShipping(tx *gorm.DB, order *config.Order, weightGrams int, pr *pricing) error {
+	if order.ShippingAddress.Country == "" {
+		return errAddressRequired
+	}
+	if order.ShippingMethodID == nil {
+		return errShippingUnavailable
+	}
+	zone, err := shippingZoneFor(tx, order.ShippingAddress.Country)
+	if err != nil {
+		return err
+	}
+	if zone == nil {
+		return errShippingUnavailable
+	}
+	var method config.ShippingMethod
+	err = tx.First(&method, "id = ? AND shipping_zone_id = ? AND active = ?", *order.ShippingMethodID, zone.ID, true).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return errShippingUnavailable
+	}
+	if err != nil {
+		return err
+	}
+
+	order.ShippingMethodName = method.Name
+	order.ShippingTotal = shippingCost(method, pr, order.Subtotal, weightGrams)
+	order.TotalPrice += order.ShippingTotal
+	return nil
+}
+
+// ListShippingMethods - the active methods shipping to ?country=, priced in ?currency=
+func ListShippingMethods(db *gorm.DB, cfg *config.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		pr, ok := requestPricing(c, db, cfg)
+		if !ok {
+			return
+		}
+		country := strings.ToUpper(c.Query("country"))
+		if !countryPattern.MatchString(country) {
+			utils.JSON(c, http.StatusBadRequest, false, "country must be an ISO 3166-1 alpha-2 code", nil, nil)
+			return
+		}
+		zone, err := shippingZoneFor(db, country)
+		if err != nil {
+			utils.JSON(c, http.StatusInternalServerError, false, "failed to load shipping zones", nil, err.Error())
+			return
+		}
+
+		methods := []config.ShippingMethod{}
+		if zone != nil {
+			db.Where("shipping_zone_id = ? AND active = ?", zone.ID, true).Order("price, name").Find(&methods)
+		}
+		for i := range methods {
+			methods[i].Price = pr.convert(methods[i].Price)
+			methods[i].PerKg = pr.convert(methods[i].PerKg)
+			if methods[i].FreeOver != nil {
+				freeOver := pr.convert(*methods[i].FreeOver)
+				methods[i].FreeOver = &freeOver
+			}
+		}
+		utils.JSON(c, http.StatusOK, true, "shipping methods listed", gin.H{
+			"country":  country,
+			"currency": pr.Currency,
+			"methods":  methods,
+		}, nil)
+	}
+}
+
+// shippingZoneInput is the body of CreateShippingZone and UpdateShippingZone.
+type shippingZoneInput struct {
+	Name      string   `json:"name" binding:"required"`
+	Countries []string `json:"countries"`
+}
+
+// bindShippingZone validates the request body into zone.
+func bindShippingZone(c *gin.Context, zone *config.ShippingZone) error {
+	var in shippingZoneInput
+	if err := c.ShouldBindJSON(&in); err != nil {
+		return err
+	}
+	zone.Name = strings.TrimSpace(in.Name)
+	zone.Countries = nil
+	for _, raw := range in.Countries {
+		country := strings.ToUpper(strings.TrimSpace(raw))
+		if !countryPattern.MatchString(country) {
+			return errors.New("countries must be ISO 3166-1 alpha-2 codes")
+		}
+		if !zone.Countries.Contains(country) {
+			zone.Countries = append(zone.Countries, country)
+		}
+	}
+	return nil
+}
+
+// saveShippingZone writes zone, refusing a country already covered by another
+// zone or a second catch-all zone.
+func saveShippingZone(db *gorm.DB, zone *config.ShippingZone) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var others []config.ShippingZone
+		if err := tx.Where("id <> ?", zone.ID).Find(&others).Error; err != nil {
+			return err
+		}
+		for _, other := range others {
+			if len(zone.Countries) == 0 && len(other.Countries) == 0 {
+				return errShippingZoneOverlap
+			}
+			for _, country := range zone.Countries {
+				if other.Countries.Contains(country) {
+					return errShippingZoneOverlap
+				}
+			}
+		}
+		return tx.Omit("Methods").Save(zone).Error
+	})
+}
+
+// respondShippingZoneSave maps a failed saveShippingZone to an HTTP response.
+func respondShippingZoneSave(c *gin.Context, err error) {
+	if errors.Is(err, errShippingZoneOverlap) {
+		utils.JSON(c, http.StatusConflict, false, "shipping zone overlaps", nil, err.Error())
+		return
+	}
+	utils.JSON(c, http.StatusInternalServerError, false, "failed to save shipping zone", nil, err.Error())
+}
+
+// ListShippingZones (Admin) - every shipping zone with all its methods
+func ListShippingZones(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var zones []config.ShippingZone
+		db.Preload("Methods").Order("name").Find(&zones)
+		utils.JSON(c, http.StatusOK, true, "shipping zones listed", zones, nil)
+	}
+}
+
+// CreateShippingZone (Admin) - adds a zone; an empty country list ships to the rest of the world
+func CreateShippingZone(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		zone := config.ShippingZone{ID: uuid.New().String()}
+		if err := bindShippingZone(c, &zone); err != nil {
+			utils.JSON(c, http.StatusBadRequest, false, "validation error", nil, err.Error())
+			return
+		}
+		if err := saveShippingZone(db, &zone); err != nil {
+			respondShippingZoneSave(c, err)
+			return
+		}
+		utils.JSON(c, http.StatusCreated, true, "shipping zone created", zone, nil)
+	}
+}
+
+// UpdateShippingZone (Admin) - renames a zone or replaces its countries
+func UpdateShippingZone(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var zone config.ShippingZone
+		if err := db.First(&zone, "id = ?", c.Param("id")).Error; err != nil {
+			utils.JSON(c, http.StatusNotFound, false, "shipping zone not found", nil, nil)
+			return
+		}
+		if err := bindShippingZone(c, &zone); err != nil {
+			utils.JSON(c, http.StatusBadRequest, false, "validation error", nil, err.Error())
+			return
+		}
+		if err := saveShippingZone(db, &zone); err != nil {
+			respondShippingZoneSave(c, err)
+			return
+		}
+		utils.JSON(c, http.StatusOK, true, "shipping zone updated", zone, nil)
+	}
+}
+
+// DeleteShippingZone (Admin) - removes a zone and its methods; placed orders keep the method name and cost
+func DeleteShippingZone(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var deleted int64
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Where("shipping_zone_id = ?", c.Param("id")).Delete(&config.ShippingMethod{}).Error; err != nil {
+				return err
+			}
+			res := tx.Delete(&config.ShippingZone{}, "id = ?", c.Param("id"))
+			deleted = res.RowsAffected
+			return res.Error
+		})
+		if err != nil {
+			utils.JSON(c, http.StatusInternalServerError, false, "failed to delete shipping zone", nil, err.Error())
+			return
+		}
+		if deleted == 0 {
+			utils.JSON(c, http.StatusNotFound, false, "shipping zone not found", nil, nil)
+			return
+		}
+		utils.JSON(c, http.StatusOK, true, "shipping zone deleted", nil, nil)
+	}
+}
+
+// shippingMethodInput is the body of CreateShippingMethod and UpdateShippingMethod.
+// Amounts are in the store base currency.
+type shippingMethodInput struct {
+	Name     string        `json:"name" binding:"required"`
+	RateType string        `json:"rate_type" binding:"required,oneof=flat weight free_over"`
+	Price    money.Amount  `json:"price"`
+	PerKg    money.Amount  `json:"per_kg"`
+	FreeOver *money.Amount `json:"free_over"`
+	Active   *bool         `json:"active"`
+}
+
+// bindShippingMethod validates the request body into method.
+func bindShippingMethod(c *gin.Context, method *config.ShippingMethod) error {
+	var in shippingMethodInput
+	if err := c.ShouldBindJSON(&in); err != nil {
+		return err
+	}
+	if in.Price < 0 || in.PerKg < 0 || (in.FreeOver != nil && *in.FreeOver < 0) {
+		return errors.New("shipping amounts cannot be negative")
+	}
+	if in.RateType == config.ShippingRateFreeOver && in.FreeOver == nil {
+		return errors.New("free_over is required for free_over rates")
+	}
+	method.Name = strings.TrimSpace(in.Name)
+	method.RateType = in.RateType
+	method.Price = in.Price
+	method.PerKg = 0
+	method.FreeOver = nil
+	switch in.RateType {
+	case config.ShippingRateWeight:
+		method.PerKg = in.PerKg
+	case config.ShippingRateFreeOver:
+		method.FreeOver = in.FreeOver
+	}
+	if in.Active != nil {
+		method.Active = *in.Active
+	}
+	return nil
+}
+
+// CreateShippingMethod (Admin) - adds a delivery option to a zone
+func CreateShippingMethod(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var zone config.ShippingZone
+		if err := db.First(&zone, "id = ?", c.Param("id")).Error; err != nil {
+			utils.JSON(c, http.StatusNotFound, false, "shipping zone not found", nil, nil)
+			return
+		}
+		method := config.ShippingMethod{ID: uuid.New().String(), ShippingZoneID: zone.ID, Active: true}
+		if err := bindShippingMethod(c, &method); err != nil {
+			utils.JSON(c, http.StatusBadRequest, false, "validation error", nil, err.Error())
+			return
+		}
+		if err := db.Create(&method).Error; err != nil {
+			utils.JSON(c, http.StatusInternalServerError, false, "failed to save shipping method", nil, err.Error())
+			return
+		}
+		utils.JSON(c, http.StatusCreated, true, "shipping method created", method, nil)
+	}
+}
+
+// UpdateShippingMethod (Admin) - replaces a method's rate or deactivates it
+func UpdateShippingMethod(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var method config.ShippingMethod
+		if err := db.First(&method, "id = ?", c.Param("id")).Error; err != nil {
+			utils.JSON(c, http.StatusNotFound, false, "shipping method not found", nil, nil)
+			return
+		}
+		if err := bindShippingMethod(c, &method); err != nil {
+			utils.JSON(c, http.StatusBadRequest, false, "validation error", nil, err.Error())
+			return
+		}
+		if err := db.Save(&method).Error; err != nil {
+			utils.JSON(c, http.StatusInternalServerError, false, "failed to save shipping method", nil, err.Error())
+			return
+		}
+		utils.JSON(c, http.StatusOK, true, "shipping method updated", method, nil)
+	}
+}
+
+// DeleteShippingMethod (Admin) - removes a method; placed orders keep its name and cost
+func DeleteShippingMethod(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		res := db.Delete(&config.ShippingMethod{}, "id = ?", c.Param("id"))
+		if res.Error != nil {
+			utils.JSON(c, http.StatusInternalServerError, false, "failed to delete shipping method", nil, res.Error.Error())
+			return
+		}
+		if res.RowsAffected == 0 {
+			utils.JSON(c, http.StatusNotFound, false, "shipping method not found", nil, nil)
+			return
+		}
+		utils.JSON(c, http.StatusOK, true, "shipping method deleted", nil, nil)
+	}
+}
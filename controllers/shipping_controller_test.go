@@ -0,0 +1,152 @@
+package controllers
+
+import (
+	"encoding/json"
+	"kalebecommerce/config"
+	"kalebecommerce/money"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+// testAddress is the shipping address used by order tests; it matches guestAddressJSON.
+var testAddress = config.Address{FullName: "Abebe Kebede", Line1: "Bole Road 12", City: "Addis Ababa", Country: "ET"}
+
+// testShippingMethod returns a free flat-rate method shipping everywhere,
+// creating it on first use.
+func testShippingMethod(db *gorm.DB) string {
+	var method config.ShippingMethod
+	if db.First(&method, "name = ?", "Test Post").Error == nil {
+		return method.ID
+	}
+	zone := config.ShippingZone{ID: uuid.New().String(), Name: "Everywhere"}
+	db.Create(&zone)
+	method = config.ShippingMethod{ID: uuid.New().String(), ShippingZoneID: zone.ID, Name: "Test Post", RateType: config.ShippingRateFlat, Active: true}
+	db.Create(&method)
+	return method.ID
+}
+
+// orderRequestJSON is a PlaceOrder body shipping items to testAddress by testShippingMethod.
+func orderRequestJSON(db *gorm.DB, items ...OrderItemRequest) []byte {
+	body, _ := json.Marshal(OrderRequest{Items: items, ShippingAddress: &testAddress, ShippingMethodID: testShippingMethod(db)})
+	return body
+}
+
+func TestShippingZones_MethodsByCountry(t *testing.T) {
+	db := setupTestDB(t)
+	router := setupRouter()
+	cfg := storeTestConfig()
+	router.POST("/admin/shipping-zones", mockAdminAuthMiddleware(), CreateShippingZone(db))
+	router.POST("/admin/shipping-zones/:id/methods", mockAdminAuthMiddleware(), CreateShippingMethod(db))
+	router.GET("/shipping/methods", ListShippingMethods(db, cfg))
+	db.Create(&config.ExchangeRate{Currency: "ETB", Rate: "50"})
+
+	createZone := func(body string) string {
+		w := sendJSON(router, "POST", "/admin/shipping-zones", body)
+		assert.Equal(t, http.StatusCreated, w.Code)
+		var response struct {
+			Object config.ShippingZone `json:"object"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return response.Object.ID
+	}
+	eastAfrica := createZone(`{"name":"East Africa","countries":["et","KE"]}`)
+	world := createZone(`{"name":"World"}`)
+	assert.Equal(t, http.StatusConflict, sendJSON(router, "POST", "/admin/shipping-zones", `{"name":"Kenya","countries":["KE"]}`).Code)
+	assert.Equal(t, http.StatusConflict, sendJSON(router, "POST", "/admin/shipping-zones", `{"name":"Rest"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, sendJSON(router, "POST", "/admin/shipping-zones", `{"name":"Bad","countries":["Ethiopia"]}`).Code)
+
+	methods := "/admin/shipping-zones/" + eastAfrica + "/methods"
+	assert.Equal(t, http.StatusBadRequest, sendJSON(router, "POST", methods, `{"name":"Free","rate_type":"free_over","price":"5.00"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, sendJSON(router, "POST", methods, `{"name":"Odd","rate_type":"hourly","price":"5.00"}`).Code)
+	assert.Equal(t, http.StatusCreated, sendJSON(router, "POST", methods, `{"name":"Courier","rate_type":"weight","price":"4.00","per_kg":"1.50"}`).Code)
+	assert.Equal(t, http.StatusCreated, sendJSON(router, "POST", methods, `{"name":"Retired","rate_type":"flat","price":"1.00","active":false}`).Code)
+	assert.Equal(t, http.StatusCreated, sendJSON(router, "POST", "/admin/shipping-zones/"+world+"/methods", `{"name":"Air Mail","rate_type":"flat","price":"20.00"}`).Code)
+
+	list := func(query string) []config.ShippingMethod {
+		req, _ := http.NewRequest("GET", "/shipping/methods?"+query, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		var response struct {
+			Object struct {
+				Methods []config.ShippingMethod `json:"methods"`
+			} `json:"object"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return response.Object.Methods
+	}
+	if found := list("country=ke&currency=ETB"); assert.Len(t, found, 1) {
+		assert.Equal(t, "Courier", found[0].Name)
+		assert.Equal(t, money.MustParse("200.00"), found[0].Price)
+		assert.Equal(t, money.MustParse("75.00"), found[0].PerKg)
+	}
+	if found := list("country=DE"); assert.Len(t, found, 1) {
+		assert.Equal(t, "Air Mail", found[0].Name)
+	}
+}
+
+func TestPlaceOrder_AddsShippingCost(t *testing.T) {
+	db := setupTestDB(t)
+	userID := uuid.New().String()
+	productID := uuid.New().String()
+	db.Create(&config.Product{ID: productID, Name: "Kettle", Price: money.MustParse("30.00"), Stock: 10, WeightGrams: 1200})
+
+	zone := config.ShippingZone{ID: uuid.New().String(), Name: "Ethiopia", Countries: config.CountryList{"ET"}}
+	db.Create(&zone)
+	courier := config.ShippingMethod{ID: uuid.New().String(), ShippingZoneID: zone.ID, Name: "Courier",
+		RateType: config.ShippingRateWeight, Price: money.MustParse("4.00"), PerKg: money.MustParse("1.50"), Active: true}
+	freeOver := money.MustParse("50.00")
+	standard := config.ShippingMethod{ID: uuid.New().String(), ShippingZoneID: zone.ID, Name: "Standard",
+		RateType: config.ShippingRateFreeOver, Price: money.MustParse("6.00"), FreeOver: &freeOver, Active: true}
+	db.Create(&courier)
+	db.Create(&standard)
+
+	router := setupRouter()
+	router.POST("/orders", mockAuthMiddleware(userID), PlaceOrder(db, storeTestConfig()))
+	place := func(body OrderRequest) *httptest.ResponseRecorder {
+		jsonBody, _ := json.Marshal(body)
+		return sendJSON(router, "POST", "/orders", string(jsonBody))
+	}
+	decode := func(w *httptest.ResponseRecorder) config.Order {
+		var response struct {
+			Object config.Order `json:"object"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return response.Object
+	}
+
+	// 2 x 1200 g starts three kilograms: 4.00 + 3 x 1.50
+	w := place(OrderRequest{Items: []OrderItemRequest{{ProductID: productID, Quantity: 2}}, ShippingAddress: &testAddress, ShippingMethodID: courier.ID})
+	assert.Equal(t, http.StatusCreated, w.Code)
+	order := decode(w)
+	assert.Equal(t, "Courier", order.ShippingMethodName)
+	assert.Equal(t, money.MustParse("8.50"), order.ShippingTotal)
+	assert.Equal(t, money.MustParse("68.50"), order.TotalPrice)
+
+	// Below the threshold standard shipping costs 6.00; from 50.00 it's free
+	w = place(OrderRequest{Items: []OrderItemRequest{{ProductID: productID, Quantity: 1}}, ShippingAddress: &testAddress, ShippingMethodID: standard.ID})
+	assert.Equal(t, money.MustParse("36.00"), decode(w).TotalPrice)
+	w = place(OrderRequest{Items: []OrderItemRequest{{ProductID: productID, Quantity: 2}}, ShippingAddress: &testAddress, ShippingMethodID: standard.ID})
+	assert.Equal(t, money.MustParse("60.00"), decode(w).TotalPrice)
+
+	// The zone doesn't ship to Kenya
+	kenya := testAddress
+	kenya.Country = "KE"
+	w = place(OrderRequest{Items: []OrderItemRequest{{ProductID: productID, Quantity: 1}}, ShippingAddress: &kenya, ShippingMethodID: courier.ID})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "invalid shipping method")
+
+	// No address and nothing saved in the address book
+	w = place(OrderRequest{Items: []OrderItemRequest{{ProductID: productID, Quantity: 1}}, ShippingMethodID: courier.ID})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "invalid shipping address")
+
+	var product config.Product
+	db.First(&product, "id = ?", productID)
+	assert.Equal(t, 5, product.Stock)
+}
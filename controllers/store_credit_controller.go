@@ -0,0 +1,132 @@
+package controllers
+
+import (
+	"errors"
+	"kalebecommerce/config"
+	"kalebecommerce/money"
+	"kalebecommerce/utils"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// adjustStoreCredit changes a customer's balance in currency by delta and
+// records entry in the ledger, opening the account on first use. The
+// conditional UPDATE keeps concurrent redemptions from taking the balance
+// below zero.
+func adjustStoreCredit(tx *gorm.DB, userID uuid.UUID, currency string, delta money.Amount, entry config.StoreCreditTransaction) error {
+	opened := config.StoreCreditAccount{ID: uuid.New().String(), UserID: userID, Currency: currency}
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&opened).Error; err != nil {
+		return err
+	}
+	var account config.StoreCreditAccount
+	if err := tx.First(&account, "user_id = ? AND currency = ?", userID, currency).Error; err != nil {
+		return err
+	}
+
+	res := tx.Model(&config.StoreCreditAccount{}).Where("id = ? AND balance + ? >= 0", account.ID, delta).
+		Update("balance", gorm.Expr("balance + ?", delta))
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return errInsufficientBalance
+	}
+	if err := tx.Select("balance").First(&account, "id = ?", account.ID).Error; err != nil {
+		return err
+	}
+	entry.ID = uuid.New().String()
+	entry.AccountID = account.ID
+	entry.UserID = userID
+	entry.Currency = currency
+	entry.Amount = delta
+	entry.BalanceAfter = account.Balance
+	return tx.Create(&entry).Error
+}
+
+// storeCreditStatement is a customer's balances and their ledger, newest entries first.
+func storeCreditStatement(db *gorm.DB, userID string) (gin.H, error) {
+	var accounts []config.StoreCreditAccount
+	if err := db.Where("user_id = ?", userID).Order("currency").Find(&accounts).Error; err != nil {
+		return nil, err
+	}
+	var entries []config.StoreCreditTransaction
+	if err := db.Where("user_id = ?", userID).Order("created_at DESC, id").Find(&entries).Error; err != nil {
+		return nil, err
+	}
+	return gin.H{"balances": accounts, "transactions": entries}, nil
+}
+
+// GetStoreCredit - the current user's store credit balances and history
+func GetStoreCredit(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		statement, err := storeCreditStatement(db, c.GetString("user_id"))
+		if err != nil {
+			utils.JSON(c, http.StatusInternalServerError, false, "failed to fetch store credit", nil, err.Error())
+			return
+		}
+		utils.JSON(c, http.StatusOK, true, "store credit retrieved", statement, nil)
+	}
+}
+
+// AdminGetStoreCredit (Admin) - a customer's store credit balances and history
+func AdminGetStoreCredit(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		statement, err := storeCreditStatement(db, c.Param("id"))
+		if err != nil {
+			utils.JSON(c, http.StatusInternalServerError, false, "failed to fetch store credit", nil, err.Error())
+			return
+		}
+		utils.JSON(c, http.StatusOK, true, "store credit retrieved", statement, nil)
+	}
+}
+
+// GrantStoreCredit (Admin) - adds goodwill credit to a customer, or takes some
+// back with a negative amount
+func GrantStoreCredit(db *gorm.DB, cfg *config.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var in struct {
+			Amount   money.Amount `json:"amount" binding:"required"`
+			Currency string       `json:"currency"`
+			Note     string       `json:"note" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&in); err != nil {
+			utils.JSON(c, http.StatusBadRequest, false, "validation error", nil, err.Error())
+			return
+		}
+		var user config.User
+		if err := db.First(&user, "id = ?", c.Param("id")).Error; err != nil {
+			utils.JSON(c, http.StatusNotFound, false, "user not found", nil, nil)
+			return
+		}
+		userID, _ := uuid.Parse(user.ID)
+		pr, err := loadPricing(db, cfg, in.Currency)
+		if err != nil {
+			utils.JSON(c, http.StatusBadRequest, false, "unsupported currency", nil, err.Error())
+			return
+		}
+
+		entry := config.StoreCreditTransaction{Type: config.LedgerGrant, Note: strings.TrimSpace(in.Note), CreatedBy: currentUserID(c)}
+		err = db.Transaction(func(tx *gorm.DB) error {
+			return adjustStoreCredit(tx, userID, pr.Currency, in.Amount, entry)
+		})
+		if errors.Is(err, errInsufficientBalance) {
+			utils.JSON(c, http.StatusBadRequest, false, "insufficient balance", nil, "store credit can't go below zero")
+			return
+		}
+		if err != nil {
+			utils.JSON(c, http.StatusInternalServerError, false, "failed to grant store credit", nil, err.Error())
+			return
+		}
+		statement, err := storeCreditStatement(db, user.ID)
+		if err != nil {
+			utils.JSON(c, http.StatusInternalServerError, false, "failed to fetch store credit", nil, err.Error())
+			return
+		}
+		utils.JSON(c, http.StatusCreated, true, "store credit granted", statement, nil)
+	}
+}
@@ -0,0 +1,219 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"kalebecommerce/config"
+	"kalebecommerce/tax"
+	"kalebecommerce/utils"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// taxCalculator is the built-in rules calculator over the configured tax zones.
+func taxCalculator(db *gorm.DB, cfg *config.Config) (tax.Calculator, error) {
+	var zones []config.TaxZone
+	if err := db.Preload("Rates").Find(&zones).Error; err != nil {
+		return nil, err
+	}
+	rules := tax.Rules{Inclusive: cfg.PricesIncludeTax}
+	for _, zone := range zones {
+		for _, rate := range zone.Rates {
+			rules.Rates = append(rules.Rates, tax.Rate{
+				Name:     rate.Name,
+				Country:  zone.Country,
+				Region:   zone.Region,
+				TaxClass: rate.TaxClass,
+				Percent:  rate.Rate,
+			})
+		}
+	}
+	return rules, nil
+}
+
+// applyOrderTaxes taxes the order's unsaved lines after their discounts, sets the order totals and
+// stores one tax line per rate. Orders without a shipping address are taxed
+// at the store's origin country.
+func applyOrderTaxes(tx *gorm.DB, cfg *config.Config, order *config.Order) error {
+	calc, err := taxCalculator(tx, cfg)
+	if err != nil {
+		return err
+	}
+	req := tax.Request{Country: order.ShippingAddress.Country, Region: order.ShippingAddress.Region}
+	if req.Country == "" {
+		req.Country = cfg.TaxOriginCountry
+	}
+	for _, item := range order.Items {
+		req.Lines = append(req.Lines, tax.Line{Amount: item.UnitPrice.Mul(item.Quantity) - item.DiscountAmount, TaxClass: item.TaxClass})
+	}
+	res, err := calc.Calculate(req)
+	if err != nil {
+		return err
+	}
+
+	for i := range order.Items {
+		order.Items[i].TaxAmount = res.Lines[i]
+	}
+	order.TaxTotal = res.Total
+	order.TaxesIncluded = res.Inclusive
+	order.TotalPrice = order.Subtotal - order.DiscountTotal
+	if !res.Inclusive {
+		order.TotalPrice += res.Total
+	}
+
+	for _, t := range res.Taxes {
+		line := config.OrderTaxLine{ID: uuid.New().String(), OrderID: order.ID, Name: t.Name, Rate: t.Rate, Amount: t.Amount}
+		if err := tx.Create(&line).Error; err != nil {
+			return err
+		}
+		order.TaxLines = append(order.TaxLines, line)
+	}
+	return nil
+}
+
+// taxZoneInput is the body of CreateTaxZone and UpdateTaxZone.
+type taxZoneInput struct {
+	Name    string `json:"name" binding:"required"`
+	Country string `json:"country" binding:"required,len=2"`
+	Region  string `json:"region"`
+	Rates   []struct {
+		Name     string      `json:"name" binding:"required"`
+		TaxClass string      `json:"tax_class"`
+		Rate     json.Number `json:"rate" binding:"required"`
+	} `json:"rates" binding:"required,min=1,dive"`
+}
+
+// errTaxZoneExists is returned when another zone covers the same country and region.
+var errTaxZoneExists = errors.New("a tax zone for this country and region already exists")
+
+// bindTaxZone validates the request body into zone and its rates.
+func bindTaxZone(c *gin.Context, zone *config.TaxZone) error {
+	var in taxZoneInput
+	if err := c.ShouldBindJSON(&in); err != nil {
+		return err
+	}
+	zone.Name = strings.TrimSpace(in.Name)
+	zone.Country = strings.ToUpper(in.Country)
+	zone.Region = strings.ToUpper(strings.TrimSpace(in.Region))
+	zone.Rates = nil
+	for _, r := range in.Rates {
+		class, err := parseTaxClass(r.TaxClass)
+		if err != nil {
+			return err
+		}
+		if _, err := tax.ParsePercent(r.Rate.String()); err != nil {
+			return errors.New("rate must be a percentage between 0 and 100 with at most 4 decimals")
+		}
+		zone.Rates = append(zone.Rates, config.TaxRate{
+			ID:       uuid.New().String(),
+			Name:     strings.TrimSpace(r.Name),
+			TaxClass: class,
+			Rate:     r.Rate.String(),
+		})
+	}
+	return nil
+}
+
+// saveTaxZone writes zone and replaces its rates, refusing a second zone for
+// the same country and region.
+func saveTaxZone(db *gorm.DB, zone *config.TaxZone) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var clash int64
+		tx.Model(&config.TaxZone{}).Where("country = ? AND region = ? AND id <> ?", zone.Country, zone.Region, zone.ID).Count(&clash)
+		if clash > 0 {
+			return errTaxZoneExists
+		}
+		if err := tx.Omit("Rates").Save(zone).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("tax_zone_id = ?", zone.ID).Delete(&config.TaxRate{}).Error; err != nil {
+			return err
+		}
+		for i := range zone.Rates {
+			zone.Rates[i].TaxZoneID = zone.ID
+		}
+		return tx.Create(&zone.Rates).Error
+	})
+}
+
+// respondTaxZoneSave maps a failed saveTaxZone to an HTTP response.
+func respondTaxZoneSave(c *gin.Context, err error) {
+	if errors.Is(err, errTaxZoneExists) {
+		utils.JSON(c, http.StatusConflict, false, "tax zone exists", nil, err.Error())
+		return
+	}
+	utils.JSON(c, http.StatusInternalServerError, false, "failed to save tax zone", nil, err.Error())
+}
+
+// ListTaxZones (Admin) - every tax zone with its rates
+func ListTaxZones(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var zones []config.TaxZone
+		db.Preload("Rates").Order("country, region").Find(&zones)
+		utils.JSON(c, http.StatusOK, true, "tax zones listed", zones, nil)
+	}
+}
+
+// CreateTaxZone (Admin) - adds a country or region with its rates
+func CreateTaxZone(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		zone := config.TaxZone{ID: uuid.New().String()}
+		if err := bindTaxZone(c, &zone); err != nil {
+			utils.JSON(c, http.StatusBadRequest, false, "validation error", nil, err.Error())
+			return
+		}
+		if err := saveTaxZone(db, &zone); err != nil {
+			respondTaxZoneSave(c, err)
+			return
+		}
+		utils.JSON(c, http.StatusCreated, true, "tax zone created", zone, nil)
+	}
+}
+
+// UpdateTaxZone (Admin) - replaces a zone's details and rates
+func UpdateTaxZone(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var zone config.TaxZone
+		if err := db.First(&zone, "id = ?", c.Param("id")).Error; err != nil {
+			utils.JSON(c, http.StatusNotFound, false, "tax zone not found", nil, nil)
+			return
+		}
+		if err := bindTaxZone(c, &zone); err != nil {
+			utils.JSON(c, http.StatusBadRequest, false, "validation error", nil, err.Error())
+			return
+		}
+		if err := saveTaxZone(db, &zone); err != nil {
+			respondTaxZoneSave(c, err)
+			return
+		}
+		utils.JSON(c, http.StatusOK, true, "tax zone updated", zone, nil)
+	}
+}
+
+// DeleteTaxZone (Admin) - removes a zone; orders already placed keep their tax lines
+func DeleteTaxZone(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var deleted int64
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Where("tax_zone_id = ?", c.Param("id")).Delete(&config.TaxRate{}).Error; err != nil {
+				return err
+			}
+			res := tx.Delete(&config.TaxZone{}, "id = ?", c.Param("id"))
+			deleted = res.RowsAffected
+			return res.Error
+		})
+		if err != nil {
+			utils.JSON(c, http.StatusInternalServerError, false, "failed to delete tax zone", nil, err.Error())
+			return
+		}
+		if deleted == 0 {
+			utils.JSON(c, http.StatusNotFound, false, "tax zone not found", nil, nil)
+			return
+		}
+		utils.JSON(c, http.StatusOK, true, "tax zone deleted", nil, nil)
+	}
+}
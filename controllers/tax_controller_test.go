@@ -0,0 +1,169 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"kalebecommerce/config"
+	"kalebecommerce/money"
+	"kalebecommerce/payments"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+// createTestTaxZone stores a zone with one rate per class/percent pair.
+func createTestTaxZone(db *gorm.DB, country, region string, rates ...config.TaxRate) {
+	zone := config.TaxZone{ID: uuid.New().String(), Name: country + region, Country: country, Region: region}
+	db.Create(&zone)
+	for _, rate := range rates {
+		rate.ID = uuid.New().String()
+		rate.TaxZoneID = zone.ID
+		db.Create(&rate)
+	}
+}
+
+// placeTaxedOrder places an order for userID through PlaceOrder with cfg.
+func placeTaxedOrder(t *testing.T, db *gorm.DB, cfg *config.Config, userID string, items []OrderItemRequest) config.Order {
+	router := setupRouter()
+	router.POST("/orders", mockAuthMiddleware(userID), PlaceOrder(db, cfg))
+
+	jsonBody := orderRequestJSON(db, items...)
+	req, _ := http.NewRequest("POST", "/orders", bytes.NewBuffer(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	var response struct {
+		Object config.Order `json:"object"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	return response.Object
+}
+
+func TestPlaceGuestOrder_AddsExclusiveTaxByClass(t *testing.T) {
+	db := setupTestDB(t)
+	router := setupRouter()
+	mug, book := uuid.New().String(), uuid.New().String()
+	db.Create(&config.Product{ID: mug, Name: "Mug", Price: money.MustParse("8.00"), Stock: 5})
+	db.Create(&config.Product{ID: book, Name: "Book", Price: money.MustParse("10.00"), Stock: 5, TaxClass: "books"})
+	createTestTaxZone(db, "ET", "", config.TaxRate{Name: "VAT", TaxClass: config.TaxClassStandard, Rate: "15"})
+
+	router.POST("/guest/orders", PlaceGuestOrder(db, mockConfig()))
+
+	body := `{"email":"guest@example.com","shipping_address":` + guestAddressJSON +
+		`,"shipping_method_id":"` + testShippingMethod(db) + `","items":[{"productId":"` + mug + `","quantity":2},{"productId":"` + book + `","quantity":1}]}`
+	req, _ := http.NewRequest("POST", "/guest/orders", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	var stored config.Order
+	db.Preload("Items").Preload("TaxLines").First(&stored)
+	assert.Equal(t, money.MustParse("26.00"), stored.Subtotal)
+	assert.Equal(t, money.MustParse("2.40"), stored.TaxTotal)
+	assert.Equal(t, money.MustParse("28.40"), stored.TotalPrice)
+	assert.False(t, stored.TaxesIncluded)
+	if assert.Len(t, stored.TaxLines, 1) {
+		assert.Equal(t, "VAT", stored.TaxLines[0].Name)
+		assert.Equal(t, "15", stored.TaxLines[0].Rate)
+		assert.Equal(t, money.MustParse("2.40"), stored.TaxLines[0].Amount)
+	}
+	for _, item := range stored.Items {
+		if item.ProductName == "Book" {
+			assert.Equal(t, money.Amount(0), item.TaxAmount) // no rate for the books class
+		} else {
+			assert.Equal(t, money.MustParse("2.40"), item.TaxAmount)
+		}
+	}
+}
+
+func TestPlaceOrder_InclusiveTax(t *testing.T) {
+	db := setupTestDB(t)
+	productID := uuid.New().String()
+	db.Create(&config.Product{ID: productID, Name: "Lamp", Price: money.MustParse("11.60"), Stock: 5})
+	createTestTaxZone(db, "ET", "", config.TaxRate{Name: "VAT", TaxClass: config.TaxClassStandard, Rate: "16"})
+
+	cfg := mockConfig()
+	cfg.PricesIncludeTax = true
+	order := placeTaxedOrder(t, db, cfg, uuid.New().String(), []OrderItemRequest{{ProductID: productID, Quantity: 1}})
+
+	assert.True(t, order.TaxesIncluded)
+	assert.Equal(t, money.MustParse("11.60"), order.Subtotal)
+	assert.Equal(t, money.MustParse("1.60"), order.TaxTotal)
+	assert.Equal(t, money.MustParse("11.60"), order.TotalPrice)
+	assert.Len(t, order.TaxLines, 1)
+}
+
+func TestTaxZones_RegionOverridesCountry(t *testing.T) {
+	db := setupTestDB(t)
+	router := setupRouter()
+	router.POST("/admin/tax-zones", mockAdminAuthMiddleware(), CreateTaxZone(db))
+
+	post := func(body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/admin/tax-zones", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	assert.Equal(t, http.StatusCreated, post(`{"name":"US","country":"us","rates":[{"name":"Federal","rate":"0"}]}`).Code)
+	assert.Equal(t, http.StatusCreated, post(`{"name":"California","country":"US","region":"ca","rates":[{"name":"State","rate":6},{"name":"County","rate":"1.25"}]}`).Code)
+	assert.Equal(t, http.StatusConflict, post(`{"name":"Again","country":"US","region":"CA","rates":[{"name":"State","rate":"6"}]}`).Code)
+	assert.Equal(t, http.StatusBadRequest, post(`{"name":"Bad","country":"DE","rates":[{"name":"VAT","rate":"101"}]}`).Code)
+	assert.Equal(t, http.StatusBadRequest, post(`{"name":"Empty","country":"DE","rates":[]}`).Code)
+
+	order := config.Order{
+		ID:              uuid.New().String(),
+		ShippingAddress: config.Address{Country: "US", Region: "CA"},
+		Subtotal:        money.MustParse("10.00"),
+		Items:           []config.OrderItem{{Quantity: 1, UnitPrice: money.MustParse("10.00"), TaxClass: config.TaxClassStandard}},
+	}
+	assert.NoError(t, applyOrderTaxes(db, mockConfig(), &order))
+	assert.Equal(t, money.MustParse("0.73"), order.TaxTotal) // state 0.60 + county 0.125 rounded to 0.13
+	assert.Equal(t, money.MustParse("10.73"), order.TotalPrice)
+	assert.Len(t, order.TaxLines, 2)
+
+	order = config.Order{
+		ID:              uuid.New().String(),
+		ShippingAddress: config.Address{Country: "US", Region: "NY"},
+		Subtotal:        money.MustParse("10.00"),
+		Items:           []config.OrderItem{{Quantity: 1, UnitPrice: money.MustParse("10.00"), TaxClass: config.TaxClassStandard}},
+	}
+	assert.NoError(t, applyOrderTaxes(db, mockConfig(), &order))
+	assert.Equal(t, money.Amount(0), order.TaxTotal)
+}
+
+func TestCreateRefund_LineIncludesItsTax(t *testing.T) {
+	db := setupTestDB(t)
+	mock := payments.NewMockProvider()
+	productID := uuid.New().String()
+	db.Create(&config.Product{ID: productID, Name: "Mug", Price: money.MustParse("10.00"), Stock: 5})
+	createTestTaxZone(db, "ET", "", config.TaxRate{Name: "VAT", TaxClass: config.TaxClassStandard, Rate: "15"})
+
+	cfg := storeTestConfig()
+	userID := uuid.New().String()
+	order := placeTaxedOrder(t, db, cfg, userID, []OrderItemRequest{{ProductID: productID, Quantity: 2}})
+	assert.Equal(t, money.MustParse("23.00"), order.TotalPrice)
+
+	payment := startTestPayment(t, db, cfg, mock, userID, order.ID)
+	event, err := mock.Simulate(payment.IntentID, true)
+	assert.NoError(t, err)
+	assert.NoError(t, applyPaymentEvent(db, mock, *event))
+
+	router := setupRouter()
+	router.POST("/admin/orders/:id/refunds", mockAuthMiddleware(uuid.New().String()), CreateRefund(db, mock))
+	w := sendJSON(router, "POST", "/admin/orders/"+order.ID+"/refunds", `{"items":[{"order_item_id":"`+order.Items[0].ID+`","quantity":1}],"reason":"damaged"}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Contains(t, w.Body.String(), `"amount":"11.50"`)
+
+	var refunded config.Order
+	db.First(&refunded, "id = ?", order.ID)
+	assert.Equal(t, money.MustParse("11.50"), refunded.RefundedAmount)
+}
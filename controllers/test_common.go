@@ -2,7 +2,12 @@ package controllers
 
 import (
 	"kalebecommerce/config"
+	"kalebecommerce/money"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/driver/sqlite"
@@ -15,7 +20,16 @@ func setupTestDB(t *testing.T) *gorm.DB {
 	if err != nil {
 		t.Fatalf("failed to connect to database: %v", err)
 	}
-	models := []interface{}{&config.User{}, &config.Product{}, &config.Order{}, &config.OrderItem{}}
+	models := []interface{}{&config.User{}, &config.Product{}, &config.Order{}, &config.OrderItem{}, &config.ImportJob{},
+		&config.ProductPriceChange{}, &config.ScheduledPrice{}, &config.Cart{}, &config.CartItem{}, &config.IdempotencyKey{},
+		&config.OrderEvent{}, &config.Payment{}, &config.Refund{}, &config.RefundItem{},
+		&config.ReturnRequest{}, &config.ReturnItem{}, &config.ReturnPhoto{}, &config.ProductPrice{}, &config.ExchangeRate{},
+		&config.TaxZone{}, &config.TaxRate{}, &config.OrderTaxLine{},
+		&config.UserAddress{}, &config.ShippingZone{}, &config.ShippingMethod{},
+		&config.Shipment{}, &config.ShipmentItem{},
+		&config.Promotion{}, &config.PromotionRedemption{}, &config.OrderDiscount{},
+		&config.GiftCard{}, &config.GiftCardTransaction{}, &config.StoreCreditAccount{}, &config.StoreCreditTransaction{}, &config.OrderTender{},
+		&config.LoyaltyRule{}, &config.LoyaltyAccount{}, &config.LoyaltyTransaction{}}
 
 	// Drop all tables
 	db.Migrator().DropTable(models...)
@@ -57,3 +71,24 @@ func mockAdminAuthMiddleware() gin.HandlerFunc {
 func mockConfig() *config.Config {
 	return &config.Config{JWTSecret: "testsecret"}
 }
+
+// storeTestConfig returns a config with the store currency, payment, return
+// and loyalty settings filled in
+func storeTestConfig() *config.Config {
+	cfg := mockConfig()
+	cfg.Currency = "USD"
+	cfg.PaymentWebhookSecret = "webhooksecret"
+	cfg.PaymentExpiry = 30 * time.Minute
+	cfg.ReturnWindowDays = 30
+	cfg.LoyaltyPointValue = money.MustParse("0.10")
+	return cfg
+}
+
+// sendJSON sends a JSON request through router.
+func sendJSON(router http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
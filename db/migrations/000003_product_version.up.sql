@@ -0,0 +1,2 @@
+-- products: optimistic concurrency version, exposed as the ETag
+ALTER TABLE products ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1;
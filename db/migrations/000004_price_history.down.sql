@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS scheduled_prices;
+DROP TABLE IF EXISTS product_price_changes;
@@ -0,0 +1,29 @@
+-- product_price_changes table (price history)
+CREATE TABLE IF NOT EXISTS product_price_changes (
+  id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+  product_id UUID NOT NULL,
+  old_price DOUBLE PRECISION NOT NULL,
+  new_price DOUBLE PRECISION NOT NULL,
+  source TEXT NOT NULL,
+  scheduled_price_id UUID,
+  changed_by UUID,
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS idx_product_price_changes_product_id ON product_price_changes (product_id);
+
+-- scheduled_prices table
+CREATE TABLE IF NOT EXISTS scheduled_prices (
+  id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+  product_id UUID NOT NULL,
+  price DOUBLE PRECISION NOT NULL,
+  starts_at TIMESTAMP WITH TIME ZONE NOT NULL,
+  ends_at TIMESTAMP WITH TIME ZONE,
+  status TEXT NOT NULL,
+  revert_price DOUBLE PRECISION,
+  created_by UUID,
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+  updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+  CONSTRAINT fk_scheduled_prices_product FOREIGN KEY (product_id) REFERENCES products(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_scheduled_prices_product_id ON scheduled_prices (product_id);
+CREATE INDEX IF NOT EXISTS idx_scheduled_prices_status ON scheduled_prices (status);
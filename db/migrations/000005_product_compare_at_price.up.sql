@@ -0,0 +1,2 @@
+-- products: optional compare-at ("was") price for sale badges
+ALTER TABLE products ADD COLUMN IF NOT EXISTS compare_at_price DOUBLE PRECISION;
@@ -0,0 +1,19 @@
+-- Guest orders can't get a user back: refuse to roll back rather than delete them
+DO $$
+BEGIN
+  IF EXISTS (SELECT 1 FROM orders WHERE user_id IS NULL) THEN
+    RAISE EXCEPTION 'orders without a user exist; attach or archive them before rolling back';
+  END IF;
+END $$;
+DROP INDEX IF EXISTS idx_orders_guest_email;
+ALTER TABLE orders
+  DROP COLUMN IF EXISTS guest_email,
+  DROP COLUMN IF EXISTS shipping_full_name,
+  DROP COLUMN IF EXISTS shipping_line1,
+  DROP COLUMN IF EXISTS shipping_line2,
+  DROP COLUMN IF EXISTS shipping_city,
+  DROP COLUMN IF EXISTS shipping_region,
+  DROP COLUMN IF EXISTS shipping_postal_code,
+  DROP COLUMN IF EXISTS shipping_country,
+  DROP COLUMN IF EXISTS shipping_phone;
+ALTER TABLE orders ALTER COLUMN user_id SET NOT NULL;
@@ -0,0 +1,12 @@
+-- guest orders have no user, only an email and a shipping address
+ALTER TABLE orders ALTER COLUMN user_id DROP NOT NULL;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS guest_email VARCHAR(255);
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS shipping_full_name VARCHAR(255);
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS shipping_line1 VARCHAR(255);
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS shipping_line2 VARCHAR(255);
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS shipping_city VARCHAR(255);
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS shipping_region VARCHAR(255);
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS shipping_postal_code VARCHAR(32);
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS shipping_country CHAR(2);
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS shipping_phone VARCHAR(64);
+CREATE INDEX IF NOT EXISTS idx_orders_guest_email ON orders (guest_email);
@@ -0,0 +1,13 @@
+-- idempotency_keys table (stored responses for retried order requests)
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+  id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+  scope TEXT NOT NULL,
+  key VARCHAR(255) NOT NULL,
+  fingerprint TEXT NOT NULL,
+  completed BOOLEAN NOT NULL DEFAULT false,
+  response_status INTEGER NOT NULL DEFAULT 0,
+  response_body TEXT,
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_idempotency_keys_scope_key ON idempotency_keys (scope, key);
+CREATE INDEX IF NOT EXISTS idx_idempotency_keys_created_at ON idempotency_keys (created_at);
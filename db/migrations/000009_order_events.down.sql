@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_orders_status;
+DROP TABLE IF EXISTS order_events;
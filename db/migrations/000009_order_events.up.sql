@@ -0,0 +1,17 @@
+-- order_events table (order status timeline)
+CREATE TABLE IF NOT EXISTS order_events (
+  id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+  order_id UUID NOT NULL,
+  from_status TEXT NOT NULL DEFAULT '',
+  to_status TEXT NOT NULL,
+  reason TEXT NOT NULL DEFAULT '',
+  actor_id UUID,
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+  CONSTRAINT fk_order_events_order FOREIGN KEY (order_id) REFERENCES orders(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_order_events_order_id ON order_events (order_id);
+CREATE INDEX IF NOT EXISTS idx_orders_status ON orders (status);
+
+-- existing orders start their timeline with the current status
+INSERT INTO order_events (order_id, from_status, to_status, reason, actor_id, created_at)
+SELECT id, '', status, 'order placed', user_id, created_at FROM orders;
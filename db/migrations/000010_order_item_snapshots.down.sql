@@ -0,0 +1,7 @@
+DELETE FROM order_items WHERE product_id NOT IN (SELECT id FROM products);
+ALTER TABLE order_items
+  ADD CONSTRAINT fk_orderitems_product FOREIGN KEY (product_id) REFERENCES products(id) ON DELETE RESTRICT;
+ALTER TABLE order_items
+  DROP COLUMN IF EXISTS product_image_url,
+  DROP COLUMN IF EXISTS product_sku,
+  DROP COLUMN IF EXISTS product_name;
@@ -0,0 +1,13 @@
+-- order_items: product snapshot taken at purchase time
+ALTER TABLE order_items ADD COLUMN IF NOT EXISTS product_name TEXT NOT NULL DEFAULT '';
+ALTER TABLE order_items ADD COLUMN IF NOT EXISTS product_sku TEXT;
+ALTER TABLE order_items ADD COLUMN IF NOT EXISTS product_image_url TEXT NOT NULL DEFAULT '';
+
+-- best-effort backfill of name and SKU from the current products
+UPDATE order_items oi
+SET product_name = COALESCE(p.name, ''), product_sku = p.sku
+FROM products p
+WHERE p.id = oi.product_id;
+
+-- products may now be deleted after being ordered; order lines keep the snapshot
+ALTER TABLE order_items DROP CONSTRAINT IF EXISTS fk_orderitems_product;
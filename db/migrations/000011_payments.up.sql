@@ -0,0 +1,18 @@
+-- payments table (one row per payment attempt on an order)
+CREATE TABLE IF NOT EXISTS payments (
+  id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+  order_id UUID NOT NULL,
+  provider TEXT NOT NULL,
+  intent_id TEXT NOT NULL UNIQUE,
+  client_secret TEXT NOT NULL DEFAULT '',
+  amount DOUBLE PRECISION NOT NULL,
+  currency CHAR(3) NOT NULL,
+  status TEXT NOT NULL,
+  failure_message TEXT NOT NULL DEFAULT '',
+  expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+  updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+  CONSTRAINT fk_payments_order FOREIGN KEY (order_id) REFERENCES orders(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_payments_order_id ON payments (order_id);
+CREATE INDEX IF NOT EXISTS idx_payments_status ON payments (status);
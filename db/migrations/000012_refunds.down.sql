@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS refund_items;
+DROP TABLE IF EXISTS refunds;
+ALTER TABLE orders DROP COLUMN IF EXISTS refunded_amount, DROP COLUMN IF EXISTS refund_status;
@@ -0,0 +1,32 @@
+-- orders: refund state derived from refunds
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS refund_status TEXT NOT NULL DEFAULT '';
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS refunded_amount DOUBLE PRECISION NOT NULL DEFAULT 0;
+
+-- refunds table
+CREATE TABLE IF NOT EXISTS refunds (
+  id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+  order_id UUID NOT NULL,
+  payment_id UUID NOT NULL,
+  provider_refund_id TEXT NOT NULL DEFAULT '',
+  amount DOUBLE PRECISION NOT NULL,
+  reason TEXT NOT NULL,
+  restock BOOLEAN NOT NULL DEFAULT false,
+  created_by UUID,
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+  CONSTRAINT fk_refunds_order FOREIGN KEY (order_id) REFERENCES orders(id) ON DELETE CASCADE,
+  CONSTRAINT fk_refunds_payment FOREIGN KEY (payment_id) REFERENCES payments(id)
+);
+CREATE INDEX IF NOT EXISTS idx_refunds_order_id ON refunds (order_id);
+
+-- refund_items table (order lines covered by a refund)
+CREATE TABLE IF NOT EXISTS refund_items (
+  id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+  refund_id UUID NOT NULL,
+  order_item_id UUID NOT NULL,
+  quantity INTEGER NOT NULL,
+  amount DOUBLE PRECISION NOT NULL,
+  CONSTRAINT fk_refund_items_refund FOREIGN KEY (refund_id) REFERENCES refunds(id) ON DELETE CASCADE,
+  CONSTRAINT fk_refund_items_order_item FOREIGN KEY (order_item_id) REFERENCES order_items(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_refund_items_refund_id ON refund_items (refund_id);
+CREATE INDEX IF NOT EXISTS idx_refund_items_order_item_id ON refund_items (order_item_id);
@@ -0,0 +1,39 @@
+-- return_requests table (customer returns / RMAs)
+CREATE TABLE IF NOT EXISTS return_requests (
+  id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+  order_id UUID NOT NULL,
+  user_id UUID,
+  status TEXT NOT NULL,
+  reason TEXT NOT NULL,
+  admin_note TEXT NOT NULL DEFAULT '',
+  disposition TEXT NOT NULL DEFAULT '',
+  refund_id UUID,
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+  updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+  CONSTRAINT fk_return_requests_order FOREIGN KEY (order_id) REFERENCES orders(id) ON DELETE CASCADE,
+  CONSTRAINT fk_return_requests_refund FOREIGN KEY (refund_id) REFERENCES refunds(id)
+);
+CREATE INDEX IF NOT EXISTS idx_return_requests_order_id ON return_requests (order_id);
+CREATE INDEX IF NOT EXISTS idx_return_requests_user_id ON return_requests (user_id);
+CREATE INDEX IF NOT EXISTS idx_return_requests_status ON return_requests (status);
+
+-- return_items table
+CREATE TABLE IF NOT EXISTS return_items (
+  id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+  return_request_id UUID NOT NULL,
+  order_item_id UUID NOT NULL,
+  quantity INTEGER NOT NULL,
+  CONSTRAINT fk_return_items_request FOREIGN KEY (return_request_id) REFERENCES return_requests(id) ON DELETE CASCADE,
+  CONSTRAINT fk_return_items_order_item FOREIGN KEY (order_item_id) REFERENCES order_items(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_return_items_return_request_id ON return_items (return_request_id);
+CREATE INDEX IF NOT EXISTS idx_return_items_order_item_id ON return_items (order_item_id);
+
+-- return_photos table
+CREATE TABLE IF NOT EXISTS return_photos (
+  id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+  return_request_id UUID NOT NULL,
+  url TEXT NOT NULL,
+  CONSTRAINT fk_return_photos_request FOREIGN KEY (return_request_id) REFERENCES return_requests(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_return_photos_return_request_id ON return_photos (return_request_id);
@@ -0,0 +1,17 @@
+ALTER TABLE orders DROP COLUMN IF EXISTS currency;
+
+ALTER TABLE refund_items ALTER COLUMN amount TYPE DOUBLE PRECISION USING amount / 100.0;
+ALTER TABLE refunds ALTER COLUMN amount TYPE DOUBLE PRECISION USING amount / 100.0;
+ALTER TABLE payments ALTER COLUMN amount TYPE DOUBLE PRECISION USING amount / 100.0;
+
+ALTER TABLE scheduled_prices ALTER COLUMN revert_price TYPE DOUBLE PRECISION USING revert_price / 100.0;
+ALTER TABLE scheduled_prices ALTER COLUMN price TYPE DOUBLE PRECISION USING price / 100.0;
+ALTER TABLE product_price_changes ALTER COLUMN new_price TYPE DOUBLE PRECISION USING new_price / 100.0;
+ALTER TABLE product_price_changes ALTER COLUMN old_price TYPE DOUBLE PRECISION USING old_price / 100.0;
+
+ALTER TABLE order_items ALTER COLUMN unit_price TYPE DOUBLE PRECISION USING unit_price / 100.0;
+ALTER TABLE orders ALTER COLUMN refunded_amount TYPE DOUBLE PRECISION USING refunded_amount / 100.0;
+ALTER TABLE orders ALTER COLUMN total_price TYPE DOUBLE PRECISION USING total_price / 100.0;
+
+ALTER TABLE products ALTER COLUMN compare_at_price TYPE DOUBLE PRECISION USING compare_at_price / 100.0;
+ALTER TABLE products ALTER COLUMN price TYPE DOUBLE PRECISION USING price / 100.0;
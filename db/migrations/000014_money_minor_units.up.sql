@@ -0,0 +1,28 @@
+-- money columns: store integer minor units (cents) instead of floating point
+ALTER TABLE products ALTER COLUMN price TYPE BIGINT USING ROUND(price * 100)::BIGINT;
+ALTER TABLE products ALTER COLUMN compare_at_price TYPE BIGINT USING ROUND(compare_at_price * 100)::BIGINT;
+
+ALTER TABLE orders ALTER COLUMN total_price TYPE BIGINT USING ROUND(total_price * 100)::BIGINT;
+ALTER TABLE orders ALTER COLUMN refunded_amount TYPE BIGINT USING ROUND(refunded_amount * 100)::BIGINT;
+ALTER TABLE order_items ALTER COLUMN unit_price TYPE BIGINT USING ROUND(unit_price * 100)::BIGINT;
+
+ALTER TABLE product_price_changes ALTER COLUMN old_price TYPE BIGINT USING ROUND(old_price * 100)::BIGINT;
+ALTER TABLE product_price_changes ALTER COLUMN new_price TYPE BIGINT USING ROUND(new_price * 100)::BIGINT;
+ALTER TABLE scheduled_prices ALTER COLUMN price TYPE BIGINT USING ROUND(price * 100)::BIGINT;
+ALTER TABLE scheduled_prices ALTER COLUMN revert_price TYPE BIGINT USING ROUND(revert_price * 100)::BIGINT;
+
+ALTER TABLE payments ALTER COLUMN amount TYPE BIGINT USING ROUND(amount * 100)::BIGINT;
+ALTER TABLE refunds ALTER COLUMN amount TYPE BIGINT USING ROUND(amount * 100)::BIGINT;
+ALTER TABLE refund_items ALTER COLUMN amount TYPE BIGINT USING ROUND(amount * 100)::BIGINT;
+
+-- orders: currency the totals are expressed in; existing orders used the store currency
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS currency CHAR(3);
+UPDATE orders o SET currency = p.currency
+FROM (SELECT DISTINCT ON (order_id) order_id, currency FROM payments ORDER BY order_id, created_at DESC) p
+WHERE o.id = p.order_id AND o.currency IS NULL;
+-- orders never paid: the store currency (STORE_CURRENCY), set with
+--   ALTER DATABASE <db> SET app.store_currency = 'EUR';
+-- before migrating when it isn't USD
+UPDATE orders SET currency = COALESCE(NULLIF(current_setting('app.store_currency', true), ''), 'USD')
+WHERE currency IS NULL;
+ALTER TABLE orders ALTER COLUMN currency SET NOT NULL;
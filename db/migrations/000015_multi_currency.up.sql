@@ -0,0 +1,24 @@
+-- exchange_rates table (units of a currency per unit of the store base currency)
+CREATE TABLE IF NOT EXISTS exchange_rates (
+  currency CHAR(3) PRIMARY KEY,
+  rate TEXT NOT NULL,
+  updated_by UUID,
+  updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
+
+-- product_prices table (per-currency price overrides)
+CREATE TABLE IF NOT EXISTS product_prices (
+  id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+  product_id UUID NOT NULL,
+  currency CHAR(3) NOT NULL,
+  price BIGINT NOT NULL,
+  compare_at_price BIGINT,
+  updated_by UUID,
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+  updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+  CONSTRAINT fk_product_prices_product FOREIGN KEY (product_id) REFERENCES products(id) ON DELETE CASCADE
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_product_prices_product_currency ON product_prices (product_id, currency);
+
+-- orders: exchange rate used at purchase; existing orders were in the base currency
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS exchange_rate TEXT NOT NULL DEFAULT '1';
@@ -0,0 +1,9 @@
+DROP TABLE IF EXISTS order_tax_lines;
+ALTER TABLE order_items DROP COLUMN IF EXISTS tax_amount;
+ALTER TABLE order_items DROP COLUMN IF EXISTS tax_class;
+ALTER TABLE orders DROP COLUMN IF EXISTS taxes_included;
+ALTER TABLE orders DROP COLUMN IF EXISTS tax_total;
+ALTER TABLE orders DROP COLUMN IF EXISTS subtotal;
+DROP TABLE IF EXISTS tax_rates;
+DROP TABLE IF EXISTS tax_zones;
+ALTER TABLE products DROP COLUMN IF EXISTS tax_class;
@@ -0,0 +1,44 @@
+-- products: tax class used to pick rates
+ALTER TABLE products ADD COLUMN IF NOT EXISTS tax_class VARCHAR(32) NOT NULL DEFAULT 'standard';
+
+-- tax_zones table (a country, optionally narrowed to a region)
+CREATE TABLE IF NOT EXISTS tax_zones (
+  id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+  name TEXT NOT NULL,
+  country CHAR(2) NOT NULL,
+  region TEXT NOT NULL DEFAULT '',
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+  updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_tax_zones_country_region ON tax_zones (country, region);
+
+-- tax_rates table (percentages per tax class within a zone)
+CREATE TABLE IF NOT EXISTS tax_rates (
+  id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+  tax_zone_id UUID NOT NULL,
+  name TEXT NOT NULL,
+  tax_class TEXT NOT NULL,
+  rate TEXT NOT NULL,
+  CONSTRAINT fk_tax_rates_zone FOREIGN KEY (tax_zone_id) REFERENCES tax_zones(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_tax_rates_tax_zone_id ON tax_rates (tax_zone_id);
+
+-- orders: subtotal and tax kept apart from the grand total; existing orders had no tax
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS subtotal BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS tax_total BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS taxes_included BOOLEAN NOT NULL DEFAULT false;
+UPDATE orders SET subtotal = total_price WHERE subtotal = 0;
+
+ALTER TABLE order_items ADD COLUMN IF NOT EXISTS tax_class TEXT NOT NULL DEFAULT '';
+ALTER TABLE order_items ADD COLUMN IF NOT EXISTS tax_amount BIGINT NOT NULL DEFAULT 0;
+
+-- order_tax_lines table (tax collected per rate, for invoices)
+CREATE TABLE IF NOT EXISTS order_tax_lines (
+  id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+  order_id UUID NOT NULL,
+  name TEXT NOT NULL,
+  rate TEXT NOT NULL,
+  amount BIGINT NOT NULL,
+  CONSTRAINT fk_order_tax_lines_order FOREIGN KEY (order_id) REFERENCES orders(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_order_tax_lines_order_id ON order_tax_lines (order_id);
@@ -0,0 +1,10 @@
+ALTER TABLE orders DROP COLUMN IF EXISTS shipping_total;
+ALTER TABLE orders DROP COLUMN IF EXISTS shipping_method_name;
+ALTER TABLE orders DROP COLUMN IF EXISTS shipping_method_id;
+ALTER TABLE products DROP COLUMN IF EXISTS height_mm;
+ALTER TABLE products DROP COLUMN IF EXISTS width_mm;
+ALTER TABLE products DROP COLUMN IF EXISTS length_mm;
+ALTER TABLE products DROP COLUMN IF EXISTS weight_grams;
+DROP TABLE IF EXISTS shipping_methods;
+DROP TABLE IF EXISTS shipping_zones;
+DROP TABLE IF EXISTS user_addresses;
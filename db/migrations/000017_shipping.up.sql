@@ -0,0 +1,55 @@
+-- user_addresses table (customer address book, one default per user)
+CREATE TABLE IF NOT EXISTS user_addresses (
+  id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+  user_id UUID NOT NULL,
+  label TEXT NOT NULL DEFAULT '',
+  full_name VARCHAR(255) NOT NULL,
+  line1 VARCHAR(255) NOT NULL,
+  line2 VARCHAR(255),
+  city VARCHAR(255) NOT NULL,
+  region VARCHAR(255),
+  postal_code VARCHAR(32),
+  country CHAR(2) NOT NULL,
+  phone VARCHAR(64),
+  is_default BOOLEAN NOT NULL DEFAULT false,
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+  updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+  CONSTRAINT fk_user_addresses_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_user_addresses_user_id ON user_addresses (user_id);
+
+-- shipping_zones table (comma-separated country codes; empty means the rest of the world)
+CREATE TABLE IF NOT EXISTS shipping_zones (
+  id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+  name TEXT NOT NULL,
+  countries TEXT NOT NULL DEFAULT '',
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+  updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
+
+-- shipping_methods table (flat, weight or free_over rates in the base currency)
+CREATE TABLE IF NOT EXISTS shipping_methods (
+  id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+  shipping_zone_id UUID NOT NULL,
+  name TEXT NOT NULL,
+  rate_type VARCHAR(16) NOT NULL,
+  price BIGINT NOT NULL,
+  per_kg BIGINT NOT NULL DEFAULT 0,
+  free_over BIGINT,
+  active BOOLEAN NOT NULL DEFAULT true,
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+  updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+  CONSTRAINT fk_shipping_methods_zone FOREIGN KEY (shipping_zone_id) REFERENCES shipping_zones(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_shipping_methods_shipping_zone_id ON shipping_methods (shipping_zone_id);
+
+-- products: shipping weight (grams) and dimensions (millimetres)
+ALTER TABLE products ADD COLUMN IF NOT EXISTS weight_grams INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE products ADD COLUMN IF NOT EXISTS length_mm INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE products ADD COLUMN IF NOT EXISTS width_mm INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE products ADD COLUMN IF NOT EXISTS height_mm INTEGER NOT NULL DEFAULT 0;
+
+-- orders: chosen method and its cost; the method name survives the method being deleted
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS shipping_method_id UUID;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS shipping_method_name TEXT NOT NULL DEFAULT '';
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS shipping_total BIGINT NOT NULL DEFAULT 0;
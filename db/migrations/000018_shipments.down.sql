@@ -0,0 +1,3 @@
+ALTER TABLE orders DROP COLUMN IF EXISTS fulfillment_status;
+DROP TABLE IF EXISTS shipment_items;
+DROP TABLE IF EXISTS shipments;
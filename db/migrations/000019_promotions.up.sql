@@ -0,0 +1,54 @@
+-- promotions table (automatic when code is NULL, otherwise a coupon; amounts in the base currency)
+CREATE TABLE IF NOT EXISTS promotions (
+  id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+  name TEXT NOT NULL,
+  code VARCHAR(32) UNIQUE,
+  discount_type VARCHAR(16) NOT NULL,
+  percent TEXT,
+  amount BIGINT NOT NULL DEFAULT 0,
+  min_subtotal BIGINT NOT NULL DEFAULT 0,
+  product_ids TEXT,
+  categories TEXT,
+  usage_limit INTEGER,
+  per_user_limit INTEGER,
+  times_used INTEGER NOT NULL DEFAULT 0,
+  starts_at TIMESTAMP WITH TIME ZONE,
+  ends_at TIMESTAMP WITH TIME ZONE,
+  active BOOLEAN NOT NULL DEFAULT true,
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+  updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
+
+-- promotion_redemptions table (one row per promotion used by an order, for per-customer limits)
+CREATE TABLE IF NOT EXISTS promotion_redemptions (
+  id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+  promotion_id UUID NOT NULL,
+  order_id UUID NOT NULL,
+  user_id UUID,
+  guest_email VARCHAR(255),
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+  CONSTRAINT fk_promotion_redemptions_promotion FOREIGN KEY (promotion_id) REFERENCES promotions(id) ON DELETE CASCADE,
+  CONSTRAINT fk_promotion_redemptions_order FOREIGN KEY (order_id) REFERENCES orders(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_promotion_redemptions_promotion_id ON promotion_redemptions (promotion_id);
+CREATE INDEX IF NOT EXISTS idx_promotion_redemptions_order_id ON promotion_redemptions (order_id);
+CREATE INDEX IF NOT EXISTS idx_promotion_redemptions_user_id ON promotion_redemptions (user_id);
+CREATE INDEX IF NOT EXISTS idx_promotion_redemptions_guest_email ON promotion_redemptions (guest_email);
+
+-- order_discounts table (discount lines; name and code are snapshots)
+CREATE TABLE IF NOT EXISTS order_discounts (
+  id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+  order_id UUID NOT NULL,
+  promotion_id UUID NOT NULL,
+  name TEXT NOT NULL,
+  code VARCHAR(32),
+  type VARCHAR(16) NOT NULL,
+  amount BIGINT NOT NULL,
+  CONSTRAINT fk_order_discounts_order FOREIGN KEY (order_id) REFERENCES orders(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_order_discounts_order_id ON order_discounts (order_id);
+
+-- orders and order_items: coupon used and discounts taken
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS coupon_code VARCHAR(32);
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS discount_total BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE order_items ADD COLUMN IF NOT EXISTS discount_amount BIGINT NOT NULL DEFAULT 0;
@@ -0,0 +1,11 @@
+ALTER TABLE refunds DROP COLUMN IF EXISTS store_credit_amount;
+ALTER TABLE refunds DROP COLUMN IF EXISTS tender_amount;
+ALTER TABLE refunds DROP COLUMN IF EXISTS provider_amount;
+ALTER TABLE order_items DROP COLUMN IF EXISTS gift_card;
+ALTER TABLE orders DROP COLUMN IF EXISTS tender_total;
+ALTER TABLE products DROP COLUMN IF EXISTS gift_card;
+DROP TABLE IF EXISTS order_tenders;
+DROP TABLE IF EXISTS store_credit_transactions;
+DROP TABLE IF EXISTS store_credit_accounts;
+DROP TABLE IF EXISTS gift_card_transactions;
+DROP TABLE IF EXISTS gift_cards;
@@ -0,0 +1,93 @@
+-- gift_cards table (prepaid balances in the currency they were issued in)
+CREATE TABLE IF NOT EXISTS gift_cards (
+  id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+  code VARCHAR(32) NOT NULL UNIQUE,
+  currency CHAR(3) NOT NULL,
+  initial_balance BIGINT NOT NULL,
+  balance BIGINT NOT NULL CHECK (balance >= 0),
+  order_id UUID,
+  order_item_id UUID,
+  purchaser_id UUID,
+  recipient_email VARCHAR(255),
+  note TEXT,
+  expires_at TIMESTAMP WITH TIME ZONE,
+  active BOOLEAN NOT NULL DEFAULT true,
+  created_by UUID,
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+  updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS idx_gift_cards_order_id ON gift_cards (order_id);
+CREATE INDEX IF NOT EXISTS idx_gift_cards_order_item_id ON gift_cards (order_item_id);
+
+-- gift_card_transactions table (ledger; negative amounts take balance off)
+CREATE TABLE IF NOT EXISTS gift_card_transactions (
+  id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+  gift_card_id UUID NOT NULL,
+  type VARCHAR(16) NOT NULL,
+  amount BIGINT NOT NULL,
+  balance_after BIGINT NOT NULL,
+  order_id UUID,
+  refund_id UUID,
+  note TEXT,
+  created_by UUID,
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+  CONSTRAINT fk_gift_card_transactions_card FOREIGN KEY (gift_card_id) REFERENCES gift_cards(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_gift_card_transactions_gift_card_id ON gift_card_transactions (gift_card_id);
+CREATE INDEX IF NOT EXISTS idx_gift_card_transactions_order_id ON gift_card_transactions (order_id);
+
+-- store_credit_accounts table (one balance per customer and currency)
+CREATE TABLE IF NOT EXISTS store_credit_accounts (
+  id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+  user_id UUID NOT NULL,
+  currency CHAR(3) NOT NULL,
+  balance BIGINT NOT NULL DEFAULT 0 CHECK (balance >= 0),
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+  updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+  CONSTRAINT fk_store_credit_accounts_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_store_credit_accounts_user_currency ON store_credit_accounts (user_id, currency);
+
+-- store_credit_transactions table (ledger; negative amounts take balance off)
+CREATE TABLE IF NOT EXISTS store_credit_transactions (
+  id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+  account_id UUID NOT NULL,
+  user_id UUID NOT NULL,
+  currency CHAR(3) NOT NULL,
+  type VARCHAR(16) NOT NULL,
+  amount BIGINT NOT NULL,
+  balance_after BIGINT NOT NULL,
+  order_id UUID,
+  refund_id UUID,
+  note TEXT,
+  created_by UUID,
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+  CONSTRAINT fk_store_credit_transactions_account FOREIGN KEY (account_id) REFERENCES store_credit_accounts(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_store_credit_transactions_account_id ON store_credit_transactions (account_id);
+CREATE INDEX IF NOT EXISTS idx_store_credit_transactions_user_id ON store_credit_transactions (user_id);
+CREATE INDEX IF NOT EXISTS idx_store_credit_transactions_order_id ON store_credit_transactions (order_id);
+
+-- order_tenders table (parts of orders paid with gift cards or store credit)
+CREATE TABLE IF NOT EXISTS order_tenders (
+  id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+  order_id UUID NOT NULL,
+  type VARCHAR(16) NOT NULL,
+  gift_card_id UUID,
+  code_last4 VARCHAR(4),
+  amount BIGINT NOT NULL,
+  refunded_amount BIGINT NOT NULL DEFAULT 0,
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+  CONSTRAINT fk_order_tenders_order FOREIGN KEY (order_id) REFERENCES orders(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_order_tenders_order_id ON order_tenders (order_id);
+
+-- products, orders, order_items and refunds
+ALTER TABLE products ADD COLUMN IF NOT EXISTS gift_card BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS tender_total BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE order_items ADD COLUMN IF NOT EXISTS gift_card BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE refunds ADD COLUMN IF NOT EXISTS provider_amount BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE refunds ADD COLUMN IF NOT EXISTS tender_amount BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE refunds ADD COLUMN IF NOT EXISTS store_credit_amount BIGINT NOT NULL DEFAULT 0;
+-- Earlier refunds all went through the payment provider
+UPDATE refunds SET provider_amount = amount WHERE provider_amount = 0;
@@ -0,0 +1,13 @@
+ALTER TABLE orders DROP COLUMN IF EXISTS points_earned;
+ALTER TABLE orders DROP COLUMN IF EXISTS points_redeemed;
+-- Points discount lines have no promotion: refuse to roll back rather than delete them
+DO $$
+BEGIN
+  IF EXISTS (SELECT 1 FROM order_discounts WHERE promotion_id IS NULL) THEN
+    RAISE EXCEPTION 'orders paid partly with loyalty points exist; archive them before rolling back';
+  END IF;
+END $$;
+ALTER TABLE order_discounts ALTER COLUMN promotion_id SET NOT NULL;
+DROP TABLE IF EXISTS loyalty_transactions;
+DROP TABLE IF EXISTS loyalty_accounts;
+DROP TABLE IF EXISTS loyalty_rules;
@@ -0,0 +1,49 @@
+-- loyalty_rules table (points per unit of base currency, by product, category or store-wide)
+CREATE TABLE IF NOT EXISTS loyalty_rules (
+  id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+  name VARCHAR(255) NOT NULL,
+  product_id UUID,
+  category VARCHAR(255),
+  points_per_unit VARCHAR(16) NOT NULL,
+  active BOOLEAN NOT NULL DEFAULT true,
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+  updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+  CONSTRAINT fk_loyalty_rules_product FOREIGN KEY (product_id) REFERENCES products(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_loyalty_rules_product_id ON loyalty_rules (product_id);
+CREATE INDEX IF NOT EXISTS idx_loyalty_rules_category ON loyalty_rules (category);
+
+-- loyalty_accounts table (one points balance per customer; negative while refunds
+-- took back points the customer had already spent)
+CREATE TABLE IF NOT EXISTS loyalty_accounts (
+  user_id UUID PRIMARY KEY,
+  balance INTEGER NOT NULL DEFAULT 0,
+  updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+  CONSTRAINT fk_loyalty_accounts_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- loyalty_transactions table (ledger; entries adding points are lots spent oldest first)
+CREATE TABLE IF NOT EXISTS loyalty_transactions (
+  id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+  user_id UUID NOT NULL,
+  type VARCHAR(16) NOT NULL,
+  points INTEGER NOT NULL,
+  balance_after INTEGER NOT NULL,
+  remaining INTEGER NOT NULL DEFAULT 0 CHECK (remaining >= 0),
+  order_id UUID,
+  note TEXT,
+  created_by UUID,
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+  CONSTRAINT fk_loyalty_transactions_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_loyalty_transactions_user_id ON loyalty_transactions (user_id);
+CREATE INDEX IF NOT EXISTS idx_loyalty_transactions_order_id ON loyalty_transactions (order_id);
+-- The expiry worker looks for lots with points left
+CREATE INDEX IF NOT EXISTS idx_loyalty_transactions_open_lots ON loyalty_transactions (created_at) WHERE remaining > 0;
+
+-- order_discounts: points discounts don't come from a promotion
+ALTER TABLE order_discounts ALTER COLUMN promotion_id DROP NOT NULL;
+
+-- orders
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS points_redeemed INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS points_earned INTEGER NOT NULL DEFAULT 0;
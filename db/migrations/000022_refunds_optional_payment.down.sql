@@ -0,0 +1,8 @@
+-- Refunds without a payment can't be kept once payment_id is required again
+DO $$
+BEGIN
+  IF EXISTS (SELECT 1 FROM refunds WHERE payment_id IS NULL) THEN
+    RAISE EXCEPTION 'refunds without a payment exist; migrate them before rolling back';
+  END IF;
+END $$;
+ALTER TABLE refunds ALTER COLUMN payment_id SET NOT NULL;
@@ -0,0 +1,2 @@
+-- refunds: paid out only to gift cards or store credit have no card payment
+ALTER TABLE refunds ALTER COLUMN payment_id DROP NOT NULL;
@@ -0,0 +1,5 @@
+-- refunds: the card payout is sent after the refund commits and can be retried
+ALTER TABLE refunds ADD COLUMN IF NOT EXISTS provider_status VARCHAR(20) NOT NULL DEFAULT '';
+ALTER TABLE refunds ADD COLUMN IF NOT EXISTS provider_error TEXT NOT NULL DEFAULT '';
+-- Existing refunds were paid out in their own transaction
+UPDATE refunds SET provider_status = 'succeeded' WHERE provider_amount > 0;
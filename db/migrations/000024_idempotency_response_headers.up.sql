@@ -0,0 +1,2 @@
+-- idempotency_keys: headers such as Set-Cookie are replayed with the stored response
+ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS response_headers TEXT;
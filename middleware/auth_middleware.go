@@ -1,6 +1,7 @@
 package middleware
 
 import (
+	"errors"
 	"kalebecommerce/config"
 	"net/http"
 	"strings"
@@ -9,6 +10,23 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 )
 
+// parseToken validates a "Bearer <jwt>" header value and returns its claims.
+func parseToken(cfg *config.Config, auth string) (jwt.MapClaims, error) {
+	// Remove "Bearer " prefix if present
+	tokenStr := strings.TrimPrefix(auth, "Bearer ")
+
+	token, err := jwt.Parse(tokenStr, func(t *jwt.Token) (interface{}, error) {
+		// Return the secret key for validation
+		return []byte(cfg.JWTSecret), nil
+	})
+	if err != nil || !token.Valid {
+		return nil, errors.New("invalid token")
+	}
+
+	// Extract claims (data) from the token
+	return token.Claims.(jwt.MapClaims), nil
+}
+
 // AuthRequired middleware ensures that a valid JWT token is provided
 // before allowing access to protected routes.
 func AuthRequired(cfg *config.Config) gin.HandlerFunc {
@@ -21,24 +39,15 @@ func AuthRequired(cfg *config.Config) gin.HandlerFunc {
 			return
 		}
 
-		// Remove "Bearer " prefix if present
-		tokenStr := strings.TrimPrefix(auth, "Bearer ")
-
 		// Parse and validate the JWT token
-		token, err := jwt.Parse(tokenStr, func(t *jwt.Token) (interface{}, error) {
-			// Return the secret key for validation
-			return []byte(cfg.JWTSecret), nil
-		})
+		claims, err := parseToken(cfg, auth)
 
 		// If token is invalid or parsing failed, reject the request
-		if err != nil || !token.Valid {
+		if err != nil {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "invalid token"})
 			return
 		}
 
-		// Extract claims (data) from the token
-		claims := token.Claims.(jwt.MapClaims)
-
 		// Store user_id and role in the Gin context for downstream handlers
 		c.Set("user_id", claims["user_id"])
 		c.Set("role", claims["role"])
@@ -48,6 +57,20 @@ func AuthRequired(cfg *config.Config) gin.HandlerFunc {
 	}
 }
 
+// OptionalAuth middleware identifies the user when a valid JWT token is sent,
+// but lets anonymous requests through (e.g. guest carts).
+func OptionalAuth(cfg *config.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if auth := c.GetHeader("Authorization"); auth != "" {
+			if claims, err := parseToken(cfg, auth); err == nil {
+				c.Set("user_id", claims["user_id"])
+				c.Set("role", claims["role"])
+			}
+		}
+		c.Next()
+	}
+}
+
 // AdminOnly middleware restricts access to only users with the "Admin" role.
 func AdminOnly() gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -0,0 +1,156 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"kalebecommerce/config"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// IdempotencyKeyHeader is the request header clients use to make retries safe.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// replayedHeaders are the response headers stored with a response and sent
+// again on replay, such as the cart token a checkout sets or clears.
+var replayedHeaders = []string{"Content-Type", "Set-Cookie", "ETag", "Location", "X-Cart-Token"}
+
+// responseRecorder keeps a copy of everything the handler writes.
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *responseRecorder) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *responseRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// idempotencyScope is who a key belongs to: the user, or for guests their cart
+// token, falling back to their address and user agent. Keys are per client and
+// per endpoint, so one client's response is never replayed to another.
+func idempotencyScope(c *gin.Context) string {
+	scope := c.Request.Method + " " + c.FullPath() + " "
+	if userID := c.GetString("user_id"); userID != "" {
+		return scope + "user:" + userID
+	}
+	client := c.GetHeader("X-Cart-Token")
+	if client == "" {
+		client, _ = c.Cookie("cart_token")
+	}
+	if client == "" {
+		client = c.ClientIP() + " " + c.Request.UserAgent()
+	}
+	sum := sha256.Sum256([]byte(client))
+	return scope + "guest:" + hex.EncodeToString(sum[:])
+}
+
+// Idempotency middleware stores the response of requests sent with an
+// Idempotency-Key header and replays it, with its headers, for retries with the
+// same key, query and body.
+// A key reused with a different body gets 422; a retry while the first request
+// is still running gets 409. Keys are kept for the retention window.
+func Idempotency(db *gorm.DB, retention time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(IdempotencyKeyHeader)
+		if key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > 255 {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "idempotency key too long"})
+			return
+		}
+
+		// Read the body for the fingerprint, then put it back for the handler
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "failed to read request body"})
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		sum := sha256.Sum256(append([]byte(c.Request.Method+" "+c.FullPath()+"?"+c.Request.URL.RawQuery+"\n"), body...))
+		fingerprint := hex.EncodeToString(sum[:])
+		scope := idempotencyScope(c)
+
+		db.Where("created_at < ?", time.Now().Add(-retention)).Delete(&config.IdempotencyKey{})
+
+		record := config.IdempotencyKey{ID: uuid.New().String(), Scope: scope, Key: key, Fingerprint: fingerprint}
+		res := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&record)
+		if res.Error != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "failed to store idempotency key"})
+			return
+		}
+		if res.RowsAffected == 0 {
+			var existing config.IdempotencyKey
+			if err := db.Where("scope = ? AND key = ?", scope, key).First(&existing).Error; err != nil {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "failed to load idempotency key"})
+				return
+			}
+			switch {
+			case existing.Fingerprint != fingerprint:
+				c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{"message": "idempotency key was already used with a different request"})
+			case !existing.Completed:
+				c.AbortWithStatusJSON(http.StatusConflict, gin.H{"message": "a request with this idempotency key is still in progress"})
+			default:
+				var headers http.Header
+				json.Unmarshal([]byte(existing.ResponseHeaders), &headers)
+				for name, values := range headers {
+					c.Writer.Header()[name] = values
+				}
+				contentType := headers.Get("Content-Type")
+				if contentType == "" {
+					contentType = "application/json; charset=utf-8"
+				}
+				c.Header("Idempotent-Replayed", "true")
+				c.Data(existing.ResponseStatus, contentType, []byte(existing.ResponseBody))
+				c.Abort()
+			}
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+		// A panicking handler must not leave the key stuck as in progress
+		defer func() {
+			if r := recover(); r != nil {
+				db.Delete(&record)
+				panic(r)
+			}
+		}()
+		c.Next()
+
+		// Server errors are not remembered, so the client can retry them
+		if recorder.Status() >= http.StatusInternalServerError {
+			db.Delete(&record)
+			return
+		}
+		headers := http.Header{}
+		for _, name := range replayedHeaders {
+			if values := recorder.Header().Values(name); len(values) > 0 {
+				headers[name] = values
+			}
+		}
+		stored, _ := json.Marshal(headers)
+		db.Model(&record).Updates(map[string]interface{}{
+			"completed":        true,
+			"response_status":  recorder.Status(),
+			"response_headers": string(stored),
+			"response_body":    recorder.body.String(),
+		})
+	}
+}
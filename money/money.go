@@ -0,0 +1,175 @@
+// Package money represents amounts as integer minor units (cents) so sums and
+// products are exact. Amounts travel as decimal strings such as "12.50".
+package money
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Amount is a sum of money in minor units (1/100 of the currency unit).
+type Amount int64
+
+// ErrInvalidAmount is returned for text that isn't a decimal with at most two fraction digits.
+var ErrInvalidAmount = errors.New("invalid money amount")
+
+// Parse reads a decimal such as "12", "12.5" or "-0.99" exactly.
+func Parse(s string) (Amount, error) {
+	s = strings.TrimSpace(s)
+	negative := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "-"), "+")
+
+	units, cents, hasPoint := strings.Cut(s, ".")
+	if units == "" && cents == "" || len(cents) > 2 || hasPoint && cents == "" {
+		return 0, ErrInvalidAmount
+	}
+	if units == "" {
+		units = "0"
+	}
+	for len(cents) < 2 {
+		cents += "0"
+	}
+	if strings.ContainsAny(units+cents, "+-") {
+		return 0, ErrInvalidAmount
+	}
+	whole, err := strconv.ParseInt(units, 10, 64)
+	if err != nil || whole > (1<<62)/100 {
+		return 0, ErrInvalidAmount
+	}
+	frac, err := strconv.ParseInt(cents, 10, 64)
+	if err != nil {
+		return 0, ErrInvalidAmount
+	}
+
+	a := Amount(whole*100 + frac)
+	if negative {
+		a = -a
+	}
+	return a, nil
+}
+
+// MustParse is Parse for constants and tests; it panics on invalid input.
+func MustParse(s string) Amount {
+	a, err := Parse(s)
+	if err != nil {
+		panic(err)
+	}
+	return a
+}
+
+// String formats the amount with exactly two fraction digits.
+func (a Amount) String() string {
+	sign := ""
+	if a < 0 {
+		sign, a = "-", -a
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, int64(a)/100, int64(a)%100)
+}
+
+// Mul multiplies the amount by a quantity.
+func (a Amount) Mul(quantity int) Amount {
+	return a * Amount(quantity)
+}
+
+// Convert multiplies the amount by an exchange rate, rounding half away from
+// zero to whole minor units.
+func (a Amount) Convert(rate *big.Rat) Amount {
+	num := new(big.Int).Mul(big.NewInt(int64(a)), rate.Num())
+	quo, rem := new(big.Int).QuoRem(num, rate.Denom(), new(big.Int))
+	if new(big.Int).Mul(new(big.Int).Abs(rem), big.NewInt(2)).Cmp(rate.Denom()) >= 0 {
+		quo.Add(quo, big.NewInt(int64(num.Sign())))
+	}
+	return Amount(quo.Int64())
+}
+
+// ErrInvalidRate is returned for exchange rates that aren't positive decimals.
+var ErrInvalidRate = errors.New("invalid exchange rate")
+
+var ratePattern = regexp.MustCompile(`^[0-9]+(\.[0-9]{1,12})?$`)
+
+// ParseRate reads a positive decimal exchange rate such as "56.25" exactly.
+func ParseRate(s string) (*big.Rat, error) {
+	s = strings.TrimSpace(s)
+	if !ratePattern.MatchString(s) {
+		return nil, ErrInvalidRate
+	}
+	rate, ok := new(big.Rat).SetString(s)
+	if !ok || rate.Sign() <= 0 {
+		return nil, ErrInvalidRate
+	}
+	return rate, nil
+}
+
+// MarshalJSON writes the amount as a decimal string.
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return json.Marshal(a.String())
+}
+
+// UnmarshalJSON accepts a decimal string or a JSON number, read from its text
+// so no float rounding happens.
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	raw := strings.Trim(string(data), `"`)
+	parsed, err := Parse(raw)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidAmount, string(data))
+	}
+	*a = parsed
+	return nil
+}
+
+// GormDataType stores amounts as BIGINT minor units.
+func (Amount) GormDataType() string {
+	return "bigint"
+}
+
+// Value implements driver.Valuer.
+func (a Amount) Value() (driver.Value, error) {
+	return int64(a), nil
+}
+
+// Scan implements sql.Scanner.
+func (a *Amount) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case int64:
+		*a = Amount(v)
+	case []byte:
+		n, err := strconv.ParseInt(string(v), 10, 64)
+		if err != nil {
+			return err
+		}
+		*a = Amount(n)
+	case string:
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return err
+		}
+		*a = Amount(n)
+	case nil:
+		*a = 0
+	default:
+		return fmt.Errorf("cannot scan %T into money.Amount", value)
+	}
+	return nil
+}
+
+// Money is an amount in a given currency.
+type Money struct {
+	Amount   Amount `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+// New pairs an amount with an ISO 4217 currency code.
+func New(amount Amount, currency string) Money {
+	return Money{Amount: amount, Currency: strings.ToUpper(currency)}
+}
+
+// String formats the money as "12.50 USD".
+func (m Money) String() string {
+	return m.Amount.String() + " " + m.Currency
+}
@@ -0,0 +1,129 @@
+package payments
+
+import (
+	"errors"
+	"kalebecommerce/money"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// Mock intent states
+const (
+	mockRequiresPayment = "requires_payment"
+	mockAuthorized      = "authorized"
+	mockCaptured        = "captured"
+	mockFailed          = "failed"
+)
+
+type mockIntent struct {
+	amount   money.Amount
+	refunded money.Amount
+	status   string
+}
+
+type mockRefund struct {
+	id       string
+	intentID string
+	amount   money.Amount
+}
+
+// MockProvider is an in-memory gateway for local development and tests.
+// Intents are paid or declined with Simulate instead of a real checkout.
+type MockProvider struct {
+	mu      sync.Mutex
+	intents map[string]*mockIntent
+	refunds map[string]mockRefund // by idempotency key
+}
+
+// NewMockProvider returns an empty mock gateway.
+func NewMockProvider() *MockProvider {
+	return &MockProvider{intents: map[string]*mockIntent{}, refunds: map[string]mockRefund{}}
+}
+
+func (m *MockProvider) Name() string { return "mock" }
+
+func (m *MockProvider) CreateIntent(orderID string, amount money.Money) (*Intent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	id := "mock_pi_" + uuid.NewString()
+	m.intents[id] = &mockIntent{amount: amount.Amount, status: mockRequiresPayment}
+	return &Intent{ID: id, ClientSecret: id + "_secret"}, nil
+}
+
+func (m *MockProvider) Capture(intentID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	intent, ok := m.intents[intentID]
+	if !ok {
+		return ErrUnknownIntent
+	}
+	if intent.status == mockCaptured {
+		return nil
+	}
+	if intent.status != mockAuthorized {
+		return errors.New("intent is not authorized")
+	}
+	intent.status = mockCaptured
+	return nil
+}
+
+func (m *MockProvider) Refund(intentID string, amount money.Amount, idempotencyKey string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if previous, ok := m.refunds[idempotencyKey]; ok {
+		if previous.intentID != intentID || previous.amount != amount {
+			return "", errors.New("idempotency key reused with different parameters")
+		}
+		return previous.id, nil
+	}
+	intent, ok := m.intents[intentID]
+	if !ok {
+		return "", ErrUnknownIntent
+	}
+	if intent.status != mockCaptured {
+		return "", errors.New("intent is not captured")
+	}
+	if amount <= 0 || intent.refunded+amount > intent.amount {
+		return "", errors.New("refund exceeds captured amount")
+	}
+	intent.refunded += amount
+	refund := mockRefund{id: "mock_re_" + uuid.NewString(), intentID: intentID, amount: amount}
+	m.refunds[idempotencyKey] = refund
+	return refund.id, nil
+}
+
+// Refunded is how much of an intent has been refunded so far.
+func (m *MockProvider) Refunded(intentID string) money.Amount {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if intent, ok := m.intents[intentID]; ok {
+		return intent.refunded
+	}
+	return 0
+}
+
+// Simulate authorizes (succeed) or declines an intent as the customer would,
+// returning the webhook event the gateway sends for it.
+func (m *MockProvider) Simulate(intentID string, succeed bool) (*Event, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	intent, ok := m.intents[intentID]
+	if !ok {
+		return nil, ErrUnknownIntent
+	}
+	if intent.status != mockRequiresPayment {
+		return nil, errors.New("intent was already paid or declined")
+	}
+
+	event := &Event{ID: "mock_evt_" + uuid.NewString(), IntentID: intentID}
+	if succeed {
+		intent.status = mockAuthorized
+		event.Type = EventPaymentAuthorized
+	} else {
+		intent.status = mockFailed
+		event.Type = EventPaymentFailed
+		event.Message = "card declined"
+	}
+	return event, nil
+}
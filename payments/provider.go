@@ -0,0 +1,74 @@
+package payments
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"kalebecommerce/money"
+)
+
+// Webhook event types sent by providers.
+const (
+	EventPaymentAuthorized = "payment.authorized"
+	EventPaymentFailed     = "payment.failed"
+)
+
+// SignatureHeader carries the hex HMAC-SHA256 of a webhook body.
+const SignatureHeader = "X-Payment-Signature"
+
+// ErrUnknownIntent is returned for intent IDs the provider doesn't know.
+var ErrUnknownIntent = errors.New("unknown payment intent")
+
+// Intent is a provider-side request to collect an amount for an order.
+type Intent struct {
+	ID           string
+	ClientSecret string
+}
+
+// Event is a webhook notification about an intent.
+type Event struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	IntentID string `json:"intent_id"`
+	Message  string `json:"message,omitempty"`
+}
+
+// PaymentProvider is a payment gateway. Intents are authorized by the customer
+// (reported through the webhook) and then captured by the store.
+type PaymentProvider interface {
+	Name() string
+	CreateIntent(orderID string, amount money.Money) (*Intent, error)
+	// Capture collects an authorized intent. Capturing an intent that is
+	// already captured succeeds, so a capture can be retried.
+	Capture(intentID string) error
+	// Refund returns amount of a captured intent and the provider's refund ID.
+	// Repeating a call with the same idempotency key returns the first refund
+	// instead of paying out again.
+	Refund(intentID string, amount money.Amount, idempotencyKey string) (string, error)
+}
+
+// New returns the provider configured by name.
+func New(name string) (PaymentProvider, error) {
+	switch name {
+	case "mock":
+		return NewMockProvider(), nil
+	case "":
+		return nil, errors.New("no payment provider configured")
+	default:
+		return nil, fmt.Errorf("unsupported payment provider %q", name)
+	}
+}
+
+// SignWebhook computes the signature of a webhook body.
+func SignWebhook(body []byte, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhook checks a webhook body against its signature.
+func VerifyWebhook(body []byte, signature, secret string) bool {
+	return hmac.Equal([]byte(signature), []byte(SignWebhook(body, secret)))
+}
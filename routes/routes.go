@@ -4,6 +4,7 @@ import (
 	"kalebecommerce/config"
 	"kalebecommerce/controllers"
 	"kalebecommerce/middleware"
+	"kalebecommerce/payments"
 
 	"time"
 
@@ -13,7 +14,7 @@ import (
 )
 
 // SetupRouter sets up all API routes, middleware, and rate limiting.
-func SetupRouter(db *gorm.DB, cfg *config.Config, productCache *cache.Cache) *gin.Engine {
+func SetupRouter(db *gorm.DB, cfg *config.Config, productCache *cache.Cache, provider payments.PaymentProvider) *gin.Engine {
 	r := gin.Default()
 
 	// 🧩 Global rate limiter: 5 requests every 10 seconds per IP
@@ -21,24 +22,122 @@ func SetupRouter(db *gorm.DB, cfg *config.Config, productCache *cache.Cache) *gi
 
 	api := r.Group("/api")
 
+	// ♻️ Order-placing routes replay their response for retried Idempotency-Keys (kept 24h)
+	idempotent := middleware.Idempotency(db, 24*time.Hour)
+
 	// 🔐 Authentication routes (login/register)
-	api.POST("/auth/register", controllers.Register(db))
+	api.POST("/auth/register", controllers.Register(db, cfg))
 	api.POST("/auth/login", controllers.Login(db, cfg))
 
 	// 🛍 Public product routes (with cache)
-	api.GET("/products", controllers.ListOrSearchProducts(db, productCache))
-	api.GET("/products/:id", controllers.GetProduct(db))
+	api.GET("/products", controllers.ListOrSearchProducts(db, cfg, productCache))
+	api.GET("/products/:id", controllers.GetProduct(db, cfg))
+
+	// 🚚 Shipping options for a destination country
+	api.GET("/shipping/methods", controllers.ListShippingMethods(db, cfg))
+
+	// 🛒 Cart routes (logged-in users or guests with a cart token)
+	cart := api.Group("/cart").Use(middleware.OptionalAuth(cfg))
+	cart.GET("", controllers.GetCart(db, cfg))
+	cart.DELETE("", controllers.ClearCart(db, cfg))
+	cart.POST("/items", controllers.AddCartItem(db, cfg))
+	cart.PUT("/items/:productId", controllers.UpdateCartItem(db, cfg))
+	cart.DELETE("/items/:productId", controllers.RemoveCartItem(db, cfg))
+	cart.POST("/checkout", idempotent, controllers.CheckoutCart(db, cfg))
+
+	// 📦 Guest checkout (no account, signed lookup link)
+	api.POST("/guest/orders", idempotent, controllers.PlaceGuestOrder(db, cfg))
+	api.GET("/guest/orders/:id", controllers.GetGuestOrder(db, cfg))
+	api.POST("/guest/orders/:id/pay", controllers.PayGuestOrder(db, cfg, provider))
+
+	// 💳 Payment provider callbacks (signed with PAYMENT_WEBHOOK_SECRET)
+	api.POST("/payments/webhook", controllers.PaymentWebhook(db, cfg, provider))
+	// Development only: lets anyone pay or decline mock intents
+	if mock, ok := provider.(*payments.MockProvider); ok && cfg.PaymentMockEndpoints {
+		api.POST("/payments/mock/:intentId/:outcome", controllers.MockPaymentAction(db, cfg, mock))
+	}
 
 	// 👤 User routes (require login)
 	auth := api.Group("").Use(middleware.AuthRequired(cfg))
-	auth.POST("/orders", controllers.PlaceOrder(db))
+	auth.POST("/orders", idempotent, controllers.PlaceOrder(db, cfg))
 	auth.GET("/orders", controllers.ListOrders(db))
+	auth.GET("/orders/:id", controllers.GetOrder(db))
+	auth.POST("/orders/:id/cancel", controllers.CancelOrder(db, provider))
+	auth.POST("/orders/:id/pay", controllers.PayOrder(db, cfg, provider))
+	auth.POST("/guest/orders/:id/claim", controllers.ClaimGuestOrder(db, cfg))
+	auth.GET("/orders/:id/returns", controllers.ListOrderReturns(db))
+	auth.POST("/orders/:id/returns", controllers.CreateReturn(db, cfg))
+	auth.GET("/addresses", controllers.ListAddresses(db))
+	auth.POST("/addresses", controllers.CreateAddress(db))
+	auth.PUT("/addresses/:id", controllers.UpdateAddress(db))
+	auth.DELETE("/addresses/:id", controllers.DeleteAddress(db))
+	auth.GET("/gift-cards/:code", controllers.GetGiftCard(db))
+	auth.GET("/store-credit", controllers.GetStoreCredit(db))
+	auth.GET("/loyalty", controllers.GetLoyalty(db, cfg))
 
 	// 🧑‍💼 Admin routes (require admin role)
 	admin := api.Group("").Use(middleware.AuthRequired(cfg), middleware.AdminOnly())
 	admin.POST("/products", controllers.CreateProduct(db))
 	admin.PUT("/products/:id", controllers.UpdateProduct(db))
+	admin.PATCH("/products/:id", controllers.UpdateProduct(db))
 	admin.DELETE("/products/:id", controllers.DeleteProduct(db))
+	admin.POST("/admin/products/import", controllers.ImportProducts(db))
+	admin.GET("/admin/imports/:id", controllers.GetImportJob(db))
+	admin.GET("/admin/products/export", controllers.ExportProducts(db, cfg))
+	admin.GET("/admin/products/:id/price-history", controllers.ListPriceHistory(db))
+	admin.GET("/admin/products/:id/scheduled-prices", controllers.ListScheduledPrices(db))
+	admin.POST("/admin/products/:id/scheduled-prices", controllers.CreateScheduledPrice(db))
+	admin.DELETE("/admin/products/:id/scheduled-prices/:scheduleId", controllers.CancelScheduledPrice(db))
+	admin.GET("/admin/products/:id/prices", controllers.ListProductPrices(db))
+	admin.PUT("/admin/products/:id/prices/:currency", controllers.SetProductPrice(db, cfg))
+	admin.DELETE("/admin/products/:id/prices/:currency", controllers.DeleteProductPrice(db))
+	admin.GET("/admin/exchange-rates", controllers.ListExchangeRates(db, cfg))
+	admin.POST("/admin/exchange-rates/import", controllers.ImportExchangeRates(db, cfg))
+	admin.PUT("/admin/exchange-rates/:currency", controllers.SetExchangeRate(db, cfg))
+	admin.DELETE("/admin/exchange-rates/:currency", controllers.DeleteExchangeRate(db))
+	admin.GET("/admin/tax-zones", controllers.ListTaxZones(db))
+	admin.POST("/admin/tax-zones", controllers.CreateTaxZone(db))
+	admin.PUT("/admin/tax-zones/:id", controllers.UpdateTaxZone(db))
+	admin.DELETE("/admin/tax-zones/:id", controllers.DeleteTaxZone(db))
+	admin.GET("/admin/shipping-zones", controllers.ListShippingZones(db))
+	admin.POST("/admin/shipping-zones", controllers.CreateShippingZone(db))
+	admin.PUT("/admin/shipping-zones/:id", controllers.UpdateShippingZone(db))
+	admin.DELETE("/admin/shipping-zones/:id", controllers.DeleteShippingZone(db))
+	admin.POST("/admin/shipping-zones/:id/methods", controllers.CreateShippingMethod(db))
+	admin.PUT("/admin/shipping-methods/:id", controllers.UpdateShippingMethod(db))
+	admin.DELETE("/admin/shipping-methods/:id", controllers.DeleteShippingMethod(db))
+	admin.GET("/admin/promotions", controllers.ListPromotions(db))
+	admin.POST("/admin/promotions", controllers.CreatePromotion(db))
+	admin.PUT("/admin/promotions/:id", controllers.UpdatePromotion(db))
+	admin.DELETE("/admin/promotions/:id", controllers.DeletePromotion(db))
+	admin.GET("/admin/gift-cards", controllers.ListGiftCards(db))
+	admin.POST("/admin/gift-cards", controllers.IssueGiftCard(db, cfg))
+	admin.GET("/admin/gift-cards/:id", controllers.AdminGetGiftCard(db))
+	admin.PUT("/admin/gift-cards/:id", controllers.UpdateGiftCard(db))
+	admin.GET("/admin/users/:id/store-credit", controllers.AdminGetStoreCredit(db))
+	admin.POST("/admin/users/:id/store-credit", controllers.GrantStoreCredit(db, cfg))
+	admin.GET("/admin/users/:id/loyalty", controllers.AdminGetLoyalty(db, cfg))
+	admin.POST("/admin/users/:id/loyalty", controllers.AdjustLoyaltyPoints(db, cfg))
+	admin.GET("/admin/loyalty-rules", controllers.ListLoyaltyRules(db))
+	admin.POST("/admin/loyalty-rules", controllers.CreateLoyaltyRule(db))
+	admin.PUT("/admin/loyalty-rules/:id", controllers.UpdateLoyaltyRule(db))
+	admin.DELETE("/admin/loyalty-rules/:id", controllers.DeleteLoyaltyRule(db))
+	admin.GET("/admin/orders", controllers.AdminListOrders(db))
+	admin.GET("/admin/orders/:id", controllers.AdminGetOrder(db))
+	admin.PUT("/admin/orders/:id/status", controllers.UpdateOrderStatus(db, provider))
+	admin.POST("/admin/orders/:id/cancel", controllers.AdminCancelOrder(db, provider))
+	admin.GET("/admin/orders/:id/refunds", controllers.ListRefunds(db))
+	admin.POST("/admin/orders/:id/refunds", controllers.CreateRefund(db, provider))
+	admin.POST("/admin/refunds/:id/retry", controllers.RetryRefund(db, provider))
+	admin.GET("/admin/returns", controllers.AdminListReturns(db))
+	admin.POST("/admin/returns/:id/approve", controllers.ApproveReturn(db))
+	admin.POST("/admin/returns/:id/reject", controllers.RejectReturn(db))
+	admin.POST("/admin/returns/:id/receive", controllers.ReceiveReturn(db, provider))
+	admin.GET("/admin/orders/:id/timeline", controllers.GetOrderTimeline(db))
+	admin.GET("/admin/orders/:id/shipments", controllers.ListShipments(db))
+	admin.POST("/admin/orders/:id/shipments", controllers.CreateShipment(db))
+	admin.PUT("/admin/shipments/:id", controllers.UpdateShipment(db))
+	admin.POST("/admin/shipments/:id/deliver", controllers.DeliverShipment(db))
 
 	return r
 }
@@ -0,0 +1,159 @@
+// Package tax works out order taxes. Calculator is the extension point; Rules
+// is the built-in implementation driven by zone rates kept in the database.
+package tax
+
+import (
+	"errors"
+	"kalebecommerce/money"
+	"math/big"
+	"regexp"
+	"strings"
+)
+
+// Calculator computes the taxes of a set of order lines shipped to an address.
+type Calculator interface {
+	Calculate(req Request) (Result, error)
+}
+
+// Request is what a Calculator needs to tax an order.
+type Request struct {
+	Country string // ISO 3166-1 alpha-2
+	Region  string
+	Lines   []Line
+}
+
+// Line is one order line: its total as charged and the product's tax class.
+type Line struct {
+	Amount   money.Amount
+	TaxClass string
+}
+
+// Result holds the tax of each request line (same order) and the taxes summed per rate.
+type Result struct {
+	Inclusive bool // line amounts already contain the tax
+	Lines     []money.Amount
+	Taxes     []Tax
+	Total     money.Amount
+}
+
+// Tax is the amount collected under one named rate.
+type Tax struct {
+	Name   string       `json:"name"`
+	Rate   string       `json:"rate"` // percent
+	Amount money.Amount `json:"amount"`
+}
+
+// Rate is a percentage applied to one tax class in a zone. A zone is a country,
+// optionally narrowed to a region.
+type Rate struct {
+	Name     string
+	Country  string
+	Region   string
+	TaxClass string
+	Percent  string
+}
+
+// ErrInvalidPercent is returned for rate percentages that aren't non-negative decimals.
+var ErrInvalidPercent = errors.New("invalid tax rate")
+
+var percentPattern = regexp.MustCompile(`^[0-9]+(\.[0-9]{1,4})?$`)
+
+// ParsePercent reads a rate percentage such as "15" or "7.25" exactly.
+func ParsePercent(s string) (*big.Rat, error) {
+	s = strings.TrimSpace(s)
+	if !percentPattern.MatchString(s) {
+		return nil, ErrInvalidPercent
+	}
+	r, ok := new(big.Rat).SetString(s)
+	if !ok || r.Cmp(big.NewRat(100, 1)) > 0 {
+		return nil, ErrInvalidPercent
+	}
+	return r, nil
+}
+
+// Rules is the built-in Calculator. The most specific zone wins: rates for the
+// address region replace the country-wide ones. Each line is taxed at every
+// rate of its class and rounded to the cent.
+type Rules struct {
+	Rates     []Rate
+	Inclusive bool
+}
+
+// Calculate implements Calculator.
+func (r Rules) Calculate(req Request) (Result, error) {
+	res := Result{Inclusive: r.Inclusive, Lines: make([]money.Amount, len(req.Lines))}
+	rates, err := r.zoneRates(req.Country, req.Region)
+	if err != nil || len(rates) == 0 {
+		return res, err
+	}
+
+	totals := make(map[int]money.Amount)
+	for i, line := range req.Lines {
+		var matching []int
+		sum := new(big.Rat)
+		for j, rate := range rates {
+			if rate.TaxClass == line.TaxClass {
+				matching = append(matching, j)
+				sum.Add(sum, rates[j].percent)
+			}
+		}
+		if len(matching) == 0 {
+			continue
+		}
+
+		// Inclusive amounts are gross: the tax share of each rate is percent/(100+sum)
+		base := big.NewRat(100, 1)
+		if r.Inclusive {
+			base.Add(base, sum)
+		}
+		for _, j := range matching {
+			amount := line.Amount.Convert(new(big.Rat).Quo(rates[j].percent, base))
+			totals[j] += amount
+			res.Lines[i] += amount
+		}
+		res.Total += res.Lines[i]
+	}
+
+	for j, rate := range rates {
+		if amount, ok := totals[j]; ok {
+			res.Taxes = append(res.Taxes, Tax{Name: rate.Name, Rate: rate.Percent, Amount: amount})
+		}
+	}
+	return res, nil
+}
+
+// parsedRate is a Rate with its percentage parsed.
+type parsedRate struct {
+	Rate
+	percent *big.Rat
+}
+
+// zoneRates picks the rates of the most specific zone covering the address.
+func (r Rules) zoneRates(country, region string) ([]parsedRate, error) {
+	country = strings.ToUpper(strings.TrimSpace(country))
+	region = strings.ToUpper(strings.TrimSpace(region))
+	if country == "" {
+		return nil, nil
+	}
+
+	var countryWide, regional []parsedRate
+	for _, rate := range r.Rates {
+		if !strings.EqualFold(rate.Country, country) {
+			continue
+		}
+		percent, err := ParsePercent(rate.Percent)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case rate.Region == "":
+			countryWide = append(countryWide, parsedRate{rate, percent})
+		case region != "" && strings.EqualFold(rate.Region, region):
+			regional = append(regional, parsedRate{rate, percent})
+		}
+	}
+	if len(regional) > 0 {
+		return regional, nil
+	}
+	return countryWide, nil
+}
@@ -0,0 +1,33 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+)
+
+// sign computes the URL-safe HMAC-SHA256 signature of value.
+func sign(value, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(value))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// SignValue appends an HMAC signature to value so it can be handed to clients as a token.
+func SignValue(value, secret string) string {
+	return value + "." + sign(value, secret)
+}
+
+// VerifySignedValue checks a token produced by SignValue and returns the original value.
+func VerifySignedValue(token, secret string) (string, bool) {
+	i := strings.LastIndex(token, ".")
+	if i <= 0 {
+		return "", false
+	}
+	value, signature := token[:i], token[i+1:]
+	if !hmac.Equal([]byte(signature), []byte(sign(value, secret))) {
+		return "", false
+	}
+	return value, true
+}
@@ -1,26 +1,40 @@
 package utils
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"mime/multipart"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"strings"
 )
 
 const UploadDir = "uploads/products" // Directory where images will be saved
 
+const SnapshotDir = "uploads/orders" // Directory for images frozen on order lines
+
+const ReturnPhotoDir = "uploads/returns" // Directory for photos attached to return requests
+
 // saveUploadedFile handles saving the file and returns the local path/URL.
 func SaveUploadedFile(file *multipart.FileHeader, productID string) (string, error) {
+	return SaveUploadedFileTo(file, UploadDir, productID)
+}
+
+// SaveUploadedFileTo saves the file as dir/name plus its original extension and returns the local path/URL.
+func SaveUploadedFileTo(file *multipart.FileHeader, dir, name string) (string, error) {
 	// 1. Ensure the upload directory exists
-	if err := os.MkdirAll(UploadDir, 0755); err != nil {
+	if err := os.MkdirAll(dir, 0755); err != nil {
 		return "", fmt.Errorf("failed to create upload directory: %w", err)
 	}
 
-	// 2. Generate a unique filename using product ID and original extension
+	// 2. Generate a unique filename using the name and original extension
 	extension := filepath.Ext(file.Filename)
-	filename := productID + extension
-	filePath := filepath.Join(UploadDir, filename)
+	filename := name + extension
+	filePath := filepath.Join(dir, filename)
 
 	// 3. Open the uploaded file
 	src, err := file.Open()
@@ -44,3 +58,61 @@ func SaveUploadedFile(file *multipart.FileHeader, productID string) (string, err
 	// Return the relative path/URL
 	return "/" + filePath, nil
 }
+
+// UploadedImagePath returns the file behind a "/uploads/products/..." image URL,
+// and false for hosted URLs and paths that resolve outside UploadDir.
+func UploadedImagePath(imageURL string) (string, bool) {
+	u, err := url.Parse(imageURL)
+	if err != nil || u.Scheme != "" || u.Host != "" || !strings.HasPrefix(u.Path, "/") {
+		return "", false
+	}
+	local := strings.TrimPrefix(path.Clean(u.Path), "/")
+	if !strings.HasPrefix(local, UploadDir+"/") {
+		return "", false
+	}
+	return filepath.FromSlash(local), true
+}
+
+// ValidImageURL reports whether a product image URL is an http(s) URL or an
+// image uploaded to UploadDir.
+func ValidImageURL(imageURL string) bool {
+	if _, ok := UploadedImagePath(imageURL); ok {
+		return true
+	}
+	u, err := url.Parse(imageURL)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// SnapshotImage copies a stored image (a "/uploads/products/..." URL) into SnapshotDir
+// and returns the copy's URL. Copies are named by content hash, so identical images
+// are stored once. Images hosted elsewhere are returned unchanged; local paths
+// outside UploadDir are rejected.
+func SnapshotImage(imageURL string) (string, error) {
+	u, err := url.Parse(imageURL)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "" || u.Host != "" {
+		return imageURL, nil
+	}
+	local, ok := UploadedImagePath(imageURL)
+	if !ok {
+		return "", fmt.Errorf("image %s is not in %s", imageURL, UploadDir)
+	}
+	data, err := os.ReadFile(local)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(SnapshotDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create snapshot directory: %w", err)
+	}
+
+	sum := sha256.Sum256(data)
+	filePath := filepath.Join(SnapshotDir, hex.EncodeToString(sum[:])+filepath.Ext(imageURL))
+	if _, err := os.Stat(filePath); os.IsNotExist(err) {
+		if err := os.WriteFile(filePath, data, 0644); err != nil {
+			return "", err
+		}
+	}
+	return "/" + filePath, nil
+}